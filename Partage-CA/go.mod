@@ -1,3 +1,7 @@
 module partage-ca
 
-go 1.16
\ No newline at end of file
+go 1.16
+
+require partage-common v0.0.0
+
+replace partage-common => ../Partage-Common
@@ -1,3 +1,2 @@
 #!/bin/bash
-go run ./server/main/mod.go
-
+go run ./server/main/mod.go "$@"
@@ -0,0 +1,204 @@
+package impl
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"partage-ca/server"
+	"strings"
+	"sync"
+	"time"
+)
+
+// adminServer serves the administrative HTTP+JSON API of the CA on its own listener. The administrators authenticate
+// with a bearer token ("Authorization: Bearer <token>"), and every request is recorded in the audit log:
+//   - GET  /users                      lists the registered users
+//   - GET  /users/<hash>               looks up the owner of a public key (hex-encoded hash)
+//   - POST /users/<hash>/revoke        revokes a public key, which bans its owner
+//   - GET  /emails/<email>             looks up the owner of an e-mail address
+//   - POST /emails/<email>/unreserve   frees an e-mail address for a new registration
+type adminServer struct {
+	registry   server.Registry
+	token      string
+	listener   net.Listener
+	httpServer *http.Server
+	fpAudit    *os.File
+	auditMutex sync.Mutex
+}
+
+// auditRecord is a line of the audit log.
+type auditRecord struct {
+	Time   string
+	Remote string
+	Action string
+	Target string
+	Status int
+	Error  string `json:",omitempty"`
+}
+
+func NewAdminServer(registry server.Registry, address string, token string) (server.Service, error) {
+	if token == "" {
+		return nil, errors.New("the admin API needs a token")
+	}
+	fpAudit, err := OpenFileToAppend(server.AuditLogPath)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		fpAudit.Close()
+		return nil, err
+	}
+	a := &adminServer{
+		registry: registry,
+		token:    token,
+		listener: listener,
+		fpAudit:  fpAudit,
+	}
+	a.httpServer = &http.Server{Handler: a, ReadTimeout: 10 * time.Second, WriteTimeout: 10 * time.Second}
+	return a, nil
+}
+
+func (a *adminServer) Start() error {
+	fmt.Println("admin API listening on " + a.GetAddress() + " ...")
+	go a.httpServer.Serve(a.listener)
+	return nil
+}
+
+func (a *adminServer) Stop() error {
+	err := a.httpServer.Close()
+	a.auditMutex.Lock()
+	a.fpAudit.Close()
+	a.auditMutex.Unlock()
+	return err
+}
+
+func (a *adminServer) GetAddress() string {
+	return a.listener.Addr().String()
+}
+
+// ServeHTTP implements http.Handler
+func (a *adminServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !a.authorized(r) {
+		a.respond(w, r, "unauthorized", "", http.StatusUnauthorized, nil, errors.New("invalid token"))
+		return
+	}
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.Method == http.MethodGet && len(path) == 1 && path[0] == "users":
+		a.respond(w, r, "list", "", http.StatusOK, a.registry.Users(), nil)
+	case len(path) >= 2 && path[0] == "users":
+		a.serveUser(w, r, path[1], path[2:])
+	case len(path) >= 2 && path[0] == "emails":
+		a.serveEmail(w, r, path[1], path[2:])
+	default:
+		a.respond(w, r, "unknown", r.URL.Path, http.StatusNotFound, nil, errors.New("unknown operation"))
+	}
+}
+
+// serveUser serves the operations on the user owning the public key with the given hex-encoded hash.
+func (a *adminServer) serveUser(w http.ResponseWriter, r *http.Request, target string, operation []string) {
+	action := "lookup"
+	if len(operation) == 1 && operation[0] == "revoke" && r.Method == http.MethodPost {
+		action = "revoke"
+	} else if len(operation) != 0 || r.Method != http.MethodGet {
+		a.respond(w, r, "unknown", target, http.StatusNotFound, nil, errors.New("unknown operation"))
+		return
+	}
+	data, err := hex.DecodeString(target)
+	if err != nil || len(data) != 32 {
+		a.respond(w, r, action, target, http.StatusBadRequest, nil, errors.New("invalid public key hash"))
+		return
+	}
+	var publicKeyHash [32]byte
+	copy(publicKeyHash[:], data)
+	user, exists := a.registry.LookupUser(publicKeyHash)
+	if !exists {
+		a.respond(w, r, action, target, http.StatusNotFound, nil, errors.New("unknown user"))
+		return
+	}
+	if action == "revoke" {
+		err = a.registry.Revoke(publicKeyHash)
+		if err != nil {
+			a.respond(w, r, action, target, http.StatusInternalServerError, nil, err)
+			return
+		}
+		user, _ = a.registry.LookupUser(publicKeyHash)
+	}
+	a.respond(w, r, action, target, http.StatusOK, user, nil)
+}
+
+// serveEmail serves the operations on the given e-mail address.
+func (a *adminServer) serveEmail(w http.ResponseWriter, r *http.Request, email string, operation []string) {
+	if len(operation) == 1 && operation[0] == "unreserve" && r.Method == http.MethodPost {
+		user, exists := a.registry.LookupEmail(email)
+		err := a.registry.UnreserveEmail(email)
+		if err != nil {
+			a.respond(w, r, "unreserve", email, http.StatusConflict, nil, err)
+			return
+		}
+		if exists {
+			user, _ = a.registry.LookupUser(hashFromHex(user.PublicKeyHash))
+		}
+		a.respond(w, r, "unreserve", email, http.StatusOK, user, nil)
+		return
+	}
+	if len(operation) != 0 || r.Method != http.MethodGet {
+		a.respond(w, r, "unknown", email, http.StatusNotFound, nil, errors.New("unknown operation"))
+		return
+	}
+	user, exists := a.registry.LookupEmail(email)
+	if !exists {
+		a.respond(w, r, "lookup", email, http.StatusNotFound, nil, errors.New("e-mail address is not reserved"))
+		return
+	}
+	a.respond(w, r, "lookup", email, http.StatusOK, user, nil)
+}
+
+// authorized returns whether the request carries the admin token in an "Authorization: Bearer <token>" header.
+func (a *adminServer) authorized(r *http.Request) bool {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return false
+	}
+	token := strings.TrimPrefix(header, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) == 1
+}
+
+// respond records the action in the audit log, and replies with the given body, or the error.
+func (a *adminServer) respond(w http.ResponseWriter, r *http.Request, action string, target string, status int, body interface{}, err error) {
+	record := auditRecord{
+		Time:   time.Now().UTC().Format(time.RFC3339),
+		Remote: r.RemoteAddr,
+		Action: action,
+		Target: target,
+		Status: status,
+	}
+	if err != nil {
+		record.Error = err.Error()
+		body = struct{ Error string }{err.Error()}
+	}
+	auditErr := a.audit(record)
+	if auditErr != nil {
+		fmt.Println("[ERROR] writing to the audit log...", auditErr)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// audit appends the record to the audit log.
+func (a *adminServer) audit(record auditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	a.auditMutex.Lock()
+	defer a.auditMutex.Unlock()
+	return AppendToFile(append(line, '\n'), a.fpAudit)
+}
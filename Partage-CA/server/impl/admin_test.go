@@ -0,0 +1,243 @@
+package impl
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"partage-ca/server"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const adminToken = "secret"
+
+// newTestAdmin serves the admin API of a CA that registers anyone, and returns the path of its audit log.
+func newTestAdmin(t *testing.T) (*certificateAuthority, *httptest.Server, string) {
+	t.Helper()
+	ca := newTestCA(t, NewAllowlistProvider([]string{"*"}))
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	fpAudit, err := os.OpenFile(auditPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("failed to open the audit log: %v", err)
+	}
+	a := &adminServer{registry: ca, token: adminToken, fpAudit: fpAudit}
+	httpServer := httptest.NewServer(a)
+	t.Cleanup(func() {
+		httpServer.Close()
+		fpAudit.Close()
+	})
+	return ca, httpServer, auditPath
+}
+
+// register registers a user with the given e-mail address.
+func register(t *testing.T, ca *certificateAuthority, email string) *testUser {
+	t.Helper()
+	user := newTestUser(t, email)
+	checkRegistered(t, ca, user, readMessage(t, user.dial(t, ca)))
+	return user
+}
+
+// call sends a request to the admin API with the given token, and decodes the reply into body unless it is nil.
+func call(t *testing.T, httpServer *httptest.Server, method string, path string, token string, body interface{}) int {
+	t.Helper()
+	req, err := http.NewRequest(method, httpServer.URL+path, nil)
+	if err != nil {
+		t.Fatalf("failed to create the request: %v", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := httpServer.Client().Do(req)
+	if err != nil {
+		t.Fatalf("failed to send the request: %v", err)
+	}
+	defer resp.Body.Close()
+	if body != nil {
+		err = json.NewDecoder(resp.Body).Decode(body)
+		if err != nil {
+			t.Fatalf("failed to decode the reply: %v", err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func expectStatus(t *testing.T, expected int, actual int) {
+	t.Helper()
+	if actual != expected {
+		t.Fatalf("expected status %d, got %d", expected, actual)
+	}
+}
+
+func TestAdminListUsers(t *testing.T) {
+	ca, httpServer, _ := newTestAdmin(t)
+	alice := register(t, ca, "alice@example.com")
+	bob := register(t, ca, "bob@example.com")
+
+	var users []server.User
+	expectStatus(t, http.StatusOK, call(t, httpServer, http.MethodGet, "/users", adminToken, &users))
+	if len(users) != 2 {
+		t.Fatalf("expected 2 users, got %d", len(users))
+	}
+	listed := map[string]bool{users[0].PublicKeyHash: true, users[1].PublicKeyHash: true}
+	for _, user := range []*testUser{alice, bob} {
+		if !listed[hex.EncodeToString(user.publicKeyHash[:])] {
+			t.Fatalf("%s isn't listed", user.email)
+		}
+	}
+}
+
+func TestAdminLookupUser(t *testing.T) {
+	ca, httpServer, _ := newTestAdmin(t)
+	alice := register(t, ca, "alice@example.com")
+	hash := hex.EncodeToString(alice.publicKeyHash[:])
+
+	var user server.User
+	expectStatus(t, http.StatusOK, call(t, httpServer, http.MethodGet, "/users/"+hash, adminToken, &user))
+	if user.PublicKeyHash != hash || user.Status != server.StatusActive || !user.EmailReserved {
+		t.Fatalf("unexpected user: %+v", user)
+	}
+
+	unknown := hex.EncodeToString(make([]byte, 32))
+	expectStatus(t, http.StatusNotFound, call(t, httpServer, http.MethodGet, "/users/"+unknown, adminToken, nil))
+	expectStatus(t, http.StatusBadRequest, call(t, httpServer, http.MethodGet, "/users/nothex", adminToken, nil))
+	expectStatus(t, http.StatusNotFound, call(t, httpServer, http.MethodDelete, "/users/"+hash, adminToken, nil))
+}
+
+func TestAdminRevokeUser(t *testing.T) {
+	ca, httpServer, _ := newTestAdmin(t)
+	alice := register(t, ca, "alice@example.com")
+	hash := hex.EncodeToString(alice.publicKeyHash[:])
+
+	// revoking takes a POST
+	expectStatus(t, http.StatusNotFound, call(t, httpServer, http.MethodGet, "/users/"+hash+"/revoke", adminToken, nil))
+
+	var user server.User
+	expectStatus(t, http.StatusOK, call(t, httpServer, http.MethodPost, "/users/"+hash+"/revoke", adminToken, &user))
+	if user.Status != server.StatusRevoked || user.RevokedAt == 0 {
+		t.Fatalf("the reply doesn't show the revocation: %+v", user)
+	}
+	registered, _ := ca.LookupUser(alice.publicKeyHash)
+	if registered.Status != server.StatusRevoked {
+		t.Fatalf("the user isn't revoked: %+v", registered)
+	}
+	crl, err := ca.RevocationList()
+	if err != nil {
+		t.Fatalf("failed to get the revocation list: %v", err)
+	}
+	if len(crl.Revoked) != 1 || crl.Revoked[0] != alice.publicKeyHash {
+		t.Fatalf("the revocation list doesn't contain the user: %+v", crl)
+	}
+
+	unknown := hex.EncodeToString(make([]byte, 32))
+	expectStatus(t, http.StatusNotFound, call(t, httpServer, http.MethodPost, "/users/"+unknown+"/revoke", adminToken, nil))
+}
+
+func TestAdminLookupEmail(t *testing.T) {
+	ca, httpServer, _ := newTestAdmin(t)
+	alice := register(t, ca, "alice@example.com")
+
+	var user server.User
+	expectStatus(t, http.StatusOK, call(t, httpServer, http.MethodGet, "/emails/"+alice.email, adminToken, &user))
+	if user.PublicKeyHash != hex.EncodeToString(alice.publicKeyHash[:]) {
+		t.Fatalf("unexpected owner: %+v", user)
+	}
+	expectStatus(t, http.StatusNotFound, call(t, httpServer, http.MethodGet, "/emails/bob@example.com", adminToken, nil))
+}
+
+func TestAdminUnreserveEmail(t *testing.T) {
+	ca, httpServer, _ := newTestAdmin(t)
+	alice := register(t, ca, "alice@example.com")
+
+	var user server.User
+	expectStatus(t, http.StatusOK, call(t, httpServer, http.MethodPost, "/emails/"+alice.email+"/unreserve", adminToken, &user))
+	if user.EmailReserved || user.Status != server.StatusActive {
+		t.Fatalf("unexpected user: %+v", user)
+	}
+	expectStatus(t, http.StatusNotFound, call(t, httpServer, http.MethodGet, "/emails/"+alice.email, adminToken, nil))
+	expectStatus(t, http.StatusConflict, call(t, httpServer, http.MethodPost, "/emails/"+alice.email+"/unreserve", adminToken, nil))
+
+	// the e-mail address can be taken by a new key, while the former owner stays registered
+	register(t, ca, alice.email)
+	if _, exists := ca.LookupUser(alice.publicKeyHash); !exists {
+		t.Fatalf("the former owner isn't registered anymore")
+	}
+}
+
+func TestAdminUnauthorized(t *testing.T) {
+	ca, httpServer, auditPath := newTestAdmin(t)
+	alice := register(t, ca, "alice@example.com")
+	hash := hex.EncodeToString(alice.publicKeyHash[:])
+
+	requests := []struct {
+		method string
+		path   string
+	}{
+		{http.MethodGet, "/users"},
+		{http.MethodGet, "/users/" + hash},
+		{http.MethodPost, "/users/" + hash + "/revoke"},
+		{http.MethodGet, "/emails/" + alice.email},
+		{http.MethodPost, "/emails/" + alice.email + "/unreserve"},
+	}
+	for _, token := range []string{"", "wrong", adminToken + "x"} {
+		for _, r := range requests {
+			var reply struct{ Error string }
+			expectStatus(t, http.StatusUnauthorized, call(t, httpServer, r.method, r.path, token, &reply))
+			if reply.Error == "" {
+				t.Fatalf("%s %s: expected an error in the reply", r.method, r.path)
+			}
+		}
+	}
+
+	// nothing has changed
+	user, _ := ca.LookupUser(alice.publicKeyHash)
+	if user.Status != server.StatusActive || !user.EmailReserved {
+		t.Fatalf("the user has changed: %+v", user)
+	}
+
+	// every attempt is audited
+	fp, err := os.Open(auditPath)
+	if err != nil {
+		t.Fatalf("failed to open the audit log: %v", err)
+	}
+	defer fp.Close()
+	scanner := bufio.NewScanner(fp)
+	lines := 0
+	for scanner.Scan() {
+		var record auditRecord
+		err := json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil {
+			t.Fatalf("malformed audit record %q: %v", scanner.Text(), err)
+		}
+		if record.Action != "unauthorized" || record.Status != http.StatusUnauthorized || !strings.Contains(record.Error, "token") {
+			t.Fatalf("unexpected audit record: %+v", record)
+		}
+		lines++
+	}
+	if lines != 3*len(requests) {
+		t.Fatalf("expected %d audit records, got %d", 3*len(requests), lines)
+	}
+}
+
+func TestAdminTokenWithoutScheme(t *testing.T) {
+	_, httpServer, _ := newTestAdmin(t)
+
+	// the token is only accepted in the "Bearer <token>" form
+	for _, header := range []string{adminToken, "Basic " + adminToken, "bearer " + adminToken, "Bearer" + adminToken} {
+		req, err := http.NewRequest(http.MethodGet, httpServer.URL+"/users", nil)
+		if err != nil {
+			t.Fatalf("failed to create the request: %v", err)
+		}
+		req.Header.Set("Authorization", header)
+		resp, err := httpServer.Client().Do(req)
+		if err != nil {
+			t.Fatalf("failed to send the request: %v", err)
+		}
+		resp.Body.Close()
+		expectStatus(t, http.StatusUnauthorized, resp.StatusCode)
+	}
+	expectStatus(t, http.StatusOK, call(t, httpServer, http.MethodGet, "/users", adminToken, nil))
+}
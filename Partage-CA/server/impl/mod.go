@@ -6,68 +6,75 @@ import (
 	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/hex"
 	"encoding/pem"
 	"fmt"
+	"math/big"
 	"net"
 	"net/mail"
-	"net/smtp"
-	"os"
 	"partage-ca/server"
-	"strconv"
+	"partage-common/framing"
 	"sync"
 	"time"
 )
 
 type certificateAuthority struct {
 	listener      net.Listener
-	smtpAuth 	*smtp.Auth
+	verifier      server.VerificationProvider
 	//storage
+	db *store
+	//taken public keys and reserved e-mails (hashes), including the users who are verifying their e-mail
 	usersCatalog   map[[32]byte]struct{}
-	emailsCatalog map[string]struct{}
+	emailsCatalog map[[32]byte][32]byte //e-mail hash -> public key hash of its owner
 	catalogMutex  sync.RWMutex
 	
 	myCertificate *x509.Certificate
 	myPrivateKey  *rsa.PrivateKey
-	fpPublicKeys *os.File
-	fpEmails *os.File
 }
 
-func NewServer() server.Server {
+func NewServer(verifier server.VerificationProvider) server.Server {
 	//init TLS socket listener
-	l, cert, sk, auth,err := initTLSSocket(server.Addr)
+	l, cert, sk, err := initTLSSocket(server.Addr)
 	if err != nil {
 		fmt.Println("[ERROR] creating TLS socket listener...", err)
 		return nil
 	}
-	//load taken usernames from persistent memory
-	keys,emails,err := LoadUsers()
+	//load registered users from persistent memory
+	rt, err := RootDir()
 	if err != nil {
-		fmt.Println("[ERROR] reading from Public Keys file...", err)
+		fmt.Println("[ERROR] opening users store...", err)
 		return nil
 	}
-	fp,err:= OpenFileToAppend(server.UsersPath)
+	db,err := openStore(rt)
 	if err != nil {
-		fmt.Println("[ERROR] opening Public Keys file in append mode...", err)
+		fmt.Println("[ERROR] opening users store...", err)
 		return nil
 	}
-	fpEmails,err:= OpenFileToAppend(server.EmailsPath)
-	if err != nil {
-		fmt.Println("[ERROR] opening Public Keys file in append mode...", err)
-		return nil
+	return newCertificateAuthority(l, cert, sk, db, verifier)
+}
+
+// newCertificateAuthority returns a CA serving on the given TLS listener, whose catalogs are loaded from the given
+// store.
+func newCertificateAuthority(l net.Listener, cert *x509.Certificate, sk *rsa.PrivateKey, db *store, verifier server.VerificationProvider) *certificateAuthority {
+	keys := make(map[[32]byte]struct{})
+	emails := make(map[[32]byte][32]byte)
+	for _,user := range db.all(){
+		publicKeyHash:=hashFromHex(user.PublicKeyHash)
+		keys[publicKeyHash] = struct{}{}
+		if user.EmailReserved{
+			emails[hashFromHex(user.EmailHash)] = publicKeyHash
+		}
 	}
 
-	s := &certificateAuthority{
+	return &certificateAuthority{
 		listener:      l,
-		smtpAuth: auth,
+		verifier: verifier,
+		db: db,
 		usersCatalog:   keys,
 		emailsCatalog: emails,
 		myCertificate: cert,
 		myPrivateKey:  sk,
-		fpPublicKeys: fp,
-		fpEmails: fpEmails,
 	}
-
-	return s
 }
 
 func (s *certificateAuthority) Start() error {
@@ -91,8 +98,6 @@ func (s *certificateAuthority) Start() error {
 func (s *certificateAuthority) Stop() error {
 	fmt.Println("\nsmoothly stopping server...")
 	s.listener.Close()
-	s.fpPublicKeys.Close()
-	s.fpEmails.Close()
 	return nil
 }
 
@@ -131,6 +136,11 @@ func (s *certificateAuthority) handleTLSConn(conn *tls.Conn) {
 		return
 	}
 	clientPublicKeyHash:=Hash(clientPublicKeyBytes)
+	// Registered users (whose certificate we have signed) send requests instead of registering
+	if clientCert.CheckSignatureFrom(s.myCertificate)==nil{
+		s.handleRequest(conn,clientCert,clientPublicKey,clientPublicKeyHash)
+		return
+	}
 	fmt.Println("handling registration request ...")
 
 	// Get e-mail address from user's certificate
@@ -142,13 +152,14 @@ func (s *certificateAuthority) handleTLSConn(conn *tls.Conn) {
 			fmt.Println("[ERROR] marshaling {no email address} msg to send...", err)
 			return
 		}
-		_, err = conn.Write(msgBytes)
+		err = framing.WriteFrame(conn, msgBytes)
 		if err != nil {
 			fmt.Println("[ERROR] sending err message...", err)
 		}
 		return 
 	}
 	clientEmail:=clientCert.Subject.Organization[0]
+	clientEmailHash:=Hash([]byte(clientEmail))
 	if _,err:=mail.ParseAddress(clientEmail);err!=nil{
 		//invalid e-mail address
 		msg := &server.Message{Type: "ERROR", Payload: []byte("invalid e-mail address")}
@@ -157,7 +168,7 @@ func (s *certificateAuthority) handleTLSConn(conn *tls.Conn) {
 			fmt.Println("[ERROR] marshaling {no email address} msg to send...", err)
 			return
 		}
-		_, err = conn.Write(msgBytes)
+		err = framing.WriteFrame(conn, msgBytes)
 		if err != nil {
 			fmt.Println("[ERROR] sending err message...", err)
 		}
@@ -175,14 +186,14 @@ func (s *certificateAuthority) handleTLSConn(conn *tls.Conn) {
 			fmt.Println("[ERROR] marshaling {public key is taken} msg to send...", err)
 			return
 		}
-		_, err = conn.Write(msgBytes)
+		err = framing.WriteFrame(conn, msgBytes)
 		if err != nil {
 			fmt.Println("[ERROR] sending err message...", err)
 		}
 		return //...
 	}
 	// Check if email is not taken
-	if _, exists := s.emailsCatalog[clientEmail]; exists {
+	if _, exists := s.emailsCatalog[clientEmailHash]; exists {
 		s.catalogMutex.RUnlock()
 		// Send msg to client saying that public key is already taken
 		msg := &server.Message{Type: "ERROR", Payload: []byte("e-mail address is taken")}
@@ -191,7 +202,7 @@ func (s *certificateAuthority) handleTLSConn(conn *tls.Conn) {
 			fmt.Println("[ERROR] marshaling {email is taken} msg to send...", err)
 			return
 		}
-		_, err = conn.Write(msgBytes)
+		err = framing.WriteFrame(conn, msgBytes)
 		if err != nil {
 			fmt.Println("[ERROR] sending err message...", err)
 		}
@@ -201,92 +212,99 @@ func (s *certificateAuthority) handleTLSConn(conn *tls.Conn) {
 	// To avoid people registering with the same e-mail while CA is waiting for Verification Code
 	s.catalogMutex.Lock()
 	s.usersCatalog[clientPublicKeyHash] = struct{}{}
-	s.emailsCatalog[clientEmail] = struct{}{}
+	s.emailsCatalog[clientEmailHash] = clientPublicKeyHash
 	s.catalogMutex.Unlock()
 	fmt.Println("sending verification code to",clientEmail,"...")
-	// Tell user to check e-mail inbox
-	msg := &server.Message{Type: "WARNING", Payload: []byte("Check your "+clientEmail+" inbox for the Verification Code (valid for 4 minutes)")}
-	msgBytes, err := msg.Encode()
-	if err != nil {
-		fmt.Println("[ERROR] marshaling {check inbox} msg to send...", err)
-		return
-	}
-	_, err = conn.Write(msgBytes)
-	if err != nil {
-		fmt.Println("[ERROR] sending {check inbox} message...", err)
-		return
-	}
-
-	// Generate and Send Verification Code to user's e-mail (waits 4 minutes for verification code)
-	if err:=s.VerifyUser(clientEmail,conn); err!=nil{
-		//unable to verify
-		//close connection
-		conn.Close()
+	// Generate and Send Verification Code to user's e-mail (the provider may approve the e-mail right away)
+	challenge,err:=s.verifier.SendCode(clientEmail)
+	if err!=nil{
 		s.catalogMutex.Lock()
 		delete(s.usersCatalog,clientPublicKeyHash)
-		delete(s.emailsCatalog,clientEmail)
+		delete(s.emailsCatalog,clientEmailHash)
 		s.catalogMutex.Unlock()
-		fmt.Println("[ERROR] on receiving Verification Code from",clientEmail,"--->",err)
-		return 
+		fmt.Println("[ERROR] on sending Verification Code to",clientEmail,"--->",err)
+		msg := &server.Message{Type: "ERROR", Payload: []byte("could not verify e-mail address: "+err.Error())}
+		msgBytes, err := msg.Encode()
+		if err != nil {
+			fmt.Println("[ERROR] marshaling {could not verify} msg to send...", err)
+			return
+		}
+		err = framing.WriteFrame(conn, msgBytes)
+		if err != nil {
+			fmt.Println("[ERROR] sending err message...", err)
+		}
+		return
+	}
+	if challenge!=""{
+		// Tell user to check e-mail inbox
+		msg := &server.Message{Type: "WARNING", Payload: []byte("Check your "+clientEmail+" inbox for the Verification Code (valid for 4 minutes)")}
+		msgBytes, err := msg.Encode()
+		if err != nil {
+			fmt.Println("[ERROR] marshaling {check inbox} msg to send...", err)
+			return
+		}
+		err = framing.WriteFrame(conn, msgBytes)
+		if err != nil {
+			fmt.Println("[ERROR] sending {check inbox} message...", err)
+			return
+		}
+
+		// Wait for the Verification Code (4 minutes)
+		if err:=s.VerifyUser(challenge,conn); err!=nil{
+			//unable to verify
+			//close connection
+			conn.Close()
+			s.catalogMutex.Lock()
+			delete(s.usersCatalog,clientPublicKeyHash)
+			delete(s.emailsCatalog,clientEmailHash)
+			s.catalogMutex.Unlock()
+			fmt.Println("[ERROR] on receiving Verification Code from",clientEmail,"--->",err)
+			return 
+		}
 	}
 	fmt.Println("User e-mail was verified!")
 	s.catalogMutex.Lock()
 	// Sign client's certificate with CA!
 	clientCert.Subject.Organization=nil //remove e-mail from user's certificate
-	clientCertBytes, err := x509.CreateCertificate(rand.Reader, clientCert, s.myCertificate, clientPublicKey, s.myPrivateKey)
+	registration,serial,err:=s.signCertificate(clientCert,clientPublicKey,clientPublicKeyHash,clientCert.NotAfter)
 	if err != nil {
 		s.catalogMutex.Unlock()
 		fmt.Println("[ERROR] signing certificate...", err)
 		return
 	}
-	clientCertPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: clientCertBytes})
-	if clientCertPem == nil {
-		s.catalogMutex.Unlock()
-		fmt.Println("[ERROR] encoding certificate to PEM...", err)
-		return
-	}
-	// Sign client's public key with CA's private key
-	signature, err := rsa.SignPKCS1v15(rand.Reader, s.myPrivateKey, crypto.SHA256, clientPublicKeyHash[:])
-	if err != nil {
-		s.catalogMutex.Unlock()
-		fmt.Println("[ERROR] signing public key...", err)
-		return
-	}
 
-	// Record public key as taken
-	err = AppendToFile(clientPublicKeyHash[:],s.fpPublicKeys)
-	if err != nil {
-		s.catalogMutex.Unlock()
-		fmt.Println("[ERROR] storing public key in persistent-memory...", err)
-		return
-	}
-	// Record e-mail as taken
-	err = AppendToFile([]byte(clientEmail+"\n"),s.fpEmails)
+	// Record public key and e-mail as taken
+	err = s.db.put(server.User{
+		PublicKeyHash: hex.EncodeToString(clientPublicKeyHash[:]),
+		EmailHash: hex.EncodeToString(clientEmailHash[:]),
+		EmailReserved: true,
+		Status: server.StatusActive,
+		IssuedAt: time.Now().Unix(),
+		Serial: serial.String(),
+	})
 	if err != nil {
+		delete(s.usersCatalog,clientPublicKeyHash)
+		delete(s.emailsCatalog,clientEmailHash)
 		s.catalogMutex.Unlock()
-		fmt.Println("[ERROR] storing e-mail in persistent-memory...", err)
+		fmt.Println("[ERROR] storing user in persistent-memory...", err)
 		return
 	}
 	s.catalogMutex.Unlock()
 
-	// Prepare registration message to send (SignedCertificate,PublicKeySignature)
-	registration:=&server.Registration{
-		SignedCertificate: clientCertPem,
-		PublicKeySignature: signature,
-	}
+	// Send registration message (SignedCertificate,PublicKeySignature)
 	payload,err:=registration.Encode()
 	if err!=nil{
 		fmt.Println("[ERROR] marshaling payload to json...", err)
 		return
 	}
-	msg = &server.Message{Type: "OK", Payload: payload}
-	msgBytes, err = msg.Encode()
+	msg := &server.Message{Type: "OK", Payload: payload}
+	msgBytes, err := msg.Encode()
 	if err != nil {
 		fmt.Println("[ERROR] marshaling msg to json...", err)
 		return
 	}
 	// Send signed certificate to client
-	_, err = conn.Write(msgBytes)
+	err = framing.WriteFrame(conn, msgBytes)
 	if err != nil {
 		fmt.Println("[ERROR] writing to TLS connection...", err)
 		return
@@ -296,11 +314,45 @@ func (s *certificateAuthority) handleTLSConn(conn *tls.Conn) {
 	return
 }
 
-func initTLSSocket(address string) (net.Listener, *x509.Certificate, *rsa.PrivateKey, *smtp.Auth,error) {
+// signCertificate signs the certificate of a user, valid until notAfter but for at most server.MaxValidity, and the
+// user's public key. Returns the serial number of the certificate.
+func (s *certificateAuthority) signCertificate(clientCert *x509.Certificate, clientPublicKey *rsa.PublicKey, clientPublicKeyHash [32]byte, notAfter time.Time) (*server.Registration, *big.Int, error) {
+	//each certificate needs a unique serial number, including the renewed ones
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	template := *clientCert
+	template.SerialNumber = serialNumber
+	template.NotBefore = time.Now()
+	template.NotAfter = notAfter
+	if maxNotAfter := template.NotBefore.Add(server.MaxValidity); template.NotAfter.After(maxNotAfter) {
+		template.NotAfter = maxNotAfter
+	}
+	clientCertBytes, err := x509.CreateCertificate(rand.Reader, &template, s.myCertificate, clientPublicKey, s.myPrivateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	clientCertPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: clientCertBytes})
+	if clientCertPem == nil {
+		return nil, nil, fmt.Errorf("could not encode certificate to PEM")
+	}
+	// Sign client's public key with CA's private key
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.myPrivateKey, crypto.SHA256, clientPublicKeyHash[:])
+	if err != nil {
+		return nil, nil, err
+	}
+	return &server.Registration{
+		SignedCertificate: clientCertPem,
+		PublicKeySignature: signature,
+	}, serialNumber, nil
+}
+
+func initTLSSocket(address string) (net.Listener, *x509.Certificate, *rsa.PrivateKey, error) {
 	//load CA certificate from memory or generate one (if no certificate is found)
 	certificate, err := LoadCertificate(true)
 	if err != nil {
-		return nil, nil, nil,nil, err
+		return nil, nil, nil, err
 	}
 	// Create tls config with loaded certificate
 	cfg := &tls.Config{Certificates: []tls.Certificate{*certificate}, ClientAuth: tls.RequestClientCert, InsecureSkipVerify: true}
@@ -308,50 +360,25 @@ func initTLSSocket(address string) (net.Listener, *x509.Certificate, *rsa.Privat
 	// Create the listening TCP/TLS socket.
 	listener, err := tls.Listen("tcp", address, cfg)
 	if err != nil {
-		return nil, nil, nil, nil,err
+		return nil, nil, nil, err
 	}
 	x509Cert, _ := x509.ParseCertificate(certificate.Certificate[0])
 	sk := certificate.PrivateKey.(*rsa.PrivateKey)
-	//smtp server auth
-	auth:=smtp.PlainAuth("",server.SmtpUsername,server.SmtpPassword,server.SmtpHost)
-	return listener, x509Cert, sk,&auth,nil
+	return listener, x509Cert, sk, nil
 }
 
-func (s *certificateAuthority) SendVerificationCode(email string) (string,error){
-	var challenge string
-	if server.TESTING{
-		challenge=strconv.Itoa(12348765)
-		return challenge,nil
-	}else{
-		challenge=strconv.Itoa(GenerateChallenge())	
-		msg := []byte("From: "+server.SmtpUsername+"\r\n" +
-        "To: "+email+"\r\n" +
-        "Subject: Partage Verification Code\r\n\r\n" +
-        "Welcome to Partage! Here you have your Verification Code: "+challenge+"\r\n")
-		err := smtp.SendMail(server.SmtpHost+":"+server.SmtpPort,*s.smtpAuth,server.SmtpUsername,[]string{email},msg)
-		return challenge,err
-	}
-	
-} 
-
-func (s *certificateAuthority) VerifyUser(email string,conn *tls.Conn) error{
-	challenge,err:=s.SendVerificationCode(email)
-	if err!=nil{
-		return err
-	}
-
+func (s *certificateAuthority) VerifyUser(challenge string,conn *tls.Conn) error{
 	deadline := time.Now().Add(time.Second*60*4) //4 minutes timeout
-	err = conn.SetReadDeadline(deadline)
+	err := conn.SetReadDeadline(deadline)
 	if err != nil {
 		return err
 	}
-	buf:=make([]byte,4096)
-	size, err := conn.Read(buf)
+	frame, err := framing.ReadFrame(conn)
 	if err != nil {
-		return err 
+		return err
 	}
-	var msg server.Message 
-	err = msg.Decode(buf[:size])
+	var msg server.Message
+	err = msg.Decode(frame)
 	if err != nil {
 		return err 
 	}
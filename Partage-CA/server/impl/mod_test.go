@@ -0,0 +1,186 @@
+package impl
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"partage-ca/server"
+	"partage-common/framing"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestCA starts a CA with a fresh self-signed certificate on a random port. Its store lives in a temporary
+// directory.
+func newTestCA(t *testing.T, verifier server.VerificationProvider) *certificateAuthority {
+	t.Helper()
+	db := &store{path: filepath.Join(t.TempDir(), "ca.db"), users: make(map[[32]byte]server.User)}
+	return startTestCA(t, db, verifier)
+}
+
+// startTestCA starts a CA with a fresh self-signed certificate on a random port, on top of the given store.
+func startTestCA(t *testing.T, db *store, verifier server.VerificationProvider) *certificateAuthority {
+	t.Helper()
+	certificate, err := LoadCertificate(false)
+	if err != nil {
+		t.Fatalf("failed to generate the CA certificate: %v", err)
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{*certificate}, ClientAuth: tls.RequestClientCert, InsecureSkipVerify: true}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", cfg)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	x509Cert, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		t.Fatalf("failed to parse the CA certificate: %v", err)
+	}
+	ca := newCertificateAuthority(listener, x509Cert, certificate.PrivateKey.(*rsa.PrivateKey), db, verifier)
+	ca.Start()
+	t.Cleanup(func() { ca.Stop() })
+	return ca
+}
+
+// testUser is a peer that registers with the CA, with a self-signed certificate that carries its e-mail address.
+type testUser struct {
+	email         string
+	key           *rsa.PrivateKey
+	certificate   tls.Certificate
+	publicKeyHash [32]byte
+}
+
+func newTestUser(t *testing.T, email string) *testUser {
+	t.Helper()
+	key, err := generateKey()
+	if err != nil {
+		t.Fatalf("failed to generate a key: %v", err)
+	}
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		t.Fatalf("failed to generate a serial number: %v", err)
+	}
+	template := x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject:      pkix.Name{Organization: []string{email}},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create a certificate: %v", err)
+	}
+	_, publicKeyBytes, err := PublicKeyToString(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to marshal the public key: %v", err)
+	}
+	return &testUser{
+		email:         email,
+		key:           key,
+		certificate:   tls.Certificate{Certificate: [][]byte{certBytes}, PrivateKey: key},
+		publicKeyHash: Hash(publicKeyBytes),
+	}
+}
+
+// dial opens a connection to the CA with the certificate of the user, which starts its registration.
+func (u *testUser) dial(t *testing.T, ca *certificateAuthority) *tls.Conn {
+	t.Helper()
+	conn, err := tls.Dial("tcp", ca.GetAddress(), &tls.Config{Certificates: []tls.Certificate{u.certificate}, InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("failed to dial the CA: %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func readMessage(t *testing.T, conn *tls.Conn) server.Message {
+	t.Helper()
+	frame, err := framing.ReadFrame(conn)
+	if err != nil {
+		t.Fatalf("failed to read a message from the CA: %v", err)
+	}
+	var msg server.Message
+	err = msg.Decode(frame)
+	if err != nil {
+		t.Fatalf("failed to decode a message from the CA: %v", err)
+	}
+	return msg
+}
+
+func writeMessage(t *testing.T, conn *tls.Conn, msgType string, payload []byte) {
+	t.Helper()
+	msg := &server.Message{Type: msgType, Payload: payload}
+	msgBytes, err := msg.Encode()
+	if err != nil {
+		t.Fatalf("failed to encode a message: %v", err)
+	}
+	err = framing.WriteFrame(conn, msgBytes)
+	if err != nil {
+		t.Fatalf("failed to send a message to the CA: %v", err)
+	}
+}
+
+// checkRegistered checks that the CA has replied with a certificate it has signed for the user, and that the user
+// is recorded with its e-mail address.
+func checkRegistered(t *testing.T, ca *certificateAuthority, user *testUser, msg server.Message) {
+	t.Helper()
+	if msg.Type != "OK" {
+		t.Fatalf("expected the registration of %s, got %s: %s", user.email, msg.Type, msg.Payload)
+	}
+	var registration server.Registration
+	err := registration.Decode(msg.Payload)
+	if err != nil {
+		t.Fatalf("failed to decode the registration: %v", err)
+	}
+	block, _ := pem.Decode(registration.SignedCertificate)
+	if block == nil {
+		t.Fatalf("the signed certificate isn't PEM-encoded")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("failed to parse the signed certificate: %v", err)
+	}
+	if cert.CheckSignatureFrom(ca.myCertificate) != nil {
+		t.Fatalf("the certificate isn't signed by the CA")
+	}
+	if !cert.PublicKey.(*rsa.PublicKey).Equal(&user.key.PublicKey) {
+		t.Fatalf("the certificate isn't issued for the public key of the user")
+	}
+	registered, exists := ca.LookupUser(user.publicKeyHash)
+	if !exists || registered.Status != server.StatusActive {
+		t.Fatalf("the user isn't recorded as active: %+v", registered)
+	}
+	owner, exists := ca.LookupEmail(user.email)
+	if !exists || owner.PublicKeyHash != registered.PublicKeyHash {
+		t.Fatalf("the e-mail address isn't reserved by the user: %+v", owner)
+	}
+}
+
+// checkNotRegistered checks that neither the public key nor the e-mail address of the user are taken.
+func checkNotRegistered(t *testing.T, ca *certificateAuthority, user *testUser) {
+	t.Helper()
+	// The CA cleans up after the connection is closed.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		ca.catalogMutex.RLock()
+		_, keyTaken := ca.usersCatalog[user.publicKeyHash]
+		_, emailTaken := ca.emailsCatalog[Hash([]byte(user.email))]
+		ca.catalogMutex.RUnlock()
+		if !keyTaken && !emailTaken {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the registration of %s is still pending", user.email)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, exists := ca.LookupUser(user.publicKeyHash); exists {
+		t.Fatalf("the user %s is registered", user.email)
+	}
+}
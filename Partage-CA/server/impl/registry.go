@@ -0,0 +1,56 @@
+package impl
+
+import (
+	"errors"
+	"fmt"
+	"partage-ca/server"
+)
+
+// Users implements server.Registry
+func (s *certificateAuthority) Users() []server.User {
+	s.catalogMutex.RLock()
+	defer s.catalogMutex.RUnlock()
+	return s.db.all()
+}
+
+// LookupUser implements server.Registry
+func (s *certificateAuthority) LookupUser(publicKeyHash [32]byte) (server.User, bool) {
+	s.catalogMutex.RLock()
+	defer s.catalogMutex.RUnlock()
+	return s.db.get(publicKeyHash)
+}
+
+// LookupEmail implements server.Registry. The e-mail addresses of the users who are still verifying them are not
+// found.
+func (s *certificateAuthority) LookupEmail(email string) (server.User, bool) {
+	s.catalogMutex.RLock()
+	defer s.catalogMutex.RUnlock()
+	publicKeyHash, exists := s.emailsCatalog[Hash([]byte(email))]
+	if !exists {
+		return server.User{}, false
+	}
+	return s.db.get(publicKeyHash)
+}
+
+// UnreserveEmail implements server.Registry. The public key of the former owner stays registered.
+func (s *certificateAuthority) UnreserveEmail(email string) error {
+	s.catalogMutex.Lock()
+	defer s.catalogMutex.Unlock()
+	emailHash := Hash([]byte(email))
+	publicKeyHash, exists := s.emailsCatalog[emailHash]
+	if !exists {
+		return errors.New("e-mail address is not reserved")
+	}
+	user, registered := s.db.get(publicKeyHash)
+	if !registered {
+		return errors.New("e-mail address is being verified")
+	}
+	user.EmailReserved = false
+	err := s.db.put(user)
+	if err != nil {
+		return err
+	}
+	delete(s.emailsCatalog, emailHash)
+	fmt.Println("[UNRESERVE] e-mail", email, "is now free!")
+	return nil
+}
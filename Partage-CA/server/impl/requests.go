@@ -0,0 +1,130 @@
+package impl
+
+import (
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"partage-ca/server"
+	"partage-common/framing"
+	"time"
+)
+
+// handleRequest serves a registered user, i.e., a user whose certificate is signed by the CA. The user has proven the
+// possession of its private key during the TLS handshake, and sends one of the following requests:
+//  - CRL to get the latest revocation list
+//  - REVOKE to revoke its own certificate, e.g., because its private key has leaked
+//  - RENEW to get a new certificate for the same public key before the current one expires (no e-mail verification)
+func (s *certificateAuthority) handleRequest(conn *tls.Conn, clientCert *x509.Certificate, clientPublicKey *rsa.PublicKey, clientPublicKeyHash [32]byte) {
+	err := conn.SetReadDeadline(time.Now().Add(time.Second * 3))
+	if err != nil {
+		fmt.Println("[ERROR] setting read timeout for conn...", err)
+		return
+	}
+	frame, err := framing.ReadFrame(conn)
+	if err != nil {
+		fmt.Println("[ERROR] reading request of registered user...", err)
+		return
+	}
+	var request server.Message
+	err = request.Decode(frame)
+	if err != nil {
+		fmt.Println("[ERROR] malformed request of registered user...", err)
+		return
+	}
+	switch request.Type {
+	case "CRL":
+		s.sendRevocationList(conn)
+	case "REVOKE":
+		err = s.Revoke(clientPublicKeyHash)
+		if err != nil {
+			fmt.Println("[ERROR] storing revoked public key in persistent-memory...", err)
+			s.sendMessage(conn, "ERROR", []byte("could not revoke the certificate"))
+			return
+		}
+		s.sendRevocationList(conn)
+	case "RENEW":
+		s.renewCertificate(conn, clientCert, clientPublicKey, clientPublicKeyHash)
+	default:
+		s.sendMessage(conn, "ERROR", []byte("unknown request: "+request.Type))
+	}
+}
+
+// renewCertificate signs a new certificate for the public key of a registered user, which hasn't been revoked. The
+// certificate may already have expired.
+func (s *certificateAuthority) renewCertificate(conn *tls.Conn, clientCert *x509.Certificate, clientPublicKey *rsa.PublicKey, clientPublicKeyHash [32]byte) {
+	s.catalogMutex.RLock()
+	user, registered := s.db.get(clientPublicKeyHash)
+	s.catalogMutex.RUnlock()
+	if !registered {
+		s.sendMessage(conn, "ERROR", []byte("unknown user"))
+		return
+	}
+	if user.Status == server.StatusRevoked {
+		s.sendMessage(conn, "ERROR", []byte("certificate has been revoked"))
+		return
+	}
+	registration, serial, err := s.signCertificate(clientCert, clientPublicKey, clientPublicKeyHash, time.Now().Add(server.MaxValidity))
+	if err != nil {
+		fmt.Println("[ERROR] signing certificate...", err)
+		s.sendMessage(conn, "ERROR", []byte("could not sign the certificate"))
+		return
+	}
+	// Record the new certificate (the user may have been revoked in the meantime)
+	s.catalogMutex.Lock()
+	user, _ = s.db.get(clientPublicKeyHash)
+	if user.Status == server.StatusRevoked {
+		s.catalogMutex.Unlock()
+		s.sendMessage(conn, "ERROR", []byte("certificate has been revoked"))
+		return
+	}
+	user.IssuedAt = time.Now().Unix()
+	user.Serial = serial.String()
+	err = s.db.put(user)
+	s.catalogMutex.Unlock()
+	if err != nil {
+		fmt.Println("[ERROR] storing user in persistent-memory...", err)
+		s.sendMessage(conn, "ERROR", []byte("could not store the certificate"))
+		return
+	}
+	payload, err := registration.Encode()
+	if err != nil {
+		fmt.Println("[ERROR] marshaling payload to json...", err)
+		return
+	}
+	if s.sendMessage(conn, "OK", payload) {
+		fmt.Println("[RENEW] user certificate is now renewed!")
+	}
+}
+
+// sendRevocationList sends the latest revocation list.
+func (s *certificateAuthority) sendRevocationList(conn *tls.Conn) {
+	list, err := s.RevocationList()
+	if err != nil {
+		fmt.Println("[ERROR] signing revocation list...", err)
+		s.sendMessage(conn, "ERROR", []byte("could not sign the revocation list"))
+		return
+	}
+	payload, err := list.Encode()
+	if err != nil {
+		fmt.Println("[ERROR] marshaling revocation list to json...", err)
+		return
+	}
+	s.sendMessage(conn, "CRL", payload)
+}
+
+// sendMessage sends a message of the given type to the user. Returns whether it was sent.
+func (s *certificateAuthority) sendMessage(conn *tls.Conn, msgType string, payload []byte) bool {
+	msg := &server.Message{Type: msgType, Payload: payload}
+	msgBytes, err := msg.Encode()
+	if err != nil {
+		fmt.Println("[ERROR] marshaling", msgType, "msg to send...", err)
+		return false
+	}
+	err = framing.WriteFrame(conn, msgBytes)
+	if err != nil {
+		fmt.Println("[ERROR] sending", msgType, "message...", err)
+		return false
+	}
+	return true
+}
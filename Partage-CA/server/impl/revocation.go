@@ -0,0 +1,58 @@
+package impl
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"partage-ca/server"
+	"time"
+)
+
+// Revoke revokes the public key of a registered user with the given hash. Revoking the same key twice does nothing.
+func (s *certificateAuthority) Revoke(publicKeyHash [32]byte) error {
+	s.catalogMutex.Lock()
+	defer s.catalogMutex.Unlock()
+	user, exists := s.db.get(publicKeyHash)
+	if !exists {
+		return errors.New("unknown user")
+	}
+	if user.Status == server.StatusRevoked {
+		return nil
+	}
+	user.Status = server.StatusRevoked
+	user.RevokedAt = time.Now().Unix()
+	err := s.db.put(user)
+	if err != nil {
+		return err
+	}
+	fmt.Println("[REVOKE] public key", fmt.Sprintf("%x", publicKeyHash), "is now revoked!")
+	return nil
+}
+
+// RevocationList returns the current revocation list signed by the CA. Its serial is the number of revoked keys, so
+// that it increases with every revocation, even across restarts.
+func (s *certificateAuthority) RevocationList() (*server.RevocationList, error) {
+	s.catalogMutex.RLock()
+	revoked := s.db.revoked()
+	s.catalogMutex.RUnlock()
+	list := &server.RevocationList{
+		Serial:    uint64(len(revoked)),
+		Timestamp: time.Now().Unix(),
+		Revoked:   revoked,
+	}
+	digest := list.Digest()
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.myPrivateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	list.Signature = signature
+	return list, nil
+}
+
+// isRevoked returns whether the public key with the given hash is revoked. The caller must hold the catalog mutex.
+func (s *certificateAuthority) isRevoked(publicKeyHash [32]byte) bool {
+	user, exists := s.db.get(publicKeyHash)
+	return exists && user.Status == server.StatusRevoked
+}
@@ -0,0 +1,278 @@
+package impl
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"partage-ca/server"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const storeVersion = 1
+
+// store keeps the registered users of the CA in a single file, which is replaced atomically on every change: the new
+// content is written to a temporary file and synced, before being renamed over the old file. A crash leaves either the
+// old or the new content, never a mix of both. The store isn't safe for concurrent use (the CA holds its catalog mutex).
+type store struct {
+	path  string
+	users map[[32]byte]server.User
+}
+
+// storeFile is the content of the store file.
+type storeFile struct {
+	Version int
+	Users   []server.User
+}
+
+// openStore loads the store of the CA found under the given root directory, after migrating the legacy flat files
+// into it on first start.
+func openStore(rt string) (*store, error) {
+	st := &store{path: rt + server.StorePath, users: make(map[[32]byte]server.User)}
+	data, err := os.ReadFile(st.path)
+	if os.IsNotExist(err) {
+		return st, st.migrate(rt)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var content storeFile
+	err = json.Unmarshal(data, &content)
+	if err != nil {
+		return nil, fmt.Errorf("corrupted store %s: %w", st.path, err)
+	}
+	if content.Version != storeVersion {
+		return nil, fmt.Errorf("unsupported store version %d", content.Version)
+	}
+	for _, user := range content.Users {
+		st.users[hashFromHex(user.PublicKeyHash)] = user
+	}
+	fmt.Println("finished loading", len(st.users), "users from", st.path, "!")
+	return st, nil
+}
+
+// get returns the user owning the public key with the given hash.
+func (st *store) get(publicKeyHash [32]byte) (server.User, bool) {
+	user, exists := st.users[publicKeyHash]
+	return user, exists
+}
+
+// all returns the users sorted by issue time.
+func (st *store) all() []server.User {
+	users := make([]server.User, 0, len(st.users))
+	for _, user := range st.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].IssuedAt != users[j].IssuedAt {
+			return users[i].IssuedAt < users[j].IssuedAt
+		}
+		return users[i].PublicKeyHash < users[j].PublicKeyHash
+	})
+	return users
+}
+
+// revoked returns the hashes of the revoked public keys, in the order they were revoked.
+func (st *store) revoked() [][32]byte {
+	var users []server.User
+	for _, user := range st.users {
+		if user.Status == server.StatusRevoked {
+			users = append(users, user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].RevokedAt != users[j].RevokedAt {
+			return users[i].RevokedAt < users[j].RevokedAt
+		}
+		return users[i].PublicKeyHash < users[j].PublicKeyHash
+	})
+	revoked := make([][32]byte, len(users))
+	for i, user := range users {
+		revoked[i] = hashFromHex(user.PublicKeyHash)
+	}
+	return revoked
+}
+
+// put adds or replaces the given users, and saves the store. Nothing changes if the store can't be saved.
+func (st *store) put(users ...server.User) error {
+	previous := make(map[[32]byte]*server.User, len(users))
+	for _, user := range users {
+		publicKeyHash := hashFromHex(user.PublicKeyHash)
+		if _, saved := previous[publicKeyHash]; !saved {
+			previous[publicKeyHash] = nil
+			if old, exists := st.users[publicKeyHash]; exists {
+				previous[publicKeyHash] = &old
+			}
+		}
+		st.users[publicKeyHash] = user
+	}
+	err := st.save()
+	if err != nil {
+		for publicKeyHash, old := range previous {
+			if old == nil {
+				delete(st.users, publicKeyHash)
+			} else {
+				st.users[publicKeyHash] = *old
+			}
+		}
+	}
+	return err
+}
+
+// save atomically replaces the store file with the current content.
+func (st *store) save() error {
+	data, err := json.Marshal(storeFile{Version: storeVersion, Users: st.all()})
+	if err != nil {
+		return err
+	}
+	tmpPath := st.path + ".tmp"
+	fp, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = fp.Write(data)
+	if err == nil {
+		err = fp.Sync()
+	}
+	closeErr := fp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	err = os.Rename(tmpPath, st.path)
+	if err != nil {
+		return err
+	}
+	// Sync the directory, so that the rename itself survives a crash
+	dir, err := os.Open(filepath.Dir(st.path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// migrate imports the legacy flat files into the store, and renames them with a .migrated suffix once the store is
+// saved. The users and emails files were appended together on each registration, so their entries pair up in order.
+// The public keys without e-mail address, whose registration was interrupted by a crash, are dropped, as well as the
+// torn entries at the end of the files. An e-mail address can only be registered again once it has been freed, so it
+// is still reserved by its latest registrant if it was registered more times than freed.
+func (st *store) migrate(rt string) error {
+	legacyPaths := []string{server.UsersPath, server.EmailsPath, server.UnreservedPath, server.RevokedPath}
+	var migrated []string
+	for _, path := range legacyPaths {
+		if _, err := os.Stat(rt + path); err == nil {
+			migrated = append(migrated, rt+path)
+		}
+	}
+	hashes, err := readLegacyHashes(rt + server.UsersPath)
+	if err != nil {
+		return err
+	}
+	emails, err := readLegacyLines(rt + server.EmailsPath)
+	if err != nil {
+		return err
+	}
+	unreserved, err := readLegacyLines(rt + server.UnreservedPath)
+	if err != nil {
+		return err
+	}
+	revoked, err := readLegacyHashes(rt + server.RevokedPath)
+	if err != nil {
+		return err
+	}
+
+	registrations := make(map[string]int)
+	owners := make(map[string][32]byte)
+	for i := 0; i < len(hashes) && i < len(emails); i++ {
+		registrations[emails[i]]++
+		owners[emails[i]] = hashes[i]
+	}
+	for _, email := range unreserved {
+		registrations[email]--
+	}
+	for i := 0; i < len(hashes) && i < len(emails); i++ {
+		emailHash := Hash([]byte(emails[i]))
+		st.users[hashes[i]] = server.User{
+			PublicKeyHash: hex.EncodeToString(hashes[i][:]),
+			EmailHash:     hex.EncodeToString(emailHash[:]),
+			EmailReserved: registrations[emails[i]] > 0 && owners[emails[i]] == hashes[i],
+			Status:        server.StatusActive,
+		}
+	}
+	now := time.Now().Unix()
+	for _, publicKeyHash := range revoked {
+		user, exists := st.users[publicKeyHash]
+		if !exists {
+			user = server.User{PublicKeyHash: hex.EncodeToString(publicKeyHash[:])}
+		}
+		user.Status = server.StatusRevoked
+		user.RevokedAt = now
+		st.users[publicKeyHash] = user
+	}
+
+	err = st.save()
+	if err != nil {
+		return err
+	}
+	for _, path := range migrated {
+		err = os.Rename(path, path+".migrated")
+		if err != nil {
+			return err
+		}
+	}
+	if len(migrated) != 0 {
+		fmt.Println("migrated", len(st.users), "users from the legacy files into", st.path, "!")
+	}
+	return nil
+}
+
+// readLegacyHashes reads a legacy file of 32-bytes hashes. A missing file is empty.
+func readLegacyHashes(path string) ([][32]byte, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var hashes [][32]byte
+	for i := 0; i+32 <= len(data); i += 32 {
+		var hash [32]byte
+		copy(hash[:], data[i:i+32])
+		hashes = append(hashes, hash)
+	}
+	return hashes, nil
+}
+
+// readLegacyLines reads a legacy file of newline-terminated entries. A missing file is empty.
+func readLegacyLines(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	lines := bytes.Split(data, []byte("\n"))
+	// The last entry is either empty, or torn by a crash
+	var entries []string
+	for _, line := range lines[:len(lines)-1] {
+		entries = append(entries, string(line))
+	}
+	return entries, nil
+}
+
+// hashFromHex decodes a hex-encoded hash of the store.
+func hashFromHex(s string) [32]byte {
+	var hash [32]byte
+	data, _ := hex.DecodeString(s)
+	copy(hash[:], data)
+	return hash
+}
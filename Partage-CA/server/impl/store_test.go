@@ -0,0 +1,190 @@
+package impl
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"partage-ca/server"
+	"path/filepath"
+	"testing"
+)
+
+// newTestRoot returns a root directory with an empty storage directory.
+func newTestRoot(t *testing.T) string {
+	t.Helper()
+	rt := t.TempDir() + string(filepath.Separator)
+	err := os.MkdirAll(rt+server.StorageDir, 0755)
+	if err != nil {
+		t.Fatalf("failed to create the storage directory: %v", err)
+	}
+	return rt
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	err := os.WriteFile(path, data, 0644)
+	if err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+}
+
+func expectUser(t *testing.T, st *store, publicKeyHash [32]byte, email string, reserved bool, status string) {
+	t.Helper()
+	user, exists := st.get(publicKeyHash)
+	if !exists {
+		t.Fatalf("the user %x is missing", publicKeyHash)
+	}
+	emailHash := Hash([]byte(email))
+	if user.EmailHash != hex.EncodeToString(emailHash[:]) || user.EmailReserved != reserved || user.Status != status {
+		t.Fatalf("unexpected user %x: %+v", publicKeyHash, user)
+	}
+}
+
+func TestMigrateLegacyFiles(t *testing.T) {
+	rt := newTestRoot(t)
+	keys := make([][32]byte, 4)
+	for i := range keys {
+		keys[i] = Hash([]byte{byte(i)})
+	}
+	// alice frees her e-mail address and registers it again with a new key, bob is revoked, and the registration
+	// of the last key was interrupted by a crash while its e-mail address was being written
+	writeFile(t, rt+server.UsersPath, append(bytes.Join([][]byte{keys[0][:], keys[1][:], keys[2][:], keys[3][:]}, nil), 1, 2, 3))
+	writeFile(t, rt+server.EmailsPath, []byte("alice@example.com\nbob@example.com\nalice@example.com\ncar"))
+	writeFile(t, rt+server.UnreservedPath, []byte("alice@example.com\n"))
+	writeFile(t, rt+server.RevokedPath, keys[1][:])
+
+	st, err := openStore(rt)
+	if err != nil {
+		t.Fatalf("failed to migrate the store: %v", err)
+	}
+	check := func(st *store) {
+		t.Helper()
+		if len(st.users) != 3 {
+			t.Fatalf("expected 3 users, got %d", len(st.users))
+		}
+		expectUser(t, st, keys[0], "alice@example.com", false, server.StatusActive)
+		expectUser(t, st, keys[1], "bob@example.com", true, server.StatusRevoked)
+		expectUser(t, st, keys[2], "alice@example.com", true, server.StatusActive)
+		if revoked := st.revoked(); len(revoked) != 1 || revoked[0] != keys[1] {
+			t.Fatalf("unexpected revoked keys: %x", revoked)
+		}
+	}
+	check(st)
+
+	for _, path := range []string{server.UsersPath, server.EmailsPath, server.UnreservedPath, server.RevokedPath} {
+		if _, err := os.Stat(rt + path); !os.IsNotExist(err) {
+			t.Fatalf("%s wasn't moved away", path)
+		}
+		if _, err := os.Stat(rt + path + ".migrated"); err != nil {
+			t.Fatalf("%s wasn't kept as %s.migrated: %v", path, path, err)
+		}
+	}
+
+	// the migrated store is read back from its own file
+	st, err = openStore(rt)
+	if err != nil {
+		t.Fatalf("failed to open the migrated store: %v", err)
+	}
+	check(st)
+
+	// and the CA finds the reserved e-mail addresses of the users
+	ca := startTestCA(t, st, NewAllowlistProvider(nil))
+	for email, publicKeyHash := range map[string][32]byte{"alice@example.com": keys[2], "bob@example.com": keys[1]} {
+		owner, exists := ca.LookupEmail(email)
+		if !exists || owner.PublicKeyHash != hex.EncodeToString(publicKeyHash[:]) {
+			t.Fatalf("%s isn't reserved by %x: %+v", email, publicKeyHash, owner)
+		}
+	}
+}
+
+func TestOpenEmptyStore(t *testing.T) {
+	rt := newTestRoot(t)
+	st, err := openStore(rt)
+	if err != nil {
+		t.Fatalf("failed to open the store: %v", err)
+	}
+	if len(st.users) != 0 {
+		t.Fatalf("expected an empty store, got %d users", len(st.users))
+	}
+
+	user := server.User{PublicKeyHash: hex.EncodeToString(make([]byte, 32)), Status: server.StatusActive, IssuedAt: 1}
+	err = st.put(user)
+	if err != nil {
+		t.Fatalf("failed to save the store: %v", err)
+	}
+	st, err = openStore(rt)
+	if err != nil {
+		t.Fatalf("failed to open the store: %v", err)
+	}
+	if saved, _ := st.get([32]byte{}); saved != user {
+		t.Fatalf("expected %+v to be read back, got %+v", user, saved)
+	}
+}
+
+func TestOpenCorruptedStore(t *testing.T) {
+	contents := map[string]string{
+		"corrupted":   `{"Version":1,"Users":[`,
+		"unsupported": `{"Version":2,"Users":[]}`,
+	}
+	for name, content := range contents {
+		rt := newTestRoot(t)
+		writeFile(t, rt+server.StorePath, []byte(content))
+		_, err := openStore(rt)
+		if err == nil {
+			t.Fatalf("expected an error for a %s store", name)
+		}
+	}
+
+	// the store can't be read
+	rt := newTestRoot(t)
+	err := os.Mkdir(rt+server.StorePath, 0755)
+	if err != nil {
+		t.Fatalf("failed to create a directory: %v", err)
+	}
+	_, err = openStore(rt)
+	if err == nil {
+		t.Fatalf("expected an error for a store that can't be read")
+	}
+}
+
+func TestStoreWriteFailure(t *testing.T) {
+	rt := newTestRoot(t)
+	st, err := openStore(rt)
+	if err != nil {
+		t.Fatalf("failed to open the store: %v", err)
+	}
+	alice := server.User{PublicKeyHash: hex.EncodeToString(make([]byte, 32)), Status: server.StatusActive}
+	err = st.put(alice)
+	if err != nil {
+		t.Fatalf("failed to save the store: %v", err)
+	}
+
+	// nothing changes in memory nor on disk when the store can't be saved
+	path := st.path
+	st.path = filepath.Join(rt, "missing", "ca.db")
+	revoked := alice
+	revoked.Status = server.StatusRevoked
+	bob := server.User{PublicKeyHash: hex.EncodeToString(bytes.Repeat([]byte{1}, 32)), Status: server.StatusActive}
+	err = st.put(revoked, bob)
+	if err == nil {
+		t.Fatalf("expected the store to fail to save")
+	}
+	if user, _ := st.get(hashFromHex(alice.PublicKeyHash)); user != alice {
+		t.Fatalf("expected %+v to be restored, got %+v", alice, user)
+	}
+	if _, exists := st.get(hashFromHex(bob.PublicKeyHash)); exists {
+		t.Fatalf("expected %+v to be dropped", bob)
+	}
+
+	st.path = path
+	st, err = openStore(rt)
+	if err != nil {
+		t.Fatalf("failed to open the store: %v", err)
+	}
+	if len(st.users) != 1 {
+		t.Fatalf("expected 1 user on disk, got %d", len(st.users))
+	}
+	if user, _ := st.get(hashFromHex(alice.PublicKeyHash)); user != alice {
+		t.Fatalf("expected %+v on disk, got %+v", alice, user)
+	}
+}
@@ -1,50 +1,35 @@
 package impl
 
 import (
-	"bytes"
 	//"errors"
+	"crypto/rand"
 	"fmt"
-	"math/rand"
+	"math/big"
 	"os"
-	"partage-ca/server"
 	"strings"
 )
 
-func LoadUsers() (map[[32]byte]struct{},map[string]struct{}, error) {
-	wd, _ := os.Getwd()
-	rt := wd[:strings.Index(wd, "Partage-CA")]
-	fmt.Println("loading taken public keys from", rt+server.UsersPath, "file...")
-	windowSize:=32 //bytes
-	users := make(map[[32]byte]struct{})
-	emails:=make(map[string]struct{})
-	data, err := os.ReadFile(rt+server.UsersPath)
-	usersCount:=0
-	emailsCount:=0
-	if err == nil {
-		var hash [32]byte
-		for i:=0;i<=len(data)-windowSize;i+=windowSize{
-			copy(hash[:], data[i:i+windowSize])
-			users[hash] = struct{}{}
-			usersCount++
-		}
+// RootDir returns the directory that contains the Partage-CA checkout we run from, under which the storage of the CA
+// is found.
+func RootDir() (string, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", err
 	}
-	data, err = os.ReadFile(rt+server.EmailsPath)
-	if err == nil {
-		for _,email := range(bytes.Split(data,[]byte("\n"))){
-			if string(email)!=""{
-				emails[string(email)] = struct{}{}
-				emailsCount++
-			}
-		}
+	i := strings.Index(wd, "Partage-CA")
+	if i < 0 {
+		return "", fmt.Errorf("not running from a Partage-CA checkout: %s", wd)
 	}
-	fmt.Println("finished loading",usersCount,"user's public key hashes &",emailsCount,"emails !")
-	return users, emails,nil
+	return wd[:i], nil
 }
 
+// OpenFileToAppend opens the file at the given path, relative to RootDir, to append to it.
 func OpenFileToAppend(path string) (*os.File, error) {
 	//don't forget to close fp! fp.Close()
-	wd, _ := os.Getwd()
-	rt := wd[:strings.Index(wd, "Partage-CA")]
+	rt, err := RootDir()
+	if err != nil {
+		return nil, err
+	}
 	file, err := os.OpenFile(rt+path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		return nil, err
@@ -64,8 +49,12 @@ func AppendToFile(data []byte, fp *os.File) error {
 
 
 func GenerateChallenge() int{
-	//8 digits integer
+	//8 digits integer (the codes must not be predictable)
 	low:=10000000 
 	high:=99999999
-	return (low+rand.Intn(high-low)) 
-}
\ No newline at end of file
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(high-low)))
+	if err != nil {
+		panic(err)
+	}
+	return low+int(n.Int64())
+}
@@ -0,0 +1,109 @@
+package impl
+
+import (
+	"fmt"
+	"net/smtp"
+	"os"
+	"partage-ca/server"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// verificationMail returns the mail that delivers the verification code to the given e-mail address.
+func verificationMail(from string, email string, code string) []byte {
+	return []byte("From: " + from + "\r\n" +
+		"To: " + email + "\r\n" +
+		"Subject: Partage Verification Code\r\n\r\n" +
+		"Welcome to Partage! Here you have your Verification Code: " + code + "\r\n")
+}
+
+//--------
+
+// SMTPProvider mails the verification codes through an SMTP server.
+type SMTPProvider struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+}
+
+func NewSMTPProvider(host string, port string, username string, password string) *SMTPProvider {
+	return &SMTPProvider{Host: host, Port: port, Username: username, Password: password}
+}
+
+// SendCode implements server.VerificationProvider
+func (p *SMTPProvider) SendCode(email string) (string, error) {
+	code := strconv.Itoa(GenerateChallenge())
+	auth := smtp.PlainAuth("", p.Username, p.Password, p.Host)
+	err := smtp.SendMail(p.Host+":"+p.Port, auth, p.Username, []string{email}, verificationMail(p.Username, email, code))
+	if err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+//--------
+
+// MaildirProvider drops the mails with the verification codes into a local maildir instead of sending them, which
+// is useful to run the CA without a mail service, e.g., for testing. Each mail is written into the tmp/ directory, and
+// then moved into the new/ directory, so that readers never see partial mails. The name of the mail contains the
+// e-mail address of the recipient.
+type MaildirProvider struct {
+	Dir string
+}
+
+func NewMaildirProvider(dir string) (*MaildirProvider, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		err := os.MkdirAll(filepath.Join(dir, sub), 0755)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &MaildirProvider{Dir: dir}, nil
+}
+
+// SendCode implements server.VerificationProvider
+func (p *MaildirProvider) SendCode(email string) (string, error) {
+	code := strconv.Itoa(GenerateChallenge())
+	name := strconv.FormatInt(time.Now().UnixNano(), 10) + "." + strings.ReplaceAll(email, "/", "_")
+	tmpPath := filepath.Join(p.Dir, "tmp", name)
+	err := os.WriteFile(tmpPath, verificationMail("partage-ca", email, code), 0644)
+	if err != nil {
+		return "", err
+	}
+	err = os.Rename(tmpPath, filepath.Join(p.Dir, "new", name))
+	if err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+//--------
+
+// AllowlistProvider approves the e-mail addresses of the allowlist without any verification code. An entry of the
+// allowlist is either an e-mail address, a domain starting with "@" (e.g., "@epfl.ch"), or "*" to approve everyone.
+type AllowlistProvider struct {
+	allowed []string
+}
+
+func NewAllowlistProvider(allowed []string) *AllowlistProvider {
+	return &AllowlistProvider{allowed: allowed}
+}
+
+// SendCode implements server.VerificationProvider
+func (p *AllowlistProvider) SendCode(email string) (string, error) {
+	email = strings.ToLower(email)
+	for _, entry := range p.allowed {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "*" || entry == email || (strings.HasPrefix(entry, "@") && strings.HasSuffix(email, entry)) {
+			return "", nil
+		}
+	}
+	return "", fmt.Errorf("e-mail address is not allowed")
+}
+
+var _ server.VerificationProvider = (*SMTPProvider)(nil)
+var _ server.VerificationProvider = (*MaildirProvider)(nil)
+var _ server.VerificationProvider = (*AllowlistProvider)(nil)
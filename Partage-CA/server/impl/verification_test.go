@@ -0,0 +1,199 @@
+package impl
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var verificationCode = regexp.MustCompile(`Verification Code: ([0-9]+)`)
+
+// readCode returns the verification code of a mail.
+func readCode(t *testing.T, mail string) string {
+	t.Helper()
+	match := verificationCode.FindStringSubmatch(mail)
+	if match == nil {
+		t.Fatalf("no verification code in the mail: %q", mail)
+	}
+	return match[1]
+}
+
+// readMaildir returns the mail that the maildir has received for the given e-mail address.
+func readMaildir(t *testing.T, dir string, email string) string {
+	t.Helper()
+	entries, err := os.ReadDir(filepath.Join(dir, "new"))
+	if err != nil {
+		t.Fatalf("failed to read the maildir: %v", err)
+	}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), "."+email) {
+			mail, err := os.ReadFile(filepath.Join(dir, "new", entry.Name()))
+			if err != nil {
+				t.Fatalf("failed to read the mail: %v", err)
+			}
+			return string(mail)
+		}
+	}
+	t.Fatalf("no mail for %s in the maildir", email)
+	return ""
+}
+
+func TestMaildirProvider(t *testing.T) {
+	dir := t.TempDir()
+	provider, err := NewMaildirProvider(dir)
+	if err != nil {
+		t.Fatalf("failed to create the maildir: %v", err)
+	}
+	ca := newTestCA(t, provider)
+
+	alice := newTestUser(t, "alice@example.com")
+	conn := alice.dial(t, ca)
+	msg := readMessage(t, conn)
+	if msg.Type != "WARNING" {
+		t.Fatalf("expected to be asked for the verification code, got %s: %s", msg.Type, msg.Payload)
+	}
+	code := readCode(t, readMaildir(t, dir, alice.email))
+	writeMessage(t, conn, "CODE", []byte(code))
+	checkRegistered(t, ca, alice, readMessage(t, conn))
+
+	// a wrong code frees the e-mail address again
+	bob := newTestUser(t, "bob@example.com")
+	conn = bob.dial(t, ca)
+	msg = readMessage(t, conn)
+	if msg.Type != "WARNING" {
+		t.Fatalf("expected to be asked for the verification code, got %s: %s", msg.Type, msg.Payload)
+	}
+	code = readCode(t, readMaildir(t, dir, bob.email))
+	writeMessage(t, conn, "CODE", []byte(code+"0"))
+	checkNotRegistered(t, ca, bob)
+
+	// the e-mail address of a registered user can't be taken
+	mallory := newTestUser(t, alice.email)
+	msg = readMessage(t, mallory.dial(t, ca))
+	if msg.Type != "ERROR" {
+		t.Fatalf("expected the e-mail address to be taken, got %s: %s", msg.Type, msg.Payload)
+	}
+}
+
+func TestAllowlistProvider(t *testing.T) {
+	ca := newTestCA(t, NewAllowlistProvider([]string{"@epfl.ch", "carol@example.com"}))
+
+	// the allowed addresses are registered without any verification code
+	alice := newTestUser(t, "alice@epfl.ch")
+	checkRegistered(t, ca, alice, readMessage(t, alice.dial(t, ca)))
+	carol := newTestUser(t, "Carol@Example.com")
+	checkRegistered(t, ca, carol, readMessage(t, carol.dial(t, ca)))
+
+	bob := newTestUser(t, "bob@example.com")
+	msg := readMessage(t, bob.dial(t, ca))
+	if msg.Type != "ERROR" {
+		t.Fatalf("expected %s to be denied, got %s: %s", bob.email, msg.Type, msg.Payload)
+	}
+	checkNotRegistered(t, ca, bob)
+}
+
+// newFakeSMTPServer starts an SMTP server that accepts any credentials, and sends every mail it receives on the
+// returned channel.
+func newFakeSMTPServer(t *testing.T) (string, string, chan string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	mails := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn, mails)
+		}
+	}()
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	return host, port, mails
+}
+
+func serveSMTP(conn net.Conn, mails chan string) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) {
+		conn.Write([]byte(line + "\r\n"))
+	}
+	reply("220 localhost ESMTP")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250-localhost")
+			reply("250 AUTH PLAIN")
+		case strings.HasPrefix(command, "AUTH"):
+			reply("235 2.7.0 Authentication successful")
+		case command == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var mail strings.Builder
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				mail.WriteString(line)
+			}
+			mails <- mail.String()
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func TestSMTPProvider(t *testing.T) {
+	host, port, mails := newFakeSMTPServer(t)
+	ca := newTestCA(t, NewSMTPProvider(host, port, "partage@example.com", "password"))
+
+	alice := newTestUser(t, "alice@example.com")
+	conn := alice.dial(t, ca)
+	msg := readMessage(t, conn)
+	if msg.Type != "WARNING" {
+		t.Fatalf("expected to be asked for the verification code, got %s: %s", msg.Type, msg.Payload)
+	}
+	mail := <-mails
+	if !strings.Contains(mail, "To: "+alice.email) {
+		t.Fatalf("the mail isn't sent to %s: %q", alice.email, mail)
+	}
+	writeMessage(t, conn, "CODE", []byte(readCode(t, mail)))
+	checkRegistered(t, ca, alice, readMessage(t, conn))
+}
+
+func TestSMTPProviderUnreachable(t *testing.T) {
+	// nothing listens on the port once the listener is closed
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	listener.Close()
+	ca := newTestCA(t, NewSMTPProvider(host, port, "partage@example.com", "password"))
+
+	alice := newTestUser(t, "alice@example.com")
+	msg := readMessage(t, alice.dial(t, ca))
+	if msg.Type != "ERROR" {
+		t.Fatalf("expected the verification to fail, got %s: %s", msg.Type, msg.Payload)
+	}
+	checkNotRegistered(t, ca, alice)
+}
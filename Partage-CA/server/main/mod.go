@@ -1,21 +1,87 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
 	"os/signal"
+	"partage-ca/server"
 	"partage-ca/server/impl"
+	"strings"
 	"syscall"
 )
 
 func main() {
+	defaultVerification := "smtp"
+	if server.TESTING {
+		defaultVerification = "maildir"
+	}
+	verification := flag.String("verification", defaultVerification, "identity verification backend: smtp, maildir or allowlist")
+	smtpHost := flag.String("smtp-host", "smtp.gmail.com", "host of the SMTP server (smtp backend)")
+	smtpPort := flag.String("smtp-port", "587", "port of the SMTP server (smtp backend)")
+	smtpUsername := flag.String("smtp-user", "partage.register@gmail.com", "username of the SMTP account, the password is read from $PARTAGE_SMTP_PASSWORD (smtp backend)")
+	maildir := flag.String("maildir", "", "directory where the mails are dropped (maildir backend, default: "+server.MaildirPath+")")
+	allowlist := flag.String("allowlist", "", "comma-separated e-mail addresses or @domains approved without code, * for everyone (allowlist backend)")
+	adminAddr := flag.String("admin", "", "address of the admin API, the token is read from $PARTAGE_ADMIN_TOKEN (default: disabled)")
+	flag.Parse()
+
+	verifier, err := newVerificationProvider(*verification, *smtpHost, *smtpPort, *smtpUsername, *maildir, *allowlist)
+	if err != nil {
+		fmt.Println("[ERROR] creating verification provider...", err)
+		os.Exit(1)
+	}
+
 	stop := make(chan os.Signal, 2)
 	signal.Notify(stop, os.Interrupt, syscall.SIGINT)
 
-	server := impl.NewServer()
-	fmt.Println("starting CA server...")
-	server.Start()
+	ca := impl.NewServer(verifier)
+	if ca == nil {
+		os.Exit(1)
+	}
+	fmt.Println("starting CA server with", *verification, "verification...")
+	ca.Start()
+
+	var admin server.Service
+	if *adminAddr != "" {
+		admin, err = impl.NewAdminServer(ca, *adminAddr, os.Getenv("PARTAGE_ADMIN_TOKEN"))
+		if err != nil {
+			fmt.Println("[ERROR] creating admin API...", err)
+			ca.Stop()
+			os.Exit(1)
+		}
+		admin.Start()
+	}
 
 	<-stop //blocks until SIGINT (ctrl+c) signal is received
-	server.Stop()
+	if admin != nil {
+		admin.Stop()
+	}
+	ca.Stop()
+}
+
+func newVerificationProvider(name, smtpHost, smtpPort, smtpUsername, maildir, allowlist string) (server.VerificationProvider, error) {
+	switch name {
+	case "smtp":
+		password := os.Getenv("PARTAGE_SMTP_PASSWORD")
+		if password == "" {
+			return nil, fmt.Errorf("the smtp backend needs $PARTAGE_SMTP_PASSWORD")
+		}
+		return impl.NewSMTPProvider(smtpHost, smtpPort, smtpUsername, password), nil
+	case "maildir":
+		if maildir == "" {
+			rt, err := impl.RootDir()
+			if err != nil {
+				return nil, fmt.Errorf("cannot locate the default maildir, use -maildir: %v", err)
+			}
+			maildir = rt + server.MaildirPath
+		}
+		return impl.NewMaildirProvider(maildir)
+	case "allowlist":
+		if allowlist == "" {
+			return nil, fmt.Errorf("the allowlist backend needs -allowlist")
+		}
+		return impl.NewAllowlistProvider(strings.Split(allowlist, ",")), nil
+	default:
+		return nil, fmt.Errorf("unknown verification backend: %s", name)
+	}
 }
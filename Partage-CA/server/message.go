@@ -1,6 +1,10 @@
 package server
 
-import "encoding/json"
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+)
 
 type Message struct {
 	Type    string
@@ -27,4 +31,30 @@ func (r *Registration) Encode() ([]byte, error) {
 
 func (r *Registration) Decode(bytes []byte) error {
 	return json.Unmarshal(bytes, &r)
-}
\ No newline at end of file
+}
+
+//--------
+// RevocationList is the list of the public keys revoked by the CA, signed by the CA.
+type RevocationList struct{
+	// Serial increases with every revocation, so that the peers only keep the latest list.
+	Serial uint64
+	Timestamp int64
+	// Revoked holds the hashes of the revoked public keys.
+	Revoked [][32]byte
+	Signature []byte
+}
+
+func (l *RevocationList) Encode() ([]byte, error) {
+	return json.Marshal(l)
+}
+
+// Digest returns the hash signed by the CA: Hash(Serial||Timestamp||Revoked...)
+func (l *RevocationList) Digest() [32]byte {
+	data := make([]byte, 16, 16+32*len(l.Revoked))
+	binary.BigEndian.PutUint64(data[:8], l.Serial)
+	binary.BigEndian.PutUint64(data[8:], uint64(l.Timestamp))
+	for _, hash := range l.Revoked {
+		data = append(data, hash[:]...)
+	}
+	return sha256.Sum256(data)
+}
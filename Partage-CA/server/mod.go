@@ -1,5 +1,7 @@
 package server
 
+import "time"
+
 //CHANGE
 const TESTING = true
 
@@ -10,21 +12,73 @@ const StorageDir = "Partage-CA/storage/"
 const CryptoDir = StorageDir + "crypto/"
 const CertificatePath = CryptoDir + "cert.pem"
 const KeyPath = CryptoDir + "key.pem"
-//Users public keys
+//Registered users (replaced atomically on every change)
+const StorePath = StorageDir + "ca.db"
+//Legacy flat files, migrated into the store on first start
 const UsersPath = StorageDir + "users.db"
 const EmailsPath = StorageDir + "emails.db"
+const RevokedPath = StorageDir + "revoked.db"
+const UnreservedPath = StorageDir + "unreserved.db"
+//Append-only log of the administrative actions
+const AuditLogPath = StorageDir + "audit.log"
+
+//Maximum validity of the certificates signed by the CA (users renew them before they expire)
+const MaxValidity = 90 * 24 * time.Hour
 
 //Server Address
 const Addr = "127.0.0.1:1234"
 
-type Server interface {
+type Service interface {
 	Start() error
 	Stop() error
 	GetAddress() string
 }
 
-//SMS credentials
-const SmtpUsername = "partage.register@gmail.com"
-const SmtpPassword = "bilebtjkrcpstopa"
-const SmtpHost = "smtp.gmail.com"
-const SmtpPort = "587"
+type Server interface {
+	Service
+	Registry
+}
+
+//Status of a registered user
+const StatusActive = "active"
+const StatusRevoked = "revoked"
+
+//Registered user, as stored by the CA and seen by the administrators
+type User struct {
+	// PublicKeyHash is the hex-encoded hash of the user's public key.
+	PublicKeyHash string
+	// EmailHash is the hex-encoded hash of the e-mail address the user has registered with.
+	EmailHash string
+	// EmailReserved is false once the e-mail address has been freed for a new registration.
+	EmailReserved bool
+	Status        string
+	// IssuedAt is the unix time at which the latest certificate of the user was issued (0 if unknown).
+	IssuedAt int64
+	// Serial is the serial number of the latest certificate of the user (empty if unknown).
+	Serial string
+	// RevokedAt is the unix time at which the public key was revoked.
+	RevokedAt int64 `json:",omitempty"`
+}
+
+type Registry interface {
+	// Users returns the registered users, sorted by issue time.
+	Users() []User
+	// LookupUser returns the user owning the public key with the given hash.
+	LookupUser(publicKeyHash [32]byte) (User, bool)
+	// LookupEmail returns the user who has reserved the given e-mail address.
+	LookupEmail(email string) (User, bool)
+	// Revoke revokes the public key with the given hash, which bans its owner.
+	Revoke(publicKeyHash [32]byte) error
+	// UnreserveEmail frees the given e-mail address, so that it can be used to register a new public key.
+	UnreserveEmail(email string) error
+}
+
+//Identity verification
+type VerificationProvider interface {
+	// SendCode delivers a fresh verification code to the given e-mail address, and returns it. The user proves that
+	// it owns the address by sending the code back. An empty code means that the address is approved right away.
+	SendCode(email string) (string, error)
+}
+
+//Mail drop of the maildir verification provider
+const MaildirPath = StorageDir + "maildir/"
@@ -9,4 +9,7 @@ require (
 	github.com/stretchr/testify v1.7.0
 	github.com/urfave/cli/v2 v2.3.0
 	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1
+	partage-common v0.0.0
 )
+
+replace partage-common => ../Partage-Common
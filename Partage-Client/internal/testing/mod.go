@@ -9,6 +9,7 @@ import (
 	"go.dedis.ch/cs438/peer/impl/consensus/protocol"
 	"io"
 	"math/rand"
+	"os"
 
 	"strconv"
 	"testing"
@@ -24,6 +25,7 @@ import (
 	"go.dedis.ch/cs438/storage/inmemory"
 
 	"go.dedis.ch/cs438/transport"
+	"go.dedis.ch/cs438/transport/codec"
 	"go.dedis.ch/cs438/types"
 )
 
@@ -83,7 +85,7 @@ func (m FakeMessage) Compare(t *testing.T, msg *transport.Message) {
 
 	var newMsg FakeMessage
 
-	err := json.Unmarshal(msg.Payload, &newMsg)
+	err := codec.Unmarshal(msg.Payload, &newMsg)
 	require.NoError(t, err)
 
 	require.Equal(t, m.Content, newMsg.Content)
@@ -138,17 +140,31 @@ type configTemplate struct {
 	AckTimeout        time.Duration
 	ContinueMongering float64
 
-	chunkSize uint
+	chunkSize              uint
+	contentDefinedChunking peer.ContentDefinedChunking
 
 	storage           storage.Storage
 	blockchainStorage storage.MultipurposeStorage
 
 	dataRequestBackoff peer.Backoff
 
+	downloadWorkers         uint
+	downloadRequestsPerPeer uint
+
 	totalPeers         uint
 	paxosThreshold     func(uint) int
 	paxosID            uint
 	paxosProposerRetry time.Duration
+	consensusProtocol  func(string) peer.ConsensusType
+	raftHeartbeat      time.Duration
+	feedBatchWindow    time.Duration
+
+	dhtBucketSize      uint
+	dhtRefreshInterval time.Duration
+
+	blobStoreQuota uint
+	blobGCInterval time.Duration
+	clock          func() int64
 }
 
 func newConfigTemplate() configTemplate {
@@ -178,12 +194,39 @@ func newConfigTemplate() configTemplate {
 			Retry:   5,
 		},
 
+		downloadWorkers:         1,
+		downloadRequestsPerPeer: 2,
+
 		totalPeers: 1,
 		paxosThreshold: func(u uint) int {
 			return int(u/2 + 1)
 		},
 		paxosID:            0,
 		paxosProposerRetry: time.Second * 5,
+		consensusProtocol:  consensusProtocolFromEnv(),
+		raftHeartbeat:      time.Second,
+		feedBatchWindow:    0,
+	}
+}
+
+// consensusProtocolFromEnv uses the consensus protocol set in PEER_CONSENSUS,
+// e.g., "raft", for every protocol but the default one, whose tests inspect
+// the Paxos messages, unless PEER_CONSENSUS_DEFAULT is set as well. Uses Paxos
+// if the variable is not set.
+func consensusProtocolFromEnv() func(string) peer.ConsensusType {
+	consensusType := peer.ConsensusType(os.Getenv("PEER_CONSENSUS"))
+	if consensusType == "" {
+		return nil
+	}
+	defaultType := peer.ConsensusType(os.Getenv("PEER_CONSENSUS_DEFAULT"))
+	if defaultType == "" {
+		defaultType = peer.Paxos
+	}
+	return func(protocolID string) peer.ConsensusType {
+		if protocolID == "default" {
+			return defaultType
+		}
+		return consensusType
 	}
 }
 
@@ -247,6 +290,17 @@ func WithChunkSize(chunkSize uint) Option {
 	}
 }
 
+// WithContentDefinedChunking sets specific sizes of content-defined chunks.
+func WithContentDefinedChunking(min, average, max uint) Option {
+	return func(ct *configTemplate) {
+		ct.contentDefinedChunking = peer.ContentDefinedChunking{
+			Min:     min,
+			Average: average,
+			Max:     max,
+		}
+	}
+}
+
 // WithDataRequestBackoff sets a specific data request backoff.
 func WithDataRequestBackoff(initial time.Duration, factor uint, retry uint) Option {
 	return func(ct *configTemplate) {
@@ -258,6 +312,15 @@ func WithDataRequestBackoff(initial time.Duration, factor uint, retry uint) Opti
 	}
 }
 
+// WithDownloadWorkers sets a specific number of download workers, and of
+// data requests in flight to a single peer.
+func WithDownloadWorkers(workers uint, requestsPerPeer uint) Option {
+	return func(ct *configTemplate) {
+		ct.downloadWorkers = workers
+		ct.downloadRequestsPerPeer = requestsPerPeer
+	}
+}
+
 // WithStorage sets a specific storage
 func WithStorage(storage storage.Storage) Option {
 	return func(ct *configTemplate) {
@@ -300,8 +363,54 @@ func WithPaxosProposerRetry(d time.Duration) Option {
 	}
 }
 
+// WithConsensusProtocol sets the consensus protocol to use for each protocol
+// id.
+func WithConsensusProtocol(f func(string) peer.ConsensusType) Option {
+	return func(ct *configTemplate) {
+		ct.consensusProtocol = f
+	}
+}
+
+// WithRaftHeartbeat sets a specific Raft heartbeat interval.
+func WithRaftHeartbeat(d time.Duration) Option {
+	return func(ct *configTemplate) {
+		ct.raftHeartbeat = d
+	}
+}
+
+// WithFeedBatchWindow sets a specific feed batch window.
+func WithFeedBatchWindow(d time.Duration) Option {
+	return func(ct *configTemplate) {
+		ct.feedBatchWindow = d
+	}
+}
+
+// WithDHT enables the DHT with a specific bucket size and refresh interval.
+func WithDHT(bucketSize uint, refreshInterval time.Duration) Option {
+	return func(ct *configTemplate) {
+		ct.dhtBucketSize = bucketSize
+		ct.dhtRefreshInterval = refreshInterval
+	}
+}
+
+// WithBlobStore sets a specific quota of the data blob store, and interval of
+// its garbage collection.
+func WithBlobStore(quota uint, gcInterval time.Duration) Option {
+	return func(ct *configTemplate) {
+		ct.blobStoreQuota = quota
+		ct.blobGCInterval = gcInterval
+	}
+}
+
+// WithClock sets a specific clock, e.g., a fake one.
+func WithClock(clock func() int64) Option {
+	return func(ct *configTemplate) {
+		ct.clock = clock
+	}
+}
+
 // NewTestNode returns a new test node.
-func NewTestNode(t *testing.T, f peer.Factory, trans transport.Transport,
+func NewTestNode(t testing.TB, f peer.Factory, trans transport.Transport,
 	addr string, opts ...Option) TestNode {
 
 	template := newConfigTemplate()
@@ -323,11 +432,22 @@ func NewTestNode(t *testing.T, f peer.Factory, trans transport.Transport,
 	config.Storage = template.storage
 	config.BlockchainStorage = template.blockchainStorage
 	config.ChunkSize = template.chunkSize
+	config.ContentDefinedChunking = template.contentDefinedChunking
 	config.BackoffDataRequest = template.dataRequestBackoff
+	config.DownloadWorkers = template.downloadWorkers
+	config.DownloadRequestsPerPeer = template.downloadRequestsPerPeer
 	config.TotalPeers = template.totalPeers
 	config.PaxosThreshold = template.paxosThreshold
 	config.PaxosID = template.paxosID
 	config.PaxosProposerRetry = template.paxosProposerRetry
+	config.ConsensusProtocol = template.consensusProtocol
+	config.RaftHeartbeat = template.raftHeartbeat
+	config.FeedBatchWindow = template.feedBatchWindow
+	config.DHTBucketSize = template.dhtBucketSize
+	config.DHTRefreshInterval = template.dhtRefreshInterval
+	config.BlobStoreQuota = template.blobStoreQuota
+	config.BlobGCInterval = template.blobGCInterval
+	config.Clock = template.clock
 
 	node := f(config)
 
@@ -355,7 +475,7 @@ type TestNode struct {
 	peer.Peer
 	config peer.Configuration
 	socket transport.ClosableSocket
-	t      *testing.T
+	t      testing.TB
 }
 
 // GetAddr returns the node's socket address
@@ -370,6 +490,11 @@ func (t *TestNode) StopAll() {
 	require.NoError(t.t, err)
 }
 
+// GetSocket returns the node's socket.
+func (t TestNode) GetSocket() transport.ClosableSocket {
+	return t.socket
+}
+
 // GetIns returns all the messages received so far.
 func (t TestNode) GetIns() []transport.Packet {
 	return t.socket.GetIns()
@@ -467,7 +592,7 @@ func GetChat(t *testing.T, msg *transport.Message) types.ChatMessage {
 
 	var chatMessage types.ChatMessage
 
-	err := json.Unmarshal(msg.Payload, &chatMessage)
+	err := codec.Unmarshal(msg.Payload, &chatMessage)
 	require.NoError(t, err)
 
 	return chatMessage
@@ -479,7 +604,7 @@ func GetRumor(t *testing.T, msg *transport.Message) types.RumorsMessage {
 
 	var rumor types.RumorsMessage
 
-	err := json.Unmarshal(msg.Payload, &rumor)
+	err := codec.Unmarshal(msg.Payload, &rumor)
 	require.NoError(t, err)
 
 	return rumor
@@ -491,7 +616,7 @@ func GetAck(t *testing.T, msg *transport.Message) types.AckMessage {
 
 	var ack types.AckMessage
 
-	err := json.Unmarshal(msg.Payload, &ack)
+	err := codec.Unmarshal(msg.Payload, &ack)
 	require.NoError(t, err)
 
 	return ack
@@ -503,7 +628,7 @@ func GetStatus(t *testing.T, msg *transport.Message) types.StatusMessage {
 
 	var status types.StatusMessage
 
-	err := json.Unmarshal(msg.Payload, &status)
+	err := codec.Unmarshal(msg.Payload, &status)
 	require.NoError(t, err)
 
 	return status
@@ -515,7 +640,7 @@ func GetEmpty(t *testing.T, msg *transport.Message) types.EmptyMessage {
 
 	var emptyMessage types.EmptyMessage
 
-	err := json.Unmarshal(msg.Payload, &emptyMessage)
+	err := codec.Unmarshal(msg.Payload, &emptyMessage)
 	require.NoError(t, err)
 
 	return emptyMessage
@@ -527,7 +652,7 @@ func GetDataRequest(t *testing.T, msg *transport.Message) types.DataRequestMessa
 
 	var dataRequestMessage types.DataRequestMessage
 
-	err := json.Unmarshal(msg.Payload, &dataRequestMessage)
+	err := codec.Unmarshal(msg.Payload, &dataRequestMessage)
 	require.NoError(t, err)
 
 	return dataRequestMessage
@@ -539,7 +664,7 @@ func GetDataReply(t *testing.T, msg *transport.Message) types.DataReplyMessage {
 
 	var dataReplyMessage types.DataReplyMessage
 
-	err := json.Unmarshal(msg.Payload, &dataReplyMessage)
+	err := codec.Unmarshal(msg.Payload, &dataReplyMessage)
 	require.NoError(t, err)
 
 	return dataReplyMessage
@@ -551,7 +676,7 @@ func GetSearchRequest(t *testing.T, msg *transport.Message) types.SearchRequestM
 
 	var searchRequestMessage types.SearchRequestMessage
 
-	err := json.Unmarshal(msg.Payload, &searchRequestMessage)
+	err := codec.Unmarshal(msg.Payload, &searchRequestMessage)
 	require.NoError(t, err)
 
 	return searchRequestMessage
@@ -563,7 +688,7 @@ func GetSearchReply(t *testing.T, msg *transport.Message) types.SearchReplyMessa
 
 	var searchReplyMessage types.SearchReplyMessage
 
-	err := json.Unmarshal(msg.Payload, &searchReplyMessage)
+	err := codec.Unmarshal(msg.Payload, &searchReplyMessage)
 	require.NoError(t, err)
 
 	return searchReplyMessage
@@ -575,7 +700,7 @@ func GetPaxosPrepare(t *testing.T, msg *transport.Message) types.PaxosPrepareMes
 
 	var paxosPrepareMessage types.PaxosPrepareMessage
 
-	err := json.Unmarshal(msg.Payload, &paxosPrepareMessage)
+	err := codec.Unmarshal(msg.Payload, &paxosPrepareMessage)
 	require.NoError(t, err)
 
 	return paxosPrepareMessage
@@ -587,7 +712,7 @@ func GetPaxosPromise(t *testing.T, msg *transport.Message) types.PaxosPromiseMes
 
 	var paxosPromiseMessage types.PaxosPromiseMessage
 
-	err := json.Unmarshal(msg.Payload, &paxosPromiseMessage)
+	err := codec.Unmarshal(msg.Payload, &paxosPromiseMessage)
 	require.NoError(t, err)
 
 	return paxosPromiseMessage
@@ -599,7 +724,7 @@ func GetConsensus(t *testing.T, msg *transport.Message) protocol.ConsensusMessag
 
 	var consensusMessage protocol.ConsensusMessage
 
-	err := json.Unmarshal(msg.Payload, &consensusMessage)
+	err := codec.Unmarshal(msg.Payload, &consensusMessage)
 	require.NoError(t, err)
 
 	return consensusMessage
@@ -611,7 +736,7 @@ func GetPaxosPropose(t *testing.T, msg *transport.Message) types.PaxosProposeMes
 
 	var paxosProposeMessage types.PaxosProposeMessage
 
-	err := json.Unmarshal(msg.Payload, &paxosProposeMessage)
+	err := codec.Unmarshal(msg.Payload, &paxosProposeMessage)
 	require.NoError(t, err)
 
 	return paxosProposeMessage
@@ -623,7 +748,7 @@ func GetPaxosAccept(t *testing.T, msg *transport.Message) types.PaxosAcceptMessa
 
 	var paxosAcceptMessage types.PaxosAcceptMessage
 
-	err := json.Unmarshal(msg.Payload, &paxosAcceptMessage)
+	err := codec.Unmarshal(msg.Payload, &paxosAcceptMessage)
 	require.NoError(t, err)
 
 	return paxosAcceptMessage
@@ -635,7 +760,7 @@ func GetTLC(t *testing.T, msg *transport.Message) types.TLCMessage {
 
 	var tlcMessage types.TLCMessage
 
-	err := json.Unmarshal(msg.Payload, &tlcMessage)
+	err := codec.Unmarshal(msg.Payload, &tlcMessage)
 	require.NoError(t, err)
 
 	return tlcMessage
@@ -647,7 +772,7 @@ func GetPrivate(t *testing.T, msg *transport.Message) types.PrivateMessage {
 
 	var privateMessage types.PrivateMessage
 
-	err := json.Unmarshal(msg.Payload, &privateMessage)
+	err := codec.Unmarshal(msg.Payload, &privateMessage)
 	require.NoError(t, err)
 
 	return privateMessage
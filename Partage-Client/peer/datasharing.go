@@ -9,6 +9,11 @@ import (
 // MetafileSep defines the separation between chunk hashes in the metafile.
 const MetafileSep = "\n"
 
+// Progress is called with the number of chunks transferred so far, out of the
+// total number of chunks. The total is 0 while it is unknown, e.g., while a
+// stream is being uploaded.
+type Progress func(done uint, total uint)
+
 // DataSharing describes functions to share data in a bittorrent-like system.
 type DataSharing interface {
 	// Upload stores a new data blob on the peer and will make it available to
@@ -25,6 +30,26 @@ type DataSharing interface {
 	// - Implemented in HW2
 	Download(metahash string) ([]byte, error)
 
+	// UploadFrom is like Upload, but reads the blob from a reader whose length
+	// doesn't need to be known, without buffering it: each chunk is stored as
+	// soon as it is read. The progress, if not nil, is reported after each
+	// chunk.
+	UploadFrom(data io.Reader, progress Progress) (metahash string, err error)
+
+	// DownloadTo is like Download, but writes the blob to the given writer
+	// chunk after chunk, instead of returning it. The progress, if not nil, is
+	// reported after each chunk.
+	DownloadTo(metahash string, w io.Writer, progress Progress) error
+
+	// Pin keeps the blob with the given metahash on the peer: neither its
+	// metafile nor its chunks are evicted to respect the quota, or garbage
+	// collected. A blob pinned several times must be unpinned as many times.
+	// The uploaded blobs are pinned.
+	Pin(metahash string)
+
+	// Unpin undoes a Pin of the blob with the given metahash.
+	Unpin(metahash string)
+
 	// Tag creates a mapping between a (file)name and a metahash.
 	//
 	// - Implemented in HW2
@@ -9,12 +9,15 @@ import (
 	"go.dedis.ch/cs438/peer/impl/social/feed"
 	"go.dedis.ch/cs438/peer/impl/utils"
 	"sort"
-	"time"
 )
 
 // Client is a useful Partage Client to be used by a frontend.
 type Client struct {
 	Peer peer.SocialPeer
+	// TemplateDir is the directory of the templates of the GUI.
+	TemplateDir string
+	// now returns the current unix time in seconds, according to the clock of the peer.
+	now func() int64
 }
 
 func NewClient(totalPeers uint, joinNodeAddr string, config peer.Configuration) *Client {
@@ -34,29 +37,40 @@ func NewClient(totalPeers uint, joinNodeAddr string, config peer.Configuration)
 		fmt.Printf("error during start: %v\n", err)
 		return nil
 	}
-	// Load the registered users during the construction.
-	fmt.Println("Loading registered users...")
-	registered := p.(*node).social.LoadRegisteredUsers(config.BlockchainStorage)
-	fmt.Println("Registered", registered, "many users.")
-	// Initiate the self-register after acquiring the registration blockchain from the community.
-	time.Sleep(5 * time.Second)
-	fmt.Println("Initiating self-register...")
-	err = p.RegisterUser()
+	// The stored chains, if any, have been replayed during the construction of the peer.
+	fmt.Println("Loaded", len(p.GetKnownUsers()), "many registered users.")
+	// Do not register again if we are restarting with the same identity. The missing blocks are acquired through
+	// anti-entropy.
+	_, alreadyRegistered := p.GetKnownUsers()[p.GetUserID()]
+	if alreadyRegistered {
+		fmt.Println("Already registered, catching up with the community...")
+	} else {
+		// The registration syncs the blockchains with the community first.
+		fmt.Println("Initiating self-register...")
+		err = p.RegisterUser()
+		if err != nil {
+			fmt.Printf("error during registration: %v\n", err)
+			return nil
+		}
+	}
+	// Stop trusting the revoked certificates before taking part in the community.
+	fmt.Println("Fetching the revocation list...")
+	err = p.UpdateRevocationList()
 	if err != nil {
-		fmt.Printf("error during registration: %v\n", err)
-		return nil
+		fmt.Printf("error during revocation list update: %v\n", err)
 	}
 	fmt.Println("OK! Peer IP:", p.(*node).social.GetAddress())
 	// Return the client.
 	return &Client{
 		Peer: p,
+		now:  config.Now,
 	}
 }
 
 // GetUserData returns the user data associated with the given user id.
 func (c *Client) GetUserData(userID string) UserData {
 	selfID := c.Peer.GetUserID()
-	return NewUserData(selfID, c.Peer.GetUserState(userID))
+	return NewUserData(selfID, c.Peer.GetUserState(userID), c.now())
 }
 
 // GetTexts returns the texts with the given filters.
@@ -119,14 +133,14 @@ func (c *Client) GetReactions(contentID string) []Reaction {
 // PostText posts a new text.
 func (c *Client) PostText(text string) error {
 	// Create an unencrypted content.
-	cnt := content.NewPublicContent(c.Peer.GetUserID(), text, utils.Time(), "").Unencrypted()
+	cnt := content.NewPublicContent(c.Peer.GetUserID(), text, c.now(), "").Unencrypted()
 	_, _, err := c.Peer.ShareDownloadableContent(cnt, content.TEXT)
 	return err
 }
 
 func (c *Client) PostPrivateText(text string, recipientUserIDs []string) error {
 	// Create the content.
-	cnt := content.NewPublicContent(c.Peer.GetUserID(), text, utils.Time(), "")
+	cnt := content.NewPublicContent(c.Peer.GetUserID(), text, c.now(), "")
 	recipientMap, err := c.recipientListToRecipientMap(recipientUserIDs)
 	if err != nil {
 		return fmt.Errorf("error while parsing recipients: %v", err)
@@ -143,41 +157,61 @@ func (c *Client) PostPrivateText(text string, recipientUserIDs []string) error {
 
 // PostComment posts a new comment. If the given post is private, the comment will also be encrypted in the same fashion.
 func (c *Client) PostComment(comment string, postContentID string) error {
-	// Download the content associated with the post content id. Since we are posting a comment to it, we most likely have it in the local storage already.
+	publicContent := content.NewPublicContent(c.Peer.GetUserID(), comment, c.now(), postContentID)
+	privateContent, err := c.encryptLikePost(publicContent, postContentID)
+	if err != nil {
+		return err
+	}
+	// Post the comment. Finally.
+	_, _, err = c.Peer.ShareDownloadableContent(privateContent, content.COMMENT)
+	return err
+}
+
+// EditPost replaces the text of the given text/comment content id with a new version. If the given post is private,
+// the new version will also be encrypted in the same fashion.
+func (c *Client) EditPost(text string, postContentID string) error {
+	publicContent := content.NewPublicContent(c.Peer.GetUserID(), text, c.now(), postContentID)
+	privateContent, err := c.encryptLikePost(publicContent, postContentID)
+	if err != nil {
+		return err
+	}
+	_, _, err = c.Peer.ShareDownloadableContent(privateContent, content.EDIT)
+	return err
+}
+
+// encryptLikePost encrypts the given content for the recipients of the post associated with the given content id.
+// If the post was not encrypted, the content is not encrypted either.
+func (c *Client) encryptLikePost(publicContent content.PublicContent, postContentID string) (content.PrivateContent, error) {
+	// Download the content associated with the post content id. Since we are referring to it, we most likely have it in the local storage already.
 	contents := c.getDownloadableThings(content.Filter{ContentID: postContentID}, c.downloadUploadedContent)
 	if len(contents) != 1 {
-		return fmt.Errorf("there are %d != 1 associated texts", len(contents))
+		return content.PrivateContent{}, fmt.Errorf("there are %d != 1 associated texts", len(contents))
 	}
 	// Get the recipients associated with this
 	if contents[0] == nil {
-		return fmt.Errorf("unreachable content id")
+		return content.PrivateContent{}, fmt.Errorf("unreachable content id")
 	}
 	referredPostRecipientList := contents[0].(content.PrivateContent).RecipientList
-	publicContent := content.NewPublicContent(c.Peer.GetUserID(), comment, utils.Time(), postContentID)
-	var privateContent content.PrivateContent
 	// Check the encryption status of the parent text post.
 	if len(referredPostRecipientList) == 0 {
-		// If it was not encrypted, do not encrypt the comment.
-		privateContent = publicContent.Unencrypted()
-	} else {
-		// Directly use the parent post's recipient list.
-		recptMap, err := c.recipientListToRecipientMap(referredPostRecipientList)
-		if err != nil {
-			return fmt.Errorf("could not encrypt comment: %v", err)
-		}
-		privateContent, err = publicContent.Encrypted(recptMap)
-		if err != nil {
-			return fmt.Errorf("could not encrypt comment: %v", err)
-		}
+		// If it was not encrypted, do not encrypt.
+		return publicContent.Unencrypted(), nil
 	}
-	// Post the comment. Finally.
-	_, _, err := c.Peer.ShareDownloadableContent(privateContent, content.COMMENT)
-	return err
+	// Directly use the parent post's recipient list.
+	recptMap, err := c.recipientListToRecipientMap(referredPostRecipientList)
+	if err != nil {
+		return content.PrivateContent{}, fmt.Errorf("could not encrypt: %v", err)
+	}
+	privateContent, err := publicContent.Encrypted(recptMap)
+	if err != nil {
+		return content.PrivateContent{}, fmt.Errorf("could not encrypt: %v", err)
+	}
+	return privateContent, nil
 }
 
 // ReactToPost reacts to the given text/comment content id.
 func (c *Client) ReactToPost(reaction content.Reaction, contentID string) error {
-	_, err := c.Peer.UpdateFeed(content.CreateReactionMetadata(c.Peer.GetUserID(), reaction, utils.Time(), contentID))
+	_, err := c.Peer.UpdateFeed(content.CreateReactionMetadata(c.Peer.GetUserID(), reaction, c.now(), contentID))
 	return err
 }
 
@@ -193,13 +227,13 @@ func (c *Client) UndoReaction(contentID string) error {
 		return fmt.Errorf("already unfollowed")
 	}
 	// Otherwise, try to undo the latest reaction.
-	_, err := c.Peer.UpdateFeed(content.CreateUndoMetadata(c.Peer.GetUserID(), utils.Time(), reactions[len(reactions)-1].BlockHash))
+	_, err := c.Peer.UpdateFeed(content.CreateUndoMetadata(c.Peer.GetUserID(), c.now(), reactions[len(reactions)-1].BlockHash))
 	return err
 }
 
 // FollowUser follows the user associated with the given user id.
 func (c *Client) FollowUser(userID string) error {
-	_, err := c.Peer.UpdateFeed(content.CreateFollowUserMetadata(c.Peer.GetUserID(), userID))
+	_, err := c.Peer.UpdateFeed(content.CreateFollowUserMetadata(c.Peer.GetUserID(), c.now(), userID))
 	return err
 }
 
@@ -209,25 +243,25 @@ func (c *Client) UnfollowUser(userID string) error {
 	follows := c.Peer.QueryFeedContents(content.Filter{
 		OwnerIDs: []string{c.Peer.GetUserID()},
 		Types:    []content.Type{content.FOLLOW},
-		Data:     content.CreateFollowUserMetadata(c.Peer.GetUserID(), userID).Data,
+		Data:     content.CreateFollowUserMetadata(c.Peer.GetUserID(), c.now(), userID).Data,
 	})
 	if len(follows) == 0 {
 		return fmt.Errorf("already unfollowed")
 	}
 	// Otherwise, try to undo the latest follow action.
-	_, err := c.Peer.UpdateFeed(content.CreateUndoMetadata(c.Peer.GetUserID(), utils.Time(), follows[len(follows)-1].BlockHash))
+	_, err := c.Peer.UpdateFeed(content.CreateUndoMetadata(c.Peer.GetUserID(), c.now(), follows[len(follows)-1].BlockHash))
 	return err
 }
 
 // RequestEndorsement initiates an endorsement request.
 func (c *Client) RequestEndorsement() error {
-	_, err := c.Peer.UpdateFeed(content.CreateEndorsementRequestMetadata(c.Peer.GetUserID(), utils.Time()))
+	_, err := c.Peer.UpdateFeed(content.CreateEndorsementRequestMetadata(c.Peer.GetUserID(), c.now()))
 	return err
 }
 
 // EndorseUser endorses the given user.
 func (c *Client) EndorseUser(userID string) error {
-	_, err := c.Peer.UpdateFeed(content.CreateEndorseUserMetadata(c.Peer.GetUserID(), utils.Time(), userID))
+	_, err := c.Peer.UpdateFeed(content.CreateEndorseUserMetadata(c.Peer.GetUserID(), c.now(), userID))
 	return err
 }
 
@@ -26,7 +26,12 @@ func (l *Layer) HandleConsensusMessage(msg types.Message, pkt transport.Packet)
 	}
 	l.RLock()
 	p, ok := l.protocols[consensusMsg.ProtocolID]
+	left := l.left
 	l.RUnlock()
+	// Do not interfere with the consensus once we have left.
+	if left {
+		return nil
+	}
 	if !ok {
 		return fmt.Errorf("consensus layer could not find a protocol with id %s", consensusMsg.ProtocolID)
 	}
@@ -6,11 +6,14 @@ import (
 	"go.dedis.ch/cs438/peer"
 	"go.dedis.ch/cs438/peer/impl/consensus/protocol"
 	"go.dedis.ch/cs438/peer/impl/consensus/protocol/paxos"
+	"go.dedis.ch/cs438/peer/impl/consensus/protocol/raft"
+	"go.dedis.ch/cs438/peer/impl/cryptography"
 	"go.dedis.ch/cs438/peer/impl/gossip"
 	"go.dedis.ch/cs438/peer/impl/utils"
 	"go.dedis.ch/cs438/storage"
 	"go.dedis.ch/cs438/types"
 	"sync"
+	"time"
 )
 
 type Layer struct {
@@ -19,23 +22,52 @@ type Layer struct {
 	Config *peer.Configuration
 
 	protocols map[string]protocol.Protocol
+	// epoch is the membership epoch, i.e., the number of membership changes, shared by all the protocols.
+	epoch uint
+	// idSpace is the number of paxos ids over which the proposal ids are spread in the current epoch.
+	idSpace uint
+	// left is true once the peer has left the system. A peer that has left does not take part in the consensus.
+	left bool
+
+	quitDistributor *utils.SignalDistributor
 }
 
-func Construct(gossip *gossip.Layer, config *peer.Configuration) *Layer {
+func Construct(gossip *gossip.Layer, crypto *cryptography.Layer, config *peer.Configuration,
+	quitDistributor *utils.SignalDistributor) *Layer {
 	layer := &Layer{
-		Gossip:    gossip,
-		Config:    config,
-		protocols: make(map[string]protocol.Protocol),
+		Gossip:          gossip,
+		Config:          config,
+		protocols:       make(map[string]protocol.Protocol),
+		quitDistributor: quitDistributor,
+	}
+	// Let the protocols act periodically, e.g., for the Raft heartbeats.
+	if config.RaftHeartbeat > 0 {
+		quitDistributor.NewListener("consensus")
+		go layer.tick(config.RaftHeartbeat)
 	}
-	// As the default protocol, use Paxos.
-	layer.RegisterProtocol("default",
-		paxos.New("default", config, gossip,
-			DefaultBlockGenerator(config.Storage.GetBlockchainStore()),
-			DefaultBlockchainUpdater(config.Storage.GetBlockchainStore(), config.Storage.GetNamingStore()),
-			DefaultProposalChecker()))
+	defaultProtocol := NewProtocol("default", config, gossip, crypto,
+		DefaultBlockGenerator(config.Storage.GetBlockchainStore()),
+		DefaultBlockchainUpdater(config.Storage.GetBlockchainStore(), config.Storage.GetNamingStore()),
+		DefaultProposalChecker())
+	// Resume from the stored blockchain, if any.
+	defaultProtocol.SkipTo(utils.NextBlockIndex(config.Storage.GetBlockchainStore()))
+	layer.RegisterProtocol("default", defaultProtocol)
 	return layer
 }
 
+// NewProtocol creates the consensus protocol with the given id and hooks. The implementation is chosen by the
+// configuration, Paxos being used by default. The cryptography layer, nil without the TLS transport, lets Raft sign
+// and verify the votes.
+func NewProtocol(protocolID string, config *peer.Configuration, gossip *gossip.Layer, crypto *cryptography.Layer,
+	blockGenerator paxos.BlockGenerator,
+	blockchainUpdater paxos.BlockchainUpdater,
+	proposalChecker paxos.ProposalChecker) protocol.Protocol {
+	if config.ConsensusProtocol != nil && config.ConsensusProtocol(protocolID) == peer.Raft {
+		return raft.New(protocolID, config, gossip, crypto, blockGenerator, blockchainUpdater, proposalChecker)
+	}
+	return paxos.New(protocolID, config, gossip, blockGenerator, blockchainUpdater, proposalChecker)
+}
+
 func (l *Layer) GetAddress() string {
 	return l.Gossip.GetAddress()
 }
@@ -45,6 +77,14 @@ func (l *Layer) RegisterProtocol(id string, protocol protocol.Protocol) {
 	l.Lock()
 	defer l.Unlock()
 	l.protocols[id] = protocol
+	// Move the new protocol into the current membership epoch.
+	if l.epoch > 0 {
+		err := protocol.UpdateMembership(l.epoch, l.Config.TotalPeers, l.idSpace)
+		if err != nil {
+			utils.PrintDebug("consensus", l.GetAddress(), "could not move the protocol", id, "into epoch", l.epoch, ":",
+				err)
+		}
+	}
 }
 
 func (l *Layer) IsRegistered(id string) bool {
@@ -81,21 +121,75 @@ func (l *Layer) ProposeWithProtocol(protocolID string, value types.PaxosValue) (
 	return p.Propose(value)
 }
 
-func (l *Layer) UpdateSystemSize(newSize uint) {
+// ApplyBlock appends the given block through the protocol associated with the given protocol id.
+// Returns whether the block was appended.
+func (l *Layer) ApplyBlock(protocolID string, block types.BlockchainBlock) bool {
+	l.RLock()
+	p, ok := l.protocols[protocolID]
+	l.RUnlock()
+	if !ok {
+		return false
+	}
+	return p.ApplyBlock(block)
+}
+
+// UpdateMembership moves all the protocols into the given membership epoch with the given system size, in which the
+// proposal ids are spread over the given id space. The paxos id of the peer is kept. A protocol that fails to move
+// does not keep the others in the previous epoch.
+func (l *Layer) UpdateMembership(epoch uint, newSize uint, idSpace uint) {
 	l.Lock()
 	defer l.Unlock()
-	oldSize := l.Config.TotalPeers
 	l.Config.TotalPeers = newSize
-	utils.PrintDebug("consensus", l.GetAddress(), "is updating system size from", oldSize, "to", newSize)
-	for _, p := range l.protocols {
-		err := p.UpdateSystemSize(oldSize, newSize)
+	l.epoch = epoch
+	l.idSpace = idSpace
+	utils.PrintDebug("consensus", l.GetAddress(), "is moving to epoch", epoch, "with system size", newSize,
+		"and id space", idSpace)
+	for id, p := range l.protocols {
+		err := p.UpdateMembership(epoch, newSize, idSpace)
 		if err != nil {
-			fmt.Printf("error during updating system size: %v", err)
+			utils.PrintDebug("consensus", l.GetAddress(), "could not move the protocol", id, "into epoch", epoch, ":",
+				err)
+		}
+	}
+}
+
+// tick lets the protocols that implement protocol.Ticker act at the given interval, until the peer is stopped.
+func (l *Layer) tick(interval time.Duration) {
+	quitListener, _ := l.quitDistributor.GetListener("consensus")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-quitListener:
+			utils.PrintDebug("consensus", l.GetAddress(), "quitting the protocol ticks")
 			return
+		case <-ticker.C:
+			l.RLock()
+			var tickers []protocol.Ticker
+			for _, p := range l.protocols {
+				if t, ok := p.(protocol.Ticker); ok {
+					tickers = append(tickers, t)
+				}
+			}
+			left := l.left
+			l.RUnlock()
+			if left {
+				continue
+			}
+			for _, t := range tickers {
+				t.Tick()
+			}
 		}
 	}
 }
 
+// Leave stops the peer from taking part in the consensus, since it is not counted in the system size anymore.
+func (l *Layer) Leave() {
+	l.Lock()
+	defer l.Unlock()
+	l.left = true
+}
+
 // -- Paxos functions for the default protocol.
 
 func DefaultBlockGenerator(blockchainStore storage.Store) paxos.BlockGenerator {
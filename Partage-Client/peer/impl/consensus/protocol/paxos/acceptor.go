@@ -24,6 +24,11 @@ type Acceptor struct {
 
 func (a *Acceptor) HandlePrepare(msg types.PaxosPrepareMessage) error {
 	utils.PrintDebug("acceptor", a.paxos.Gossip.GetAddress(), "is handling paxos prepare for ID", msg.ID)
+	// Ignore the prepares of the other membership epochs.
+	if msg.Epoch != a.paxos.Epoch() {
+		utils.PrintDebug("acceptor", a.paxos.Gossip.GetAddress(), "ignored the prepare from epoch", msg.Epoch)
+		return nil
+	}
 	a.paxos.Clock.Lock.Lock()
 	// Ignore when receivedStep != clock.Step || receivedID <= clock.MaxID
 	if a.paxos.Clock.ShouldIgnorePrepare(msg.Step, int(msg.ID)) {
@@ -64,6 +69,11 @@ func (a *Acceptor) HandlePrepare(msg types.PaxosPrepareMessage) error {
 
 func (a *Acceptor) HandlePropose(msg types.PaxosProposeMessage) error {
 	utils.PrintDebug("acceptor", a.paxos.Gossip.GetAddress(), "is handling paxos propose for ID", msg.ID)
+	// Ignore the proposals of the other membership epochs.
+	if msg.Epoch != a.paxos.Epoch() {
+		utils.PrintDebug("acceptor", a.paxos.Gossip.GetAddress(), "ignored the proposal from epoch", msg.Epoch)
+		return nil
+	}
 	a.paxos.Clock.Lock.Lock()
 	// Ignore when receivedStep != clock.Step || receivedID != clock.MaxID
 	if a.paxos.Clock.ShouldIgnorePropose(msg.Step, int(msg.ID)) {
@@ -157,8 +167,8 @@ func (a *Acceptor) HandleTLC(msg types.TLCMessage) error {
 
 func (a *Acceptor) HandleAccept(msg types.PaxosAcceptMessage) error {
 	utils.PrintDebug("acceptor", a.paxos.Gossip.GetAddress(), "is handling paxos accept for ID", msg.ID)
-	// Dismiss rejects.
-	if content.IsReject(msg.Value.CustomValue) {
+	// Dismiss rejects and the accepts of the other membership epochs.
+	if content.IsReject(msg.Value.CustomValue) || msg.Epoch != a.paxos.Epoch() {
 		return nil
 	}
 	a.paxos.Clock.Lock.Lock()
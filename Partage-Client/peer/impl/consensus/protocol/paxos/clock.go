@@ -111,6 +111,23 @@ func (c *Clock) CatchUp(threshold int) []types.BlockchainBlock {
 	return newBlocks
 }
 
+// SkipTo moves the clock forward to the given step without appending any blocks. The progress of the skipped steps
+// is discarded. Does nothing if the clock is already at or after the given step.
+func (c *Clock) SkipTo(step uint) {
+	if step <= c.Step {
+		return
+	}
+	for s := range c.TLCProgressMap {
+		if s < int(step) {
+			delete(c.TLCProgressMap, s)
+		}
+	}
+	c.AcceptedID = 0
+	c.AcceptedValue = nil
+	c.MaxID = 0
+	c.Step = step
+}
+
 func (c *Clock) UpdateMaxID(newMaxID int) {
 	if newMaxID > c.MaxID {
 		c.MaxID = newMaxID
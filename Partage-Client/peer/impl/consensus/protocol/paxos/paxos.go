@@ -20,6 +20,11 @@ type Paxos struct {
 	acceptor       *Acceptor
 	Proposer       *StateMachine
 	LastProposalID uint
+	// Protects the membership epoch, the id space and the last proposal ID.
+	membershipLock sync.Mutex
+	epoch          uint
+	// idSpace is the number of paxos ids over which the proposal ids are spread.
+	idSpace uint
 
 	Notification *utils.AsyncNotificationHandler
 	Gossip       *gossip.Layer
@@ -35,6 +40,7 @@ func New(protocolID string, config *peer.Configuration, gossip *gossip.Layer,
 		Clock:          NewClock(),
 		Proposer:       &StateMachine{},
 		LastProposalID: config.PaxosID,
+		idSpace:        config.TotalPeers,
 
 		Notification: utils.NewAsyncNotificationHandler(),
 		Gossip:       gossip,
@@ -93,27 +99,55 @@ func (p *Paxos) HandleConsensusMessage(msg protocol.ConsensusMessage) error {
 	return nil
 }
 
-func (p *Paxos) UpdateSystemSize(oldSize uint, newSize uint) error {
-	if newSize < oldSize {
-		return fmt.Errorf("cannot decrease system size in Paxos")
-	}
+func (p *Paxos) UpdateMembership(epoch uint, newSize uint, idSpace uint) error {
 	if p.ProtocolID != "registration" {
-		// Wait for the proposal to finish before updating the system size.
-		p.proposalLock.Lock()
-		defer p.proposalLock.Unlock()
-		// Wait for the message handling to finish before updating the system size.
+		// Wait for the message handling to finish before updating the membership.
 		p.handleLock.Lock()
 		defer p.handleLock.Unlock()
 	}
-	// Update the last proposal ID.
-	if p.LastProposalID > oldSize {
-		incrementAmount := (p.LastProposalID - 1) / oldSize
-		diff := newSize - oldSize
-		p.LastProposalID += diff * incrementAmount
+	p.membershipLock.Lock()
+	defer p.membershipLock.Unlock()
+	p.epoch = epoch
+	// Move the last proposal ID above the IDs used in the previous epochs, so that the in-flight rounds are never
+	// reused. The new IDs are spread according to the paxos id and the id space.
+	if idSpace > 0 {
+		p.idSpace = idSpace
+		p.LastProposalID = (p.LastProposalID/idSpace+1)*idSpace + p.Config.PaxosID
 	}
 	return nil
 }
 
+// Epoch returns the current membership epoch.
+func (p *Paxos) Epoch() uint {
+	p.membershipLock.Lock()
+	defer p.membershipLock.Unlock()
+	return p.epoch
+}
+
+func (p *Paxos) SkipTo(step uint) {
+	p.Clock.Lock.Lock()
+	defer p.Clock.Lock.Unlock()
+	p.Clock.SkipTo(step)
+}
+
+func (p *Paxos) ApplyBlock(block types.BlockchainBlock) bool {
+	p.Clock.Lock.Lock()
+	// Only the block for the current step can be appended.
+	if !p.Clock.InStep(block.Index) {
+		p.Clock.Lock.Unlock()
+		return false
+	}
+	p.acceptor.BlockchainUpdater(block)
+	p.Clock.SkipTo(block.Index + 1)
+	p.Clock.Lock.Unlock()
+	// Inform the local proposer that we have moved the clock.
+	p.Notification.DispatchResponse(fmt.Sprint("tick", block.Index), types.TLCMessage{
+		Step:  block.Index,
+		Block: block,
+	})
+	return true
+}
+
 func (p *Paxos) LocalUpdate(value types.PaxosValue) (string, error) {
 	return "", nil
 }
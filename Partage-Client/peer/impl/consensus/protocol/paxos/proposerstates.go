@@ -23,6 +23,7 @@ type ProposerWaitPromiseState struct {
 	paxos         *Paxos
 	proposalID    uint
 	proposalStep  uint
+	epoch         uint
 	originalValue types.PaxosValue
 	notification  *utils.AsyncNotificationHandler
 }
@@ -33,6 +34,7 @@ type ProposerWaitAcceptState struct {
 	notification  *utils.AsyncNotificationHandler
 	proposalStep  uint
 	proposalID    uint
+	epoch         uint
 	chosenValue   types.PaxosValue
 	originalValue types.PaxosValue
 }
@@ -52,21 +54,26 @@ func (s ProposerBeginState) Next() (State, types.BlockchainBlock) {
 		return nil, types.BlockchainBlock{}
 	}
 	s.paxos.Clock.Lock.RLock()
+	maxID := s.paxos.Clock.MaxID
+	proposalStep := s.paxos.Clock.Step
+	s.paxos.Clock.Lock.RUnlock()
+	s.paxos.membershipLock.Lock()
 	// Catch up with the clock!
-	for int(s.paxos.LastProposalID) < s.paxos.Clock.MaxID {
-		s.paxos.LastProposalID += s.paxos.Config.TotalPeers
+	for int(s.paxos.LastProposalID) < maxID {
+		s.paxos.LastProposalID += s.paxos.idSpace
 	}
 	proposalID := s.paxos.LastProposalID
-	proposalStep := s.paxos.Clock.Step
-	s.paxos.Clock.Lock.RUnlock()
+	epoch := s.paxos.epoch
 	//println("proposer", p.gossip.GetAddress(), "is proposing", value.String(), "with ID", proposalID, "at step", proposalStep)
 	// Update the next proposal ID.
-	s.paxos.LastProposalID += s.paxos.Config.TotalPeers
+	s.paxos.LastProposalID += s.paxos.idSpace
+	s.paxos.membershipLock.Unlock()
 	return ProposerWaitPromiseState{
 		trial:         s.trial + 1,
 		paxos:         s.paxos,
 		proposalID:    proposalID,
 		proposalStep:  proposalStep,
+		epoch:         epoch,
 		notification:  utils.NewAsyncNotificationHandler(),
 		originalValue: s.value,
 	}, types.BlockchainBlock{}
@@ -86,6 +93,7 @@ func (s ProposerWaitPromiseState) Next() (State, types.BlockchainBlock) {
 		Step:   s.proposalStep,
 		ID:     s.proposalID,
 		Source: s.paxos.Gossip.GetAddress(),
+		Epoch:  s.epoch,
 	}
 	// Pass the created prepare message to the next state.
 	prepareTranspMsg, _ := s.paxos.Config.MessageRegistry.MarshalMessage(&prepareMsg)
@@ -101,8 +109,8 @@ func (s ProposerWaitPromiseState) Next() (State, types.BlockchainBlock) {
 		promises = append(promises, r.(*types.PaxosPromiseMessage))
 	}
 	utils.PrintDebug("proposer", s.paxos.Gossip.GetAddress(), "has received", len(promises), "promises with ID", s.proposalID)
-	// Retry with new proposal ID.
-	if len(promises) < threshold {
+	// Retry with new proposal ID. The threshold is not valid anymore if the membership has changed meanwhile.
+	if len(promises) < threshold || s.paxos.Epoch() != s.epoch {
 		utils.PrintDebug("proposer", s.paxos.Gossip.GetAddress(), "couldn't collect enough promises.")
 		//println(p.gossip.GetAddress(), "NOT ENOUGH PROMISES")
 		return ProposerBeginState{
@@ -134,6 +142,7 @@ func (s ProposerWaitPromiseState) Next() (State, types.BlockchainBlock) {
 		chosenValue:   chosenValue,
 		proposalID:    s.proposalID,
 		proposalStep:  s.proposalStep,
+		epoch:         s.epoch,
 		notification:  utils.NewAsyncNotificationHandler(),
 	}, types.BlockchainBlock{}
 }
@@ -163,6 +172,7 @@ func (s ProposerWaitAcceptState) Next() (State, types.BlockchainBlock) {
 		ID:     s.proposalID,
 		Value:  s.chosenValue,
 		Source: s.paxos.Gossip.GetAddress(),
+		Epoch:  s.epoch,
 	}
 	proposeTranspMsg, _ := s.paxos.Config.MessageRegistry.MarshalMessage(&proposeMsg)
 	// Broadcast the proposal.
@@ -216,7 +226,7 @@ func (s ProposerWaitAcceptState) Accept(message types.Message) bool {
 	if !ok {
 		return false
 	}
-	if acceptMsg.ID != s.proposalID {
+	if acceptMsg.ID != s.proposalID || acceptMsg.Epoch != s.epoch {
 		return false
 	}
 	s.notification.DispatchResponse(fmt.Sprint("proposer-accept-id", acceptMsg.ID), acceptMsg)
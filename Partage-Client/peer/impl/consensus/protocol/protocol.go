@@ -10,5 +10,20 @@ type Protocol interface {
 	LocalUpdate(types.PaxosValue) (string, error)
 	GetProtocolID() string
 	HandleConsensusMessage(ConsensusMessage) error
-	UpdateSystemSize(oldSize uint, newSize uint) error
+	// UpdateMembership moves the protocol into the given membership epoch, in which the system consists of newSize
+	// many peers. The messages of the other epochs are ignored, so that the quorums of different sizes are never mixed.
+	// The proposal ids are spread over idSpace, which never decreases, so that the configured paxos ids stay unique
+	// when the system shrinks.
+	UpdateMembership(epoch uint, newSize uint, idSpace uint) error
+	// SkipTo moves the protocol forward to the given step, i.e., the index of the next block. It is used when the
+	// blockchain was restored from the storage.
+	SkipTo(step uint)
+	// ApplyBlock appends a block that was acquired outside the protocol, e.g., from a blockchain sync. The block is
+	// only appended if it is the next block. Returns whether the block was appended.
+	ApplyBlock(block types.BlockchainBlock) bool
+}
+
+// Ticker is implemented by the protocols that act periodically, e.g., to send heartbeats or to detect a failed leader.
+type Ticker interface {
+	Tick()
 }
@@ -0,0 +1,236 @@
+package raft
+
+import (
+	"bytes"
+	"fmt"
+	"go.dedis.ch/cs438/peer/impl/consensus/protocol"
+	"go.dedis.ch/cs438/peer/impl/utils"
+	"go.dedis.ch/cs438/types"
+)
+
+func (r *Raft) HandleConsensusMessage(msg protocol.ConsensusMessage) error {
+	innerMsg := protocol.UnwrapConsensusMessage(msg)
+	switch m := innerMsg.(type) {
+	case *types.RaftRequestVoteMessage:
+		return r.handleRequestVote(*m)
+	case *types.RaftVoteMessage:
+		r.Notification.DispatchResponse(fmt.Sprint("vote", m.Term), m)
+	case *types.RaftHeartbeatMessage:
+		return r.handleHeartbeat(*m)
+	case *types.RaftForwardMessage:
+		return r.handleForward(*m)
+	case *types.RaftAppendEntryMessage:
+		return r.handleAppendEntry(*m)
+	case *types.RaftAppendReplyMessage:
+		r.handleAppendReply(*m)
+	case *types.RaftCommitMessage:
+		r.handleCommit(*m)
+	case *types.RaftRejectMessage:
+		r.Notification.DispatchResponse(outcomeID(m.UniqID), m)
+	}
+	return nil
+}
+
+func (r *Raft) handleRequestVote(msg types.RaftRequestVoteMessage) error {
+	utils.PrintDebug("raft", r.GetAddress(), "is handling a vote request from", msg.Candidate, "for term", msg.Term)
+	// Ignore the candidates of the other membership epochs.
+	if msg.Epoch != r.Epoch() {
+		return nil
+	}
+	r.lock.Lock()
+	r.observeTerm(msg.Term, "", nil)
+	voteMsg := types.RaftVoteMessage{
+		Term:      r.term,
+		Source:    r.GetAddress(),
+		Candidate: msg.Candidate,
+		Step:      r.step,
+	}
+	// Only vote once per term, and only for candidates that are at least as up-to-date as us.
+	upToDate := msg.Step > r.step || (msg.Step == r.step && msg.AcceptedTerm >= r.acceptedTerm)
+	if msg.Term == r.term && (r.votedFor == "" || r.votedFor == msg.Candidate) && upToDate {
+		r.votedFor = msg.Candidate
+		voteMsg.Granted = true
+		// Give the candidate the time to win the election before starting our own.
+		r.lastHeard = r.Config.Now()
+		r.resetElectionTimeout()
+		// Inform the candidate of the entry we have accepted, so that it is not lost.
+		voteMsg.AcceptedTerm = r.acceptedTerm
+		voteMsg.AcceptedValue = r.acceptedValue
+	}
+	r.lock.Unlock()
+	if voteMsg.Granted {
+		err := r.signVote(&voteMsg)
+		if err != nil {
+			return err
+		}
+	}
+	return r.sendPrivate(msg.Candidate, &voteMsg)
+}
+
+func (r *Raft) handleHeartbeat(msg types.RaftHeartbeatMessage) error {
+	r.lock.Lock()
+	fromLeader := r.observeTerm(msg.Term, msg.Leader, msg.Votes)
+	replyMsg := types.RaftAppendReplyMessage{
+		Term:     r.term,
+		Source:   r.GetAddress(),
+		Step:     msg.Step,
+		NextStep: r.step,
+	}
+	// Inform the stale leaders of the new term, and ask our leader for the blocks we have missed.
+	stale := msg.Term < r.term
+	behind := fromLeader && msg.Step > r.step
+	r.lock.Unlock()
+	if stale || behind {
+		return r.sendPrivate(msg.Leader, &replyMsg)
+	}
+	return nil
+}
+
+func (r *Raft) handleForward(msg types.RaftForwardMessage) error {
+	utils.PrintDebug("raft", r.GetAddress(), "has received a forwarded value from", msg.Source)
+	// Append in the background, since it may take a while.
+	go func() {
+		_, rejected := r.lead(msg.Value)
+		if rejected {
+			_ = r.sendPrivate(msg.Source, &types.RaftRejectMessage{UniqID: msg.Value.UniqID})
+		}
+	}()
+	return nil
+}
+
+func (r *Raft) handleAppendEntry(msg types.RaftAppendEntryMessage) error {
+	utils.PrintDebug("raft", r.GetAddress(), "is handling an append entry at step", msg.Step)
+	// Ignore the leaders of the other membership epochs.
+	if msg.Epoch != r.Epoch() {
+		return nil
+	}
+	r.lock.Lock()
+	fromLeader := r.observeTerm(msg.Term, msg.Leader, msg.Votes)
+	replyMsg := types.RaftAppendReplyMessage{
+		Term:     r.term,
+		Source:   r.GetAddress(),
+		Step:     msg.Step,
+		UniqID:   msg.Value.UniqID,
+		NextStep: r.step,
+	}
+	// Inform the stale leaders of the new term.
+	if msg.Term < r.term {
+		r.lock.Unlock()
+		return r.sendPrivate(msg.Leader, &replyMsg)
+	}
+	// Only the leader of the current term appends entries.
+	if !fromLeader || msg.Step < r.step {
+		r.lock.Unlock()
+		return nil
+	}
+	// Let the leader resend the blocks we have missed, and append the entry once we have caught up.
+	if msg.Step > r.step {
+		r.pendingEntry = &msg
+		r.lock.Unlock()
+		return r.sendPrivate(msg.Leader, &replyMsg)
+	}
+	r.lock.Unlock()
+	// The entry must extend our blockchain.
+	block := r.BlockGenerator(types.PaxosAcceptMessage{
+		Step:  msg.Step,
+		Value: msg.Value,
+	})
+	if !bytes.Equal(block.PrevHash, msg.PrevHash) {
+		utils.PrintDebug("raft", r.GetAddress(), "has received an entry that does not extend its blockchain")
+		return nil
+	}
+	// The checker may take a while, so do not hold the lock.
+	accepted := r.ProposalChecker(types.PaxosProposeMessage{
+		Step:   msg.Step,
+		ID:     msg.Term,
+		Source: msg.Leader,
+		Value:  msg.Value,
+	})
+	r.lock.Lock()
+	if !accepted {
+		replyMsg.Rejected = true
+	} else if msg.Term == r.term && msg.Step == r.step {
+		r.acceptedTerm = msg.Term
+		r.acceptedValue = &msg.Value
+		replyMsg.Success = true
+	}
+	r.lock.Unlock()
+	return r.sendPrivate(msg.Leader, &replyMsg)
+}
+
+func (r *Raft) handleAppendReply(msg types.RaftAppendReplyMessage) {
+	r.lock.Lock()
+	r.observeTerm(msg.Term, "", nil)
+	// Resend the committed blocks that the follower has missed.
+	behind := r.role == Leader && msg.Term == r.term && !msg.Success && !msg.Rejected && msg.NextStep < r.step
+	r.lock.Unlock()
+	if behind {
+		go r.resend(msg.Source, msg.NextStep)
+	}
+	// The replies to the heartbeats are not awaited, and neither are those of the followers that are behind, which
+	// reply again once they have caught up.
+	if msg.UniqID != "" && (msg.Success || msg.Rejected) {
+		r.Notification.DispatchResponse(fmt.Sprint("append", msg.Step, msg.UniqID), &msg)
+	}
+}
+
+func (r *Raft) handleCommit(msg types.RaftCommitMessage) {
+	utils.PrintDebug("raft", r.GetAddress(), "is handling a commit at step", msg.Step)
+	r.lock.Lock()
+	// Only the leader of the current term commits, once a majority has appended the block.
+	fromLeader := r.observeTerm(msg.Term, msg.Leader, msg.Votes)
+	if !fromLeader || msg.Step < r.step || msg.Block.Index != msg.Step {
+		r.lock.Unlock()
+		return
+	}
+	r.pendingBlocks[msg.Step] = msg.Block
+	r.lock.Unlock()
+	r.applyPending()
+}
+
+// broadcast broadcasts the given message to the network, including ourselves. The gossip waits for the ack of a random
+// neighbor, which may have failed, so the message is broadcast in the background.
+func (r *Raft) broadcast(msg types.Message) error {
+	transpMsg, err := r.Config.MessageRegistry.MarshalMessage(msg)
+	if err != nil {
+		return err
+	}
+	go func() {
+		_ = r.Gossip.BroadcastMessage(protocol.WrapInConsensusMessage(r.ProtocolID, transpMsg))
+	}()
+	return nil
+}
+
+// unicast sends the given message directly to the given peer.
+func (r *Raft) unicast(dest string, msg types.Message) error {
+	transpMsg, err := r.Config.MessageRegistry.MarshalMessage(msg)
+	if err != nil {
+		return err
+	}
+	return r.Gossip.Unicast(dest, protocol.WrapInConsensusMessage(r.ProtocolID, transpMsg))
+}
+
+// sendPrivate sends the given message to the given peer, directly if it can be reached, or in a private message
+// otherwise.
+func (r *Raft) sendPrivate(dest string, msg types.Message) error {
+	if dest != r.GetAddress() && r.unicast(dest, msg) == nil {
+		return nil
+	}
+	transpMsg, err := r.Config.MessageRegistry.MarshalMessage(msg)
+	if err != nil {
+		return err
+	}
+	consensusMsg := protocol.WrapInConsensusMessage(r.ProtocolID, transpMsg)
+	consensusTransportMsg, err := r.Config.MessageRegistry.MarshalMessage(&consensusMsg)
+	if err != nil {
+		return err
+	}
+	privateMsg := types.PrivateMessage{
+		Recipients: map[string]struct{}{dest: {}},
+		Msg:        &consensusTransportMsg,
+	}
+	go func() {
+		_ = r.Gossip.BroadcastMessage(privateMsg)
+	}()
+	return nil
+}
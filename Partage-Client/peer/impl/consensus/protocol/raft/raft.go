@@ -0,0 +1,691 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"go.dedis.ch/cs438/peer"
+	"go.dedis.ch/cs438/peer/impl/consensus/protocol"
+	"go.dedis.ch/cs438/peer/impl/consensus/protocol/paxos"
+	"go.dedis.ch/cs438/peer/impl/cryptography"
+	"go.dedis.ch/cs438/peer/impl/gossip"
+	"go.dedis.ch/cs438/peer/impl/utils"
+	"go.dedis.ch/cs438/types"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type Role int
+
+const (
+	Follower Role = iota
+	Candidate
+	Leader
+)
+
+// retainedBlocks is the number of last committed blocks that are kept in memory, to resend them to the followers that
+// have missed them and to recognize the values that are proposed again. The older blocks are pruned: they are kept in
+// the blockchain, and acquired through the blockchain sync instead.
+const retainedBlocks = 64
+
+// Raft is a leader-based consensus protocol. The leader appends the proposed values one by one, and commits a value
+// once a majority of the peers have appended it. The leader announces itself with heartbeats, and the followers that
+// stop hearing from it start an election. Since an idle protocol does not need a leader, the first election is
+// triggered by a proposer instead.
+type Raft struct {
+	protocol.Protocol
+	// Serializes the elections started by the local proposers and the election timeouts.
+	campaignLock sync.Mutex
+	// Serializes the values appended by the leader.
+	leadLock   sync.Mutex
+	lock       sync.Mutex
+	ProtocolID string
+
+	term     uint
+	votedFor string
+	role     Role
+	leader   string
+	// The granted votes that have elected us, if we are the leader. Our messages carry them, so that the followers
+	// only follow a leader that has won the election of its term.
+	quorum []types.RaftVoteMessage
+	// lastHeard is the unix time, according to the clock of the configuration, at which we have last heard from the
+	// leader, or started an election. It is zero until we learn of a leader, so that the idle protocols do not hold
+	// elections.
+	lastHeard int64
+	// electionTimeout is how long a follower waits for the leader before starting an election. It is randomized to
+	// avoid split votes.
+	electionTimeout time.Duration
+	// Step is the index of the next block.
+	step uint
+	// The entry accepted at the current step and the term in which it was accepted.
+	acceptedTerm  uint
+	acceptedValue *types.PaxosValue
+	// Committed blocks that wait for the previous blocks to be committed.
+	pendingBlocks map[uint]types.BlockchainBlock
+	// The entry appended by the leader ahead of our step, handled once we have caught up. The commit of the previous
+	// block may reach us after the next entry.
+	pendingEntry *types.RaftAppendEntryMessage
+	// The last committed blocks, resent to the followers that have missed them.
+	blocks map[uint]types.BlockchainBlock
+	// Maps the unique ids of the values of the last committed blocks to their block hashes.
+	committed map[string]string
+	// Protects the membership epoch, which is updated by the registration blockchain updater that may run under lock.
+	membershipLock sync.Mutex
+	epoch          uint
+
+	Notification *utils.AsyncNotificationHandler
+	Gossip       *gossip.Layer
+	Config       *peer.Configuration
+	// crypto signs and verifies the votes. Nil without the TLS transport.
+	crypto *cryptography.Layer
+
+	// Hooks shared with Paxos.
+	BlockGenerator    paxos.BlockGenerator
+	BlockchainUpdater paxos.BlockchainUpdater
+	ProposalChecker   paxos.ProposalChecker
+}
+
+func New(protocolID string, config *peer.Configuration, gossip *gossip.Layer, crypto *cryptography.Layer,
+	blockGenerator paxos.BlockGenerator,
+	blockchainUpdater paxos.BlockchainUpdater,
+	proposalChecker paxos.ProposalChecker) *Raft {
+	return &Raft{
+		ProtocolID:        protocolID,
+		role:              Follower,
+		pendingBlocks:     make(map[uint]types.BlockchainBlock),
+		blocks:            make(map[uint]types.BlockchainBlock),
+		committed:         make(map[string]string),
+		Notification:      utils.NewAsyncNotificationHandler(),
+		Gossip:            gossip,
+		Config:            config,
+		crypto:            crypto,
+		BlockGenerator:    blockGenerator,
+		BlockchainUpdater: blockchainUpdater,
+		ProposalChecker:   proposalChecker,
+	}
+}
+
+func (r *Raft) GetProtocolID() string {
+	return r.ProtocolID
+}
+
+func (r *Raft) GetAddress() string {
+	return r.Gossip.GetAddress()
+}
+
+// Propose proposes the given value through the leader, and waits until the value is committed. An election is
+// started if the leader is not known or does not respond.
+func (r *Raft) Propose(val types.PaxosValue) (string, error) {
+	for trial := uint(0); trial <= paxos.MAX_TRIALS; trial++ {
+		// The value may have been committed while we were retrying.
+		r.lock.Lock()
+		blockHash, ok := r.committed[val.UniqID]
+		leader := r.leader
+		term := r.term
+		r.lock.Unlock()
+		if ok {
+			return blockHash, nil
+		}
+		if leader == "" {
+			r.campaign()
+			continue
+		}
+		if leader == r.GetAddress() {
+			block, rejected := r.lead(val)
+			if rejected {
+				return "", fmt.Errorf("proposal was rejected at the consensus layer")
+			}
+			// Retry if we could not commit the value.
+			if block == nil {
+				continue
+			}
+		} else {
+			err := r.forward(leader, val)
+			if err != nil {
+				return "", err
+			}
+		}
+		// Wait for the outcome of the proposal. The leader needs up to a proposer retry to collect the replies.
+		outcome := r.Notification.ResponseCollector(outcomeID(val.UniqID), 2*r.Config.PaxosProposerRetry)
+		switch msg := outcome.(type) {
+		case *types.RaftCommitMessage:
+			return hex.EncodeToString(msg.Block.Hash), nil
+		case *types.RaftRejectMessage:
+			return "", fmt.Errorf("proposal was rejected at the consensus layer")
+		}
+		// Suspect the leader if it has not responded in time.
+		if leader != r.GetAddress() {
+			utils.PrintDebug("raft", r.GetAddress(), "suspects the leader", leader, "of term", term)
+			r.lock.Lock()
+			if r.term == term && r.leader == leader {
+				r.leader = ""
+			}
+			r.lock.Unlock()
+		}
+	}
+	return "", fmt.Errorf("could not reach consensus after %d trials", paxos.MAX_TRIALS)
+}
+
+// Tick implements protocol.Ticker. The leader sends a heartbeat, and a follower that has not heard from its leader
+// within the election timeout starts an election.
+func (r *Raft) Tick() {
+	r.lock.Lock()
+	role := r.role
+	heartbeatMsg := types.RaftHeartbeatMessage{
+		Term:   r.term,
+		Leader: r.GetAddress(),
+		Step:   r.step,
+		Votes:  r.quorum,
+	}
+	// A candidate waits for its running election to conclude instead. The clock counts in seconds, so the timeout
+	// is rounded up to the next second.
+	now := r.Config.Now()
+	timedOut := role == Follower && r.lastHeard != 0 && time.Duration(now-r.lastHeard)*time.Second > r.electionTimeout
+	term := r.term
+	if timedOut {
+		utils.PrintDebug("raft", r.GetAddress(), "has not heard from the leader", r.leader, "of term", r.term)
+		r.leader = ""
+		// Wait for another timeout before starting the next election.
+		r.lastHeard = now
+	}
+	// The followers of a failed leader time out at the same second, so they wait for a random part of a second before
+	// campaigning, to avoid split votes.
+	delay := time.Duration(rand.Int63n(int64(time.Second)))
+	r.lock.Unlock()
+	if role == Leader {
+		r.sendNeighbors(&heartbeatMsg)
+		_ = r.broadcast(&heartbeatMsg)
+	} else if timedOut {
+		go func() {
+			time.Sleep(delay)
+			// Do not compete with the candidate of a newer term, nor with a new leader.
+			r.lock.Lock()
+			preempted := r.term != term || r.leader != ""
+			r.lock.Unlock()
+			if !preempted {
+				r.campaign()
+			}
+		}()
+	}
+}
+
+// campaign starts an election for a new term. On success, the peer becomes the leader and re-appends the entry
+// accepted by the voters, if any.
+func (r *Raft) campaign() {
+	r.campaignLock.Lock()
+	defer r.campaignLock.Unlock()
+	r.lock.Lock()
+	// Another election may have concluded while we were waiting.
+	if r.leader != "" {
+		r.lock.Unlock()
+		return
+	}
+	// A peer that has not heard of any term yet, e.g., a late joiner, first listens for the heartbeats of the current
+	// leader, if any, so as not to compete with it in its own term.
+	if r.term == 0 && r.Config.RaftHeartbeat > 0 && len(r.Gossip.GetNeighbors()) > 0 {
+		r.lock.Unlock()
+		time.Sleep(2 * r.Config.RaftHeartbeat)
+		r.lock.Lock()
+		if r.leader != "" {
+			r.lock.Unlock()
+			return
+		}
+	}
+	// Give the candidate we have voted for the time to win the election of its term, and compete otherwise.
+	if r.votedFor != "" && r.votedFor != r.GetAddress() {
+		term := r.term
+		timeout := r.electionTimeout
+		r.lock.Unlock()
+		time.Sleep(timeout)
+		r.lock.Lock()
+		if r.leader != "" || r.term != term {
+			r.lock.Unlock()
+			return
+		}
+	}
+	r.term++
+	r.role = Candidate
+	r.votedFor = r.GetAddress()
+	r.quorum = nil
+	// Start another election if this one does not conclude.
+	r.lastHeard = r.Config.Now()
+	r.resetElectionTimeout()
+	term := r.term
+	step := r.step
+	requestVoteMsg := types.RaftRequestVoteMessage{
+		Term:         term,
+		Candidate:    r.GetAddress(),
+		Step:         step,
+		AcceptedTerm: r.acceptedTerm,
+		Epoch:        r.Epoch(),
+	}
+	r.lock.Unlock()
+	utils.PrintDebug("raft", r.GetAddress(), "is campaigning for term", term)
+	r.sendNeighbors(&requestVoteMsg)
+	_ = r.broadcast(&requestVoteMsg)
+	// Collect the votes of a majority, or enough denials to lose the election.
+	threshold := r.Config.PaxosThreshold(r.Config.TotalPeers)
+	granted := 0
+	denied := 0
+	var votes []types.RaftVoteMessage
+	voters := make(map[string]struct{})
+	var recoveredTerm uint
+	var recoveredValue *types.PaxosValue
+	r.collect(fmt.Sprint("vote", term), func(msg types.Message) string {
+		return msg.(*types.RaftVoteMessage).Source
+	}, func(msg types.Message) bool {
+		voteMsg := msg.(*types.RaftVoteMessage)
+		if voteMsg.Term != term || voteMsg.Candidate != r.GetAddress() || !voteMsg.Granted {
+			denied++
+			return denied > int(r.Config.TotalPeers)-threshold
+		}
+		// Only the valid votes, each from a different voter, prove the election to the followers.
+		voter, valid := r.voter(*voteMsg)
+		if _, counted := voters[voter]; !valid || counted {
+			return false
+		}
+		voters[voter] = struct{}{}
+		granted++
+		// Keep the vote as a proof of the election, without the accepted entry.
+		votes = append(votes, types.RaftVoteMessage{
+			Term:      voteMsg.Term,
+			Source:    voteMsg.Source,
+			Candidate: voteMsg.Candidate,
+			Granted:   true,
+			PublicKey: voteMsg.PublicKey,
+			Signature: voteMsg.Signature,
+		})
+		// Keep track of the most recent entry accepted at our step.
+		if voteMsg.Step == step && voteMsg.AcceptedValue != nil && voteMsg.AcceptedTerm > recoveredTerm {
+			recoveredTerm = voteMsg.AcceptedTerm
+			recoveredValue = voteMsg.AcceptedValue
+		}
+		return granted >= threshold
+	})
+	// The votes are not valid anymore if the membership has changed meanwhile.
+	sameEpoch := r.Epoch() == requestVoteMsg.Epoch
+	r.lock.Lock()
+	if r.term != term || r.role != Candidate || granted < threshold || !sameEpoch {
+		utils.PrintDebug("raft", r.GetAddress(), "has lost the election for term", term)
+		if r.term == term && r.role == Candidate {
+			r.role = Follower
+		}
+		r.lock.Unlock()
+		// Back off for a random duration to avoid split votes.
+		time.Sleep(time.Duration(rand.Int63n(int64(r.Config.PaxosProposerRetry/4) + 1)))
+		return
+	}
+	utils.PrintDebug("raft", r.GetAddress(), "has become the leader of term", term)
+	r.role = Leader
+	r.leader = r.GetAddress()
+	r.quorum = votes
+	step = r.step
+	r.lock.Unlock()
+	heartbeatMsg := types.RaftHeartbeatMessage{
+		Term:   term,
+		Leader: r.GetAddress(),
+		Step:   step,
+		Votes:  votes,
+	}
+	r.sendNeighbors(&heartbeatMsg)
+	_ = r.broadcast(&heartbeatMsg)
+	// The recovered entry may have been committed by the previous leader.
+	if recoveredValue != nil {
+		r.lead(*recoveredValue)
+	}
+}
+
+// lead appends the given value at the current step, and commits it once a majority of the peers have appended it.
+// Returns the committed block, or whether the value was rejected by the proposal checkers.
+func (r *Raft) lead(val types.PaxosValue) (*types.BlockchainBlock, bool) {
+	r.leadLock.Lock()
+	defer r.leadLock.Unlock()
+	r.lock.Lock()
+	_, alreadyCommitted := r.committed[val.UniqID]
+	if r.role != Leader || alreadyCommitted {
+		r.lock.Unlock()
+		return nil, false
+	}
+	term := r.term
+	step := r.step
+	votes := r.quorum
+	r.lock.Unlock()
+	epoch := r.Epoch()
+	// Generate the block before anything else is appended.
+	block := r.BlockGenerator(types.PaxosAcceptMessage{
+		Step:   step,
+		ID:     term,
+		Source: r.GetAddress(),
+		Value:  val,
+	})
+	utils.PrintDebug("raft", r.GetAddress(), "is appending", val, "at step", step)
+	_ = r.broadcast(&types.RaftAppendEntryMessage{
+		Term:     term,
+		Leader:   r.GetAddress(),
+		Step:     step,
+		PrevHash: block.PrevHash,
+		Value:    val,
+		Epoch:    epoch,
+		Votes:    votes,
+	})
+	// Collect the replies until a majority has either appended or rejected the value.
+	threshold := r.Config.PaxosThreshold(r.Config.TotalPeers)
+	successes := 0
+	rejects := 0
+	r.collect(fmt.Sprint("append", step, val.UniqID), func(msg types.Message) string {
+		return msg.(*types.RaftAppendReplyMessage).Source
+	}, func(msg types.Message) bool {
+		replyMsg := msg.(*types.RaftAppendReplyMessage)
+		if replyMsg.Term != term {
+			return false
+		}
+		if replyMsg.Success {
+			successes++
+		} else if replyMsg.Rejected {
+			rejects++
+		}
+		return successes >= threshold || rejects >= threshold
+	})
+	// We may have been deposed meanwhile.
+	r.lock.Lock()
+	deposed := r.term != term || r.role != Leader
+	r.lock.Unlock()
+	if deposed {
+		return nil, false
+	}
+	if rejects >= threshold {
+		utils.PrintDebug("raft", r.GetAddress(), "has received", rejects, "rejects for", val)
+		return nil, true
+	}
+	// The appends are not valid anymore if the membership has changed meanwhile.
+	if successes < threshold || r.Epoch() != epoch {
+		utils.PrintDebug("raft", r.GetAddress(), "could not collect enough appends for", val)
+		return nil, false
+	}
+	commitMsg := types.RaftCommitMessage{
+		Term:   term,
+		Leader: r.GetAddress(),
+		Step:   step,
+		Block:  block,
+		Votes:  votes,
+	}
+	r.sendNeighbors(&commitMsg)
+	_ = r.broadcast(&commitMsg)
+	// Commit locally right away so that the next value is appended at the next step.
+	r.handleCommit(commitMsg)
+	return &block, false
+}
+
+// sendNeighbors sends the given message directly to our neighbors. The broadcasts only reach a random neighbor at
+// first, which may have failed, so the others would otherwise wait for the rumor to be gossiped further.
+func (r *Raft) sendNeighbors(msg types.Message) {
+	for neighbor := range r.Gossip.GetNeighbors() {
+		go func(neighbor string) {
+			_ = r.unicast(neighbor, msg)
+		}(neighbor)
+	}
+}
+
+// collect collects the responses with the given id within a proposer retry, at most one per source. The handler is
+// called on each response, and returns whether enough responses have been collected.
+func (r *Raft) collect(id string, sourceOf func(types.Message) string, handle func(types.Message) bool) {
+	deadline := time.Now().Add(r.Config.PaxosProposerRetry)
+	sources := make(map[string]struct{})
+	for time.Now().Before(deadline) {
+		response := r.Notification.ResponseCollector(id, time.Until(deadline))
+		if response == nil {
+			return
+		}
+		source := sourceOf(response)
+		if _, ok := sources[source]; ok {
+			continue
+		}
+		sources[source] = struct{}{}
+		if handle(response) {
+			return
+		}
+	}
+}
+
+// forward forwards the given value to the leader.
+func (r *Raft) forward(leader string, val types.PaxosValue) error {
+	utils.PrintDebug("raft", r.GetAddress(), "is forwarding", val, "to", leader)
+	return r.sendPrivate(leader, &types.RaftForwardMessage{
+		Source: r.GetAddress(),
+		Value:  val,
+	})
+}
+
+// observeTerm moves to the given term if it is newer, and records the leader of the current term if given. A leader is
+// only recorded if the given votes show that it has won the election of its term, and the messages of the leaders that
+// don't show it are ignored altogether. Only the first leader of a term is recorded, since a term has at most one
+// elected leader. Returns whether the message comes from the leader of the current term. Must be called with the lock
+// held.
+func (r *Raft) observeTerm(term uint, leader string, votes []types.RaftVoteMessage) bool {
+	newLeader := leader != "" && (term > r.term || (term == r.term && r.leader == ""))
+	if newLeader && !r.elected(term, leader, votes) {
+		utils.PrintDebug("raft", r.GetAddress(), "ignores", leader, "that was not elected in term", term)
+		return false
+	}
+	if term > r.term {
+		r.term = term
+		r.votedFor = ""
+		r.role = Follower
+		r.leader = ""
+		r.quorum = nil
+	}
+	if term != r.term || leader == "" {
+		return false
+	}
+	if r.leader == "" {
+		r.leader = leader
+		if leader != r.GetAddress() {
+			r.role = Follower
+		}
+	}
+	if r.leader != leader {
+		return false
+	}
+	r.lastHeard = r.Config.Now()
+	r.resetElectionTimeout()
+	return true
+}
+
+// elected returns whether the given votes were granted to the given candidate by a majority in the given term. Each
+// voter is counted once, and only its valid votes are counted, see voter.
+func (r *Raft) elected(term uint, candidate string, votes []types.RaftVoteMessage) bool {
+	voters := make(map[string]struct{}, len(votes))
+	for _, vote := range votes {
+		if vote.Term != term || vote.Candidate != candidate || !vote.Granted {
+			continue
+		}
+		if voter, valid := r.voter(vote); valid {
+			voters[voter] = struct{}{}
+		}
+	}
+	return len(voters) >= r.Config.PaxosThreshold(r.Config.TotalPeers)
+}
+
+// resetElectionTimeout draws a new election timeout between 3 and 6 heartbeats. Since a heartbeat sent to a stopped
+// neighbor is only gossiped further after the ack timeout, the timeout is never shorter than the ack timeout. Must be
+// called with the lock held.
+func (r *Raft) resetElectionTimeout() {
+	base := 3 * int64(r.Config.RaftHeartbeat)
+	if ackTimeout := int64(r.Config.AckTimeout); ackTimeout > base {
+		base = ackTimeout
+	}
+	r.electionTimeout = time.Duration(base + rand.Int63n(base+1))
+}
+
+// verifyBlock checks that the given block extends our blockchain and is the block we would generate for its value.
+// Unless the value is the given accepted entry, which was checked when it was appended, it must also pass the
+// proposal checker. Must be called without the lock held, since the checker may take a while.
+func (r *Raft) verifyBlock(block types.BlockchainBlock, acceptedValue *types.PaxosValue) bool {
+	expected := r.BlockGenerator(types.PaxosAcceptMessage{
+		Step:  block.Index,
+		Value: block.Value,
+	})
+	if !bytes.Equal(expected.Hash, block.Hash) || !bytes.Equal(expected.PrevHash, block.PrevHash) {
+		utils.PrintDebug("raft", r.GetAddress(), "has received a block that does not extend its blockchain")
+		return false
+	}
+	if acceptedValue != nil {
+		accepted := r.BlockGenerator(types.PaxosAcceptMessage{
+			Step:  block.Index,
+			Value: *acceptedValue,
+		})
+		if bytes.Equal(accepted.Hash, block.Hash) {
+			return true
+		}
+	}
+	return r.ProposalChecker(types.PaxosProposeMessage{
+		Step:  block.Index,
+		Value: block.Value,
+	})
+}
+
+// applyPending appends the pending committed blocks in order, as long as they are valid.
+func (r *Raft) applyPending() {
+	for {
+		r.lock.Lock()
+		step := r.step
+		block, ok := r.pendingBlocks[step]
+		acceptedValue := r.acceptedValue
+		entry := r.pendingEntry
+		if !ok && entry != nil && entry.Step <= step {
+			r.pendingEntry = nil
+		}
+		r.lock.Unlock()
+		if !ok {
+			// Append the entry we have caught up with.
+			if entry != nil && entry.Step == step {
+				_ = r.handleAppendEntry(*entry)
+			}
+			return
+		}
+		valid := r.verifyBlock(block, acceptedValue)
+		r.lock.Lock()
+		delete(r.pendingBlocks, step)
+		// The block may have been appended concurrently.
+		if !valid || r.step != step {
+			r.lock.Unlock()
+			if !valid {
+				return
+			}
+			continue
+		}
+		r.appendBlock(block)
+		r.lock.Unlock()
+		r.notifyCommit(block)
+	}
+}
+
+// appendBlock appends the given block, which must be the next block. Must be called with the lock held.
+func (r *Raft) appendBlock(block types.BlockchainBlock) {
+	r.BlockchainUpdater(block)
+	r.blocks[block.Index] = block
+	r.committed[block.Value.UniqID] = hex.EncodeToString(block.Hash)
+	r.step++
+	r.acceptedTerm = 0
+	r.acceptedValue = nil
+	// Prune the blocks that are not retained anymore, along with their values.
+	for step, oldBlock := range r.blocks {
+		if step+retainedBlocks < r.step {
+			delete(r.blocks, step)
+			delete(r.committed, oldBlock.Value.UniqID)
+		}
+	}
+}
+
+// resend sends the committed blocks from the given step to the given follower, which has missed them.
+func (r *Raft) resend(dest string, from uint) {
+	r.lock.Lock()
+	if r.role != Leader {
+		r.lock.Unlock()
+		return
+	}
+	var commitMsgs []types.RaftCommitMessage
+	for step := from; step < r.step; step++ {
+		block, ok := r.blocks[step]
+		if !ok {
+			// The follower must sync the blocks committed before our start, or pruned since.
+			continue
+		}
+		commitMsgs = append(commitMsgs, types.RaftCommitMessage{
+			Term:   r.term,
+			Leader: r.GetAddress(),
+			Step:   step,
+			Block:  block,
+			Votes:  r.quorum,
+		})
+	}
+	r.lock.Unlock()
+	utils.PrintDebug("raft", r.GetAddress(), "is resending", len(commitMsgs), "blocks to", dest)
+	for i := range commitMsgs {
+		_ = r.sendPrivate(dest, &commitMsgs[i])
+	}
+}
+
+func (r *Raft) UpdateMembership(epoch uint, newSize uint, idSpace uint) error {
+	// The thresholds are computed from the configuration whenever needed, and the leaders are elected by terms
+	// rather than proposal ids, so only the epoch needs to be updated.
+	r.membershipLock.Lock()
+	defer r.membershipLock.Unlock()
+	r.epoch = epoch
+	return nil
+}
+
+// Epoch returns the current membership epoch.
+func (r *Raft) Epoch() uint {
+	r.membershipLock.Lock()
+	defer r.membershipLock.Unlock()
+	return r.epoch
+}
+
+func (r *Raft) SkipTo(step uint) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if step <= r.step {
+		return
+	}
+	for s := range r.pendingBlocks {
+		if s < step {
+			delete(r.pendingBlocks, s)
+		}
+	}
+	r.step = step
+	r.acceptedTerm = 0
+	r.acceptedValue = nil
+}
+
+func (r *Raft) ApplyBlock(block types.BlockchainBlock) bool {
+	r.lock.Lock()
+	if block.Index != r.step {
+		r.lock.Unlock()
+		return false
+	}
+	delete(r.pendingBlocks, block.Index)
+	r.appendBlock(block)
+	r.lock.Unlock()
+	r.notifyCommit(block)
+	// The next blocks may have been committed already.
+	r.applyPending()
+	return true
+}
+
+func (r *Raft) LocalUpdate(value types.PaxosValue) (string, error) {
+	return "", nil
+}
+
+// notifyCommit informs the local proposer that its value was committed.
+func (r *Raft) notifyCommit(block types.BlockchainBlock) {
+	r.Notification.DispatchResponse(outcomeID(block.Value.UniqID), &types.RaftCommitMessage{
+		Step:  block.Index,
+		Block: block,
+	})
+}
+
+func outcomeID(uniqID string) string {
+	return "outcome-" + uniqID
+}
@@ -0,0 +1,66 @@
+package raft
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
+	"fmt"
+	"go.dedis.ch/cs438/peer/impl/utils"
+	"go.dedis.ch/cs438/transport"
+	"go.dedis.ch/cs438/types"
+)
+
+// voteDigest returns the hash of a granted vote that the voter signs. The protocol id is included, so that a vote
+// cannot be replayed as a proof of election in the other protocols.
+func voteDigest(protocolID string, vote types.RaftVoteMessage) [32]byte {
+	return utils.Hash([]byte(fmt.Sprintf("%s:%d:%s", protocolID, vote.Term, vote.Candidate)))
+}
+
+// SignVote signs the given granted vote of the given protocol with the given private key, whose public key signed by
+// the CA is attached to the vote.
+func SignVote(vote *types.RaftVoteMessage, protocolID string, privateKey *rsa.PrivateKey,
+	signedPK *transport.SignedPublicKey) error {
+	digest := voteDigest(protocolID, *vote)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return fmt.Errorf("could not sign vote: %w", err)
+	}
+	vote.PublicKey = signedPK
+	vote.Signature = signature
+	return nil
+}
+
+// signVote signs the given granted vote, unless there is no cryptography layer.
+func (r *Raft) signVote(vote *types.RaftVoteMessage) error {
+	if r.crypto == nil {
+		return nil
+	}
+	return SignVote(vote, r.ProtocolID, r.crypto.GetPrivateKey(), r.crypto.GetSignedPublicKey())
+}
+
+// voter returns the identity of the peer that has granted the given vote, and whether the vote is valid. With a
+// cryptography layer, the voter is identified by its public key: the key must be signed by the CA and not revoked,
+// and the vote must be signed with it, so that a candidate cannot forge the votes of a majority. Without it, the votes
+// cannot be authenticated, and the voter is identified by its address.
+func (r *Raft) voter(vote types.RaftVoteMessage) (string, bool) {
+	if r.crypto == nil {
+		return vote.Source, true
+	}
+	if vote.PublicKey == nil || vote.PublicKey.PublicKey == nil {
+		return "", false
+	}
+	publicKey := vote.PublicKey.PublicKey
+	if !utils.VerifyPublicKeySignature(publicKey, vote.PublicKey.Signature, r.crypto.GetCAPublicKey()) {
+		return "", false
+	}
+	hashedPK := utils.HashPublicKey(publicKey)
+	if r.crypto.IsRevoked(hashedPK) {
+		return "", false
+	}
+	digest := voteDigest(r.ProtocolID, vote)
+	if rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], vote.Signature) != nil {
+		return "", false
+	}
+	return hex.EncodeToString(hashedPK[:]), true
+}
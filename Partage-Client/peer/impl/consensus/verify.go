@@ -0,0 +1,123 @@
+package consensus
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"go.dedis.ch/cs438/peer"
+	"go.dedis.ch/cs438/peer/impl/content"
+	"go.dedis.ch/cs438/peer/impl/utils"
+	"go.dedis.ch/cs438/storage"
+	"go.dedis.ch/cs438/types"
+)
+
+// BlockHasher recomputes the hash of a block from its content.
+type BlockHasher = func(types.BlockchainBlock) []byte
+
+// DefaultBlockHasher hashes the blocks of the default (naming) blockchain.
+func DefaultBlockHasher(block types.BlockchainBlock) []byte {
+	return utils.HashNameBlock(int(block.Index), block.Value.UniqID, block.Value.Filename, block.Value.Metahash,
+		block.PrevHash)
+}
+
+// MetadataBlockHasher hashes the blocks of the registration and feed blockchains, which carry one or more content
+// metadata.
+func MetadataBlockHasher(block types.BlockchainBlock) []byte {
+	batch := content.ParseMetadataBatch(block.Value.CustomValue)
+	return content.HashMetadataBatch(block.Index, block.Value.UniqID, batch, block.PrevHash)
+}
+
+// BlockHasherFromProtocolID returns the block hasher used by the protocol associated with the given protocol id.
+func BlockHasherFromProtocolID(protocolID string) BlockHasher {
+	if protocolID == "default" {
+		return DefaultBlockHasher
+	}
+	return MetadataBlockHasher
+}
+
+// BlockchainStoreFromProtocolID returns the store that holds the blockchain of the protocol associated with the given
+// protocol id. The default (naming) blockchain is kept in the storage of the peer, and the others in the blockchain
+// storage.
+func BlockchainStoreFromProtocolID(protocolID string, config *peer.Configuration) storage.Store {
+	if protocolID == "default" {
+		return config.Storage.GetBlockchainStore()
+	}
+	return config.BlockchainStorage.GetStore(protocolID)
+}
+
+// BrokenLinkError describes the first broken link of a blockchain.
+type BrokenLinkError struct {
+	// Index is the index of the block at which the blockchain is broken.
+	Index uint
+	// BlockHash is the hex-encoded hash under which the broken block is stored.
+	BlockHash string
+	Reason    string
+}
+
+func (e BrokenLinkError) Error() string {
+	return fmt.Sprintf("broken link at block %d (%s): %s", e.Index, e.BlockHash, e.Reason)
+}
+
+// VerifyBlockchain verifies the blockchain kept in the given store. It walks the blockchain from the last block back
+// to the first block, and checks that every block links to the previous one, that the indices are contiguous and
+// that the stored hashes match the ones recomputed with the given hasher.
+// Returns the number of blocks in the blockchain, or a BrokenLinkError describing the first broken link.
+func VerifyBlockchain(blockchainStore storage.Store, hasher BlockHasher) (int, error) {
+	firstPrevHash := make([]byte, 32)
+	lastBlockHash := blockchainStore.Get(storage.LastBlockKey)
+	// An empty blockchain is valid.
+	if lastBlockHash == nil {
+		return 0, nil
+	}
+	// Collect the blocks from the last one to the first one.
+	var blocks []types.BlockchainBlock
+	blockHash := hex.EncodeToString(lastBlockHash)
+	for {
+		blockBuf := blockchainStore.Get(blockHash)
+		var block types.BlockchainBlock
+		if blockBuf == nil || block.Unmarshal(blockBuf) != nil {
+			// The earlier blocks are unreachable, so this is the first broken link that we can find.
+			brokenLink := BrokenLinkError{BlockHash: blockHash, Reason: "missing or malformed block"}
+			if len(blocks) > 0 && blocks[len(blocks)-1].Index > 0 {
+				brokenLink.Index = blocks[len(blocks)-1].Index - 1
+			}
+			return 0, brokenLink
+		}
+		blocks = append(blocks, block)
+		if bytes.Equal(block.PrevHash, firstPrevHash) {
+			break
+		}
+		// Do not loop forever on a cyclic blockchain.
+		if len(blocks) > blockchainStore.Len() {
+			return 0, BrokenLinkError{Index: block.Index, BlockHash: blockHash, Reason: "cyclic blockchain"}
+		}
+		blockHash = hex.EncodeToString(block.PrevHash)
+	}
+	// Check the blocks from the first one to the last one, so that we report the first broken link.
+	for i := len(blocks) - 1; i >= 0; i-- {
+		block := blocks[i]
+		blockHash := hex.EncodeToString(block.Hash)
+		expectedIndex := uint(len(blocks) - 1 - i)
+		if block.Index != expectedIndex {
+			return 0, BrokenLinkError{
+				Index:     block.Index,
+				BlockHash: blockHash,
+				Reason:    fmt.Sprintf("expected index %d", expectedIndex),
+			}
+		}
+		expectedPrevHash := firstPrevHash
+		if i < len(blocks)-1 {
+			expectedPrevHash = blocks[i+1].Hash
+		}
+		if i == 0 && !bytes.Equal(block.Hash, lastBlockHash) {
+			return 0, BrokenLinkError{Index: block.Index, BlockHash: blockHash, Reason: "last block hash mismatch"}
+		}
+		if !bytes.Equal(block.PrevHash, expectedPrevHash) {
+			return 0, BrokenLinkError{Index: block.Index, BlockHash: blockHash, Reason: "previous hash mismatch"}
+		}
+		if !bytes.Equal(hasher(block), block.Hash) {
+			return 0, BrokenLinkError{Index: block.Index, BlockHash: blockHash, Reason: "hash mismatch"}
+		}
+	}
+	return len(blocks), nil
+}
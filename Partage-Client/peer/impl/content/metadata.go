@@ -1,7 +1,12 @@
 package content
 
 import (
+	"bytes"
 	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 )
@@ -40,12 +45,87 @@ func UnparseMetadata(value Metadata) []byte {
 }
 
 func HashMetadata(index uint, uniqID string, metadata Metadata, prevHash []byte) []byte {
+	return HashMetadataBatch(index, uniqID, []Metadata{metadata}, prevHash)
+}
+
+// ParseMetadataBatch parses the list of metadata held by a block. A block holds either a single metadata or a list of
+// metadata that were decided together.
+func ParseMetadataBatch(customValue []byte) []Metadata {
+	trimmed := bytes.TrimSpace(customValue)
+	if len(trimmed) == 0 || trimmed[0] != '[' {
+		return []Metadata{ParseMetadata(customValue)}
+	}
+	var batch []Metadata
+	_ = json.Unmarshal(trimmed, &batch)
+	return batch
+}
+
+// UnparseMetadataBatch encodes the given list of metadata into a block value. A single metadata is encoded on its own,
+// so that the blocks holding a single metadata are left unchanged.
+func UnparseMetadataBatch(batch []Metadata) []byte {
+	if len(batch) == 1 {
+		return UnparseMetadata(batch[0])
+	}
+	b, err := json.Marshal(batch)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// HashMetadataBatch hashes a block holding the given list of metadata. Same as HashMetadata for a single metadata.
+func HashMetadataBatch(index uint, uniqID string, batch []Metadata, prevHash []byte) []byte {
 	h := crypto.SHA256.New()
-	metadataBytes := UnparseMetadata(metadata)
 	h.Write([]byte(fmt.Sprint(index)))
 	h.Write([]byte(uniqID))
-	h.Write(metadataBytes)
+	for _, metadata := range batch {
+		h.Write(UnparseMetadata(metadata))
+	}
 	h.Write(prevHash)
 	hashSlice := h.Sum(nil)
 	return hashSlice
 }
+
+// EntryHash returns the hex-encoded hash that identifies the metadata at the given position of the block with the
+// given hex-encoded hash. The first metadata is identified by the block hash itself, so that the blocks holding a
+// single metadata keep their usual identifier.
+func EntryHash(blockHash string, position int) string {
+	if position == 0 {
+		return blockHash
+	}
+	hashed := sha256.Sum256([]byte(fmt.Sprint(blockHash, position)))
+	return hex.EncodeToString(hashed[:])
+}
+
+// hashForSignature returns the hash of the metadata without its signature field. This is the value that gets signed
+// by the owner of the feed.
+func hashForSignature(metadata Metadata) [32]byte {
+	metadata.Signature = nil
+	return sha256.Sum256(UnparseMetadata(metadata))
+}
+
+// Sign signs the metadata with the given private key and stores the signature in the Signature field.
+func (m *Metadata) Sign(privateKey *rsa.PrivateKey) error {
+	hashed := hashForSignature(*m)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, hashed[:])
+	if err != nil {
+		return fmt.Errorf("could not sign metadata: %w", err)
+	}
+	m.Signature = signature
+	return nil
+}
+
+// VerifySignature checks whether the metadata was signed by the private key associated with the given public key.
+func (m Metadata) VerifySignature(publicKey *rsa.PublicKey) error {
+	if publicKey == nil {
+		return fmt.Errorf("no public key to verify the metadata against")
+	}
+	if len(m.Signature) == 0 {
+		return fmt.Errorf("metadata is not signed")
+	}
+	hashed := hashForSignature(m)
+	if rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, hashed[:], m.Signature) != nil {
+		return fmt.Errorf("metadata has invalid signature")
+	}
+	return nil
+}
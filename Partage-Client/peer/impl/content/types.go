@@ -2,9 +2,10 @@ package content
 
 import (
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"github.com/rs/xid"
-	"go.dedis.ch/cs438/peer/impl/utils"
+	"go.dedis.ch/cs438/transport"
 	"strconv"
 )
 
@@ -23,6 +24,10 @@ const (
 	ENDORSEMENT_REQUEST
 	UNDO
 	DUMMY
+	// New types are appended below, since the types are persisted and sent by value.
+	LEAVE
+	EDIT
+	REMOVE
 )
 
 func (c Type) String() string {
@@ -45,6 +50,12 @@ func (c Type) String() string {
 		return "endorsement_request"
 	case UNDO:
 		return "undo"
+	case LEAVE:
+		return "leave"
+	case EDIT:
+		return "edit"
+	case REMOVE:
+		return "remove"
 	}
 	return "unknown"
 }
@@ -57,40 +68,74 @@ func (c Type) Cost() int {
 		return 2
 	case REACTION:
 		return 1
+	case EDIT:
+		return 2
 	}
 	return 0
 }
 
-func CreateJoinMetadata(userID string, timestamp int64) Metadata {
+// CreateJoinMetadata creates a JOIN metadata. The signed public key of the user, if given, is recorded in the
+// registration blockchain, so that the signatures of the user can be verified without searching for the key.
+func CreateJoinMetadata(userID string, timestamp int64, signedPK *transport.SignedPublicKey) Metadata {
+	var data []byte
+	if signedPK != nil {
+		data, _ = json.Marshal(signedPK)
+	}
 	return Metadata{
 		FeedUserID:   userID,
 		Type:         JOIN,
 		ContentID:    "",
 		RefContentID: "",
 		Timestamp:    timestamp,
+		Data:         data,
+		Signature:    nil,
+	}
+}
+
+func CreateLeaveMetadata(userID string, timestamp int64) Metadata {
+	return Metadata{
+		FeedUserID:   userID,
+		Type:         LEAVE,
+		ContentID:    "",
+		RefContentID: "",
+		Timestamp:    timestamp,
 		Data:         nil,
 		Signature:    nil,
 	}
 }
 
-func CreateChangeUsernameMetadata(userID string, newUsername string) Metadata {
+// CreateRemoveMetadata creates a REMOVE metadata, with which a member of the system votes to remove another member
+// that has become unresponsive.
+func CreateRemoveMetadata(userID string, timestamp int64, removedUserID string) Metadata {
+	data, _ := hex.DecodeString(removedUserID)
+	return Metadata{
+		FeedUserID: userID,
+		Type:       REMOVE,
+		ContentID:  "",
+		Timestamp:  timestamp,
+		Data:       data,
+		Signature:  nil,
+	}
+}
+
+func CreateChangeUsernameMetadata(userID string, timestamp int64, newUsername string) Metadata {
 	return Metadata{
 		FeedUserID: userID,
 		Type:       USERNAME,
 		ContentID:  "",
-		Timestamp:  utils.Time(),
+		Timestamp:  timestamp,
 		Data:       []byte(newUsername),
 		Signature:  nil,
 	}
 }
 
-func CreateFollowUserMetadata(userID string, targetUserID string) Metadata {
+func CreateFollowUserMetadata(userID string, timestamp int64, targetUserID string) Metadata {
 	data, _ := hex.DecodeString(targetUserID)
 	return Metadata{
 		FeedUserID: userID,
 		Type:       FOLLOW,
 		ContentID:  "",
-		Timestamp:  utils.Time(),
+		Timestamp:  timestamp,
 		Data:       data,
 		Signature:  nil,
 	}
@@ -162,6 +207,12 @@ func CreateDownloadableContentMetadata(userID string, timestamp int64, refConten
 	}
 }
 
+// CreateEditMetadata creates the metadata of an edit of the given text or comment. refContentID is the content id of
+// the text or comment that is edited, and metahash refers to its new version.
+func CreateEditMetadata(userID string, timestamp int64, refContentID string, metahash string) Metadata {
+	return CreateDownloadableContentMetadata(userID, timestamp, refContentID, metahash, EDIT)
+}
+
 func CreateReactionMetadata(userID string, reaction Reaction, timestamp int64, refContentID string) Metadata {
 	return Metadata{
 		Type:         REACTION,
@@ -192,6 +243,22 @@ func ParseUsername(metadata Metadata) (string, error) {
 	return string(metadata.Data), nil
 }
 
+// ParseJoinPublicKey extracts the signed public key of the user from a JOIN metadata object.
+func ParseJoinPublicKey(metadata Metadata) (*transport.SignedPublicKey, error) {
+	if metadata.Type != JOIN {
+		return nil, fmt.Errorf("cannot extract the public key from non-join metadata")
+	}
+	if len(metadata.Data) == 0 {
+		return nil, fmt.Errorf("join metadata has no public key")
+	}
+	var signedPK transport.SignedPublicKey
+	err := json.Unmarshal(metadata.Data, &signedPK)
+	if err != nil || signedPK.PublicKey == nil {
+		return nil, fmt.Errorf("join metadata has a malformed public key")
+	}
+	return &signedPK, nil
+}
+
 // ParseFollowedUser extracts the target followed user id string from a FOLLOW metadata object.
 func ParseFollowedUser(metadata Metadata) (string, error) {
 	if metadata.Type != FOLLOW {
@@ -208,9 +275,17 @@ func ParseEndorsedUserID(metadata Metadata) (string, error) {
 	return hex.EncodeToString(metadata.Data), nil
 }
 
-// ParsePostMetadata extracts the metahash for the post object from a TEXT or COMMENT metadata object.
+// ParseRemovedUserID extracts the id of the removed user from a REMOVE metadata object.
+func ParseRemovedUserID(metadata Metadata) (string, error) {
+	if metadata.Type != REMOVE {
+		return "", fmt.Errorf("cannot extract the removed user id from non-remove metadata")
+	}
+	return hex.EncodeToString(metadata.Data), nil
+}
+
+// ParsePostMetadata extracts the metahash for the post object from a TEXT, COMMENT, or EDIT metadata object.
 func ParsePostMetadata(metadata Metadata) (string, error) {
-	if metadata.Type != TEXT && metadata.Type != COMMENT {
+	if metadata.Type != TEXT && metadata.Type != COMMENT && metadata.Type != EDIT {
 		return "", fmt.Errorf("cannot extract the metahash from non-text metadata")
 	}
 	return string(metadata.Data), nil
@@ -11,6 +11,7 @@ import (
 func (l *Layer) RegisterHandlers() {
 	l.config.MessageRegistry.RegisterMessageCallback(types.SearchPKReplyMessage{}, l.SearchPKReplyMessageHandler)
 	l.config.MessageRegistry.RegisterMessageCallback(types.SearchPKRequestMessage{}, l.SearchPKRequestMessageHandler)
+	l.config.MessageRegistry.RegisterMessageCallback(types.RevocationListMessage{}, l.RevocationListMessageHandler)
 }
 
 func (l *Layer) SearchPKReplyMessageHandler(msg types.Message, pkt transport.Packet) error {
@@ -26,13 +27,22 @@ func (l *Layer) SearchPKReplyMessageHandler(msg types.Message, pkt transport.Pac
 	}
 	// CHECK IF PUBLIC KEY IS SIGNED BY TRUSTED CA!
 	isValid := utils.VerifyPublicKeySignature(searchPKReplyMsg.Response.PublicKey, searchPKReplyMsg.Response.Signature, CAPublicKey)
-	if isValid {
-		l.notification.DispatchResponse(searchPKReplyMsg.RequestID, msg)
-
-		bytesPK, _ := utils.PublicKeyToBytes(searchPKReplyMsg.Response.PublicKey)
-		//add entry to catalog
-		l.AddUserToCatalog(utils.Hash(bytesPK), &searchPKReplyMsg.Response)
+	if !isValid {
+		return nil
+	}
+	// Drop the CA-signed public keys of other users than the one we are looking for.
+	bytesPK, err := utils.PublicKeyToBytes(searchPKReplyMsg.Response.PublicKey)
+	if err != nil {
+		return nil
 	}
+	hashedPK, ok := l.searchedPublicKey(searchPKReplyMsg.RequestID)
+	if !ok || utils.Hash(bytesPK) != hashedPK {
+		utils.PrintDebug("searchPK", l.GetAddress(), "is ignoring a search PK reply with another public key")
+		return nil
+	}
+	l.notification.DispatchResponse(searchPKReplyMsg.RequestID, msg)
+	//add entry to catalog
+	l.AddUserToCatalog(hashedPK, &searchPKReplyMsg.Response)
 
 	return nil
 }
@@ -89,3 +99,19 @@ func (l *Layer) SearchPKRequestMessageHandler(msg types.Message, pkt transport.P
 
 	return nil
 }
+
+// RevocationListMessageHandler applies the revocation list spread in the network if it is more recent than ours.
+func (l *Layer) RevocationListMessageHandler(msg types.Message, pkt transport.Packet) error {
+	revocationListMsg, ok := msg.(*types.RevocationListMessage)
+	if !ok {
+		return fmt.Errorf("could not parse the received revocation list msg")
+	}
+	updated, err := l.socket.UpdateRevocationList(&revocationListMsg.List)
+	if err != nil {
+		return fmt.Errorf("could not apply the received revocation list: %w", err)
+	}
+	if updated {
+		utils.PrintDebug("tls", l.GetAddress(), "has applied the revocation list", revocationListMsg.List.Serial)
+	}
+	return nil
+}
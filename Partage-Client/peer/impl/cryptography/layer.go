@@ -7,6 +7,7 @@ import (
 
 	"github.com/rs/xid"
 	"go.dedis.ch/cs438/peer"
+	"go.dedis.ch/cs438/peer/impl/dht"
 	"go.dedis.ch/cs438/peer/impl/network"
 	"go.dedis.ch/cs438/peer/impl/utils"
 	"go.dedis.ch/cs438/transport"
@@ -16,15 +17,18 @@ import (
 
 type Layer struct {
 	network *network.Layer
+	dht     *dht.Layer
 
 	notification            *utils.AsyncNotificationHandler
 	config                  *peer.Configuration
 	socket                  *tcptls.Socket
 	processedSearchRequests map[string]struct{}
-	expandingConf           peer.ExpandingRing
+	// Maps the ids of our own search requests to the hash of the public key they look for.
+	searchedPublicKeys map[string][32]byte
+	expandingConf      peer.ExpandingRing
 }
 
-func Construct(network *network.Layer, config *peer.Configuration) *Layer {
+func Construct(network *network.Layer, dht *dht.Layer, config *peer.Configuration) *Layer {
 	socket, ok := config.Socket.(*tcptls.Socket)
 	if !ok {
 		panic("node must have a tcp socket in order to use tls")
@@ -35,9 +39,11 @@ func Construct(network *network.Layer, config *peer.Configuration) *Layer {
 	}
 	return &Layer{
 		network:                 network,
+		dht:                     dht,
 		config:                  config,
 		socket:                  socket,
 		processedSearchRequests: make(map[string]struct{}),
+		searchedPublicKeys:      make(map[string][32]byte),
 		notification:            utils.NewAsyncNotificationHandler(),
 		expandingConf: peer.ExpandingRing{ //TODO: should be depend on the qnt of network nodes
 			Initial: 1,
@@ -53,11 +59,11 @@ func (l *Layer) GetAddress() string {
 }
 
 // Send encapsulates the transport.Message contained in pkt.Msg, into a types.SignedMessage by adding a layer of
-//security and sends it through the TLS socket
+//security and sends it through the TLS socket. Within a mutually-authenticated session with the destination itself,
+//the socket adds a MAC to the packet instead of signing it with myPrivateKey. The packets sent through a relay are
+//signed, so that the destination can authenticate their source.
 func (l *Layer) Send(dest string, pkt transport.Packet, timeout time.Duration) error {
-	// Add Validation check to packet's header (Signs packet with myPrivateKey!)
-	pkt.AddValidation(l.GetPrivateKey(), l.GetSignedPublicKey())
-	return l.network.Send(dest, pkt, timeout)
+	return l.socket.SendAuthenticated(dest, pkt, timeout)
 }
 
 func (l *Layer) Unicast(dest string, msg transport.Message) error {
@@ -145,15 +151,19 @@ func (l *Layer) SearchPublicKey(hashedPK [32]byte, conf *peer.ExpandingRing) *rs
 		return signedPK.PublicKey
 	}
 
+	// Ask the owner of the public key, found in the DHT, before flooding the network.
+	publicKey := l.searchPublicKeyInDHT(hashedPK, conf.Timeout)
+	if publicKey != nil {
+		return publicKey
+	}
+
 	// Initiate the expanding ring search.
 	budget := conf.Initial
 	for i := uint(0); i < conf.Retry; i++ {
 		// Create the search request id.
 		searchRequestID := xid.New().String()
 		// Save the search request id.
-		l.socket.CatalogLock.Lock()
-		l.processedSearchRequests[searchRequestID] = struct{}{}
-		l.socket.CatalogLock.Unlock()
+		l.saveSearchRequest(searchRequestID, hashedPK)
 		budgetMap := utils.DistributeBudget(budget, l.network.GetNeighbors())
 		for neighbor, budget := range budgetMap {
 			// Create the search request.
@@ -174,13 +184,15 @@ func (l *Layer) SearchPublicKey(hashedPK [32]byte, conf *peer.ExpandingRing) *rs
 		}
 		// Collect the received responses.
 		collectedResponses := l.notification.MultiResponseCollector(searchRequestID, conf.Timeout, -1)
+		l.forgetSearchRequest(searchRequestID)
 		utils.PrintDebug("searchPK", l.GetAddress(), "has received the following search PK RESPONSES during the timeout", collectedResponses)
 		// Iterate through all the received responses within the timeout.
 		//var signedPK types.SignedPublicKey
 		for _, resp := range collectedResponses {
 			searchResp := resp.(*types.SearchPKReplyMessage)
-			//if it is in the collectedResponses it's because it is valid...
-			return searchResp.Response.PublicKey // signedPK.PublicKey //found the user's Public Key
+			if utils.HashPublicKey(searchResp.Response.PublicKey) == hashedPK {
+				return searchResp.Response.PublicKey //found the user's Public Key
+			}
 		}
 		// no PK found yet..increase the budget and try again.
 		budget = budget * conf.Factor
@@ -188,6 +200,60 @@ func (l *Layer) SearchPublicKey(hashedPK [32]byte, conf *peer.ExpandingRing) *rs
 	return nil
 }
 
+// searchPublicKeyInDHT sends a search request with a budget of 1 to the providers of the public key in the DHT, and
+// returns the first valid reply. Returns nil if the reply holds another public key than the requested one, since
+// anyone can announce itself as a provider.
+func (l *Layer) searchPublicKeyInDHT(hashedPK [32]byte, timeout time.Duration) *rsa.PublicKey {
+	providers := l.dht.FindProviders(dht.PublicKeyKey(hashedPK))
+	if len(providers) == 0 {
+		return nil
+	}
+	searchRequestID := xid.New().String()
+	l.saveSearchRequest(searchRequestID, hashedPK)
+	defer l.forgetSearchRequest(searchRequestID)
+	msg := types.SearchPKRequestMessage{
+		RequestID: searchRequestID,
+		Origin:    l.GetAddress(),
+		Username:  hashedPK,
+		Budget:    1,
+	}
+	transpMsg, _ := l.config.MessageRegistry.MarshalMessage(&msg)
+	for provider := range providers {
+		err := l.network.Unicast(provider, transpMsg)
+		if err != nil {
+			utils.PrintDebug("searchPK", "Could not unicast the search PK request to the provider", provider)
+		}
+	}
+	reply, ok := l.notification.ResponseCollector(searchRequestID, timeout).(*types.SearchPKReplyMessage)
+	if !ok || utils.HashPublicKey(reply.Response.PublicKey) != hashedPK {
+		return nil
+	}
+	return reply.Response.PublicKey
+}
+
+// saveSearchRequest saves the id of our search request for the public key with the given hash.
+func (l *Layer) saveSearchRequest(searchRequestID string, hashedPK [32]byte) {
+	l.socket.CatalogLock.Lock()
+	defer l.socket.CatalogLock.Unlock()
+	l.processedSearchRequests[searchRequestID] = struct{}{}
+	l.searchedPublicKeys[searchRequestID] = hashedPK
+}
+
+// forgetSearchRequest stops accepting the replies to our search request.
+func (l *Layer) forgetSearchRequest(searchRequestID string) {
+	l.socket.CatalogLock.Lock()
+	defer l.socket.CatalogLock.Unlock()
+	delete(l.searchedPublicKeys, searchRequestID)
+}
+
+// searchedPublicKey returns the hash of the public key our search request looks for.
+func (l *Layer) searchedPublicKey(searchRequestID string) ([32]byte, bool) {
+	l.socket.CatalogLock.RLock()
+	defer l.socket.CatalogLock.RUnlock()
+	hashedPK, ok := l.searchedPublicKeys[searchRequestID]
+	return hashedPK, ok
+}
+
 func (l *Layer) GetExpandingConf() *peer.ExpandingRing {
 	return &l.expandingConf
 }
@@ -212,6 +278,10 @@ func (l *Layer) IsBlocked(hash [32]byte) bool{
 	return l.socket.IsBlocked(hash)
 }
 
+func (l *Layer) IsRevoked(hash [32]byte) bool {
+	return l.socket.IsRevoked(hash)
+}
+
 func (l *Layer) AddBlockedIP(addr string,publicKeyHash [32]byte) {
 	l.socket.AddBlockedIP(addr,publicKeyHash)
 }
@@ -0,0 +1,266 @@
+package data
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+
+	"go.dedis.ch/cs438/peer"
+	"go.dedis.ch/cs438/storage"
+)
+
+// blobStore wraps the data blob store of the peer to keep it within a byte quota: once the quota is exceeded, the least
+// recently used blobs are evicted, except the pinned ones. Pinning a metahash pins its metafile and all its chunks. The
+// pins are either explicit (e.g., the uploads of the user), or given by the feeds (see SetFeedPins). The pinned blobs
+// are never evicted, even if they don't fit in the quota.
+type blobStore struct {
+	sync.Mutex
+	store storage.Store
+	quota uint
+	size  uint
+	// Blobs from the most to the least recently used.
+	lru      *list.List
+	elements map[string]*list.Element
+	// Number of explicit pins of each metahash.
+	pins map[string]uint
+	// Returns the metahashes pinned by the feeds, if set.
+	feedPins func() map[string]struct{}
+	// Number of ongoing operations during which nothing is evicted.
+	holds uint
+}
+
+type blobEntry struct {
+	key  string
+	size uint
+}
+
+// newBlobStore wraps the given store, whose blobs are all considered as used in no particular order.
+func newBlobStore(store storage.Store, quota uint) *blobStore {
+	b := &blobStore{
+		store:    store,
+		quota:    quota,
+		lru:      list.New(),
+		elements: make(map[string]*list.Element),
+		pins:     make(map[string]uint),
+	}
+	store.ForEach(func(key string, val []byte) bool {
+		b.touch(key, uint(len(val)))
+		return true
+	})
+	return b
+}
+
+// Get implements storage.Store
+func (b *blobStore) Get(key string) []byte {
+	b.Lock()
+	defer b.Unlock()
+	val := b.store.Get(key)
+	if val != nil {
+		b.touch(key, uint(len(val)))
+	}
+	return val
+}
+
+// Set implements storage.Store. The least recently used blobs are evicted if the quota is exceeded.
+func (b *blobStore) Set(key string, val []byte) {
+	b.Lock()
+	b.store.Set(key, val)
+	b.touch(key, uint(len(val)))
+	exceeded := b.quota > 0 && b.size > b.quota && b.holds == 0
+	b.Unlock()
+	if exceeded {
+		b.evict()
+	}
+}
+
+// Delete implements storage.Store
+func (b *blobStore) Delete(key string) {
+	b.Lock()
+	defer b.Unlock()
+	b.delete(key)
+}
+
+// Len implements storage.Store
+func (b *blobStore) Len() int {
+	return b.store.Len()
+}
+
+// ForEach implements storage.Store. The blobs are not marked as used.
+func (b *blobStore) ForEach(f func(key string, val []byte) bool) {
+	b.store.ForEach(f)
+}
+
+// pin protects the file with the given metahash from eviction and garbage collection, until it is unpinned as many
+// times as it was pinned.
+func (b *blobStore) pin(metahash string) {
+	b.Lock()
+	defer b.Unlock()
+	b.pins[metahash]++
+}
+
+func (b *blobStore) unpin(metahash string) {
+	b.Lock()
+	defer b.Unlock()
+	if b.pins[metahash] <= 1 {
+		delete(b.pins, metahash)
+		return
+	}
+	b.pins[metahash]--
+}
+
+// hold prevents the eviction of any blob until release is called, e.g., while a file is uploaded and its metahash is
+// not known yet.
+func (b *blobStore) hold() {
+	b.Lock()
+	defer b.Unlock()
+	b.holds++
+}
+
+// release ends a hold, and evicts the blobs that don't fit in the quota anymore.
+func (b *blobStore) release() {
+	b.Lock()
+	b.holds--
+	exceeded := b.quota > 0 && b.size > b.quota && b.holds == 0
+	b.Unlock()
+	if exceeded {
+		b.evict()
+	}
+}
+
+// setFeedPins sets the function that returns the metahashes pinned by the feeds.
+func (b *blobStore) setFeedPins(feedPins func() map[string]struct{}) {
+	b.Lock()
+	defer b.Unlock()
+	b.feedPins = feedPins
+}
+
+// evict removes the least recently used blobs that are not pinned, until the store fits in the quota.
+func (b *blobStore) evict() {
+	roots := b.pinnedRoots()
+	b.Lock()
+	defer b.Unlock()
+	if b.holds > 0 {
+		return
+	}
+	pinned := b.reachable(roots)
+	for e := b.lru.Back(); e != nil && b.size > b.quota; {
+		prev := e.Prev()
+		key := e.Value.(*blobEntry).key
+		if _, ok := pinned[key]; !ok {
+			b.delete(key)
+		}
+		e = prev
+	}
+}
+
+// collect removes the blobs that are neither reachable from the given metahashes, nor pinned. Returns the number of
+// removed blobs. Nothing is removed during a hold, since the chunks of an ongoing upload are not reachable yet: they are
+// collected next time if they are still unreferenced.
+func (b *blobStore) collect(referenced map[string]struct{}) uint {
+	roots := b.pinnedRoots()
+	for metahash := range referenced {
+		roots[metahash] = struct{}{}
+	}
+	b.Lock()
+	defer b.Unlock()
+	if b.holds > 0 {
+		return 0
+	}
+	kept := b.reachable(roots)
+	var garbage []string
+	b.store.ForEach(func(key string, val []byte) bool {
+		if _, ok := kept[key]; !ok {
+			garbage = append(garbage, key)
+		}
+		return true
+	})
+	for _, key := range garbage {
+		b.delete(key)
+	}
+	return uint(len(garbage))
+}
+
+// pinnedRoots returns the explicitly pinned metahashes along with the ones pinned by the feeds. The feeds are queried
+// without holding the lock.
+func (b *blobStore) pinnedRoots() map[string]struct{} {
+	b.Lock()
+	roots := make(map[string]struct{}, len(b.pins))
+	for metahash := range b.pins {
+		roots[metahash] = struct{}{}
+	}
+	feedPins := b.feedPins
+	b.Unlock()
+	if feedPins != nil {
+		for metahash := range feedPins() {
+			roots[metahash] = struct{}{}
+		}
+	}
+	return roots
+}
+
+// reachable returns the given metahashes along with the chunks of their stored metafiles.
+// Warning: thread-unsafe
+func (b *blobStore) reachable(roots map[string]struct{}) map[string]struct{} {
+	blobs := make(map[string]struct{}, len(roots))
+	for metahash := range roots {
+		blobs[metahash] = struct{}{}
+		metafile := b.store.Get(metahash)
+		if metafile == nil {
+			continue
+		}
+		for _, chunkHash := range strings.Split(string(metafile), peer.MetafileSep) {
+			blobs[chunkHash] = struct{}{}
+		}
+	}
+	return blobs
+}
+
+// touch marks the given blob as the most recently used one, and updates its size.
+// Warning: thread-unsafe
+func (b *blobStore) touch(key string, size uint) {
+	e, ok := b.elements[key]
+	if !ok {
+		b.elements[key] = b.lru.PushFront(&blobEntry{key: key, size: size})
+		b.size += size
+		return
+	}
+	entry := e.Value.(*blobEntry)
+	b.size = b.size - entry.size + size
+	entry.size = size
+	b.lru.MoveToFront(e)
+}
+
+// delete removes the given blob.
+// Warning: thread-unsafe
+func (b *blobStore) delete(key string) {
+	b.store.Delete(key)
+	e, ok := b.elements[key]
+	if !ok {
+		return
+	}
+	b.size -= e.Value.(*blobEntry).size
+	b.lru.Remove(e)
+	delete(b.elements, key)
+}
+
+// Pin implements peer.DataSharing
+func (l *Layer) Pin(metahash string) {
+	l.blobs.pin(metahash)
+}
+
+// Unpin implements peer.DataSharing
+func (l *Layer) Unpin(metahash string) {
+	l.blobs.unpin(metahash)
+}
+
+// SetFeedPins sets the function that returns the metahashes of the contents that must be kept, e.g., the posts of the
+// followees.
+func (l *Layer) SetFeedPins(feedPins func() map[string]struct{}) {
+	l.blobs.setFeedPins(feedPins)
+}
+
+// CollectGarbage removes the blobs that are neither pinned, nor part of the files with the given metahashes. Returns
+// the number of removed blobs.
+func (l *Layer) CollectGarbage(referenced map[string]struct{}) uint {
+	return l.blobs.collect(referenced)
+}
@@ -3,8 +3,11 @@ package data
 import (
 	"fmt"
 	"github.com/rs/xid"
+	"go.dedis.ch/cs438/peer"
 	content2 "go.dedis.ch/cs438/peer/impl/content"
+	"go.dedis.ch/cs438/peer/impl/dht"
 	"go.dedis.ch/cs438/peer/impl/utils"
+	"io"
 	"time"
 )
 
@@ -59,13 +62,32 @@ func (l *Layer) SearchAllPostContent(filter content2.Filter, budget uint, timeou
 }
 
 func (l *Layer) DownloadContent(contentID string) ([]byte, error) {
+	metahash, err := l.contentMetahash(contentID)
+	if err != nil {
+		return nil, err
+	}
+	l.findProviders(metahash, dht.ContentKey(contentID))
+	return l.Download(metahash)
+}
+
+func (l *Layer) DownloadContentTo(contentID string, w io.Writer, progress peer.Progress) error {
+	metahash, err := l.contentMetahash(contentID)
+	if err != nil {
+		return err
+	}
+	l.findProviders(metahash, dht.ContentKey(contentID))
+	return l.DownloadTo(metahash, w, progress)
+}
+
+// contentMetahash returns the metahash of the post content with the given content id.
+func (l *Layer) contentMetahash(contentID string) (string, error) {
 	// First, get the metadata with the given content id.
 	metadataBytes := l.config.BlockchainStorage.GetStore("metadata").Get(contentID)
 	if metadataBytes == nil {
-		return nil, fmt.Errorf("unknown content id")
+		return "", fmt.Errorf("unknown content id")
 	}
 	metadata := content2.ParseMetadata(metadataBytes)
 	// Then, get the metahash associated with the given post content.
 	metahash, _ := content2.ParsePostMetadata(metadata)
-	return l.Download(metahash)
+	return metahash, nil
 }
@@ -24,7 +24,19 @@ func (l *Layer) DataReplyMessageHandler(msg types.Message, pkt transport.Packet)
 	if !ok {
 		return fmt.Errorf("could not parse the received data reply message")
 	}
+	// Only the owner the request was sent to may reply, so that a relay cannot get it penalized with corrupted data.
+	// Its reply is handed to the pending request in any case, so that the requester can penalize it and try another
+	// owner right away.
+	l.catalogLock.Lock()
+	owner, ok := l.pendingRequests[dataReplyMsg.RequestID]
+	l.catalogLock.Unlock()
+	if !ok || owner != pkt.Header.Source {
+		return fmt.Errorf("%s sent a reply to a data request that was not sent to it", pkt.Header.Source)
+	}
 	l.notification.DispatchResponse(dataReplyMsg.RequestID, msg)
+	if dataReplyMsg.Value != nil && !utils.VerifyData(dataReplyMsg.Key, dataReplyMsg.Value, peer.MetafileSep) {
+		return fmt.Errorf("%s sent data that does not match the hash", pkt.Header.Source)
+	}
 	return nil
 }
 
@@ -34,7 +46,7 @@ func (l *Layer) DataRequestMessageHandler(msg types.Message, pkt transport.Packe
 	if !ok {
 		return fmt.Errorf("could not parse the received data request message")
 	}
-	chunk := l.config.Storage.GetDataBlobStore().Get(dataRequestMsg.Key)
+	chunk := l.blobs.Get(dataRequestMsg.Key)
 	dataReplyMsg := types.DataReplyMessage{
 		RequestID: dataRequestMsg.RequestID,
 		Key:       dataRequestMsg.Key,
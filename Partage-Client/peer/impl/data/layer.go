@@ -6,42 +6,58 @@ import (
 	"go.dedis.ch/cs438/peer"
 	"go.dedis.ch/cs438/peer/impl/consensus"
 	"go.dedis.ch/cs438/peer/impl/cryptography"
+	"go.dedis.ch/cs438/peer/impl/dht"
 	"go.dedis.ch/cs438/peer/impl/gossip"
 	"go.dedis.ch/cs438/peer/impl/network"
 	"go.dedis.ch/cs438/peer/impl/utils"
 	"go.dedis.ch/cs438/types"
-	"io"
 	"regexp"
-	"strings"
 	"sync"
 	"time"
 )
 
+// penaltyDuration is the time, in seconds, during which a peer that sent corrupted data is left out of the catalog.
+const penaltyDuration = 10 * 60
+
 type Layer struct {
 	gossip       *gossip.Layer
 	consensus    *consensus.Layer
 	network      *network.Layer
 	cryptography *cryptography.Layer
+	dht          *dht.Layer
 
 	notification            *utils.AsyncNotificationHandler
 	config                  *peer.Configuration
 	catalog                 peer.Catalog
 	catalogLock             sync.Mutex
 	processedSearchRequests map[string]struct{}
+	// Peers that sent data not matching its hash, with the unix time their penalty ends. They are left out of the
+	// catalog until then.
+	penalizedPeers map[string]int64
+	// The owners our pending data requests were sent to, by request id. Only their replies are accepted.
+	pendingRequests map[string]string
+	requestSlots    *peerSlots
+	// The data blob store, within the quota.
+	blobs *blobStore
 }
 
 func Construct(gossip *gossip.Layer, consensus *consensus.Layer, network *network.Layer,
-	crypto *cryptography.Layer,
+	crypto *cryptography.Layer, dht *dht.Layer,
 	config *peer.Configuration) *Layer {
 	return &Layer{
 		gossip:                  gossip,
 		consensus:               consensus,
 		network:                 network,
 		cryptography:            crypto,
+		dht:                     dht,
 		notification:            utils.NewAsyncNotificationHandler(),
 		config:                  config,
 		catalog:                 make(peer.Catalog),
 		processedSearchRequests: make(map[string]struct{}),
+		penalizedPeers:          make(map[string]int64),
+		pendingRequests:         make(map[string]string),
+		requestSlots:            newPeerSlots(config.DownloadRequestsPerPeer),
+		blobs:                   newBlobStore(config.Storage.GetDataBlobStore(), config.BlobStoreQuota),
 	}
 }
 
@@ -54,6 +70,14 @@ func (l *Layer) RemoteDataRequest(dest string, msg types.DataRequestMessage) ([]
 	if err != nil {
 		return nil, fmt.Errorf("could not marshal data request message: %w", err)
 	}
+	l.catalogLock.Lock()
+	l.pendingRequests[msg.RequestID] = dest
+	l.catalogLock.Unlock()
+	defer func() {
+		l.catalogLock.Lock()
+		delete(l.pendingRequests, msg.RequestID)
+		l.catalogLock.Unlock()
+	}()
 	replyTimeout := l.config.BackoffDataRequest.Initial
 	for i := uint(0); i < l.config.BackoffDataRequest.Retry; i++ {
 		// Unicast the data request message to the given destination peer -- using the routing table.
@@ -75,63 +99,65 @@ func (l *Layer) RemoteDataRequest(dest string, msg types.DataRequestMessage) ([]
 	return nil, fmt.Errorf("could not get a reply")
 }
 
-func (l *Layer) Upload(data io.Reader) (metahash string, err error) {
-	chunks, metahash, err := utils.Chunkify(l.config.ChunkSize, peer.MetafileSep, data)
-	if err != nil {
-		return "", err
-	}
-	for h, c := range chunks {
-		l.config.Storage.GetDataBlobStore().Set(h, c)
-	}
-	return metahash, err
-}
-
-func (l *Layer) Download(metahash string) ([]byte, error) {
-	metafileBytes, err := l.AcquireData(metahash)
-	if err != nil {
-		return nil, fmt.Errorf("could not acquire the metafile: %w", err)
-	}
-	var recoveredData []byte
-	chunkHashes := strings.Split(string(metafileBytes), peer.MetafileSep)
-	for _, chunkHash := range chunkHashes {
-		chunk, err := l.AcquireData(chunkHash)
-		if err != nil {
-			return nil, fmt.Errorf("could not acquire a chunk: %w", err)
-		}
-		recoveredData = append(recoveredData, chunk...)
-	}
-	return recoveredData, nil
-}
-
+// AcquireData returns the data with the given hash, from the local store or from the least busy of its owners in the
+// catalog. The remote data is only kept if it matches its hash: otherwise the owner the request was sent to is
+// penalized, and we try another one, as we do when the owner fails to reply.
 func (l *Layer) AcquireData(hash string) ([]byte, error) {
 	// First, try to find the data locally.
-	chunk := l.config.Storage.GetDataBlobStore().Get(hash)
+	chunk := l.blobs.Get(hash)
 	if chunk != nil {
 		return chunk, nil
 	}
 	// If the data does not exist locally, we will get it from a remote peer. Find the owners
 	// of the data in our catalog.
-	ownerPeers, ok := l.catalog[hash]
-	if !ok || len(ownerPeers) == 0 {
+	ownerPeers := l.getOwners(hash)
+	if len(ownerPeers) == 0 {
 		return nil, fmt.Errorf("no way to access the chunk")
 	}
-	// Choose a random peer from the catalog.
-	randomPeer, err := utils.ChooseRandom(ownerPeers, nil)
-	if err != nil {
-		return nil, fmt.Errorf("no way to access the chunk: %w", err)
-	}
-	msg := types.DataRequestMessage{
-		RequestID: xid.New().String(),
-		Key:       hash,
+	triedPeers := make(map[string]struct{})
+	var lastErr error
+	for len(triedPeers) < len(ownerPeers) {
+		// Choose the least busy peer that we haven't tried yet, waiting for one if they are all busy.
+		owner, err := l.requestSlots.acquire(ownerPeers, triedPeers)
+		if err != nil {
+			break
+		}
+		triedPeers[owner] = struct{}{}
+		msg := types.DataRequestMessage{
+			RequestID: xid.New().String(),
+			Key:       hash,
+		}
+		// Get the data remotely.
+		remoteData, err := l.RemoteDataRequest(owner, msg)
+		l.requestSlots.release(owner)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if !utils.VerifyData(hash, remoteData, peer.MetafileSep) {
+			l.penalize(owner)
+			lastErr = fmt.Errorf("%s sent data that does not match the hash", owner)
+			continue
+		}
+		// Save the remote data locally.
+		l.blobs.Set(hash, remoteData)
+		return remoteData, nil
 	}
-	// Get the data remotely.
-	remoteData, err := l.RemoteDataRequest(randomPeer, msg)
-	if err != nil {
-		return nil, err
+	return nil, fmt.Errorf("no way to access the chunk: %w", lastErr)
+}
+
+// penalize removes the given peer from the catalog, and ignores its catalog updates for the penalty duration.
+func (l *Layer) penalize(peerAddr string) {
+	utils.PrintDebug("data", l.GetAddress(), "is penalizing", peerAddr, "for sending corrupted data")
+	l.catalogLock.Lock()
+	defer l.catalogLock.Unlock()
+	l.penalizedPeers[peerAddr] = l.config.Now() + penaltyDuration
+	for key, owners := range l.catalog {
+		delete(owners, peerAddr)
+		if len(owners) == 0 {
+			delete(l.catalog, key)
+		}
 	}
-	// Save the remote data locally.
-	l.config.Storage.GetDataBlobStore().Set(hash, remoteData)
-	return remoteData, nil
 }
 
 // Tag implements peer.DataSharing
@@ -178,10 +204,27 @@ func (l *Layer) GetCatalog() peer.Catalog {
 	return copied
 }
 
+// getOwners returns a copy of the set of peers that own the given key in the catalog.
+func (l *Layer) getOwners(key string) map[string]struct{} {
+	l.catalogLock.Lock()
+	defer l.catalogLock.Unlock()
+	owners := make(map[string]struct{}, len(l.catalog[key]))
+	for p := range l.catalog[key] {
+		owners[p] = struct{}{}
+	}
+	return owners
+}
+
 // UpdateCatalog implements peer.DataSharing
 func (l *Layer) UpdateCatalog(key string, peer string) {
 	l.catalogLock.Lock()
 	defer l.catalogLock.Unlock()
+	if until, penalized := l.penalizedPeers[peer]; penalized {
+		if l.config.Now() < until {
+			return
+		}
+		delete(l.penalizedPeers, peer)
+	}
 	_, ok := l.catalog[key]
 	if !ok {
 		l.catalog[key] = make(map[string]struct{})
@@ -0,0 +1,255 @@
+package data
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go.dedis.ch/cs438/peer"
+	"go.dedis.ch/cs438/peer/impl/dht"
+	"go.dedis.ch/cs438/peer/impl/utils"
+	"io"
+	"strings"
+	"sync"
+)
+
+// downloadBatchFactor is the number of chunks per download worker in a batch of chunks being downloaded.
+const downloadBatchFactor = 4
+
+// Upload implements peer.DataSharing
+func (l *Layer) Upload(data io.Reader) (metahash string, err error) {
+	return l.UploadFrom(data, nil)
+}
+
+// UploadFrom implements peer.DataSharing. The metafile is stored last, once all the chunks are. The data is cut into
+// chunks of ChunkSize bytes, unless content-defined chunking is configured: the metafile is the same either way.
+func (l *Layer) UploadFrom(data io.Reader, progress peer.Progress) (metahash string, err error) {
+	chunker := utils.NewFixedChunker(data, l.config.ChunkSize)
+	if cdc := l.config.ContentDefinedChunking; cdc.Average != 0 {
+		chunker, err = utils.NewContentDefinedChunker(data, cdc.Min, cdc.Average, cdc.Max)
+		if err != nil {
+			return "", err
+		}
+	}
+	// Nothing is evicted before the file is pinned.
+	l.blobs.hold()
+	defer l.blobs.release()
+	blobStore := l.blobs
+	done := uint(0)
+	metafile, metahash, err := utils.ChunkifyStream(chunker, peer.MetafileSep,
+		func(hash string, chunk []byte) error {
+			blobStore.Set(hash, chunk)
+			done++
+			if progress != nil {
+				progress(done, 0)
+			}
+			return nil
+		})
+	if err != nil {
+		return "", err
+	}
+	blobStore.Set(metahash, metafile)
+	// The uploads of the user are pinned.
+	l.blobs.pin(metahash)
+	if progress != nil {
+		progress(done, done)
+	}
+	go l.dht.Provide(dht.DataKey(metahash))
+	return metahash, nil
+}
+
+// Download implements peer.DataSharing
+func (l *Layer) Download(metahash string) ([]byte, error) {
+	var buffer bytes.Buffer
+	err := l.DownloadTo(metahash, &buffer, nil)
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// DownloadTo implements peer.DataSharing. The file is downloaded in batches of chunks: the chunks of a batch missing
+// from the blob store are fetched concurrently by a pool of workers, from all their known owners, before the batch is
+// written. The chunks fetched before a failure stay in the blob store, so that downloading the file again resumes where
+// it stopped. If no owner of the file is known, its providers are looked up in the DHT.
+func (l *Layer) DownloadTo(metahash string, w io.Writer, progress peer.Progress) error {
+	// Nothing of the file is evicted until it is written.
+	l.blobs.pin(metahash)
+	defer l.blobs.unpin(metahash)
+	l.findProviders(metahash, dht.DataKey(metahash))
+	metafileBytes, err := l.AcquireData(metahash)
+	if err != nil {
+		return fmt.Errorf("could not acquire the metafile: %w", err)
+	}
+	blobStore := l.blobs
+	chunkHashes := strings.Split(string(metafileBytes), peer.MetafileSep)
+	if l.dht.Enabled() {
+		// The providers of a file have all its chunks.
+		fileOwners := l.getOwners(metahash)
+		for _, chunkHash := range chunkHashes {
+			if len(l.getOwners(chunkHash)) == 0 && blobStore.Get(chunkHash) == nil {
+				for owner := range fileOwners {
+					l.UpdateCatalog(chunkHash, owner)
+				}
+			}
+		}
+	}
+	total := uint(len(chunkHashes))
+	if progress != nil {
+		progress(0, total)
+	}
+	batchSize := downloadBatchFactor * int(l.config.DownloadWorkers)
+	if batchSize < downloadBatchFactor {
+		batchSize = downloadBatchFactor
+	}
+	for start := 0; start < len(chunkHashes); start += batchSize {
+		end := start + batchSize
+		if end > len(chunkHashes) {
+			end = len(chunkHashes)
+		}
+		batch := chunkHashes[start:end]
+		// Find the missing chunks, each only once even if it appears several times in the batch.
+		var missingHashes []string
+		seen := make(map[string]struct{})
+		for _, chunkHash := range batch {
+			if _, ok := seen[chunkHash]; ok {
+				continue
+			}
+			seen[chunkHash] = struct{}{}
+			if blobStore.Get(chunkHash) == nil {
+				missingHashes = append(missingHashes, chunkHash)
+			}
+		}
+		err = l.acquireAll(missingHashes)
+		if err != nil {
+			return fmt.Errorf("could not acquire a chunk: %w", err)
+		}
+		for i, chunkHash := range batch {
+			chunk := blobStore.Get(chunkHash)
+			if chunk == nil {
+				return fmt.Errorf("could not acquire a chunk: %s was removed from the store", chunkHash)
+			}
+			_, err = w.Write(chunk)
+			if err != nil {
+				return fmt.Errorf("could not write a chunk: %w", err)
+			}
+			if progress != nil {
+				progress(uint(start+i+1), total)
+			}
+		}
+	}
+	go l.dht.Provide(dht.DataKey(metahash))
+	return nil
+}
+
+// findProviders adds to the catalog the providers of the given key in the DHT as owners of the data, unless the data
+// is stored locally or some owners are already known.
+func (l *Layer) findProviders(hash string, key dht.Key) {
+	if !l.dht.Enabled() || l.blobs.Get(hash) != nil || len(l.getOwners(hash)) > 0 {
+		return
+	}
+	for provider := range l.dht.FindProviders(key) {
+		l.UpdateCatalog(hash, provider)
+	}
+}
+
+// acquireAll acquires the data with the given hashes with the pool of download workers, in order. It stops handing out
+// hashes after the first failure, and returns it.
+func (l *Layer) acquireAll(hashes []string) error {
+	workers := int(l.config.DownloadWorkers)
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(hashes) {
+		workers = len(hashes)
+	}
+	jobs := make(chan string)
+	failed := make(chan struct{})
+	var failOnce sync.Once
+	var firstErr error
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for hash := range jobs {
+				_, err := l.AcquireData(hash)
+				if err != nil {
+					failOnce.Do(func() {
+						firstErr = err
+						close(failed)
+					})
+				}
+			}
+		}()
+	}
+dispatch:
+	for _, hash := range hashes {
+		select {
+		case jobs <- hash:
+		case <-failed:
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return firstErr
+}
+
+// peerSlots limits the number of data requests in flight to each peer.
+type peerSlots struct {
+	mutex    sync.Mutex
+	freed    *sync.Cond
+	limit    uint
+	inFlight map[string]uint
+}
+
+func newPeerSlots(limit uint) *peerSlots {
+	if limit == 0 {
+		limit = 1
+	}
+	s := &peerSlots{
+		limit:    limit,
+		inFlight: make(map[string]uint),
+	}
+	s.freed = sync.NewCond(&s.mutex)
+	return s
+}
+
+// acquire takes a slot of the least busy of the given peers, leaving out the excluded ones, and returns that peer. It
+// blocks while all of them are busy.
+func (s *peerSlots) acquire(peers map[string]struct{}, exclusion map[string]struct{}) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for {
+		candidates := 0
+		chosen := ""
+		for p := range peers {
+			if _, excluded := exclusion[p]; excluded {
+				continue
+			}
+			candidates++
+			if s.inFlight[p] < s.limit && (chosen == "" || s.inFlight[p] < s.inFlight[chosen]) {
+				chosen = p
+			}
+		}
+		if candidates == 0 {
+			return "", errors.New("no possible choice")
+		}
+		if chosen != "" {
+			s.inFlight[chosen]++
+			return chosen, nil
+		}
+		s.freed.Wait()
+	}
+}
+
+// release frees the slot taken on the given peer.
+func (s *peerSlots) release(p string) {
+	s.mutex.Lock()
+	s.inFlight[p]--
+	if s.inFlight[p] == 0 {
+		delete(s.inFlight, p)
+	}
+	s.mutex.Unlock()
+	s.freed.Broadcast()
+}
@@ -0,0 +1,60 @@
+package dht
+
+import (
+	"fmt"
+
+	"go.dedis.ch/cs438/peer/impl/utils"
+	"go.dedis.ch/cs438/transport"
+	"go.dedis.ch/cs438/types"
+)
+
+func (l *Layer) RegisterHandlers() {
+	l.config.MessageRegistry.RegisterMessageCallback(FindRequestMessage{}, l.FindRequestMessageHandler)
+	l.config.MessageRegistry.RegisterMessageCallback(FindReplyMessage{}, l.FindReplyMessageHandler)
+	l.config.MessageRegistry.RegisterMessageCallback(ProvideMessage{}, l.ProvideMessageHandler)
+}
+
+func (l *Layer) FindRequestMessageHandler(msg types.Message, pkt transport.Packet) error {
+	utils.PrintDebug("dht", l.GetAddress(), "is at FindRequestMessageHandler")
+	findRequestMsg, ok := msg.(*FindRequestMessage)
+	if !ok {
+		return fmt.Errorf("could not parse the received dht find request")
+	}
+	l.table.update(pkt.Header.Source)
+	findReplyMsg := FindReplyMessage{
+		RequestID: findRequestMsg.RequestID,
+		Contacts:  l.closestContacts(findRequestMsg.Key, pkt.Header.Source),
+	}
+	if findRequestMsg.Providers {
+		findReplyMsg.Providers = l.getRecords(findRequestMsg.Key)
+	}
+	transpMsg, err := l.config.MessageRegistry.MarshalMessage(&findReplyMsg)
+	if err != nil {
+		return fmt.Errorf("could not marshal the dht find reply: %w", err)
+	}
+	return l.network.Unicast(pkt.Header.Source, transpMsg)
+}
+
+func (l *Layer) FindReplyMessageHandler(msg types.Message, pkt transport.Packet) error {
+	utils.PrintDebug("dht", l.GetAddress(), "is at FindReplyMessageHandler")
+	findReplyMsg, ok := msg.(*FindReplyMessage)
+	if !ok {
+		return fmt.Errorf("could not parse the received dht find reply")
+	}
+	l.table.update(pkt.Header.Source)
+	l.notification.DispatchResponse(findReplyMsg.RequestID, msg)
+	return nil
+}
+
+// ProvideMessageHandler stores the provider record of the source of the message, so that a peer can only announce
+// itself.
+func (l *Layer) ProvideMessageHandler(msg types.Message, pkt transport.Packet) error {
+	utils.PrintDebug("dht", l.GetAddress(), "is at ProvideMessageHandler")
+	provideMsg, ok := msg.(*ProvideMessage)
+	if !ok {
+		return fmt.Errorf("could not parse the received dht provide message")
+	}
+	l.table.update(pkt.Header.Source)
+	l.addRecord(provideMsg.Key, pkt.Header.Source)
+	return nil
+}
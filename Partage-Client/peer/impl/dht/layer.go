@@ -0,0 +1,267 @@
+package dht
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+	"go.dedis.ch/cs438/peer"
+	"go.dedis.ch/cs438/peer/impl/network"
+	"go.dedis.ch/cs438/peer/impl/utils"
+)
+
+// alpha is the number of peers queried concurrently during a lookup.
+const alpha = 3
+
+// rpcTimeout is how long we wait for the reply of a peer during a lookup.
+const rpcTimeout = time.Second
+
+// Layer is a Kademlia-style DHT that stores provider records: for each key, the peers that provide its data. The
+// records are stored on the peers whose keys are the closest to the key, which a lookup reaches in O(log n) routed
+// queries. The peers are reached through the routing table of the network layer.
+type Layer struct {
+	network *network.Layer
+	config  *peer.Configuration
+
+	notification *utils.AsyncNotificationHandler
+	table        *routingTable
+	recordsLock  sync.Mutex
+	// Provider records stored on this peer, from the least to the most recently announced provider.
+	records map[Key][]string
+	// Keys provided by this peer.
+	provided map[Key]struct{}
+}
+
+func Construct(network *network.Layer, config *peer.Configuration) *Layer {
+	return &Layer{
+		network:      network,
+		config:       config,
+		notification: utils.NewAsyncNotificationHandler(),
+		table:        newRoutingTable(network.GetAddress(), int(config.DHTBucketSize)),
+		records:      make(map[Key][]string),
+		provided:     make(map[Key]struct{}),
+	}
+}
+
+func (l *Layer) GetAddress() string {
+	return l.network.GetAddress()
+}
+
+// Enabled returns whether the DHT is enabled. Otherwise, nothing is provided nor found.
+func (l *Layer) Enabled() bool {
+	return l.config.DHTBucketSize > 0
+}
+
+// Provide announces that this peer provides the data of the given key. The announcement is repeated by Refresh.
+func (l *Layer) Provide(key Key) {
+	if !l.Enabled() {
+		return
+	}
+	l.recordsLock.Lock()
+	_, provided := l.provided[key]
+	l.provided[key] = struct{}{}
+	l.recordsLock.Unlock()
+	if !provided {
+		l.announce(key)
+	}
+}
+
+// Refresh announces again all the keys provided by this peer, so that their records reach the peers that joined since
+// the last announcement.
+func (l *Layer) Refresh() {
+	l.recordsLock.Lock()
+	keys := make([]Key, 0, len(l.provided))
+	for key := range l.provided {
+		keys = append(keys, key)
+	}
+	l.recordsLock.Unlock()
+	for _, key := range keys {
+		l.announce(key)
+	}
+}
+
+// FindProviders returns the providers of the given key, other than this peer. The records stored locally are used if
+// there are some, otherwise the DHT is looked up.
+func (l *Layer) FindProviders(key Key) map[string]struct{} {
+	if !l.Enabled() {
+		return nil
+	}
+	providers := make(map[string]struct{})
+	for _, provider := range l.getRecords(key) {
+		providers[provider] = struct{}{}
+	}
+	delete(providers, l.GetAddress())
+	if len(providers) > 0 {
+		return providers
+	}
+	_, providers = l.lookup(key, true)
+	delete(providers, l.GetAddress())
+	return providers
+}
+
+// announce stores the provider record of this peer for the given key on the closest peers to the key, and locally.
+func (l *Layer) announce(key Key) {
+	l.addRecord(key, l.GetAddress())
+	closest, _ := l.lookup(key, false)
+	transpMsg, err := l.config.MessageRegistry.MarshalMessage(&ProvideMessage{Key: key})
+	if err != nil {
+		return
+	}
+	for _, contact := range closest {
+		err = l.network.Unicast(contact, transpMsg)
+		if err != nil {
+			utils.PrintDebug("dht", l.GetAddress(), "could not announce a record to", contact, err)
+		}
+	}
+}
+
+// lookup walks the DHT towards the given key, querying alpha peers at a time, until the closest peers that it knows
+// have all been queried. Returns the closest peers that replied. If providers is set, it also collects the providers
+// of the key, and stops as soon as some are found.
+func (l *Layer) lookup(key Key, providers bool) ([]string, map[string]struct{}) {
+	size := int(l.config.DHTBucketSize)
+	found := make(map[string]struct{})
+	candidates := make(map[string]struct{})
+	for _, contact := range l.knownContacts() {
+		candidates[contact] = struct{}{}
+	}
+	queried := make(map[string]struct{})
+	var responded []string
+	for {
+		// Query the closest candidates that we haven't queried yet, among the closest ones.
+		closest := make([]string, 0, len(candidates))
+		for contact := range candidates {
+			closest = append(closest, contact)
+		}
+		sortByDistance(key, closest)
+		if len(closest) > size {
+			closest = closest[:size]
+		}
+		var batch []string
+		for _, contact := range closest {
+			if _, ok := queried[contact]; !ok && len(batch) < alpha {
+				batch = append(batch, contact)
+			}
+		}
+		if len(batch) == 0 {
+			break
+		}
+		replies := l.query(batch, key, providers)
+		for i, contact := range batch {
+			queried[contact] = struct{}{}
+			if replies[i] == nil {
+				delete(candidates, contact)
+				l.table.remove(contact)
+				continue
+			}
+			responded = append(responded, contact)
+			for _, newContact := range replies[i].Contacts {
+				if newContact != l.GetAddress() {
+					candidates[newContact] = struct{}{}
+				}
+			}
+			for _, provider := range replies[i].Providers {
+				found[provider] = struct{}{}
+			}
+		}
+		if providers && len(found) > 0 {
+			break
+		}
+	}
+	sortByDistance(key, responded)
+	if len(responded) > size {
+		responded = responded[:size]
+	}
+	return responded, found
+}
+
+// query sends a find request to each of the given peers concurrently, and returns their replies, nil for the peers
+// that didn't reply in time.
+func (l *Layer) query(contacts []string, key Key, providers bool) []*FindReplyMessage {
+	replies := make([]*FindReplyMessage, len(contacts))
+	var wg sync.WaitGroup
+	for i, contact := range contacts {
+		wg.Add(1)
+		go func(i int, contact string) {
+			defer wg.Done()
+			msg := FindRequestMessage{
+				RequestID: xid.New().String(),
+				Key:       key,
+				Providers: providers,
+			}
+			transpMsg, err := l.config.MessageRegistry.MarshalMessage(&msg)
+			if err != nil {
+				return
+			}
+			err = l.network.Unicast(contact, transpMsg)
+			if err != nil {
+				utils.PrintDebug("dht", l.GetAddress(), "could not query", contact, err)
+				return
+			}
+			reply, ok := l.notification.ResponseCollector(msg.RequestID, rpcTimeout).(*FindReplyMessage)
+			if ok {
+				replies[i] = reply
+			}
+		}(i, contact)
+	}
+	wg.Wait()
+	return replies
+}
+
+// knownContacts returns the contacts of the routing table, along with the peers known by the network layer, so that
+// the DHT is bootstrapped from the network.
+func (l *Layer) knownContacts() []string {
+	contacts := make(map[string]struct{})
+	for _, contact := range l.table.contacts() {
+		contacts[contact] = struct{}{}
+	}
+	for dest := range l.network.GetRoutingTable() {
+		contacts[dest] = struct{}{}
+	}
+	delete(contacts, l.GetAddress())
+	known := make([]string, 0, len(contacts))
+	for contact := range contacts {
+		known = append(known, contact)
+	}
+	return known
+}
+
+// closestContacts returns the known contacts closest to the given key, leaving out the given peer.
+func (l *Layer) closestContacts(key Key, exclude string) []string {
+	var contacts []string
+	for _, contact := range l.knownContacts() {
+		if contact != exclude {
+			contacts = append(contacts, contact)
+		}
+	}
+	sortByDistance(key, contacts)
+	if len(contacts) > int(l.config.DHTBucketSize) {
+		contacts = contacts[:l.config.DHTBucketSize]
+	}
+	return contacts
+}
+
+// addRecord stores that the given peer provides the data of the key. The most recent providers are kept.
+func (l *Layer) addRecord(key Key, provider string) {
+	l.recordsLock.Lock()
+	defer l.recordsLock.Unlock()
+	providers := l.records[key]
+	for i, p := range providers {
+		if p == provider {
+			providers = append(providers[:i:i], providers[i+1:]...)
+			break
+		}
+	}
+	providers = append(providers, provider)
+	if len(providers) > int(l.config.DHTBucketSize) {
+		providers = providers[len(providers)-int(l.config.DHTBucketSize):]
+	}
+	l.records[key] = providers
+}
+
+// getRecords returns the providers of the key stored on this peer.
+func (l *Layer) getRecords(key Key) []string {
+	l.recordsLock.Lock()
+	defer l.recordsLock.Unlock()
+	return append([]string(nil), l.records[key]...)
+}
@@ -0,0 +1,80 @@
+package dht
+
+import (
+	"encoding/hex"
+	"fmt"
+	"go.dedis.ch/cs438/types"
+)
+
+// FindRequestMessage asks a peer for the contacts it knows closest to a key, and for the providers of the key it
+// stores if Providers is set.
+type FindRequestMessage struct {
+	// RequestID must be a unique identifier. Use xid.New().String() to generate
+	// it.
+	RequestID string
+	Key       Key
+	Providers bool
+}
+
+func (f FindRequestMessage) NewEmpty() types.Message {
+	return &FindRequestMessage{}
+}
+
+func (f FindRequestMessage) Name() string {
+	return "dhtfindrequest"
+}
+
+func (f FindRequestMessage) String() string {
+	return fmt.Sprintf("{dhtfindrequest %s - %s}", f.RequestID, hex.EncodeToString(f.Key[:]))
+}
+
+func (f FindRequestMessage) HTML() string {
+	return f.String()
+}
+
+// FindReplyMessage describes the response of a find request.
+type FindReplyMessage struct {
+	// RequestID must be the same as the RequestID set in the
+	// FindRequestMessage.
+	RequestID string
+	Contacts  []string
+	Providers []string
+}
+
+func (f FindReplyMessage) NewEmpty() types.Message {
+	return &FindReplyMessage{}
+}
+
+func (f FindReplyMessage) Name() string {
+	return "dhtfindreply"
+}
+
+func (f FindReplyMessage) String() string {
+	return fmt.Sprintf("{dhtfindreply %s - %d contacts, %d providers}", f.RequestID, len(f.Contacts),
+		len(f.Providers))
+}
+
+func (f FindReplyMessage) HTML() string {
+	return f.String()
+}
+
+// ProvideMessage asks a peer to store that the source of the message provides the data of a key.
+type ProvideMessage struct {
+	Key Key
+}
+
+func (p ProvideMessage) NewEmpty() types.Message {
+	return &ProvideMessage{}
+}
+
+func (p ProvideMessage) Name() string {
+	return "dhtprovide"
+}
+
+func (p ProvideMessage) String() string {
+	return fmt.Sprintf("{dhtprovide %s}", hex.EncodeToString(p.Key[:]))
+}
+
+func (p ProvideMessage) HTML() string {
+	return p.String()
+}
@@ -0,0 +1,138 @@
+package dht
+
+import (
+	"crypto/sha256"
+	"math/bits"
+	"sort"
+	"sync"
+)
+
+// keyBits is the number of bits of a key, and so the number of buckets of the routing table.
+const keyBits = 256
+
+// Key identifies the peers and the data in the DHT: the peers by the SHA-256 of their address, and the data by the
+// SHA-256 of its kind and identifier.
+type Key [32]byte
+
+// DataKey returns the key of the blob with the given metahash.
+func DataKey(metahash string) Key {
+	return newKey("data", metahash)
+}
+
+// ContentKey returns the key of the post content with the given content id.
+func ContentKey(contentID string) Key {
+	return newKey("content", contentID)
+}
+
+// PublicKeyKey returns the key of the public key with the given hash.
+func PublicKeyKey(publicKeyHash [32]byte) Key {
+	return newKey("publickey", string(publicKeyHash[:]))
+}
+
+func newKey(kind string, id string) Key {
+	return sha256.Sum256([]byte(kind + "/" + id))
+}
+
+// peerKey returns the key of the peer with the given address.
+func peerKey(addr string) Key {
+	return sha256.Sum256([]byte(addr))
+}
+
+// less returns whether a is closer to the key than b, by the XOR metric.
+func (k Key) less(a Key, b Key) bool {
+	for i := range k {
+		da, db := a[i]^k[i], b[i]^k[i]
+		if da != db {
+			return da < db
+		}
+	}
+	return false
+}
+
+// commonPrefix returns the number of leading bits that both keys share.
+func (k Key) commonPrefix(other Key) int {
+	for i := range k {
+		if x := k[i] ^ other[i]; x != 0 {
+			return i*8 + bits.LeadingZeros8(x)
+		}
+	}
+	return keyBits
+}
+
+// sortByDistance sorts the given addresses from the closest to the furthest from the key.
+func sortByDistance(key Key, addrs []string) {
+	sort.Slice(addrs, func(i, j int) bool {
+		return key.less(peerKey(addrs[i]), peerKey(addrs[j]))
+	})
+}
+
+// routingTable keeps the contacts of the peer in k-buckets: the i-th bucket holds the contacts whose key shares
+// exactly i leading bits with ours. Each bucket is ordered from the least to the most recently seen contact, and the
+// oldest contacts are kept when a bucket is full, as they are the most likely to stay.
+type routingTable struct {
+	sync.Mutex
+	self    Key
+	size    int
+	buckets [keyBits][]string
+}
+
+func newRoutingTable(self string, size int) *routingTable {
+	return &routingTable{self: peerKey(self), size: size}
+}
+
+// bucket returns the index of the bucket of the given contact, or -1 if it is us.
+func (t *routingTable) bucket(addr string) int {
+	prefix := t.self.commonPrefix(peerKey(addr))
+	if prefix == keyBits {
+		return -1
+	}
+	return prefix
+}
+
+// update records that we heard from the given contact.
+func (t *routingTable) update(addr string) {
+	i := t.bucket(addr)
+	if i < 0 {
+		return
+	}
+	t.Lock()
+	defer t.Unlock()
+	bucket := t.buckets[i]
+	for j, contact := range bucket {
+		if contact == addr {
+			t.buckets[i] = append(append(bucket[:j:j], bucket[j+1:]...), addr)
+			return
+		}
+	}
+	if len(bucket) < t.size {
+		t.buckets[i] = append(bucket, addr)
+	}
+}
+
+// remove forgets the given contact, e.g., because it didn't reply.
+func (t *routingTable) remove(addr string) {
+	i := t.bucket(addr)
+	if i < 0 {
+		return
+	}
+	t.Lock()
+	defer t.Unlock()
+	bucket := t.buckets[i]
+	for j, contact := range bucket {
+		if contact == addr {
+			t.buckets[i] = append(bucket[:j:j], bucket[j+1:]...)
+			return
+		}
+	}
+}
+
+// contacts returns all the contacts.
+func (t *routingTable) contacts() []string {
+	t.Lock()
+	defer t.Unlock()
+	var contacts []string
+	for _, bucket := range t.buckets {
+		contacts = append(contacts, bucket...)
+	}
+	return contacts
+}
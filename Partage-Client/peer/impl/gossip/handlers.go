@@ -38,7 +38,7 @@ func (l *Layer) RumorsMessageHandler(msg types.Message, pkt transport.Packet) er
 		if l.view.IsExpected(rumor.Origin, int64(rumor.Sequence)) {
 			// Validate rumor's signature
 			if l.cryptography != nil {
-				if err := rumor.Validate(l.cryptography.GetCAPublicKey()); err != nil {
+				if err := rumor.Validate(l.cryptography.GetCAPublicKey(), l.cryptography.IsRevoked); err != nil {
 					fmt.Println("dropped rumor due to invalid signature..", err)
 					continue
 				} else {
@@ -69,8 +69,11 @@ func (l *Layer) RumorsMessageHandler(msg types.Message, pkt transport.Packet) er
 			rumorsOfInterest = append(rumorsOfInterest, rumor)
 			// Save the rumor.
 			l.view.SaveRumor(rumor)
-			// Update the routing table with the rumor origin.
-			l.network.SetRoutingEntry(rumor.Origin, pkt.Header.RelayedBy)
+			// Update the routing table with the rumor origin, unless it is a direct neighbor: routing it through a
+			// relay would make it unreachable once that relay goes down.
+			if l.network.GetRoutingTable()[rumor.Origin] != rumor.Origin {
+				l.network.SetRoutingEntry(rumor.Origin, pkt.Header.RelayedBy)
+			}
 		}
 	}
 	// End of critical section.
@@ -101,12 +104,7 @@ func (l *Layer) RumorsMessageHandler(msg types.Message, pkt transport.Packet) er
 	// Create the ack message.
 	ackMsg := types.AckMessage{}
 	ackMsg.AckedPacketID = pkt.Header.PacketID
-	ackMsg.Status = l.view.AsStatusMsg()
-	if l.cryptography != nil {
-		for _, ip := range l.cryptography.GetBlockedIPs() {
-			ackMsg.Status[ip] = -1
-		}
-	}
+	ackMsg.Status = l.advertisedStatus()
 	// Convert it into a transport message.
 	ackTranspMsg, err := l.config.MessageRegistry.MarshalMessage(&ackMsg)
 	if err != nil {
@@ -178,12 +176,7 @@ func (l *Layer) StatusMessageHandler(msg types.Message, pkt transport.Packet) er
 	}
 	// Request my missing rumors from the remote peer after I make sure that he is up to date.
 	if len(rmtNews) > 0 {
-		myStatusMsg := l.view.AsStatusMsg()
-		if l.cryptography != nil {
-			for _, ip := range l.cryptography.GetBlockedIPs() {
-				myStatusMsg[ip] = -1
-			}
-		}
+		myStatusMsg := l.advertisedStatus()
 		trnspMsg, _ := l.config.MessageRegistry.MarshalMessage(&myStatusMsg)
 		if l.cryptography != nil {
 			_ = l.cryptography.Route(l.GetAddress(), pkt.Header.RelayedBy, pkt.Header.RelayedBy, trnspMsg)
@@ -202,12 +195,7 @@ func (l *Layer) StatusMessageHandler(msg types.Message, pkt transport.Packet) er
 				utils.PrintDebug("gossip", l.GetAddress(), "has stopped mongering since there are no neighbors to choose from.")
 				return nil
 			}
-			myStatusMsg := l.view.AsStatusMsg()
-			if l.cryptography != nil {
-				for _, ip := range l.cryptography.GetBlockedIPs() {
-					myStatusMsg[ip] = -1
-				}
-			}
+			myStatusMsg := l.advertisedStatus()
 			trnspMsg, _ := l.config.MessageRegistry.MarshalMessage(&myStatusMsg)
 			if l.cryptography != nil {
 				_ = l.cryptography.Route(l.GetAddress(), dest, dest, trnspMsg)
@@ -54,6 +54,58 @@ func (l *Layer) BroadcastMessage(msg types.Message) error {
 	return l.Broadcast(tMsg)
 }
 
+// Unicast sends a given message directly to the given peer, without gossiping it. The packet is signed when running
+// TLS.
+func (l *Layer) Unicast(dest string, msg types.Message) error {
+	tMsg, err := l.config.MessageRegistry.MarshalMessage(msg)
+	if err != nil {
+		return err
+	}
+	if l.cryptography != nil {
+		return l.cryptography.Unicast(dest, tMsg)
+	}
+	return l.network.Unicast(dest, tMsg)
+}
+
+// GetNeighbors returns the set of neighbors of the peer.
+func (l *Layer) GetNeighbors() map[string]struct{} {
+	return l.network.GetNeighbors()
+}
+
 func (l *Layer) GetViewAsStatusMsg() types.StatusMessage {
 	return l.view.AsStatusMsg()
 }
+
+// advertisedStatus returns the view of the peer as a status message, where the origins of the blocked users are
+// advertised with the sequence -1 so that their rumors are not sent to us.
+func (l *Layer) advertisedStatus() types.StatusMessage {
+	statusMsg := l.view.AsStatusMsg()
+	if l.cryptography != nil {
+		for _, ip := range l.cryptography.GetBlockedIPs() {
+			statusMsg[ip] = -1
+		}
+	}
+	return statusMsg
+}
+
+// CatchUp sends the status of the peer to all of its neighbors, so that they send back the rumors that the peer is
+// missing, e.g., the rumors of an unblocked user that were dropped.
+func (l *Layer) CatchUp() {
+	utils.PrintDebug("gossip", l.GetAddress(), "is catching up with its neighbors")
+	statusMsg := l.advertisedStatus()
+	transpMsg, err := l.config.MessageRegistry.MarshalMessage(&statusMsg)
+	if err != nil {
+		utils.PrintDebug("gossip", l.GetAddress(), "could not marshal the status message:", err)
+		return
+	}
+	for neighbor := range l.network.GetNeighbors() {
+		if l.cryptography != nil {
+			err = l.cryptography.Unicast(neighbor, transpMsg)
+		} else {
+			err = l.network.Unicast(neighbor, transpMsg)
+		}
+		if err != nil {
+			utils.PrintDebug("gossip", l.GetAddress(), "could not send its status to", neighbor, ":", err)
+		}
+	}
+}
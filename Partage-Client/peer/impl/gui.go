@@ -6,31 +6,37 @@ import (
 	"html/template"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"go.dedis.ch/cs438/registry/standard"
+	"go.dedis.ch/cs438/storage/file"
 	"go.dedis.ch/cs438/storage/inmemory"
 
 	"go.dedis.ch/cs438/peer"
 	"go.dedis.ch/cs438/peer/impl/content"
+	"go.dedis.ch/cs438/peer/impl/utils"
+	"go.dedis.ch/cs438/transport/codec"
 	"go.dedis.ch/cs438/transport/tcptls"
 )
 
-func TemplatePath(fileName string) string {
-	wd, _ := os.Getwd()
-	rt := wd[:strings.Index(wd, "Partage")]
-	return rt + "Partage/Partage-Client/templates/" + fileName
+// DefaultTemplateDir returns the templates directory next to the executable.
+func DefaultTemplateDir() (string, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("cannot locate the default templates, give them explicitly: %w", err)
+	}
+	return filepath.Join(filepath.Dir(executable), "templates"), nil
 }
 
-var StaticFilePath = TemplatePath("") + "/static"
-
-var TemplateFileMap = map[string]string{
-	"index":    TemplatePath("index.html"),
-	"post":     TemplatePath("post.html"),
-	"profile":  TemplatePath("profile.html"),
-	"discover": TemplatePath("discover.html"),
-	"base":     TemplatePath("base.html"),
+// templateFiles returns the template files of the given page of the GUI.
+func (c Client) templateFiles(page string) []string {
+	return []string{
+		filepath.Join(c.TemplateDir, "base.html"),
+		filepath.Join(c.TemplateDir, page+".html"),
+		filepath.Join(c.TemplateDir, "components.html"),
+	}
 }
 
 func NewDefaultConfig() peer.Configuration {
@@ -49,21 +55,107 @@ func NewDefaultConfig() peer.Configuration {
 			Factor:  2,
 			Retry:   5,
 		},
-		TotalPeers: 1,
+		DownloadWorkers:         8,
+		DownloadRequestsPerPeer: 2,
+		TotalPeers:              1,
 		PaxosThreshold: func(u uint) int {
 			return int(u/2 + 1)
 		},
 		PaxosID:            1,
 		PaxosProposerRetry: time.Second * 5,
+		RaftHeartbeat:      time.Second,
+		FeedBatchWindow:    100 * time.Millisecond,
+		CertificateRenewal: 7 * 24 * time.Hour,
+		DHTBucketSize:      20,
+		DHTRefreshInterval: time.Minute,
+		BlobStoreQuota:     1 << 30,
+		BlobGCInterval:     10 * time.Minute,
 	}
 }
 
-func StartClient(port uint, peerID uint, introducerAddr string) {
+// NewPersistentConfig returns the default configuration, where the storages are kept on disk under the given data
+// directory.
+func NewPersistentConfig(dataDir string) (peer.Configuration, error) {
+	config := NewDefaultConfig()
+	persistency, err := file.NewPersistency(dataDir)
+	if err != nil {
+		return config, fmt.Errorf("could not create the file storage: %w", err)
+	}
+	blockchainStorage, err := file.NewPersistentMultipurposeStorage(dataDir)
+	if err != nil {
+		return config, fmt.Errorf("could not create the blockchain storage: %w", err)
+	}
+	config.Storage = persistency
+	config.BlockchainStorage = blockchainStorage
+	return config, nil
+}
+
+// ClientConfig configures the Partage client.
+type ClientConfig struct {
+	// Port of the GUI
+	Port   uint
+	PeerID uint
+	// IntroducerAddr is the address of a peer of the network to join, empty for the first peer.
+	IntroducerAddr string
+	// Profile holds the identity of the user. Several identities can run on the same machine with different profiles.
+	// The default profile is used if it is empty.
+	Profile utils.Profile
+	// DataDir is where the storages are kept on disk, in memory if empty.
+	DataDir string
+	// CAAddress is the address of the CA server.
+	CAAddress string
+	// Codec is the name of the codec of the messages.
+	Codec string
+	// TemplateDir is the directory of the templates of the GUI, next to the executable if empty.
+	TemplateDir string
+}
+
+// NewDefaultClientConfig returns the configuration of a client with the default profile, which keeps everything in
+// memory.
+func NewDefaultClientConfig() ClientConfig {
+	return ClientConfig{
+		Port:        8000,
+		PeerID:      1,
+		CAAddress: tcptls.DefaultCAAddress,
+		Codec:     codec.Binary.Name(),
+	}
+}
+
+// StartClient starts the Partage client and serves the GUI on the configured port. If a data directory is given, the
+// storages are kept on disk and the client resumes from them on restart, with the identity stored in its profile.
+// Otherwise, everything is kept in memory. With the JSON codec, the connections use JSON too, which is convenient for
+// debugging.
+func StartClient(conf ClientConfig) {
 	mux := http.NewServeMux() //server multiplexer
+	dataDir := conf.DataDir
+	if conf.TemplateDir == "" {
+		templateDir, err := DefaultTemplateDir()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		conf.TemplateDir = templateDir
+	}
+
+	msgCodec, err := codec.Get(conf.Codec)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	codecs := tcptls.DefaultCodecs
+	if msgCodec == codec.JSON {
+		codecs = []codec.Codec{codec.JSON}
+	}
 
 	//create and initiate new Client instance.. TODO:
 	nodeAddr := "127.0.0.1:0"
-	transp := tcptls.NewTCP()
+	// Keep the same identity across restarts if there is a data directory.
+	transp := tcptls.NewTCPWithOptions(tcptls.Options{
+		Profile:    conf.Profile,
+		Persistent: dataDir != "",
+		CAAddress:  conf.CAAddress,
+		Codecs:     codecs,
+	})
 	// Create TLS socket
 	sock, err := transp.CreateSocket(nodeAddr)
 	if err != nil {
@@ -72,13 +164,32 @@ func StartClient(port uint, peerID uint, introducerAddr string) {
 	}
 	// Create the configuration.
 	config := NewDefaultConfig()
+	if dataDir != "" {
+		config, err = NewPersistentConfig(dataDir)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		// Do not resume from corrupted blockchains.
+		for _, report := range VerifyBlockchains(config) {
+			if report.Err != nil {
+				fmt.Printf("stored %s blockchain is corrupted: %v\n", report.ProtocolID, report.Err)
+				return
+			}
+		}
+	}
 	config.Socket = sock
-	config.PaxosID = peerID
-	client := NewClient(1, introducerAddr, config)
+	config.MessageRegistry = standard.NewRegistryWithCodec(msgCodec)
+	config.PaxosID = conf.PeerID
+	client := NewClient(1, conf.IntroducerAddr, config)
+	if client == nil {
+		return
+	}
+	client.TemplateDir = conf.TemplateDir
 	//Start node....
 
 	// Start the static file server.
-	fs := http.FileServer(http.Dir(StaticFilePath))
+	fs := http.FileServer(http.Dir(filepath.Join(conf.TemplateDir, "static")))
 	// Start the actual server.
 	// Serve the static file directory.
 	mux.Handle("/static/", http.StripPrefix("/static", fs))
@@ -105,7 +216,7 @@ func StartClient(port uint, peerID uint, introducerAddr string) {
 	// POST
 	mux.Handle("/changeusername", client.ChangeUsernameHandler())
 
-	err = http.ListenAndServe(fmt.Sprintf(":%d", port), mux)
+	err = http.ListenAndServe(fmt.Sprintf(":%d", conf.Port), mux)
 	if err != nil {
 		fmt.Println(err)
 	}
@@ -120,7 +231,7 @@ func (c Client) ChangeUsernameHandler() http.HandlerFunc {
 			from := r.FormValue("from")
 			//parse recipients list
 			if newUsername != "" {
-				_, err := c.Peer.UpdateFeed(content.CreateChangeUsernameMetadata(c.Peer.GetUserID(), newUsername))
+				_, err := c.Peer.UpdateFeed(content.CreateChangeUsernameMetadata(c.Peer.GetUserID(), c.now(), newUsername))
 				if err != nil {
 					from = URLWithErrorMsg(from, err.Error())
 				}
@@ -166,7 +277,7 @@ func (c Client) IndexHandler() http.HandlerFunc {
 				Posts:  c.GetTexts(userdata.Followees, 0, MaxTimeLimit),
 				MyData: userdata,
 			}
-			t, err := template.ParseFiles(TemplateFileMap["base"], TemplateFileMap["index"], TemplatePath("components.html"))
+			t, err := template.ParseFiles(c.templateFiles("index")...)
 			if err != nil {
 				fmt.Println(err)
 				return
@@ -215,7 +326,7 @@ func (c Client) SinglePostHandler() http.HandlerFunc {
 				return
 			}
 			// Render Post with all info, comments and reactions
-			t, err := template.ParseFiles(TemplateFileMap["base"], TemplateFileMap["post"], TemplatePath("components.html"))
+			t, err := template.ParseFiles(c.templateFiles("post")...)
 			if err != nil {
 				fmt.Println(err)
 				return
@@ -415,10 +526,10 @@ func (c Client) ProfileHandler() http.HandlerFunc {
 			var followerUsers []UserData
 			var followeeUsers []UserData
 			for _, userID := range data.Followers {
-				followerUsers = append(followerUsers, NewUserData(c.Peer.GetUserID(), c.Peer.GetUserState(userID)))
+				followerUsers = append(followerUsers, NewUserData(c.Peer.GetUserID(), c.Peer.GetUserState(userID), c.now()))
 			}
 			for _, userID := range data.Followees {
-				followeeUsers = append(followeeUsers, NewUserData(c.Peer.GetUserID(), c.Peer.GetUserState(userID)))
+				followeeUsers = append(followeeUsers, NewUserData(c.Peer.GetUserID(), c.Peer.GetUserState(userID), c.now()))
 			}
 
 			profile := ProfilePage{
@@ -436,7 +547,7 @@ func (c Client) ProfileHandler() http.HandlerFunc {
 				IsBlocked:     isBlocked,
 			}
 			// Render
-			t, err := template.ParseFiles(TemplateFileMap["base"], TemplateFileMap["profile"], TemplatePath("components.html"))
+			t, err := template.ParseFiles(c.templateFiles("profile")...)
 			if err != nil {
 				fmt.Println(err)
 				return
@@ -581,7 +692,7 @@ func (c Client) DiscoverHandler() http.HandlerFunc {
 			// Convert to undiscovered user data.
 			var undiscoveredUserData []UserData
 			for _, uID := range undiscoveredUsers {
-				undiscoveredUserData = append(undiscoveredUserData, NewUserData(c.Peer.GetUserID(), c.Peer.GetUserState(uID)))
+				undiscoveredUserData = append(undiscoveredUserData, NewUserData(c.Peer.GetUserID(), c.Peer.GetUserState(uID), c.now()))
 			}
 			var suggestedUsers []UserData
 			if len(undiscoveredUsers) > 5 {
@@ -600,7 +711,7 @@ func (c Client) DiscoverHandler() http.HandlerFunc {
 				MyData:         c.GetUserData(c.Peer.GetUserID()),
 			}
 			// Render
-			t, err := template.ParseFiles(TemplateFileMap["base"], TemplateFileMap["discover"], TemplatePath("components.html"))
+			t, err := template.ParseFiles(c.templateFiles("discover")...)
 			if err != nil {
 				fmt.Println(err)
 				return
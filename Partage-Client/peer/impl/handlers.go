@@ -1,7 +1,6 @@
 package impl
 
 import (
-	"encoding/json"
 	"fmt"
 
 	"go.dedis.ch/cs438/peer/impl/utils"
@@ -9,19 +8,10 @@ import (
 	"go.dedis.ch/cs438/types"
 )
 
-func ParsePayload(payload json.RawMessage) (map[string]string, error) {
-	payloadMap := make(map[string]string, 1)
-	err := json.Unmarshal(payload, &payloadMap)
-	if err != nil {
-		return nil, err
-	}
-	return payloadMap, nil
-}
-
 func (n *node) ChatMessageHandler(msg types.Message, pkt transport.Packet) error {
-	_, err := ParsePayload(pkt.Msg.Payload)
-	if err != nil {
-		return fmt.Errorf("could not handle chat message: %w", err)
+	_, ok := msg.(*types.ChatMessage)
+	if !ok {
+		return fmt.Errorf("could not handle chat message: unexpected type %T", msg)
 	}
 	//fmt.Println("Received message:", payload["Message"])
 	return nil
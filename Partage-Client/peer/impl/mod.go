@@ -15,6 +15,7 @@ import (
 
 	"go.dedis.ch/cs438/peer/impl/cryptography"
 	"go.dedis.ch/cs438/peer/impl/data"
+	"go.dedis.ch/cs438/peer/impl/dht"
 	"go.dedis.ch/cs438/peer/impl/gossip"
 	"go.dedis.ch/cs438/peer/impl/network"
 	"go.dedis.ch/cs438/peer/impl/utils"
@@ -26,6 +27,9 @@ import (
 	"go.dedis.ch/cs438/types"
 )
 
+// certificateCheckInterval is the interval at which the peer checks whether its certificate must be renewed.
+const certificateCheckInterval = time.Minute
+
 // node implements a peer to build a Peerster system
 //
 // - implements peer.Peer
@@ -40,6 +44,7 @@ type node struct {
 	data      *data.Layer
 	consensus *consensus.Layer
 	gossip    *gossip.Layer
+	dht       *dht.Layer
 	network   *network.Layer
 	// For tcp connections only
 	cryptography *cryptography.Layer
@@ -56,23 +61,36 @@ func NewPeer(conf peer.Configuration) peer.Peer {
 	tlsSock, isRunningTLS := conf.Socket.(*tcptls.Socket)
 	if isRunningTLS {
 		_ = tlsSock.RegisterUser()
+		if conf.CertificateRenewal > 0 {
+			quitDistributor.NewListener("renewal")
+		}
 	}
 	// Create the layers.
 	networkLayer := network.Construct(&conf)
+	dhtLayer := dht.Construct(networkLayer, &conf)
+	if dhtLayer.Enabled() {
+		dhtLayer.RegisterHandlers()
+		if conf.DHTRefreshInterval > 0 {
+			quitDistributor.NewListener("dht")
+		}
+	}
+	if conf.BlobGCInterval > 0 {
+		quitDistributor.NewListener("gc")
+	}
 	var cryptographyLayer *cryptography.Layer
 	if isRunningTLS {
-		cryptographyLayer = cryptography.Construct(networkLayer, &conf)
+		cryptographyLayer = cryptography.Construct(networkLayer, dhtLayer, &conf)
 		cryptographyLayer.RegisterHandlers()
 	}
 
 	gossipLayer := gossip.Construct(networkLayer, cryptographyLayer, &conf, quitDistributor)
-	consensusLayer := consensus.Construct(gossipLayer, &conf)
-	dataLayer := data.Construct(gossipLayer, consensusLayer, networkLayer, cryptographyLayer, &conf)
+	consensusLayer := consensus.Construct(gossipLayer, cryptographyLayer, &conf, quitDistributor)
+	dataLayer := data.Construct(gossipLayer, consensusLayer, networkLayer, cryptographyLayer, dhtLayer, &conf)
 	var hashedPK [32]byte
 	if isRunningTLS {
 		hashedPK = cryptographyLayer.GetHashedPublicKey()
 	}
-	socialLayer := social.Construct(&conf, dataLayer, consensusLayer, gossipLayer, hashedPK)
+	socialLayer := social.Construct(&conf, dataLayer, consensusLayer, gossipLayer, networkLayer, cryptographyLayer, hashedPK)
 
 	node := &node{
 		addr: conf.Socket.GetAddress(),
@@ -85,14 +103,19 @@ func NewPeer(conf peer.Configuration) peer.Peer {
 		data:         dataLayer,
 		consensus:    consensusLayer,
 		gossip:       gossipLayer,
+		dht:          dhtLayer,
 		network:      networkLayer,
 		cryptography: cryptographyLayer,
 	}
+	// The posts of the user and its followees are kept in the blob store.
+	dataLayer.SetFeedPins(node.followedMetahashes)
+	// Replay the stored chains, if any, so that a peer restarting on a persistent storage resumes where it stopped.
+	socialLayer.LoadRegisteredUsers(conf.BlockchainStorage)
 	// Register the handlers.
 	gossipLayer.RegisterHandlers()
 	consensusLayer.RegisterHandlers()
 	dataLayer.RegisterHandlers()
-	//socialLayer.RegisterHandlers()
+	socialLayer.RegisterHandlers()
 
 	conf.MessageRegistry.RegisterMessageCallback(types.ChatMessage{}, node.ChatMessageHandler)
 	conf.MessageRegistry.RegisterMessageCallback(types.EmptyMessage{}, node.EmptyMessageHandler)
@@ -126,6 +149,11 @@ func (n *node) Start() error {
 				}
 			}
 		}()
+		if n.conf.CertificateRenewal > 0 {
+			go n.renewCertificateBeforeExpiry(sock)
+		}
+		// Let the other peers find our public key.
+		go n.dht.Provide(dht.PublicKeyKey(n.cryptography.GetHashedPublicKey()))
 		go func() {
 			pktQueue := *sock.GetPktQueue()
 			// Wait for new packets...
@@ -209,6 +237,12 @@ func (n *node) Start() error {
 		}()
 	}
 
+	if n.dht.Enabled() && n.conf.DHTRefreshInterval > 0 {
+		go n.refreshDHT()
+	}
+	if n.conf.BlobGCInterval > 0 {
+		go n.collectGarbagePeriodically()
+	}
 	return nil
 }
 
@@ -262,6 +296,16 @@ func (n *node) Download(metahash string) ([]byte, error) {
 	return n.data.Download(metahash)
 }
 
+// UploadFrom implements peer.DataSharing
+func (n *node) UploadFrom(data io.Reader, progress peer.Progress) (metahash string, err error) {
+	return n.data.UploadFrom(data, progress)
+}
+
+// DownloadTo implements peer.DataSharing
+func (n *node) DownloadTo(metahash string, w io.Writer, progress peer.Progress) error {
+	return n.data.DownloadTo(metahash, w, progress)
+}
+
 // Tag implements peer.DataSharing
 func (n *node) Tag(name string, mh string) error {
 	return n.data.Tag(name, mh)
@@ -277,6 +321,16 @@ func (n *node) GetCatalog() peer.Catalog {
 	return n.data.GetCatalog()
 }
 
+// Pin implements peer.DataSharing
+func (n *node) Pin(metahash string) {
+	n.data.Pin(metahash)
+}
+
+// Unpin implements peer.DataSharing
+func (n *node) Unpin(metahash string) {
+	n.data.Unpin(metahash)
+}
+
 // UpdateCatalog implements peer.DataSharing
 func (n *node) UpdateCatalog(key string, peer string) {
 	n.data.UpdateCatalog(key, peer)
@@ -312,6 +366,21 @@ func (n *node) RegisterUser() error {
 	return n.social.Register()
 }
 
+// LeaveNetwork implements peer.SocialPeer
+func (n *node) LeaveNetwork() error {
+	return n.social.Leave()
+}
+
+// RemoveMember implements peer.SocialPeer
+func (n *node) RemoveMember(userID string) error {
+	return n.social.Remove(userID)
+}
+
+// SyncBlockchains implements peer.SocialPeer
+func (n *node) SyncBlockchains(peerAddr string) error {
+	return n.social.SyncBlockchains(peerAddr)
+}
+
 // BlockUser implements peer.SocialPeer
 func (n *node) BlockUser(publicKeyHash [32]byte) {
 	tlsSock, ok := n.conf.Socket.(*tcptls.Socket)
@@ -339,8 +408,164 @@ func (n *node) IsBlocked(userID string) bool {
 func (n *node) UnblockUser(publicKeyHash [32]byte) {
 	tlsSock, ok := n.conf.Socket.(*tcptls.Socket)
 	if ok {
-		tlsSock.Unblock(publicKeyHash)
+		unblockedIPs := tlsSock.Unblock(publicKeyHash)
+		// Re-fetch the rumors of the user that were dropped while it was blocked.
+		if len(unblockedIPs) > 0 {
+			n.gossip.CatchUp()
+		}
+	}
+}
+
+// UpdateRevocationList implements peer.SocialPeer
+func (n *node) UpdateRevocationList() error {
+	tlsSock, ok := n.conf.Socket.(*tcptls.Socket)
+	if !ok {
+		return fmt.Errorf("revocation lists require a tls socket")
+	}
+	list, err := tlsSock.FetchRevocationList()
+	if err != nil {
+		return fmt.Errorf("could not fetch the revocation list: %w", err)
+	}
+	return n.spreadRevocationList(tlsSock, list)
+}
+
+// RevokeCertificate implements peer.SocialPeer
+func (n *node) RevokeCertificate() error {
+	tlsSock, ok := n.conf.Socket.(*tcptls.Socket)
+	if !ok {
+		return fmt.Errorf("revocation lists require a tls socket")
 	}
+	list, err := tlsSock.RevokeCertificate()
+	if err != nil {
+		return fmt.Errorf("could not revoke the certificate: %w", err)
+	}
+	return n.spreadRevocationList(tlsSock, list)
+}
+
+// spreadRevocationList applies the given revocation list and broadcasts it if it is more recent than ours. The peers
+// check the rumor against their current list, so that it also spreads when it revokes our own certificate.
+func (n *node) spreadRevocationList(tlsSock *tcptls.Socket, list *types.RevocationList) error {
+	updated, err := tlsSock.UpdateRevocationList(list)
+	if err != nil || !updated {
+		return err
+	}
+	msg := types.RevocationListMessage{List: *list}
+	transpMsg, err := n.conf.MessageRegistry.MarshalMessage(&msg)
+	if err != nil {
+		return err
+	}
+	return n.gossip.Broadcast(transpMsg)
+}
+
+// RenewCertificate implements peer.SocialPeer
+func (n *node) RenewCertificate() error {
+	tlsSock, ok := n.conf.Socket.(*tcptls.Socket)
+	if !ok {
+		return fmt.Errorf("certificates require a tls socket")
+	}
+	return tlsSock.RenewCertificate()
+}
+
+// renewCertificateBeforeExpiry periodically checks whether the certificate expires within the configured renewal
+// window, and renews it if so.
+func (n *node) renewCertificateBeforeExpiry(sock *tcptls.Socket) {
+	quitListener, _ := n.quitDistributor.GetListener("renewal")
+	for {
+		if sock.ExpiresWithin(n.conf.CertificateRenewal) {
+			err := sock.RenewCertificate()
+			if err != nil {
+				fmt.Println("could not renew the certificate:", err)
+			}
+		}
+		select {
+		case <-quitListener:
+			return
+		case <-time.After(certificateCheckInterval):
+		}
+	}
+}
+
+// refreshDHT periodically announces again the data provided by the peer in the DHT.
+func (n *node) refreshDHT() {
+	quitListener, _ := n.quitDistributor.GetListener("dht")
+	for {
+		select {
+		case <-quitListener:
+			return
+		case <-time.After(n.conf.DHTRefreshInterval):
+			n.dht.Refresh()
+		}
+	}
+}
+
+// collectGarbagePeriodically removes the data blobs that are not referenced anymore from the blob store.
+func (n *node) collectGarbagePeriodically() {
+	quitListener, _ := n.quitDistributor.GetListener("gc")
+	for {
+		select {
+		case <-quitListener:
+			return
+		case <-time.After(n.conf.BlobGCInterval):
+			removed := n.CollectGarbage()
+			utils.PrintDebug("data", n.addr, "removed", removed, "blobs from the blob store")
+		}
+	}
+}
+
+// CollectGarbage implements peer.SocialPeer
+func (n *node) CollectGarbage() uint {
+	return n.data.CollectGarbage(n.feedMetahashes(n.GetKnownUsers()))
+}
+
+// followedMetahashes returns the metahashes of the posts of the user and its followees.
+func (n *node) followedMetahashes() map[string]struct{} {
+	userID := n.social.GetUserID()
+	userIDs := map[string]struct{}{userID: {}}
+	for followee := range n.GetUserState(userID).Followees {
+		userIDs[followee] = struct{}{}
+	}
+	return n.feedMetahashes(userIDs)
+}
+
+// feedMetahashes returns the metahashes of all the versions of the visible posts of the given users. The undone posts
+// are left out.
+func (n *node) feedMetahashes(userIDs map[string]struct{}) map[string]struct{} {
+	metahashes := make(map[string]struct{})
+	for userID := range userIDs {
+		f := n.social.FeedStore.GetFeedCopy(userID)
+		// The user is not registered.
+		if f == nil {
+			continue
+		}
+		for _, c := range f.GetContents() {
+			// The content id of the undone posts is hidden.
+			if c.ContentID == "" {
+				continue
+			}
+			for _, version := range n.GetContentHistory(c.ContentID) {
+				metahash, err := content.ParsePostMetadata(version.Metadata)
+				if err == nil {
+					metahashes[metahash] = struct{}{}
+				}
+			}
+		}
+	}
+	return metahashes
+}
+
+// IsRevoked implements peer.SocialPeer
+func (n *node) IsRevoked(userID string) bool {
+	tlsSock, ok := n.conf.Socket.(*tcptls.Socket)
+	if ok {
+		hashedPK, err := hex.DecodeString(userID)
+		if err != nil {
+			return false
+		}
+		var hashedPKArray [32]byte
+		copy(hashedPKArray[:], hashedPK)
+		return tlsSock.IsRevoked(hashedPKArray)
+	}
+	return false
 }
 
 // GetHashedPublicKey implements peer.SocialPeer
@@ -374,6 +599,11 @@ func (n *node) GetFeedContents(userID string) []feed.Content {
 	return n.social.FeedStore.GetFeedCopy(userID).GetContents()
 }
 
+// GetContentHistory implements peer.SocialPeer
+func (n *node) GetContentHistory(contentID string) []feed.Content {
+	return n.social.FeedStore.GetContentHistory(contentID)
+}
+
 // GetReactions implements peer.SocialPeer
 func (n *node) GetReactions(contentID string) []feed.ReactionInfo {
 	return n.social.FeedStore.GetReactions(contentID)
@@ -404,6 +634,9 @@ func (n *node) ShareDownloadableContent(cnt content.PrivateContent, t content.Ty
 	// Then, update the feed with the new metadata.
 	metadata := content.CreateDownloadableContentMetadata(cnt.AuthorID, cnt.Timestamp, cnt.RefContentID, metahash, t)
 	blockHash, err := n.UpdateFeed(metadata)
+	if err == nil {
+		go n.dht.Provide(dht.ContentKey(metadata.ContentID))
+	}
 	return metadata, blockHash, err
 }
 
@@ -416,5 +649,19 @@ func (n *node) QueryFeedContents(filter content.Filter) []feed.Content {
 }
 
 func (n *node) DownloadContent(contentID string) ([]byte, error) {
-	return n.data.DownloadContent(contentID)
+	return n.data.DownloadContent(n.latestContentID(contentID))
+}
+
+func (n *node) DownloadContentTo(contentID string, w io.Writer, progress peer.Progress) error {
+	return n.data.DownloadContentTo(n.latestContentID(contentID), w, progress)
+}
+
+// latestContentID returns the content id of the latest version of the content with the given content id, i.e., the
+// content id of its latest edit if it has been edited.
+func (n *node) latestContentID(contentID string) string {
+	history := n.social.FeedStore.GetContentHistory(contentID)
+	if len(history) == 0 {
+		return contentID
+	}
+	return history[len(history)-1].ContentID
 }
@@ -4,7 +4,6 @@ import (
 	"time"
 
 	"go.dedis.ch/cs438/peer/impl/social/feed"
-	"go.dedis.ch/cs438/peer/impl/utils"
 )
 
 type UserData struct {
@@ -36,6 +35,7 @@ type Comment struct {
 	Timestamp       int64
 	Reactions       []Reaction
 	AlreadyReacted  string
+	Edited          bool
 	TimestampToDate func(int64) string
 }
 
@@ -49,10 +49,11 @@ type Text struct {
 	Comments        []Comment
 	Recipients      []string
 	AlreadyReacted  string
+	Edited          bool
 	TimestampToDate func(int64) string
 }
 
-func NewUserData(selfUserID string, userState feed.UserState) UserData {
+func NewUserData(selfUserID string, userState feed.UserState, now int64) UserData {
 	var followers []string
 	for f := range userState.Followers {
 		followers = append(followers, f)
@@ -67,8 +68,8 @@ func NewUserData(selfUserID string, userState feed.UserState) UserData {
 		Credits:                userState.CurrentCredits,
 		Followers:              followers,
 		Followees:              followees,
-		CanRequestEndorsements: userState.CanRequest(utils.Time()),
-		CanBeEndorsed:          userState.CanEndorse(utils.Time(), selfUserID),
+		CanRequestEndorsements: userState.CanRequest(now),
+		CanBeEndorsed:          userState.CanEndorse(now, selfUserID),
 		ReceivedEndorsements:   userState.ReceivedEndorsements,
 	}
 }
@@ -93,6 +94,7 @@ func NewComment(text string, c feed.Content, author UserData, reactions []Reacti
 		BlockHash:       c.BlockHash,
 		Timestamp:       c.Timestamp,
 		Reactions:       reactions,
+		Edited:          c.Edited,
 		TimestampToDate: timestampToDate,
 	}
 }
@@ -106,6 +108,7 @@ func NewText(text string, c feed.Content, author UserData, reactions []Reaction,
 		Timestamp:       c.Timestamp,
 		Reactions:       reactions,
 		Comments:        comments,
+		Edited:          c.Edited,
 		TimestampToDate: timestampToDate,
 	}
 }
@@ -0,0 +1,94 @@
+package social
+
+import (
+	"fmt"
+	"github.com/rs/xid"
+	"go.dedis.ch/cs438/peer/impl/content"
+	"go.dedis.ch/cs438/peer/impl/social/feed"
+	"go.dedis.ch/cs438/peer/impl/utils"
+	"go.dedis.ch/cs438/types"
+	"sort"
+	"time"
+)
+
+// feedEntry is a metadata waiting to be decided in the feed blockchain of the user.
+type feedEntry struct {
+	metadata content.Metadata
+	// hash identifies the metadata in the feed once it is decided.
+	hash string
+	err  error
+}
+
+// feedBatch collects the metadata proposed during a batching window, so that they are decided within a single block.
+type feedBatch struct {
+	entries []*feedEntry
+	// done is closed once the batch has been decided or has failed.
+	done chan struct{}
+}
+
+// proposeInBatch adds the given metadata into the pending batch and blocks until the batch is decided. Returns the hash
+// that identifies the metadata in the feed.
+func (l *Layer) proposeInBatch(metadata content.Metadata) (string, error) {
+	entry := &feedEntry{metadata: metadata}
+	l.batchLock.Lock()
+	batch := l.pendingBatch
+	// Open a new batch if there is none pending.
+	if batch == nil {
+		batch = &feedBatch{done: make(chan struct{})}
+		l.pendingBatch = batch
+		time.AfterFunc(l.Config.FeedBatchWindow, func() {
+			l.decideBatch(batch)
+		})
+	}
+	batch.entries = append(batch.entries, entry)
+	l.batchLock.Unlock()
+	<-batch.done
+	return entry.hash, entry.err
+}
+
+// decideBatch proposes the metadata of the given batch within a single block of the user's feed blockchain. The
+// batches are decided one after the other, so that each batch is checked against the feed that includes the
+// previous ones.
+func (l *Layer) decideBatch(batch *feedBatch) {
+	// The metadata proposed from now on go into the next batch.
+	l.batchLock.Lock()
+	if l.pendingBatch == batch {
+		l.pendingBatch = nil
+	}
+	l.batchLock.Unlock()
+	defer close(batch.done)
+	l.decideLock.Lock()
+	defer l.decideLock.Unlock()
+	// Apply the metadata in the order of their timestamps and leave out the ones that conflict with the previous ones.
+	sort.SliceStable(batch.entries, func(i, j int) bool {
+		return batch.entries[i].metadata.Timestamp < batch.entries[j].metadata.Timestamp
+	})
+	var accepted []*feedEntry
+	var metadataList []content.Metadata
+	for _, entry := range batch.entries {
+		candidateList := append(metadataList, entry.metadata)
+		err := l.FeedStore.CheckMetadataBatch(candidateList)
+		if err != nil {
+			entry.err = err
+			continue
+		}
+		accepted = append(accepted, entry)
+		metadataList = candidateList
+	}
+	if len(accepted) == 0 {
+		return
+	}
+	utils.PrintDebug("social", l.GetAddress(), "is proposing a batch of", len(accepted), "metadata")
+	paxosVal := types.PaxosValue{
+		UniqID:      xid.New().String(),
+		CustomValue: content.UnparseMetadataBatch(metadataList),
+	}
+	blockHash, err := l.consensus.ProposeWithProtocol(feed.IDFromUserID(l.UserID), paxosVal)
+	for i, entry := range accepted {
+		if err != nil {
+			entry.err = fmt.Errorf("could not propose metadata at social layer: %v", err)
+			continue
+		}
+		entry.hash = content.EntryHash(blockHash, i)
+	}
+}
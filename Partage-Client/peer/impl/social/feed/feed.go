@@ -12,6 +12,8 @@ import (
 type Content struct {
 	content.Metadata
 	BlockHash string
+	// Edited is true if the content was edited, in which case the metadata refers to its latest version.
+	Edited bool
 }
 
 // Feed represents a user's feed.
@@ -23,7 +25,9 @@ type Feed struct {
 	blockHashes map[string]Content
 	// Undo-ed contents.
 	hiddenContentIDs map[string]struct{}
-	metadataStore    storage.Store
+	// Edit history of the edited contents, from the original content to the latest edit.
+	versions      map[string][]Content
+	metadataStore storage.Store
 }
 
 func NewEmptyFeed(userID string, metadataStore storage.Store) *Feed {
@@ -33,6 +37,7 @@ func NewEmptyFeed(userID string, metadataStore storage.Store) *Feed {
 		contents:         []Content{},
 		blockHashes:      make(map[string]Content),
 		hiddenContentIDs: make(map[string]struct{}),
+		versions:         make(map[string][]Content),
 		metadataStore:    metadataStore,
 	}
 }
@@ -53,12 +58,17 @@ func (f *Feed) Copy() *Feed {
 	for k, v := range f.hiddenContentIDs {
 		hiddenContentIDs[k] = v
 	}
+	versions := make(map[string][]Content, len(f.versions))
+	for k, v := range f.versions {
+		versions[k] = append([]Content{}, v...)
+	}
 	return &Feed{
 		UserID:           f.UserID,
 		userState:        &userState,
 		contents:         contents,
 		blockHashes:      blockHashes,
 		hiddenContentIDs: hiddenContentIDs,
+		versions:         versions,
 		// The store cannot be copied!
 		metadataStore: f.metadataStore,
 	}
@@ -75,6 +85,7 @@ func (f *Feed) GetContents() []Content {
 	defer f.RUnlock()
 	var contents []Content
 	for _, c := range f.contents {
+		c = f.latestVersion(c)
 		_, hidden := f.hiddenContentIDs[c.ContentID]
 		// Hide the content id.
 		if hidden {
@@ -85,6 +96,66 @@ func (f *Feed) GetContents() []Content {
 	return contents
 }
 
+// latestVersion returns the given content as of its latest edit.
+// Warning: thread-unsafe
+func (f *Feed) latestVersion(c Content) Content {
+	versions, edited := f.versions[c.ContentID]
+	if !edited || c.ContentID == "" {
+		return c
+	}
+	c.Data = versions[len(versions)-1].Data
+	c.Edited = true
+	return c
+}
+
+// GetWithContentID returns the latest version of the content associated with the given content id.
+func (f *Feed) GetWithContentID(contentID string) (Content, error) {
+	f.RLock()
+	defer f.RUnlock()
+	c, ok := f.getWithContentID(contentID)
+	if !ok {
+		return Content{}, fmt.Errorf("feed: unknown content id")
+	}
+	return f.latestVersion(c), nil
+}
+
+// Thread unsafe version of GetWithContentID, which returns the original content.
+func (f *Feed) getWithContentID(contentID string) (Content, bool) {
+	if contentID == "" {
+		return Content{}, false
+	}
+	for _, c := range f.contents {
+		if c.ContentID == contentID {
+			return c, true
+		}
+	}
+	return Content{}, false
+}
+
+// GetHistory returns the versions of the content associated with the given content id, from the original content to
+// the latest edit. Returns nil if the content id is unknown.
+func (f *Feed) GetHistory(contentID string) []Content {
+	f.RLock()
+	defer f.RUnlock()
+	versions, edited := f.versions[contentID]
+	if edited {
+		return append([]Content{}, versions...)
+	}
+	c, ok := f.getWithContentID(contentID)
+	if !ok {
+		return nil
+	}
+	return []Content{c}
+}
+
+// IsHidden returns true if the content associated with the given content id was undone.
+func (f *Feed) IsHidden(contentID string) bool {
+	f.RLock()
+	defer f.RUnlock()
+	_, hidden := f.hiddenContentIDs[contentID]
+	return hidden
+}
+
 // GetWithHash returns the metadata associated with the given block hash.
 func (f *Feed) GetWithHash(blockHash string) (Content, error) {
 	f.RLock()
@@ -96,6 +167,19 @@ func (f *Feed) GetWithHash(blockHash string) (Content, error) {
 	return m, nil
 }
 
+// GetLastTimestamp returns the timestamp of the last content appended by the given user. Returns false if the user
+// has not appended anything yet.
+func (f *Feed) GetLastTimestamp(userID string) (int64, bool) {
+	f.RLock()
+	defer f.RUnlock()
+	for i := len(f.contents) - 1; i >= 0; i-- {
+		if f.contents[i].FeedUserID == userID {
+			return f.contents[i].Timestamp, true
+		}
+	}
+	return 0, false
+}
+
 // Append appends a new feed content into the feed and updates the user state accordingly. The underlying blockchain is not modified.
 // Returns the appended content.
 func (f *Feed) Append(metadata content.Metadata, blockHash string) (Content, error) {
@@ -128,6 +212,23 @@ func (f *Feed) Undo(metadata content.Metadata) error {
 	return nil
 }
 
+// Edit appends the given edit as the latest version of the content that it refers to. The underlying blockchain is not
+// modified. Returns the latest version of the edited content.
+func (f *Feed) Edit(edit Content) (Content, error) {
+	f.Lock()
+	defer f.Unlock()
+	original, ok := f.getWithContentID(edit.RefContentID)
+	if !ok {
+		return Content{}, fmt.Errorf("feed: unknown content id")
+	}
+	// The history starts with the original content.
+	if _, edited := f.versions[original.ContentID]; !edited {
+		f.versions[original.ContentID] = []Content{original}
+	}
+	f.versions[original.ContentID] = append(f.versions[original.ContentID], edit)
+	return f.latestVersion(original), nil
+}
+
 // ReceiveEndorsement updates the endorsement given by a different user.
 func (f *Feed) ReceiveEndorsement(endorsement content.Metadata) {
 	f.Lock()
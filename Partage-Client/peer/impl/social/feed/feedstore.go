@@ -15,19 +15,51 @@ type Store struct {
 	sync.RWMutex
 	feedMap         map[string]*Feed
 	knownUsers      map[string]struct{}
+	leftUsers       map[string]struct{}
 	reactionHandler *ReactionHandler
 
 	BlockchainStorage storage.MultipurposeStorage
 	MetadataStore     storage.Store
+	// Clock returns the current unix time in seconds, against which the timestamps are checked.
+	Clock func() int64
 }
 
-func LoadStore(blockchainStorage storage.MultipurposeStorage, metadataStore storage.Store) *Store {
+func LoadStore(blockchainStorage storage.MultipurposeStorage, metadataStore storage.Store, clock func() int64) *Store {
 	return &Store{
 		feedMap:           make(map[string]*Feed),
 		knownUsers:        make(map[string]struct{}),
+		leftUsers:         make(map[string]struct{}),
 		reactionHandler:   NewReactionHandler(),
 		BlockchainStorage: blockchainStorage,
 		MetadataStore:     metadataStore,
+		Clock:             clock,
+	}
+}
+
+// workingCopy returns a copy of the in-memory state of the store, onto which metadata can be appended without
+// modifying the store. The copy does not save anything into the metadata store.
+func (s *Store) workingCopy() *Store {
+	s.RLock()
+	defer s.RUnlock()
+	feedMap := make(map[string]*Feed, len(s.feedMap))
+	for k, v := range s.feedMap {
+		feedMap[k] = v.Copy()
+	}
+	knownUsers := make(map[string]struct{}, len(s.knownUsers))
+	for k := range s.knownUsers {
+		knownUsers[k] = struct{}{}
+	}
+	leftUsers := make(map[string]struct{}, len(s.leftUsers))
+	for k := range s.leftUsers {
+		leftUsers[k] = struct{}{}
+	}
+	return &Store{
+		feedMap:           feedMap,
+		knownUsers:        knownUsers,
+		leftUsers:         leftUsers,
+		reactionHandler:   s.reactionHandler.Copy(),
+		BlockchainStorage: s.BlockchainStorage,
+		Clock:             s.Clock,
 	}
 }
 
@@ -38,14 +70,25 @@ func (s *Store) loadFeed(userID string) {
 	if utils.GLOBAL_FEED && ok {
 		return
 	}
-	store := s.BlockchainStorage.GetStore(IDFromUserID(userID))
-	blocks := utils.LoadBlockchain(store)
-	// Create an empty feed.
+	s.createFeed(userID)
+	s.replayFeed(userID)
+}
+
+// createFeed creates an empty feed associated with the given user id.
+// Warning: thread-unsafe
+func (s *Store) createFeed(userID string) {
 	feedName := userID
 	if utils.GLOBAL_FEED {
 		feedName = "feed"
 	}
 	s.feedMap[feedName] = NewEmptyFeed(userID, s.MetadataStore)
+}
+
+// replayFeed appends the blocks of the given user's feed blockchain into the in-memory feed.
+// Warning: thread-unsafe
+func (s *Store) replayFeed(userID string) {
+	store := s.BlockchainStorage.GetStore(IDFromUserID(userID))
+	blocks := utils.LoadBlockchain(store)
 	// Move into memory.
 	for _, block := range blocks {
 		s.appendToFeed(userID, block)
@@ -75,6 +118,20 @@ func (s *Store) GetFeedCopy(userID string) *Feed {
 	return feed.Copy()
 }
 
+// GetContentHistory returns the versions of the content associated with the given content id, from the original
+// content to the latest edit. Returns nil if the content id is unknown.
+func (s *Store) GetContentHistory(contentID string) []Content {
+	s.RLock()
+	defer s.RUnlock()
+	for _, feed := range s.feedMap {
+		history := feed.GetHistory(contentID)
+		if history != nil {
+			return history
+		}
+	}
+	return nil
+}
+
 // GetReactions returns the known reactions associated with the given content id.
 func (s *Store) GetReactions(contentID string) []ReactionInfo {
 	return s.reactionHandler.GetReactionsCopy(contentID)
@@ -144,6 +201,26 @@ func (s *Store) LoadUser(userID string) {
 	s.loadFeed(userID)
 }
 
+// LoadUsers adds the given user ids to this feed store and replays their feeds from storage. Should be invoked when
+// restoring the feed store from a previously populated storage.
+func (s *Store) LoadUsers(userIDs []string) {
+	s.Lock()
+	defer s.Unlock()
+	// Create all the feeds first, so that the replayed blocks can refer to any known user.
+	for _, userID := range userIDs {
+		s.knownUsers[userID] = struct{}{}
+		s.createFeed(userID)
+	}
+	// With the global feed, all the blocks are stored in the same blockchain.
+	if utils.GLOBAL_FEED && len(userIDs) > 0 {
+		s.replayFeed(userIDs[0])
+		return
+	}
+	for _, userID := range userIDs {
+		s.replayFeed(userID)
+	}
+}
+
 // IsKnown returns true if the given user id was added with LoadUser to this feed store.
 func (s *Store) IsKnown(userID string) bool {
 	s.RLock()
@@ -152,6 +229,21 @@ func (s *Store) IsKnown(userID string) bool {
 	return isKnown
 }
 
+// MarkLeft marks the given user as left. The feed of the user is kept, but cannot be extended anymore.
+func (s *Store) MarkLeft(userID string) {
+	s.Lock()
+	defer s.Unlock()
+	s.leftUsers[userID] = struct{}{}
+}
+
+// HasLeft returns true if the given user was marked as left with MarkLeft.
+func (s *Store) HasLeft(userID string) bool {
+	s.RLock()
+	defer s.RUnlock()
+	_, hasLeft := s.leftUsers[userID]
+	return hasLeft
+}
+
 // AppendToFeed updates the feed state associated with the given user id with the given new block.
 func (s *Store) AppendToFeed(userID string, newBlock types.BlockchainBlock) {
 	s.Lock()
@@ -161,19 +253,27 @@ func (s *Store) AppendToFeed(userID string, newBlock types.BlockchainBlock) {
 
 // Thread unsafe version of AppendToFeed.
 func (s *Store) appendToFeed(userID string, newBlock types.BlockchainBlock) {
-	// Extract the content metadata.
-	metadata := content.ParseMetadata(newBlock.Value.CustomValue)
+	blockHash := hex.EncodeToString(newBlock.Hash)
+	// Apply the metadata held by the block in order.
+	for i, metadata := range content.ParseMetadataBatch(newBlock.Value.CustomValue) {
+		s.appendMetadata(userID, metadata, content.EntryHash(blockHash, i))
+	}
+}
+
+// appendMetadata updates the feed state associated with the given user id with the given metadata, which is
+// identified by the given entry hash.
+// Warning: thread-unsafe
+func (s *Store) appendMetadata(userID string, metadata content.Metadata, entryHash string) {
 	// --- Append into the in-memory as well.
 	// Get the associated feed.
 	feed := s.getFeed(userID)
 	// First, save the metadata into the metadata storage.
-	if metadata.ContentID != "" {
+	if metadata.ContentID != "" && s.MetadataStore != nil {
 		metadataBytes := content.UnparseMetadata(metadata)
 		s.MetadataStore.Set(metadata.ContentID, metadataBytes)
 	}
-	blockHash := hex.EncodeToString(newBlock.Hash)
 	// Append into the actual feed & update the user state.
-	feedContent, err := feed.Append(metadata, blockHash)
+	feedContent, err := feed.Append(metadata, entryHash)
 	if err != nil {
 		fmt.Println(err)
 		return
@@ -210,6 +310,15 @@ func (s *Store) appendToFeed(userID string, newBlock types.BlockchainBlock) {
 		// Save the reaction.
 		s.reactionHandler.SaveReaction(feedContent, reaction)
 	}
+	// If we have an edit block, record the new version of the edited content. The signed metadata of the original
+	// content stays in the metadata store, and the edit is stored under its own content id.
+	if metadata.Type == content.EDIT {
+		_, err := feed.Edit(feedContent)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
 	// If we have an undo block, we need to do some special stuff.
 	if metadata.Type == content.UNDO {
 		// Extract the referred block hash.
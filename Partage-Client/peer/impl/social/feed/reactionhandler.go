@@ -24,6 +24,18 @@ func NewReactionHandler() *ReactionHandler {
 	}
 }
 
+func (h *ReactionHandler) Copy() *ReactionHandler {
+	h.RLock()
+	defer h.RUnlock()
+	reactionMap := make(map[string][]ReactionInfo, len(h.reactionMap))
+	for k, v := range h.reactionMap {
+		reactionMap[k] = append([]ReactionInfo{}, v...)
+	}
+	return &ReactionHandler{
+		reactionMap: reactionMap,
+	}
+}
+
 // AlreadyReacted returns true if the given user has reacted to the given content.
 func (h *ReactionHandler) AlreadyReacted(contentID string, userID string) bool {
 	h.RLock()
@@ -6,3 +6,7 @@ var REQUIRED_ENDORSEMENTS = 2
 var ENDORSEMENT_INTERVAL int64 = 60 * 60
 var ENDORSEMENT_REWARD = 30
 var ENDORSEMENT_REQUEST_CREDIT_LIMIT = 5
+
+// TIMESTAMP_SKEW_WINDOW is the maximum allowed difference (in seconds) between the timestamp of a proposed metadata and
+// the local clock.
+var TIMESTAMP_SKEW_WINDOW int64 = 5 * 60
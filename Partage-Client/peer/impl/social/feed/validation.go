@@ -3,16 +3,24 @@ package feed
 import (
 	"fmt"
 	"go.dedis.ch/cs438/peer/impl/content"
-	"go.dedis.ch/cs438/peer/impl/utils"
 )
 
 // CheckMetadata checks the validity of the given metadata. Returns either an error string explaining the issue or nil
 // in case the metadata is valid.
 func (feedStore *Store) CheckMetadata(c content.Metadata) error {
+	return feedStore.checkMetadata(c, feedStore.Clock())
+}
+
+// checkMetadata checks the validity of the given metadata as of the given unix time.
+func (feedStore *Store) checkMetadata(c content.Metadata, now int64) error {
 	// The user must be registered!
 	if !feedStore.IsKnown(c.FeedUserID) {
 		return fmt.Errorf("user is not registered")
 	}
+	// The user must not have left.
+	if feedStore.HasLeft(c.FeedUserID) {
+		return fmt.Errorf("user has left")
+	}
 	// Accept reactions only when the user has not reacted to the referred content id yet.
 	if c.Type == content.REACTION {
 		alreadyReacted := feedStore.reactionHandler.AlreadyReacted(c.RefContentID, c.FeedUserID)
@@ -52,10 +60,29 @@ func (feedStore *Store) CheckMetadata(c content.Metadata) error {
 		if !feedStore.IsKnown(referredUser) {
 			return fmt.Errorf("user to endorse is not known")
 		}
-		if !referredFeed.userState.CanEndorse(utils.Time(), c.FeedUserID) {
+		if !referredFeed.userState.CanEndorse(now, c.FeedUserID) {
 			return fmt.Errorf("cannot endorse the user")
 		}
 	}
+	// Check whether the attempted edit is valid.
+	if c.Type == content.EDIT {
+		referredContent, err := proposerFeed.GetWithContentID(c.RefContentID)
+		// The referred content must exist.
+		if err != nil {
+			return fmt.Errorf("nothing to edit")
+		}
+		// Referred content must be owned by the same user.
+		if c.FeedUserID != referredContent.FeedUserID {
+			return fmt.Errorf("cannot edit other people's stuff")
+		}
+		// Only texts and comments can be edited.
+		if referredContent.Type != content.TEXT && referredContent.Type != content.COMMENT {
+			return fmt.Errorf("content is not editable")
+		}
+		if proposerFeed.IsHidden(c.RefContentID) {
+			return fmt.Errorf("cannot edit an undone content")
+		}
+	}
 	// Check whether the attempted undo is valid.
 	if c.Type == content.UNDO {
 		referredHash, _ := content.ParseUndoMetadata(c)
@@ -78,5 +105,79 @@ func (feedStore *Store) CheckMetadata(c content.Metadata) error {
 			return fmt.Errorf("content is not undoable")
 		}
 	}
+	return feedStore.checkTimestamp(c, now)
+}
+
+// CheckMetadataBatch checks the validity of the given list of metadata, which are to be appended in order within a
+// single block. Each metadata is checked against the state that includes the ones before it in the list.
+func (feedStore *Store) CheckMetadataBatch(batch []content.Metadata) error {
+	return feedStore.checkMetadataBatch(batch, feedStore.Clock())
+}
+
+// CheckSyncedMetadataBatch checks the validity of the given list of metadata, which were decided in the past and
+// acquired through the blockchain sync. Since the batch was proposed at the time of its latest timestamp, the checks
+// are made as of that time, which must not be in our future.
+func (feedStore *Store) CheckSyncedMetadataBatch(batch []content.Metadata) error {
+	var batchTime int64
+	for _, c := range batch {
+		if c.Timestamp > batchTime {
+			batchTime = c.Timestamp
+		}
+	}
+	if batchTime > feedStore.Clock()+TIMESTAMP_SKEW_WINDOW {
+		return fmt.Errorf("timestamp is out of the accepted window")
+	}
+	return feedStore.checkMetadataBatch(batch, batchTime)
+}
+
+// checkMetadataBatch checks the validity of the given list of metadata as of the given unix time. The metadata are
+// checked in order, each one being applied to a working copy of the state before the next one is checked.
+func (feedStore *Store) checkMetadataBatch(batch []content.Metadata, now int64) error {
+	if len(batch) == 0 {
+		return fmt.Errorf("empty batch")
+	}
+	working := feedStore.workingCopy()
+	for i, c := range batch {
+		err := working.checkMetadata(c, now)
+		if err != nil {
+			return err
+		}
+		// The block hash is not known yet, so the entries are identified by their position in the batch.
+		working.appendMetadata(c.FeedUserID, c, content.EntryHash("", i))
+	}
+	return nil
+}
+
+// CheckTimestamp checks whether the timestamp of the given metadata is within the accepted clock skew window and
+// whether it is not older than the last content of the same user.
+func (feedStore *Store) CheckTimestamp(c content.Metadata) error {
+	return feedStore.checkTimestamp(c, feedStore.Clock())
+}
+
+// CheckSyncedTimestamp checks the timestamp of the given metadata, which was decided in the past and acquired through
+// the blockchain sync. The timestamp must not be in our future, nor older than the last content of the same user.
+func (feedStore *Store) CheckSyncedTimestamp(c content.Metadata) error {
+	if c.Timestamp > feedStore.Clock()+TIMESTAMP_SKEW_WINDOW {
+		return fmt.Errorf("timestamp is out of the accepted window")
+	}
+	return feedStore.checkTimestamp(c, c.Timestamp)
+}
+
+// checkTimestamp checks the timestamp of the given metadata as of the given unix time.
+func (feedStore *Store) checkTimestamp(c content.Metadata, now int64) error {
+	if c.Timestamp < now-TIMESTAMP_SKEW_WINDOW || c.Timestamp > now+TIMESTAMP_SKEW_WINDOW {
+		return fmt.Errorf("timestamp is out of the accepted window")
+	}
+	feedStore.RLock()
+	userFeed := feedStore.getFeed(c.FeedUserID)
+	feedStore.RUnlock()
+	// Unregistered users do not have a feed yet.
+	if userFeed == nil {
+		return nil
+	}
+	lastTimestamp, ok := userFeed.GetLastTimestamp(c.FeedUserID)
+	if ok && c.Timestamp < lastTimestamp {
+		return fmt.Errorf("timestamp is older than the last content of the user")
+	}
 	return nil
 }
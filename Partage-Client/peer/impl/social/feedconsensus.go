@@ -1,7 +1,10 @@
 package social
 
 import (
+	"crypto/rsa"
 	"encoding/hex"
+	"fmt"
+	"go.dedis.ch/cs438/peer/impl/consensus"
 	"go.dedis.ch/cs438/peer/impl/consensus/protocol"
 	"go.dedis.ch/cs438/peer/impl/consensus/protocol/paxos"
 	"go.dedis.ch/cs438/peer/impl/content"
@@ -14,8 +17,8 @@ import (
 // feedBlockchainUpdater takes a user id and returns a paxos feed blockchain updater.
 func (l *Layer) feedBlockchainUpdater(userID string) paxos.BlockchainUpdater {
 	return func(newBlock types.BlockchainBlock) {
-		c := content.ParseMetadata(newBlock.Value.CustomValue)
-		utils.PrintDebug("social", l.GetAddress(), " is updating its local feed with", c)
+		batch := content.ParseMetadataBatch(newBlock.Value.CustomValue)
+		utils.PrintDebug("social", l.GetAddress(), " is updating its local feed with", batch)
 		// Get the blockchain store associated with the user's feed.
 		blockchainStore := l.FeedStore.BlockchainStorage.GetStore(feed.IDFromUserID(userID))
 		// If the block contains a join metadata, then we need to also append to the registration blockchain.
@@ -33,20 +36,12 @@ func (l *Layer) feedBlockchainUpdater(userID string) paxos.BlockchainUpdater {
 // feedProposalChecker takes a user id and returns a paxos proposal checker.
 func (l *Layer) feedProposalChecker(userID string) paxos.ProposalChecker {
 	return func(msg types.PaxosProposeMessage) bool {
-		metadata := content.ParseMetadata(msg.Value.CustomValue)
-		// Reject if the feed user id does not match.
-		if !utils.GLOBAL_FEED && metadata.FeedUserID != userID {
-			utils.PrintDebug("social", l.GetAddress(), metadata.FeedUserID, " != ", userID)
-			return false
-		}
-		// Reject the dummy blocks!
-		if metadata.Type == content.DUMMY {
-			return false
-		}
-		checkerError := l.FeedStore.CheckMetadata(metadata)
+		batch := content.ParseMetadataBatch(msg.Value.CustomValue)
+		checkerError := l.checkFeedBatch(userID, batch, l.FeedStore.CheckMetadataBatch)
 		utils.PrintDebug("social", l.GetAddress(), " has checked a proposal. Error? =", checkerError)
 		return checkerError == nil
-		// TODO timestamp, signature etc.
+		// Signature ... DONE
+		// Timestamp window & monotonicity ... DONE
 		// Check remaining credits ... DONE
 		// Self-endorsement, re-endorsement ... DONE
 		// Re-reactions ... DONE
@@ -54,6 +49,58 @@ func (l *Layer) feedProposalChecker(userID string) paxos.ProposalChecker {
 	}
 }
 
+// checkFeedBatch checks the given batch of metadata proposed into the feed of the given user. The metadata must be
+// signed by the owner of the feed, and valid one after the other according to the given batch checker.
+func (l *Layer) checkFeedBatch(userID string, batch []content.Metadata,
+	checkBatch func([]content.Metadata) error) error {
+	for _, metadata := range batch {
+		// Reject if the feed user id does not match.
+		if !utils.GLOBAL_FEED && metadata.FeedUserID != userID {
+			return fmt.Errorf("metadata of %s in the feed of %s", metadata.FeedUserID, userID)
+		}
+		// Reject the dummy blocks!
+		if metadata.Type == content.DUMMY {
+			return fmt.Errorf("dummy metadata")
+		}
+		// Reject if the metadata was not signed by the owner of the feed.
+		if signatureError := l.checkMetadataSignature(metadata); signatureError != nil {
+			return signatureError
+		}
+	}
+	// The metadata must be valid one after the other.
+	return checkBatch(batch)
+}
+
+// checkMetadataSignature verifies the signature of the given metadata against the public key of the feed owner,
+// i.e., the public key whose hash is the FeedUserID. The public key must not have been revoked by the CA. The key is
+// only resolved locally, since the proposal checker must not block on the network: it is either our own key, or the
+// key recorded in the registration blockchain (or otherwise verified and added to the catalog). Without a
+// cryptography layer, no signature can be verified, so the metadata is rejected.
+func (l *Layer) checkMetadataSignature(metadata content.Metadata) error {
+	if l.cryptography == nil {
+		return fmt.Errorf("cannot verify the signature of %s without a cryptography layer", metadata.FeedUserID)
+	}
+	hashedPKBytes, err := hex.DecodeString(metadata.FeedUserID)
+	if err != nil || len(hashedPKBytes) != 32 {
+		return fmt.Errorf("malformed feed user id %s", metadata.FeedUserID)
+	}
+	var hashedPK [32]byte
+	copy(hashedPK[:], hashedPKBytes)
+	if l.cryptography.IsRevoked(hashedPK) {
+		return fmt.Errorf("the public key of %s has been revoked", metadata.FeedUserID)
+	}
+	var publicKey *rsa.PublicKey
+	if metadata.FeedUserID == l.UserID {
+		publicKey = l.cryptography.GetSignedPublicKey().PublicKey
+	} else if signedPK, ok := l.cryptography.GetUserFromCatalog(hashedPK); ok && signedPK != nil {
+		publicKey = signedPK.PublicKey
+	}
+	if publicKey == nil {
+		return fmt.Errorf("unknown public key of %s", metadata.FeedUserID)
+	}
+	return metadata.VerifySignature(publicKey)
+}
+
 // feedBlockGenerator takes a user id and returns a paxos feed block generator.
 func (l *Layer) feedBlockGenerator(userID string) paxos.BlockGenerator {
 	return func(msg types.PaxosAcceptMessage) types.BlockchainBlock {
@@ -70,10 +117,10 @@ func (l *Layer) feedBlockGenerator(userID string) paxos.BlockGenerator {
 			_ = lastBlock.Unmarshal(lastBlockBuf)
 			prevHash = lastBlock.Hash
 		}
-		// Extract the content metadata from the proposed value to hash it.
-		metadata := content.ParseMetadata(msg.Value.CustomValue)
+		// Extract the content metadata from the proposed value to hash them.
+		batch := content.ParseMetadataBatch(msg.Value.CustomValue)
 		// Create the block hash.
-		blockHash := content.HashMetadata(msg.Step, msg.Value.UniqID, metadata, prevHash)
+		blockHash := content.HashMetadataBatch(msg.Step, msg.Value.UniqID, batch, prevHash)
 		// Create the block.
 		return types.BlockchainBlock{
 			Index:    msg.Step,
@@ -87,8 +134,11 @@ func (l *Layer) feedBlockGenerator(userID string) paxos.BlockGenerator {
 // newFeedConsensusProtocol generates a new feed consensus protocol for the given user.
 func (l *Layer) newFeedConsensusProtocol(userID string) protocol.Protocol {
 	protocolID := feed.IDFromUserID(userID)
-	return paxos.New(protocolID, l.Config, l.gossip,
+	p := consensus.NewProtocol(protocolID, l.Config, l.gossip, l.cryptography,
 		l.feedBlockGenerator(userID),
 		l.feedBlockchainUpdater(userID),
 		l.feedProposalChecker(userID))
+	// Resume from the stored feed blockchain, if any.
+	p.SkipTo(utils.NextBlockIndex(l.FeedStore.BlockchainStorage.GetStore(protocolID)))
+	return p
 }
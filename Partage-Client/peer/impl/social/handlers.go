@@ -0,0 +1,54 @@
+package social
+
+import (
+	"encoding/hex"
+	"fmt"
+	"go.dedis.ch/cs438/peer/impl/consensus"
+	"go.dedis.ch/cs438/peer/impl/utils"
+	"go.dedis.ch/cs438/storage"
+	"go.dedis.ch/cs438/transport"
+	"go.dedis.ch/cs438/types"
+)
+
+func (l *Layer) RegisterHandlers() {
+	l.Config.MessageRegistry.RegisterMessageCallback(BlockRequestMessage{}, l.BlockRequestMessageHandler)
+	l.Config.MessageRegistry.RegisterMessageCallback(BlockReplyMessage{}, l.BlockReplyMessageHandler)
+}
+
+func (l *Layer) BlockRequestMessageHandler(msg types.Message, pkt transport.Packet) error {
+	utils.PrintDebug("social", l.GetAddress(), "is at BlockRequestMessageHandler")
+	blockRequestMsg, ok := msg.(*BlockRequestMessage)
+	if !ok {
+		return fmt.Errorf("could not parse the received block request message")
+	}
+	blockReplyMsg := BlockReplyMessage{
+		RequestID: blockRequestMsg.RequestID,
+	}
+	// Only serve the blockchains of the registered protocols. Otherwise, we would be creating new stores.
+	if l.consensus.IsRegistered(blockRequestMsg.ProtocolID) {
+		blockchainStore := consensus.BlockchainStoreFromProtocolID(blockRequestMsg.ProtocolID, l.Config)
+		blockHash := blockRequestMsg.BlockHash
+		if blockHash == "" {
+			blockHash = hex.EncodeToString(blockchainStore.Get(storage.LastBlockKey))
+		}
+		if blockHash != "" {
+			blockReplyMsg.Block = blockchainStore.Get(blockHash)
+		}
+	}
+	blockReplyMsgTransp, err := l.Config.MessageRegistry.MarshalMessage(blockReplyMsg)
+	if err != nil {
+		return fmt.Errorf("could not parse the responding block reply message")
+	}
+	// The requester may not be in our routing table yet, so send the reply back through the relay.
+	return l.route(pkt.Header.RelayedBy, pkt.Header.Source, blockReplyMsgTransp)
+}
+
+func (l *Layer) BlockReplyMessageHandler(msg types.Message, pkt transport.Packet) error {
+	utils.PrintDebug("social", l.GetAddress(), "is at BlockReplyMessageHandler")
+	blockReplyMsg, ok := msg.(*BlockReplyMessage)
+	if !ok {
+		return fmt.Errorf("could not parse the received block reply message")
+	}
+	l.notification.DispatchResponse(blockReplyMsg.RequestID, msg)
+	return nil
+}
@@ -7,39 +7,76 @@ import (
 	"go.dedis.ch/cs438/peer"
 	"go.dedis.ch/cs438/peer/impl/consensus"
 	"go.dedis.ch/cs438/peer/impl/content"
+	"go.dedis.ch/cs438/peer/impl/cryptography"
 	"go.dedis.ch/cs438/peer/impl/data"
 	"go.dedis.ch/cs438/peer/impl/gossip"
+	"go.dedis.ch/cs438/peer/impl/network"
 	"go.dedis.ch/cs438/peer/impl/social/feed"
 	"go.dedis.ch/cs438/peer/impl/utils"
 	"go.dedis.ch/cs438/types"
+	"sync"
+	"time"
 )
 
+// registerSyncAttempts is the number of times the blockchains are synced with the neighbors before registering, until
+// a sync succeeds.
+const registerSyncAttempts = 3
+
 type Layer struct {
-	consensus *consensus.Layer
-	gossip    *gossip.Layer
-	data      *data.Layer
+	consensus    *consensus.Layer
+	gossip       *gossip.Layer
+	data         *data.Layer
+	network      *network.Layer
+	cryptography *cryptography.Layer
+
+	notification *utils.AsyncNotificationHandler
+	Config       *peer.Configuration
+	FeedStore    *feed.Store
+	UserID       string
+
+	membershipLock sync.Mutex
+	// members are the users that have joined and not left, in the order of registration.
+	members []string
+	// minSize is the configured system size. The system size stays at minSize until as many users have joined, so
+	// that the peers that are still registering are counted, and follows the members afterwards.
+	minSize uint
+	// bootstrapped is true once minSize users have joined.
+	bootstrapped bool
+	// idSpace is the largest system size so far, over which the proposal ids are spread.
+	idSpace uint
+	// removalVotes maps each member that others have voted to remove since the last membership change to its voters.
+	removalVotes map[string]map[string]struct{}
 
-	Config    *peer.Configuration
-	FeedStore *feed.Store
-	UserID    string
+	batchLock sync.Mutex
+	// pendingBatch collects the metadata proposed during the current batching window. Nil if there is none.
+	pendingBatch *feedBatch
+	// decideLock ensures that the batches are decided one at a time.
+	decideLock sync.Mutex
 }
 
 func Construct(config *peer.Configuration,
 	data *data.Layer,
 	consensus *consensus.Layer,
 	gossip *gossip.Layer,
+	network *network.Layer,
+	crypto *cryptography.Layer,
 	hashedPublicKey [32]byte) *Layer {
 	// Create the feed store.
-	feedStore := feed.LoadStore(config.BlockchainStorage, config.BlockchainStorage.GetStore("metadata"))
+	feedStore := feed.LoadStore(config.BlockchainStorage, config.BlockchainStorage.GetStore("metadata"), config.Now)
 	// Convert the byte array into a hex string.
 	userID := hex.EncodeToString(hashedPublicKey[:])
 	l := &Layer{
-		consensus: consensus,
-		data:      data,
-		gossip:    gossip,
-		Config:    config,
-		FeedStore: feedStore,
-		UserID:    userID,
+		consensus:    consensus,
+		data:         data,
+		gossip:       gossip,
+		network:      network,
+		cryptography: crypto,
+		notification: utils.NewAsyncNotificationHandler(),
+		Config:       config,
+		FeedStore:    feedStore,
+		UserID:       userID,
+		minSize:      config.TotalPeers,
+		idSpace:      config.TotalPeers,
 	}
 	// Register the registration consensus protocol.
 	consensus.RegisterProtocol("registration", l.newRegistrationConsensusProtocol(config, gossip, l.FeedStore))
@@ -54,36 +91,116 @@ func (l *Layer) GetUserID() string {
 	return l.UserID
 }
 
+// requireCryptography returns an error if the peer does not run the TLS transport. The metadata of the social layer
+// cannot be signed and verified without it, so the registration chain and the feeds would reject them.
+func (l *Layer) requireCryptography() error {
+	if l.cryptography == nil {
+		return fmt.Errorf("the social layer requires the TLS transport to sign and verify the metadata")
+	}
+	return nil
+}
+
 func (l *Layer) Register() error {
 	utils.PrintDebug("social", l.GetAddress(), "is self-registering with id", l.UserID)
-	regMetadata := content.CreateJoinMetadata(l.UserID, utils.Time())
+	if err := l.requireCryptography(); err != nil {
+		return err
+	}
+	// Catch up with the community first, so that the registration is proposed on top of the current blockchain.
+	err := l.syncWithNeighbors()
+	for attempt := 1; err != nil && attempt < registerSyncAttempts; attempt++ {
+		utils.PrintDebug("social", l.GetAddress(), "could not sync before registering, retrying:", err)
+		time.Sleep(l.Config.BackoffDataRequest.Initial)
+		err = l.syncWithNeighbors()
+	}
+	if err != nil {
+		return fmt.Errorf("could not sync before registering: %w", err)
+	}
+	// The synced registration blockchain may already hold our registration, e.g., if we have lost our storage.
+	if l.FeedStore.IsKnown(l.UserID) {
+		utils.PrintDebug("social", l.GetAddress(), "is already registered")
+		return nil
+	}
+	regMetadata := content.CreateJoinMetadata(l.UserID, l.Config.Now(), l.cryptography.GetSignedPublicKey())
 	val := content.UnparseMetadata(regMetadata)
 	paxosVal := types.PaxosValue{
 		UniqID:      xid.New().String(),
 		CustomValue: val,
 	}
-	_, err := l.consensus.ProposeWithProtocol("registration", paxosVal)
+	_, err = l.consensus.ProposeWithProtocol("registration", paxosVal)
 	if err != nil {
 		return fmt.Errorf("error during registration: %v", err)
 	}
 	return nil
 }
 
-func (l *Layer) ProposeMetadata(metadata content.Metadata) (string, error) {
-	utils.PrintDebug("social", l.GetAddress(), "is proposing a new post")
-	err := l.FeedStore.CheckMetadata(metadata)
+// Leave proposes a leave for the user into the registration blockchain. Once the leave is appended, the system size
+// is decreased and the peer stops taking part in the consensus.
+func (l *Layer) Leave() error {
+	utils.PrintDebug("social", l.GetAddress(), "is leaving with id", l.UserID)
+	if err := l.requireCryptography(); err != nil {
+		return err
+	}
+	leaveMetadata := content.CreateLeaveMetadata(l.UserID, l.Config.Now())
+	// Sign the metadata so that nobody else can remove us.
+	err := leaveMetadata.Sign(l.cryptography.GetPrivateKey())
 	if err != nil {
-		return "", err
+		return err
 	}
-	val := content.UnparseMetadata(metadata)
 	paxosVal := types.PaxosValue{
 		UniqID:      xid.New().String(),
-		CustomValue: val,
+		CustomValue: content.UnparseMetadata(leaveMetadata),
 	}
-	protocolID := feed.IDFromUserID(l.UserID)
-	blockHash, err := l.consensus.ProposeWithProtocol(protocolID, paxosVal)
+	_, err = l.consensus.ProposeWithProtocol("registration", paxosVal)
 	if err != nil {
-		return "", fmt.Errorf("could not propose metadata at social layer: %v", err)
+		return fmt.Errorf("error during leave: %v", err)
+	}
+	return nil
+}
+
+// Remove votes to remove the given member from the system, e.g., because it has become unresponsive and keeps the
+// system size too large for the remaining members to reach consensus. Once a majority of the members have voted to
+// remove it, the system size is decreased.
+func (l *Layer) Remove(userID string) error {
+	utils.PrintDebug("social", l.GetAddress(), "is removing", userID)
+	if err := l.requireCryptography(); err != nil {
+		return err
+	}
+	if userID == l.UserID {
+		return fmt.Errorf("cannot remove ourselves, leave instead")
+	}
+	if !l.isMember(l.UserID) || !l.isMember(userID) {
+		return fmt.Errorf("%s cannot remove %s", l.UserID, userID)
+	}
+	if l.hasVotedRemoval(l.UserID, userID) {
+		return fmt.Errorf("%s has already voted to remove %s", l.UserID, userID)
+	}
+	removeMetadata := content.CreateRemoveMetadata(l.UserID, l.Config.Now(), userID)
+	// Sign the metadata so that each member votes only for itself.
+	err := removeMetadata.Sign(l.cryptography.GetPrivateKey())
+	if err != nil {
+		return err
+	}
+	paxosVal := types.PaxosValue{
+		UniqID:      xid.New().String(),
+		CustomValue: content.UnparseMetadata(removeMetadata),
+	}
+	_, err = l.consensus.ProposeWithProtocol("registration", paxosVal)
+	if err != nil {
+		return fmt.Errorf("error during remove: %v", err)
+	}
+	return nil
+}
+
+func (l *Layer) ProposeMetadata(metadata content.Metadata) (string, error) {
+	utils.PrintDebug("social", l.GetAddress(), "is proposing a new post")
+	if err := l.requireCryptography(); err != nil {
+		return "", err
+	}
+	// Sign the metadata so that the acceptors can verify that it originates from the owner of the feed.
+	err := metadata.Sign(l.cryptography.GetPrivateKey())
+	if err != nil {
+		return "", err
 	}
-	return blockHash, nil
+	// The metadata is checked once its batch is decided, against the state that includes the metadata before it.
+	return l.proposeInBatch(metadata)
 }
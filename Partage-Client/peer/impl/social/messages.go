@@ -0,0 +1,56 @@
+package social
+
+import (
+	"fmt"
+	"go.dedis.ch/cs438/types"
+)
+
+// BlockRequestMessage is used to request a block from the blockchain of a consensus protocol.
+type BlockRequestMessage struct {
+	// RequestID must be a unique identifier. Use xid.New().String() to generate
+	// it.
+	RequestID  string
+	ProtocolID string
+	// BlockHash is the hex-encoded hash of the requested block. If empty, the last block is requested.
+	BlockHash string
+}
+
+func (b BlockRequestMessage) NewEmpty() types.Message {
+	return &BlockRequestMessage{}
+}
+
+func (b BlockRequestMessage) Name() string {
+	return "blockrequest"
+}
+
+func (b BlockRequestMessage) String() string {
+	return fmt.Sprintf("{blockrequest %s - %s}", b.ProtocolID, b.BlockHash)
+}
+
+func (b BlockRequestMessage) HTML() string {
+	return b.String()
+}
+
+// BlockReplyMessage describes the response of a block request.
+type BlockReplyMessage struct {
+	// RequestID must be the same as the RequestID set in the BlockRequestMessage.
+	RequestID string
+	// Block is the marshaled block. It is nil if the peer does not have the requested block.
+	Block []byte
+}
+
+func (b BlockReplyMessage) NewEmpty() types.Message {
+	return &BlockReplyMessage{}
+}
+
+func (b BlockReplyMessage) Name() string {
+	return "blockreply"
+}
+
+func (b BlockReplyMessage) String() string {
+	return "{blockreply}"
+}
+
+func (b BlockReplyMessage) HTML() string {
+	return b.String()
+}
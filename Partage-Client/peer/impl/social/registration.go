@@ -2,7 +2,9 @@ package social
 
 import (
 	"encoding/hex"
+	"fmt"
 	"go.dedis.ch/cs438/peer"
+	"go.dedis.ch/cs438/peer/impl/consensus"
 	"go.dedis.ch/cs438/peer/impl/consensus/protocol"
 	"go.dedis.ch/cs438/peer/impl/consensus/protocol/paxos"
 	"go.dedis.ch/cs438/peer/impl/content"
@@ -10,12 +12,18 @@ import (
 	"go.dedis.ch/cs438/peer/impl/social/feed"
 	"go.dedis.ch/cs438/peer/impl/utils"
 	"go.dedis.ch/cs438/storage"
+	"go.dedis.ch/cs438/transport"
 	"go.dedis.ch/cs438/types"
 )
 
 func (l *Layer) registerUser(newUserID string) {
 	// Load the user feed and add it to the list of known users.
 	l.FeedStore.LoadUser(newUserID)
+	l.registerFeedProtocol(newUserID)
+}
+
+// registerFeedProtocol registers the feed consensus protocol of the given user.
+func (l *Layer) registerFeedProtocol(newUserID string) {
 	// Add the appropriate protocol for new blocks proposed by this user.
 	protocolID := feed.IDFromUserID(newUserID)
 	alreadyExists := l.consensus.IsRegistered(protocolID)
@@ -23,13 +31,92 @@ func (l *Layer) registerUser(newUserID string) {
 		utils.PrintDebug("social", l.GetAddress(), "is registering", newUserID)
 		l.consensus.RegisterProtocol(protocolID, l.newFeedConsensusProtocol(newUserID))
 	}
-	// Update the system size if we are not self-registering.
-	if newUserID != l.UserID {
-		utils.PrintDebug("social", l.GetAddress(), "is updating system size to", l.consensus.Config.TotalPeers+1)
-		if utils.DYNAMIC_SYSTEM_SIZE {
-			l.consensus.UpdateSystemSize(l.Config.TotalPeers + 1)
+}
+
+// applyMembership applies the membership change recorded in the given registration block, and moves the consensus
+// layer into the next membership epoch, i.e., the length of the registration blockchain. The system size is the
+// number of members once the system is bootstrapped. Every peer keeps its configured paxos id: the proposal ids are
+// spread over the largest system size so far, so that they stay unique when the system shrinks.
+func (l *Layer) applyMembership(block types.BlockchainBlock) {
+	c := content.ParseMetadata(block.Value.CustomValue)
+	l.membershipLock.Lock()
+	defer l.membershipLock.Unlock()
+	switch c.Type {
+	case content.JOIN:
+		l.members = append(l.members, c.FeedUserID)
+		l.removalVotes = nil
+	case content.LEAVE:
+		l.removeMember(c.FeedUserID)
+	case content.REMOVE:
+		removedUserID, _ := content.ParseRemovedUserID(c)
+		if !l.voteRemoval(c.FeedUserID, removedUserID) {
+			// The member stays until a majority has voted to remove it.
+			break
+		}
+		l.removeMember(removedUserID)
+	}
+	if uint(len(l.members)) >= l.minSize {
+		l.bootstrapped = true
+	}
+	newSize := l.minSize
+	if utils.DYNAMIC_SYSTEM_SIZE && (l.bootstrapped || uint(len(l.members)) > newSize) {
+		newSize = uint(len(l.members))
+	}
+	// The system consists of at least ourselves.
+	if newSize < 1 {
+		newSize = 1
+	}
+	if newSize > l.idSpace {
+		l.idSpace = newSize
+	}
+	utils.PrintDebug("social", l.GetAddress(), "is updating system size to", newSize)
+	l.consensus.UpdateMembership(block.Index+1, newSize, l.idSpace)
+}
+
+// voteRemoval records the vote of the given member to remove another member, and returns whether a majority of the
+// members have voted for its removal. A single member cannot evict the others on its own.
+func (l *Layer) voteRemoval(voterID string, removedUserID string) bool {
+	if l.removalVotes == nil {
+		l.removalVotes = make(map[string]map[string]struct{})
+	}
+	voters, ok := l.removalVotes[removedUserID]
+	if !ok {
+		voters = make(map[string]struct{})
+		l.removalVotes[removedUserID] = voters
+	}
+	voters[voterID] = struct{}{}
+	return 2*len(voters) > len(l.members)
+}
+
+// hasVotedRemoval returns whether the given member has already voted to remove another member since the last
+// membership change.
+func (l *Layer) hasVotedRemoval(voterID string, removedUserID string) bool {
+	l.membershipLock.Lock()
+	defer l.membershipLock.Unlock()
+	_, ok := l.removalVotes[removedUserID][voterID]
+	return ok
+}
+
+// removeMember removes the given user from the members, and marks them as left. The peer stops taking part in the
+// consensus if it is the removed user. The pending votes are discarded, since they were cast for the former members.
+func (l *Layer) removeMember(removedUserID string) {
+	l.removalVotes = nil
+	for i, userID := range l.members {
+		if userID == removedUserID {
+			l.members = append(l.members[:i], l.members[i+1:]...)
+			break
 		}
 	}
+	l.FeedStore.MarkLeft(removedUserID)
+	if removedUserID == l.UserID {
+		utils.PrintDebug("social", l.GetAddress(), "has left the system")
+		l.consensus.Leave()
+	}
+}
+
+// isMember returns whether the given user has joined and not left.
+func (l *Layer) isMember(userID string) bool {
+	return l.FeedStore.IsKnown(userID) && !l.FeedStore.HasLeft(userID)
 }
 
 // LoadRegisteredUsers loads the registered users from the registration blockchain.
@@ -37,13 +124,25 @@ func (l *Layer) registerUser(newUserID string) {
 func (l *Layer) LoadRegisteredUsers(blockchainStorage storage.MultipurposeStorage) int {
 	// Get the blocks.
 	blocks := utils.LoadBlockchain(blockchainStorage.GetStore("registration"))
-	// Now we have a list of registration blocks. Register them one by one.
+	var userIDs []string
 	for _, block := range blocks {
 		c := content.ParseMetadata(block.Value.CustomValue)
-		newUserID := c.FeedUserID
-		l.registerUser(newUserID)
+		if c.Type == content.JOIN {
+			userIDs = append(userIDs, c.FeedUserID)
+			l.catalogPublicKey(c)
+		}
+	}
+	// Replay the stored feeds. All the users must be known before a feed is replayed, since feeds refer to each other.
+	l.FeedStore.LoadUsers(userIDs)
+	// Now we have a list of registered users. Register their protocols one by one.
+	for _, userID := range userIDs {
+		l.registerFeedProtocol(userID)
+	}
+	// Replay the membership changes in order.
+	for _, block := range blocks {
+		l.applyMembership(block)
 	}
-	return len(blocks)
+	return len(userIDs)
 }
 
 // registrationBlockchainUpdater takes a user id and returns a paxos feed blockchain updater.
@@ -59,10 +158,13 @@ func (l *Layer) registrationBlockchainUpdater(blockchainStorage storage.Multipur
 		newBlockBytes, _ := newBlock.Marshal()
 		// Append the block into the blockchain.
 		blockchainStore.Set(newBlockHash, newBlockBytes)
-		// Register the user.
+		// Register the user, or mark them as left.
 		c := content.ParseMetadata(newBlock.Value.CustomValue)
-		newUserID := c.FeedUserID
-		l.registerUser(newUserID)
+		if c.Type == content.JOIN {
+			l.catalogPublicKey(c)
+			l.registerUser(c.FeedUserID)
+		}
+		l.applyMembership(newBlock)
 	}
 }
 
@@ -70,13 +172,89 @@ func (l *Layer) registrationBlockchainUpdater(blockchainStorage storage.Multipur
 func (l *Layer) registrationProposalChecker() paxos.ProposalChecker {
 	return func(msg types.PaxosProposeMessage) bool {
 		metadata := content.ParseMetadata(msg.Value.CustomValue)
-		// Only allow registration blocks.
-		if metadata.Type != content.JOIN {
+		err := l.checkRegistration(metadata, l.FeedStore.CheckTimestamp)
+		if err != nil {
+			utils.PrintDebug("social", l.GetAddress(), " has rejected a registration:", err)
 			return false
 		}
-		// The user must be unregistered!
-		return !l.FeedStore.IsKnown(metadata.FeedUserID)
+		return true
+	}
+}
+
+// checkRegistration checks the given metadata proposed into the registration blockchain, whose timestamp is checked
+// with the given timestamp checker.
+func (l *Layer) checkRegistration(metadata content.Metadata, checkTimestamp func(content.Metadata) error) error {
+	// Only allow registration blocks.
+	if metadata.Type != content.JOIN && metadata.Type != content.LEAVE && metadata.Type != content.REMOVE {
+		return fmt.Errorf("%s is not a registration", metadata.Type)
+	}
+	// The registration must have happened recently.
+	if err := checkTimestamp(metadata); err != nil {
+		return err
+	}
+	// The user must be unregistered to join, with a public key signed by the CA.
+	if metadata.Type == content.JOIN {
+		if _, err := l.checkJoinPublicKey(metadata); err != nil {
+			return err
+		}
+		if l.FeedStore.IsKnown(metadata.FeedUserID) {
+			return fmt.Errorf("%s is already registered", metadata.FeedUserID)
+		}
+		return nil
+	}
+	// Only the members can leave, and only once, or remove others. The metadata must be signed by the member itself.
+	if err := l.checkMetadataSignature(metadata); err != nil {
+		return err
+	}
+	if !l.isMember(metadata.FeedUserID) {
+		return fmt.Errorf("%s is not a member", metadata.FeedUserID)
+	}
+	// A member can vote to remove another member, e.g., one that has become unresponsive. The removal takes effect
+	// once a majority of the members have voted for it, each with its own signed REMOVE.
+	if metadata.Type == content.REMOVE {
+		removedUserID, err := content.ParseRemovedUserID(metadata)
+		if err != nil {
+			return err
+		}
+		if removedUserID == metadata.FeedUserID || !l.isMember(removedUserID) {
+			return fmt.Errorf("%s cannot remove %s", metadata.FeedUserID, removedUserID)
+		}
+		if l.hasVotedRemoval(metadata.FeedUserID, removedUserID) {
+			return fmt.Errorf("%s has already voted to remove %s", metadata.FeedUserID, removedUserID)
+		}
+	}
+	return nil
+}
+
+// checkJoinPublicKey returns the public key recorded in the given join metadata, after checking that it was signed by
+// the CA, and that its hash is the id of the joining user. Without a cryptography layer, the CA signature cannot be
+// verified, so the join is rejected.
+func (l *Layer) checkJoinPublicKey(metadata content.Metadata) (*transport.SignedPublicKey, error) {
+	if l.cryptography == nil {
+		return nil, fmt.Errorf("cannot verify the public key of %s without a cryptography layer", metadata.FeedUserID)
+	}
+	signedPK, err := content.ParseJoinPublicKey(metadata)
+	if err != nil {
+		return nil, err
+	}
+	hashedPK := utils.HashPublicKey(signedPK.PublicKey)
+	if hex.EncodeToString(hashedPK[:]) != metadata.FeedUserID {
+		return nil, fmt.Errorf("the public key of %s does not match its id", metadata.FeedUserID)
+	}
+	if !utils.VerifyPublicKeySignature(signedPK.PublicKey, signedPK.Signature, l.cryptography.GetCAPublicKey()) {
+		return nil, fmt.Errorf("the public key of %s is not signed by the CA", metadata.FeedUserID)
+	}
+	return signedPK, nil
+}
+
+// catalogPublicKey adds the public key recorded in the given join metadata to the catalog, so that the signatures of
+// the user are verified without searching for the key.
+func (l *Layer) catalogPublicKey(metadata content.Metadata) {
+	signedPK, err := l.checkJoinPublicKey(metadata)
+	if err != nil {
+		return
 	}
+	l.cryptography.AddUserToCatalog(utils.HashPublicKey(signedPK.PublicKey), signedPK)
 }
 
 // registrationBlockGenerator takes a user id and returns a paxos feed block generator.
@@ -111,8 +289,11 @@ func (l *Layer) registrationBlockGenerator(blockchainStorage storage.Multipurpos
 
 func (l *Layer) newRegistrationConsensusProtocol(config *peer.Configuration, gossip *gossip.Layer, feedStore *feed.Store) protocol.Protocol {
 	protocolID := "registration"
-	return paxos.New(protocolID, config, gossip,
+	p := consensus.NewProtocol(protocolID, config, gossip, l.cryptography,
 		l.registrationBlockGenerator(config.BlockchainStorage),
 		l.registrationBlockchainUpdater(config.BlockchainStorage),
 		l.registrationProposalChecker())
+	// Resume from the stored registration blockchain, if any.
+	p.SkipTo(utils.NextBlockIndex(config.BlockchainStorage.GetStore(protocolID)))
+	return p
 }
@@ -0,0 +1,189 @@
+package social
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"github.com/rs/xid"
+	"go.dedis.ch/cs438/peer/impl/consensus"
+	"go.dedis.ch/cs438/peer/impl/content"
+	"go.dedis.ch/cs438/peer/impl/social/feed"
+	"go.dedis.ch/cs438/peer/impl/utils"
+	"go.dedis.ch/cs438/storage"
+	"go.dedis.ch/cs438/transport"
+	"go.dedis.ch/cs438/types"
+	"time"
+)
+
+// SyncBlockchains acquires the blocks that are missing from the local registration and feed blockchains from the
+// given peer. The registration blockchain is synced first, so that the feed protocols of all the registered users
+// are known before their feeds are synced.
+func (l *Layer) SyncBlockchains(peerAddr string) error {
+	utils.PrintDebug("social", l.GetAddress(), "is syncing its blockchains with", peerAddr)
+	err := l.syncBlockchain(peerAddr, "registration", func(block types.BlockchainBlock) error {
+		return l.checkRegistration(content.ParseMetadata(block.Value.CustomValue), l.FeedStore.CheckSyncedTimestamp)
+	})
+	if err != nil {
+		return fmt.Errorf("could not sync the registration blockchain: %v", err)
+	}
+	// Multiple users may share the same feed protocol, e.g., with the global feed.
+	protocolIDs := make(map[string]string)
+	for userID := range l.FeedStore.GetKnownUsers() {
+		protocolIDs[feed.IDFromUserID(userID)] = userID
+	}
+	for protocolID, userID := range protocolIDs {
+		userID := userID
+		err = l.syncBlockchain(peerAddr, protocolID, func(block types.BlockchainBlock) error {
+			batch := content.ParseMetadataBatch(block.Value.CustomValue)
+			return l.checkFeedBatch(userID, batch, l.FeedStore.CheckSyncedMetadataBatch)
+		})
+		if err != nil {
+			return fmt.Errorf("could not sync the feed blockchain %s: %v", protocolID, err)
+		}
+	}
+	return nil
+}
+
+// syncWithNeighbors syncs the blockchains with the first neighbor that replies. Does nothing if there are no
+// neighbors.
+func (l *Layer) syncWithNeighbors() error {
+	var err error
+	for neighbor := range l.network.GetNeighbors() {
+		err = l.SyncBlockchains(neighbor)
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// syncBlockchain walks back the remote blockchain associated with the given protocol id, starting from its last
+// block, until it reaches a block that is in the local blockchain. Then, the missing blocks are appended in order.
+// Each block must pass the given checker, which runs the same checks as the proposal checker of the protocol, before
+// it is appended. The rest of the remote blockchain is rejected from the first block that does not.
+func (l *Layer) syncBlockchain(peerAddr string, protocolID string, check func(types.BlockchainBlock) error) error {
+	blockchainStore := consensus.BlockchainStoreFromProtocolID(protocolID, l.Config)
+	firstPrevHash := make([]byte, 32)
+	var missingBlocks []types.BlockchainBlock
+	// An empty block hash requests the last block.
+	blockHash := ""
+	for {
+		block, err := l.requestBlock(peerAddr, protocolID, blockHash)
+		if err != nil {
+			return err
+		}
+		if block == nil && blockHash != "" {
+			return fmt.Errorf("peer does not have the block %s", blockHash)
+		}
+		// The remote blockchain is empty.
+		if block == nil {
+			break
+		}
+		if blockHash != "" && hex.EncodeToString(block.Hash) != blockHash {
+			return fmt.Errorf("received block %x instead of %s", block.Hash, blockHash)
+		}
+		err = verifyBlock(protocolID, *block)
+		if err != nil {
+			return err
+		}
+		// Stop once we reach our own blockchain.
+		if blockchainStore.Get(hex.EncodeToString(block.Hash)) != nil {
+			break
+		}
+		missingBlocks = append(missingBlocks, *block)
+		// Stop at the first block.
+		if bytes.Equal(block.PrevHash, firstPrevHash) {
+			break
+		}
+		blockHash = hex.EncodeToString(block.PrevHash)
+	}
+	utils.PrintDebug("social", l.GetAddress(), "will be appending", len(missingBlocks), "synced blocks to", protocolID)
+	// Append the missing blocks from the oldest to the newest.
+	for i := len(missingBlocks) - 1; i >= 0; i-- {
+		block := missingBlocks[i]
+		// The block must extend our blockchain.
+		lastBlockHash := blockchainStore.Get(storage.LastBlockKey)
+		if lastBlockHash == nil {
+			lastBlockHash = firstPrevHash
+		}
+		if !bytes.Equal(block.PrevHash, lastBlockHash) {
+			// We may have appended the block through the consensus protocol in the meantime.
+			if blockchainStore.Get(hex.EncodeToString(block.Hash)) != nil {
+				continue
+			}
+			return fmt.Errorf("synced block %d does not extend the local blockchain", block.Index)
+		}
+		// The block is checked against the blocks appended before it.
+		err := check(block)
+		if err != nil {
+			return fmt.Errorf("synced block %d is invalid: %v", block.Index, err)
+		}
+		if !l.consensus.ApplyBlock(protocolID, block) {
+			return fmt.Errorf("could not append the synced block %d", block.Index)
+		}
+	}
+	return nil
+}
+
+// requestBlock requests the block with the given hash from the given peer. If the block hash is empty, the last
+// block is requested. Returns nil if the peer does not have the block.
+func (l *Layer) requestBlock(peerAddr string, protocolID string, blockHash string) (*types.BlockchainBlock, error) {
+	msg := BlockRequestMessage{
+		RequestID:  xid.New().String(),
+		ProtocolID: protocolID,
+		BlockHash:  blockHash,
+	}
+	transpMsg, err := l.Config.MessageRegistry.MarshalMessage(msg)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal block request message: %w", err)
+	}
+	replyTimeout := l.Config.BackoffDataRequest.Initial
+	for i := uint(0); i < l.Config.BackoffDataRequest.Retry; i++ {
+		err = l.unicast(peerAddr, transpMsg)
+		if err != nil {
+			return nil, fmt.Errorf("could not unicast the block request: %w", err)
+		}
+		// Block until we receive the response.
+		reply := l.notification.ResponseCollector(msg.RequestID, replyTimeout)
+		if reply != nil {
+			blockBytes := reply.(*BlockReplyMessage).Block
+			if blockBytes == nil {
+				return nil, nil
+			}
+			var block types.BlockchainBlock
+			err = block.Unmarshal(blockBytes)
+			if err != nil {
+				return nil, fmt.Errorf("could not unmarshal the received block: %w", err)
+			}
+			return &block, nil
+		}
+		// Increase the reply timeout by the factor.
+		replyTimeout = replyTimeout * time.Duration(l.Config.BackoffDataRequest.Factor)
+	}
+	return nil, fmt.Errorf("could not get a reply")
+}
+
+// verifyBlock checks that the hash of the given block of the given protocol matches its content.
+func verifyBlock(protocolID string, block types.BlockchainBlock) error {
+	if !bytes.Equal(consensus.BlockHasherFromProtocolID(protocolID)(block), block.Hash) {
+		return fmt.Errorf("block %d has an invalid hash", block.Index)
+	}
+	return nil
+}
+
+// unicast sends the given message to the given destination. The packet is signed when running TLS.
+func (l *Layer) unicast(dest string, msg transport.Message) error {
+	if l.cryptography != nil {
+		return l.cryptography.Unicast(dest, msg)
+	}
+	return l.network.Unicast(dest, msg)
+}
+
+// route sends the given message to the given destination through the given relay. The packet is signed when running
+// TLS.
+func (l *Layer) route(relay string, dest string, msg transport.Message) error {
+	if l.cryptography != nil {
+		return l.cryptography.Route(l.GetAddress(), relay, dest, msg)
+	}
+	return l.network.Route(l.GetAddress(), relay, dest, msg)
+}
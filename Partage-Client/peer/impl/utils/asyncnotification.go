@@ -34,6 +34,7 @@ func (c *Cache) Save(id string, reply types.Message) {
 }
 
 // Collect returns the cached responses pertaining to the given id. If amount < 0, all the responses are returned.
+// The responses that are not returned are kept for the next collection.
 func (c *Cache) Collect(id string, amount int) []types.Message {
 	c.Lock()
 	defer c.Unlock()
@@ -49,7 +50,11 @@ func (c *Cache) Collect(id string, amount int) []types.Message {
 		max = len(l)
 	}
 	ret := l[:max]
-	delete(c.cache, id)
+	if max < len(l) {
+		c.cache[id] = l[max:]
+	} else {
+		delete(c.cache, id)
+	}
 	return ret
 }
 
@@ -90,9 +95,12 @@ out:
 			break out
 		}
 	}
-	// Cleanup
+	// Cleanup. The responses dispatched after the last one was collected are kept for the next collection.
 	a.Lock()
 	delete(a.waitingChannels, id)
+	for len(respChan) > 0 {
+		a.cache.Save(id, <-respChan)
+	}
 	a.Unlock()
 	return respList
 }
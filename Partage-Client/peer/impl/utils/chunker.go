@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"math"
+)
+
+// Chunker cuts a stream into chunks.
+type Chunker interface {
+	// Next returns the next chunk of the stream, or io.EOF once the stream is exhausted.
+	Next() ([]byte, error)
+}
+
+// fixedChunker cuts a chunk every size bytes.
+type fixedChunker struct {
+	reader io.Reader
+	size   uint
+}
+
+// NewFixedChunker returns a chunker that cuts the stream every size bytes, however the reader splits its data.
+func NewFixedChunker(reader io.Reader, size uint) Chunker {
+	return &fixedChunker{reader: reader, size: size}
+}
+
+func (c *fixedChunker) Next() ([]byte, error) {
+	chunk := make([]byte, c.size)
+	n, err := io.ReadFull(c.reader, chunk)
+	if n > 0 && (err == nil || err == io.ErrUnexpectedEOF) {
+		return chunk[:n], nil
+	}
+	if err == nil || err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return nil, err
+}
+
+// gearTable maps each byte to a random value of the gear rolling hash. It is generated from a fixed seed, so that all
+// the peers cut the same data at the same boundaries.
+var gearTable = func() [256]uint64 {
+	var table [256]uint64
+	state := uint64(0x5061727461676521)
+	for i := range table {
+		// splitmix64
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// contentDefinedChunker cuts a chunk where the gear rolling hash of the last bytes is below a threshold, so that the
+// boundaries move along with the content when bytes are inserted or removed.
+type contentDefinedChunker struct {
+	reader    *bufio.Reader
+	min, max  uint
+	threshold uint64
+}
+
+// NewContentDefinedChunker returns a chunker that cuts the stream on boundaries defined by its content. The chunks are
+// at least min bytes long, at most max bytes long, and about average bytes long.
+func NewContentDefinedChunker(reader io.Reader, min, average, max uint) (Chunker, error) {
+	if min == 0 || average <= min || max < average {
+		return nil, errors.New("content-defined chunking needs 0 < min < average <= max")
+	}
+	// Past the minimum, a boundary is cut with a probability of 1/(average-min) per byte, when the hash, whose top bits
+	// depend on the last 64 bytes, is below the threshold.
+	return &contentDefinedChunker{
+		reader:    bufio.NewReader(reader),
+		min:       min,
+		max:       max,
+		threshold: math.MaxUint64 / uint64(average-min),
+	}, nil
+}
+
+func (c *contentDefinedChunker) Next() ([]byte, error) {
+	var chunk []byte
+	var hash uint64
+	for {
+		b, err := c.reader.ReadByte()
+		if err == io.EOF && len(chunk) > 0 {
+			return chunk, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		chunk = append(chunk, b)
+		hash = (hash << 1) + gearTable[b]
+		size := uint(len(chunk))
+		if size >= c.max || (size >= c.min && hash < c.threshold) {
+			return chunk, nil
+		}
+	}
+}
@@ -20,40 +20,33 @@ import (
 	"math/big"
 	mathRand "math/rand"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 )
 
 const TESTING = true //TODO: change!!
 
-const dir = "Partage/Partage-Client/partage-storage/"
-const cryptoDir = dir + "crypto/"
-const certificatePath = cryptoDir + "cert.pem"
-const keyPath = cryptoDir + "key.pem"
-const signaturePath = cryptoDir + "publickey.signature"
-const BlockedUsersPath = cryptoDir + "blocked-users.db"
-const emailPath = dir + "my.email"
-const CACertificatePath = cryptoDir + "CA/cert.pem"
+// TestMaildirEnv is the environment variable that gives the maildir where the CA drops the mails with the verification
+// codes when it runs with its maildir verification backend, e.g., for testing.
+const TestMaildirEnv = "PARTAGE_TEST_MAILDIR"
 
-func LoadCertificate(fromPersistentMem bool) (*tls.Certificate, error) {
+func (p Profile) LoadCertificate(fromPersistentMem bool) (*tls.Certificate, error) {
 	if fromPersistentMem {
-		wd, _ := os.Getwd()
-		rt := wd[:strings.Index(wd, "Partage")]
-
-		cert, err := tls.LoadX509KeyPair(rt+certificatePath, rt+keyPath)
+		cert, err := tls.LoadX509KeyPair(p.CertificatePath(), p.KeyPath())
 		if err != nil {
 			//generate a new key-pair
 			privateKey, _ := generateKey()
 
-			keyPem, err := storePrivateKey(privateKey, rt+keyPath)
+			keyPem, err := storePrivateKey(privateKey, p.KeyPath())
 			if err != nil {
 				return nil, err
 			}
 
 			//generate a new certificate from the newly-generated key-pair
-			certificate, _ := GenerateCertificate(privateKey, nil) //SELF-SIGNED! TODO: ..to later be implemented with the CA
+			certificate, _ := GenerateCertificate(privateKey, nil, p.loadEmail()) //SELF-SIGNED! TODO: ..to later be implemented with the CA
 
-			certPem, err := storeCertificate(certificate, rt+certificatePath)
+			certPem, err := storeCertificate(certificate, p.CertificatePath())
 			if err != nil {
 				return nil, err
 			}
@@ -75,7 +68,7 @@ func LoadCertificate(fromPersistentMem bool) (*tls.Certificate, error) {
 			return nil, err
 		}
 
-		certificate, _ := GenerateCertificate(privateKey, nil)
+		certificate, _ := GenerateCertificate(privateKey, nil, p.loadEmail())
 		certPem, err := CertificateToPem(certificate)
 		if err != nil {
 			return nil, err
@@ -210,10 +203,8 @@ func PemToCertificate(cert []byte) (*x509.Certificate, error) {
 	return x509.ParseCertificate(block.Bytes)
 }
 
-func StoreCertificate(cert *x509.Certificate) ([]byte, error) {
-	wd, _ := os.Getwd()
-	rt := wd[:strings.Index(wd, "Partage")]
-	return storeCertificate(cert, rt+certificatePath)
+func (p Profile) StoreCertificate(cert *x509.Certificate) ([]byte, error) {
+	return storeCertificate(cert, p.CertificatePath())
 }
 
 func storeCertificate(cert *x509.Certificate, path string) ([]byte, error) {
@@ -259,28 +250,64 @@ func generateKey() (*rsa.PrivateKey, error) {
 	return rsa.GenerateKey(rand.Reader, 1024)
 }
 
-func loadEmailFromFile() string {
-	wd, _ := os.Getwd()
-	rt := wd[:strings.Index(wd, "Partage")]
-	data, err := os.ReadFile(rt + emailPath)
+func (p Profile) loadEmail() string {
+	if TESTING {
+		mathRand.Seed(time.Now().Unix())
+		return "abdefg" + strconv.Itoa(mathRand.Intn(99999999)) + "@gmail.com" //testing purposes
+	}
+	data, err := os.ReadFile(p.EmailPath())
 	if err != nil {
 		return ""
 	}
 	return string(data)
 }
 
-//used to generate a signed certificate (if signingAuthority==nil, certificate is self-signed)
-//returns new certificate as ASN.1 DER data (can be parsed to x509.Certificate object with x509.ParseCertificate(der []byte) function)
-func GenerateCertificate(privateKey *rsa.PrivateKey, signingAuthority *x509.Certificate) (*x509.Certificate, error) {
-	// Load e-mail from file!
-	var email string
-	if TESTING {
-		mathRand.Seed(time.Now().Unix())
-		email = "abdefg" + strconv.Itoa(mathRand.Intn(99999999)) + "@gmail.com" //testing purposes
-	} else {
-		email = loadEmailFromFile()
+// TestMaildir returns the directory where the CA drops the mails with the verification codes, given in
+// $PARTAGE_TEST_MAILDIR.
+func TestMaildir() (string, error) {
+	dir := os.Getenv(TestMaildirEnv)
+	if dir == "" {
+		return "", fmt.Errorf("cannot locate the maildir of the CA, set $%s", TestMaildirEnv)
 	}
+	return dir, nil
+}
 
+// ReadTestVerificationCode returns the latest verification code that the CA has dropped into its maildir for the given
+// e-mail address. The CA must run with its maildir verification backend (e.g., for testing).
+func ReadTestVerificationCode(email string) (string, error) {
+	maildir, err := TestMaildir()
+	if err != nil {
+		return "", err
+	}
+	entries, err := os.ReadDir(maildir)
+	if err != nil {
+		return "", err
+	}
+	// The mails are named after their timestamp and their recipient, so the latest one comes last.
+	latest := ""
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), "."+email) && entry.Name() > latest {
+			latest = entry.Name()
+		}
+	}
+	if latest == "" {
+		return "", fmt.Errorf("no verification code for %s", email)
+	}
+	data, err := os.ReadFile(filepath.Join(maildir, latest))
+	if err != nil {
+		return "", err
+	}
+	const marker = "Verification Code: "
+	i := strings.LastIndex(string(data), marker)
+	if i < 0 {
+		return "", fmt.Errorf("malformed verification mail %s", latest)
+	}
+	return strings.TrimSpace(string(data[i+len(marker):])), nil
+}
+
+//used to generate a signed certificate (if signingAuthority==nil, certificate is self-signed)
+//returns new certificate as ASN.1 DER data (can be parsed to x509.Certificate object with x509.ParseCertificate(der []byte) function)
+func GenerateCertificate(privateKey *rsa.PrivateKey, signingAuthority *x509.Certificate, email string) (*x509.Certificate, error) {
 	//each certificate needs a unique serial number
 	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
 	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
@@ -348,30 +375,40 @@ func loadPublicKeySignature(path string) []byte {
 	return data
 }
 
-func LoadPublicKeySignature() []byte {
-	wd, _ := os.Getwd()
-	rt := wd[:strings.Index(wd, "Partage")]
-	return loadPublicKeySignature(rt + signaturePath)
+func (p Profile) LoadPublicKeySignature() []byte {
+	return loadPublicKeySignature(p.SignaturePath())
 }
 
-func LoadCACertificate() *x509.Certificate {
-	wd, _ := os.Getwd()
-	rt := wd[:strings.Index(wd, "Partage")]
-	cert, _ := loadCertificate(rt + CACertificatePath)
+func (p Profile) LoadCACertificate() *x509.Certificate {
+	cert, _ := loadCertificate(p.CACertificatePath())
 	return cert
 }
 
-func StoreCACertificate(cert *x509.Certificate) ([]byte, error) {
-	wd, _ := os.Getwd()
-	rt := wd[:strings.Index(wd, "Partage")]
-	return storeCertificate(cert, rt+CACertificatePath)
+func (p Profile) StoreCACertificate(cert *x509.Certificate) ([]byte, error) {
+	return storeCertificate(cert, p.CACertificatePath())
+}
+
+func (p Profile) StorePublicKeySignature(signature []byte) error {
+	return os.WriteFile(p.SignaturePath(), signature, 0644)
 }
 
-func StorePublicKeySignature(signature []byte) error {
-	wd, _ := os.Getwd()
-	rt := wd[:strings.Index(wd, "Partage")]
-	path := rt + signaturePath
-	return os.WriteFile(path, signature, 0644)
+// LoadRevocationList returns the encoded revocation list of the CA, nil if there is none.
+func (p Profile) LoadRevocationList() []byte {
+	data, err := os.ReadFile(p.RevocationListPath())
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// StoreRevocationList replaces the stored revocation list of the CA with the given encoded one.
+func (p Profile) StoreRevocationList(data []byte) error {
+	path := p.RevocationListPath()
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
 }
 
 func VerifyPublicKeySignature(publicKey *rsa.PublicKey, signature []byte, CAPublicKey *rsa.PublicKey) bool {
@@ -405,12 +442,10 @@ func Hash(bytes []byte) [32]byte {
 	return sha256.Sum256(bytes)
 }
 
-func LoadBlockedUsers() (map[[32]byte]struct{}, error) {
-	wd, _ := os.Getwd()
-	rt := wd[:strings.Index(wd, "Partage")]
+func (p Profile) LoadBlockedUsers() (map[[32]byte]struct{}, error) {
 	windowSize := 32 //bytes
 	users := make(map[[32]byte]struct{})
-	data, err := os.ReadFile(rt + BlockedUsersPath)
+	data, err := os.ReadFile(p.BlockedUsersPath())
 	if err == nil {
 		var hash [32]byte
 		for i := 0; i <= len(data)-windowSize; i += windowSize {
@@ -22,36 +22,65 @@ func HashChunk(chunk []byte) ([]byte, string) {
 // Chunkify converts the given file reader into chunks. Returns a mapping from the chunk hash to the chunk, and the
 // metahash.
 func Chunkify(chunkSize uint, metafileSep string, reader io.Reader) (map[string][]byte, string, error) {
-	buffer := make([]byte, chunkSize)
 	chunks := make(map[string][]byte)
+	metafile, metaFileKey, err := ChunkifyStream(NewFixedChunker(reader, chunkSize), metafileSep,
+		func(hash string, chunk []byte) error {
+			chunks[hash] = chunk
+			return nil
+		})
+	if err != nil {
+		return nil, "", err
+	}
+	chunks[metaFileKey] = metafile
+	return chunks, metaFileKey, nil
+}
+
+// ChunkifyStream takes the chunks cut by the given chunker one after the other, and hands each chunk over to the given
+// function along with its hash, without keeping it. Returns the metafile and the metahash.
+func ChunkifyStream(chunker Chunker, metafileSep string, onChunk func(hash string, chunk []byte) error) ([]byte,
+	string, error) {
 	var metafileKeyBytes []byte
 	var hashList []string
 	for {
-		n, err := reader.Read(buffer)
-		if err != io.EOF && err != nil {
-			return nil, "", err
-		}
-		// Process the buffer if we were able to read some bytes.
-		if n > 0 {
-			// Create the hash of the chunk.
-			chunkHashBytes, chunkHashHex := HashChunk(buffer[:n])
-			// Copy the bytes from the buffer into the chunk map.
-			chunks[chunkHashHex] = make([]byte, 0, len(buffer))
-			for i := 0; i < n; i++ {
-				chunks[chunkHashHex] = append(chunks[chunkHashHex], buffer[i])
-			}
-			// Add the chunk hash to the ordered list of hashes.
-			metafileKeyBytes = append(metafileKeyBytes, chunkHashBytes...)
-			hashList = append(hashList, chunkHashHex)
-		}
-		// Exit if EOF
+		chunk, err := chunker.Next()
 		if err == io.EOF {
 			break
 		}
+		if err != nil {
+			return nil, "", err
+		}
+		// Create the hash of the chunk.
+		chunkHashBytes, chunkHashHex := HashChunk(chunk)
+		err = onChunk(chunkHashHex, chunk)
+		if err != nil {
+			return nil, "", err
+		}
+		// Add the chunk hash to the ordered list of hashes.
+		metafileKeyBytes = append(metafileKeyBytes, chunkHashBytes...)
+		hashList = append(hashList, chunkHashHex)
 	}
 	_, metaFileKey := HashChunk(metafileKeyBytes)
-	chunks[metaFileKey] = []byte(strings.Join(hashList, metafileSep))
-	return chunks, metaFileKey, nil
+	return []byte(strings.Join(hashList, metafileSep)), metaFileKey, nil
+}
+
+// VerifyData returns whether the given data is the chunk, or the metafile, with the given hash.
+func VerifyData(hash string, data []byte, metafileSep string) bool {
+	_, dataHash := HashChunk(data)
+	if dataHash == hash {
+		return true
+	}
+	// The metahash is the hash of the concatenated chunk hashes. The chunk hashes must be in their canonical form, i.e.,
+	// in lower-case hex, since they are the keys of the chunks in the blob stores.
+	var metafileKeyBytes []byte
+	for _, chunkHash := range strings.Split(string(data), metafileSep) {
+		chunkHashBytes, err := hex.DecodeString(chunkHash)
+		if err != nil || hex.EncodeToString(chunkHashBytes) != chunkHash {
+			return false
+		}
+		metafileKeyBytes = append(metafileKeyBytes, chunkHashBytes...)
+	}
+	_, metahash := HashChunk(metafileKeyBytes)
+	return metahash == hash
 }
 
 // GetChunkHashses returns the chunk hashes associated with the metafile of the given metahash.
@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// defaultProfileDir is the profile used when none is given, relative to the configuration directory of the user.
+const defaultProfileDir = "partage"
+
+// Profile is the directory that holds the identity of a user: its key and certificates, the CA's signature of its
+// public key, its e-mail address, its blocklist, and the storage of its blockchains. Each identity that runs on the
+// same machine needs its own profile.
+type Profile struct {
+	Dir string
+}
+
+// NewProfile returns the profile in the given directory.
+func NewProfile(dir string) Profile {
+	return Profile{Dir: filepath.Clean(dir)}
+}
+
+// DefaultProfile returns the profile in the configuration directory of the user, e.g., ~/.config/partage on Linux.
+func DefaultProfile() (Profile, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return Profile{}, fmt.Errorf("cannot locate the default profile, give one explicitly: %w", err)
+	}
+	return NewProfile(filepath.Join(dir, defaultProfileDir)), nil
+}
+
+// Init creates the directories of the profile.
+func (p Profile) Init() error {
+	return os.MkdirAll(filepath.Dir(p.CACertificatePath()), 0755)
+}
+
+func (p Profile) CertificatePath() string {
+	return filepath.Join(p.Dir, "crypto", "cert.pem")
+}
+
+func (p Profile) KeyPath() string {
+	return filepath.Join(p.Dir, "crypto", "key.pem")
+}
+
+func (p Profile) SignaturePath() string {
+	return filepath.Join(p.Dir, "crypto", "publickey.signature")
+}
+
+func (p Profile) BlockedUsersPath() string {
+	return filepath.Join(p.Dir, "crypto", "blocked-users.db")
+}
+
+func (p Profile) EmailPath() string {
+	return filepath.Join(p.Dir, "my.email")
+}
+
+func (p Profile) CACertificatePath() string {
+	return filepath.Join(p.Dir, "crypto", "CA", "cert.pem")
+}
+
+func (p Profile) RevocationListPath() string {
+	return filepath.Join(p.Dir, "crypto", "CA", "revocation-list.json")
+}
+
+// DataDir is the directory where the blockchains and blobs of the user are persisted.
+func (p Profile) DataDir() string {
+	return filepath.Join(p.Dir, "data")
+}
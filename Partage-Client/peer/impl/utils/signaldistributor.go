@@ -18,7 +18,8 @@ func NewSignalDistributor(receiver chan bool) *SignalDistributor {
 func (s *SignalDistributor) NewListener(id string) chan bool {
 	s.Lock()
 	defer s.Unlock()
-	c := make(chan bool)
+	// Buffer the signal so that a listener whose routine is not running does not hold the other listeners back.
+	c := make(chan bool, 1)
 	s.signalListeners[id] = c
 	return c
 }
@@ -29,6 +29,7 @@ var DEBUG = map[string]bool{
 	"acceptor":      false,
 	"proposer":      false,
 	"tlc":           false,
+	"raft":          false,
 	"searchPK":      false,
 	"social":        false,
 	"statemachine":  false,
@@ -41,6 +42,8 @@ func PrintDebug(tag string, objs ...interface{}) {
 	}
 }
 
+// Time returns the current unix time in seconds of the system clock. The peers use the clock of their configuration
+// instead (see peer.Configuration.Now).
 func Time() int64 {
 	return time.Now().UTC().Unix()
 }
@@ -99,9 +102,7 @@ func HashNameBlock(index int, uniqID string, fileName string, metahash string, p
 
 func OpenFileToAppend(path string) (*os.File, error) {
 	//don't forget to close fp! fp.Close()
-	wd, _ := os.Getwd()
-	rt := wd[:strings.Index(wd, "Partage")]
-	file, err := os.OpenFile(rt+path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		return nil, err
 	}
@@ -110,9 +111,7 @@ func OpenFileToAppend(path string) (*os.File, error) {
 
 func OpenFileToWrite(path string) (*os.File, error) {
 	//don't forget to close fp! fp.Close()
-	wd, _ := os.Getwd()
-	rt := wd[:strings.Index(wd, "Partage")]
-	file, err := os.OpenFile(rt+path, os.O_CREATE|os.O_WRONLY, 0644)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		return nil, err
 	}
@@ -126,6 +125,21 @@ func AppendToFile(data []byte, fp *os.File) error {
 	return nil
 }
 
+// NextBlockIndex returns the index of the block that would be appended next into the given blockchain, i.e., the
+// index of the last block + 1. Returns 0 if the blockchain is empty.
+func NextBlockIndex(blockchainStore storage.Store) uint {
+	lastBlockHashHex := hex.EncodeToString(blockchainStore.Get(storage.LastBlockKey))
+	if lastBlockHashHex == "" {
+		return 0
+	}
+	var lastBlock types.BlockchainBlock
+	err := lastBlock.Unmarshal(blockchainStore.Get(lastBlockHashHex))
+	if err != nil {
+		return 0
+	}
+	return lastBlock.Index + 1
+}
+
 // LoadBlockchain loads the given blockchain from storage and returns it as an ordered list of blocks.
 // If the store is empty, returns an empty list (nil).
 func LoadBlockchain(blockchainStore storage.Store) []types.BlockchainBlock {
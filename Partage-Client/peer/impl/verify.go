@@ -0,0 +1,53 @@
+package impl
+
+import (
+	"go.dedis.ch/cs438/peer"
+	"go.dedis.ch/cs438/peer/impl/consensus"
+	"go.dedis.ch/cs438/peer/impl/content"
+	"go.dedis.ch/cs438/peer/impl/social/feed"
+	"go.dedis.ch/cs438/peer/impl/utils"
+)
+
+// BlockchainReport is the result of the verification of a single blockchain.
+type BlockchainReport struct {
+	ProtocolID string
+	// Length is the number of blocks in the blockchain. Zero if the blockchain is broken.
+	Length int
+	// Err describes the first broken link. Nil if the blockchain is valid.
+	Err error
+}
+
+// VerifyBlockchains verifies the default, registration and feed blockchains kept in the storages of the given
+// configuration. The feed blockchains are found through the registration blockchain, so they are only verified if the
+// registration blockchain is valid.
+func VerifyBlockchains(config peer.Configuration) []BlockchainReport {
+	registrationReport := verifyBlockchain("registration", config)
+	reports := []BlockchainReport{
+		verifyBlockchain("default", config),
+		registrationReport,
+	}
+	if registrationReport.Err != nil {
+		return reports
+	}
+	// Multiple users may share the same feed blockchain, e.g., with the global feed.
+	verifiedFeeds := make(map[string]struct{})
+	for _, block := range utils.LoadBlockchain(config.BlockchainStorage.GetStore("registration")) {
+		protocolID := feed.IDFromUserID(content.ParseMetadata(block.Value.CustomValue).FeedUserID)
+		if _, ok := verifiedFeeds[protocolID]; ok {
+			continue
+		}
+		verifiedFeeds[protocolID] = struct{}{}
+		reports = append(reports, verifyBlockchain(protocolID, config))
+	}
+	return reports
+}
+
+func verifyBlockchain(protocolID string, config peer.Configuration) BlockchainReport {
+	blockchainStore := consensus.BlockchainStoreFromProtocolID(protocolID, &config)
+	length, err := consensus.VerifyBlockchain(blockchainStore, consensus.BlockHasherFromProtocolID(protocolID))
+	return BlockchainReport{
+		ProtocolID: protocolID,
+		Length:     length,
+		Err:        err,
+	}
+}
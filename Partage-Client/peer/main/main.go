@@ -2,13 +2,41 @@ package main
 
 import (
 	"flag"
+	"os"
+
 	"go.dedis.ch/cs438/peer/impl"
+	"go.dedis.ch/cs438/peer/impl/utils"
+	"go.dedis.ch/cs438/transport/tcptls"
 )
 
 func main() {
+	// The verify subcommand checks the stored blockchains without starting the client.
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		os.Exit(verify(os.Args[2:]))
+	}
 	port := flag.Uint("port", 8000, "a free port")
 	peerID := flag.Uint("id", 1, "peer id must be >= 1")
 	introducerAddr := flag.String("i", "", "address of the introducer")
+	profileDir := flag.String("profile", "", "directory of the identity (keys, certificates, blocklist), and of the storages unless -data is given (default: partage in the user configuration directory, in-memory storages)")
+	dataDir := flag.String("data", "", "directory to persist the blockchains and blobs (in-memory if empty)")
+	caAddr := flag.String("ca", tcptls.DefaultCAAddress, "address of the certificate authority")
+	templateDir := flag.String("templates", "", "directory of the templates of the GUI (default: templates next to the executable)")
+	codecName := flag.String("codec", "binary", "codec of the messages, either binary or json (for debugging)")
 	flag.Parse()
-	impl.StartClient(*port, *peerID, *introducerAddr)
+
+	conf := impl.NewDefaultClientConfig()
+	conf.Port = *port
+	conf.PeerID = *peerID
+	conf.IntroducerAddr = *introducerAddr
+	conf.DataDir = *dataDir
+	if *profileDir != "" {
+		conf.Profile = utils.NewProfile(*profileDir)
+		if conf.DataDir == "" {
+			conf.DataDir = conf.Profile.DataDir()
+		}
+	}
+	conf.CAAddress = *caAddr
+	conf.TemplateDir = *templateDir
+	conf.Codec = *codecName
+	impl.StartClient(conf)
 }
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"go.dedis.ch/cs438/peer/impl"
+	"go.dedis.ch/cs438/peer/impl/utils"
+)
+
+// verify verifies the blockchains stored in the data directory (or profile) given in the arguments and prints a report
+// for each of them. Returns the exit code, which is non-zero if a blockchain is broken.
+func verify(args []string) int {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	dataDir := flags.String("data", "", "directory where the blockchains were persisted")
+	profileDir := flags.String("profile", "", "directory of the identity whose blockchains were persisted")
+	_ = flags.Parse(args)
+	if *dataDir == "" && *profileDir != "" {
+		*dataDir = utils.NewProfile(*profileDir).DataDir()
+	}
+	if *dataDir == "" {
+		fmt.Println("usage: main verify -data <directory> | -profile <directory>")
+		return 2
+	}
+	// Do not create an empty storage by mistake.
+	if _, err := os.Stat(*dataDir); err != nil {
+		fmt.Printf("could not open the data directory: %v\n", err)
+		return 2
+	}
+	config, err := impl.NewPersistentConfig(*dataDir)
+	if err != nil {
+		fmt.Println(err)
+		return 2
+	}
+	exitCode := 0
+	for _, report := range impl.VerifyBlockchains(config) {
+		if report.Err != nil {
+			fmt.Printf("%s: %v\n", report.ProtocolID, report.Err)
+			exitCode = 1
+			continue
+		}
+		fmt.Printf("%s: OK (%d blocks)\n", report.ProtocolID, report.Length)
+	}
+	return exitCode
+}
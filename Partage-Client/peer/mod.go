@@ -56,10 +56,28 @@ type Configuration struct {
 	// Default: 8192
 	ChunkSize uint
 
+	// ContentDefinedChunking cuts the chunks on boundaries defined by the
+	// content of the data instead of every ChunkSize bytes, so that similar
+	// data share most of their chunks. It is disabled if its average size is
+	// 0.
+	// Default: {0 0 0}
+	ContentDefinedChunking ContentDefinedChunking
+
 	// Backoff parameters used for DataRequests.
 	// Default: {2s 2 5}
 	BackoffDataRequest Backoff
 
+	// DownloadWorkers is the number of chunks of a file that are downloaded
+	// concurrently. 0 or 1 means that the chunks are downloaded one after the
+	// other.
+	// Default: 1
+	DownloadWorkers uint
+
+	// DownloadRequestsPerPeer is the maximum number of data requests in flight
+	// to a single peer. 0 means 1.
+	// Default: 2
+	DownloadRequestsPerPeer uint
+
 	Storage           storage.Storage
 	BlockchainStorage storage.MultipurposeStorage
 
@@ -81,6 +99,90 @@ type Configuration struct {
 	// retries to send a prepare when it doesn't get enough promises or accepts.
 	// Default: 5s.
 	PaxosProposerRetry time.Duration
+
+	// ConsensusProtocol returns the consensus protocol to use for the given
+	// protocol id, e.g., "default", "registration" or "feed-<id>". If nil,
+	// Paxos is used for every protocol.
+	// Default: nil
+	ConsensusProtocol func(protocolID string) ConsensusType
+
+	// RaftHeartbeat is the interval at which a Raft leader announces itself to
+	// its followers. A follower that has not heard from its leader for a few
+	// intervals starts an election. 0 disables the heartbeats, so that the
+	// elections are only started by the proposers.
+	// Default: 0
+	RaftHeartbeat time.Duration
+
+	// FeedBatchWindow is the amount of time during which the metadata
+	// proposed into the feed of the user are collected, so that they are
+	// decided within a single block. 0 means that the metadata are proposed
+	// right away.
+	// Default: 0
+	FeedBatchWindow time.Duration
+
+	// CertificateRenewal is how long before the expiry of its certificate the
+	// peer renews it with the CA. 0 means the certificate is never renewed.
+	// Default: 0
+	CertificateRenewal time.Duration
+
+	// DHTBucketSize is the number of contacts in each bucket of the routing
+	// table of the DHT, and the number of peers that store each provider
+	// record. 0 disables the DHT, so that the lookups only flood the network.
+	// Default: 0
+	DHTBucketSize uint
+
+	// DHTRefreshInterval is the interval at which the peer announces again the
+	// data it provides in the DHT, so that the records reach the peers that
+	// joined in the meantime. 0 means that the data is announced only once.
+	// Default: 0
+	DHTRefreshInterval time.Duration
+
+	// BlobStoreQuota is the maximum number of bytes in the data blob store.
+	// Once it is exceeded, the least recently used blobs are evicted, except
+	// the pinned ones: the uploads of the user, and the posts of the user and
+	// its followees. 0 means no quota.
+	// Default: 0
+	BlobStoreQuota uint
+
+	// BlobGCInterval is the interval at which the data blobs that are not
+	// referenced by any visible content of the feeds, nor pinned, are removed.
+	// 0 means that they are only removed by CollectGarbage.
+	// Default: 0
+	BlobGCInterval time.Duration
+
+	// Clock returns the current unix time in seconds. It is used to
+	// timestamp the metadata and to check the timestamps of the others. nil
+	// means the system clock.
+	// Default: nil
+	Clock func() int64
+}
+
+// Now returns the current unix time in seconds according to the clock of the
+// configuration.
+func (c *Configuration) Now() int64 {
+	if c.Clock != nil {
+		return c.Clock()
+	}
+	return time.Now().UTC().Unix()
+}
+
+// ConsensusType identifies an implementation of a consensus protocol.
+type ConsensusType string
+
+const (
+	// Paxos is the multi-step Paxos with TLC.
+	Paxos ConsensusType = "paxos"
+	// Raft is the leader-based Raft.
+	Raft ConsensusType = "raft"
+)
+
+// ContentDefinedChunking describes the sizes of the chunks cut with a rolling
+// hash: a chunk is at least Min bytes long, at most Max bytes long, and
+// Average bytes long on average, with Min < Average <= Max.
+type ContentDefinedChunking struct {
+	Min     uint
+	Average uint
+	Max     uint
 }
 
 // Backoff describes parameters for a backoff algorithm. The initial time must
@@ -4,14 +4,26 @@ import (
 	"crypto/rsa"
 	"go.dedis.ch/cs438/peer/impl/content"
 	"go.dedis.ch/cs438/peer/impl/social/feed"
+	"io"
 )
 
 type SocialPeer interface {
 	RegisterUser() error
+	// LeaveNetwork removes the user from the system. Once the leave is agreed on, the system size is decreased and the
+	// peer stops taking part in the consensus.
+	LeaveNetwork() error
+	// RemoveMember votes to remove the given member from the system, e.g., because it has become unresponsive. Once a
+	// majority of the members have voted to remove it, the system size is decreased.
+	RemoveMember(userID string) error
+	// SyncBlockchains acquires the missing registration and feed blocks from the given peer.
+	SyncBlockchains(peerAddr string) error
 	// ShareDownloadableContent shares the given content into the network and returns the generated metadata and its block hash.
 	ShareDownloadableContent(post content.PrivateContent, p content.Type) (content.Metadata, string, error)
 	// DownloadContent fetches the post with the given content id from the network.
 	DownloadContent(contentID string) ([]byte, error)
+	// DownloadContentTo fetches the post with the given content id from the network, and writes it to the given writer
+	// chunk after chunk. The progress, if not nil, is reported after each chunk.
+	DownloadContentTo(contentID string, w io.Writer, progress Progress) error
 	// QueryFeedContents queries the feed store and returns all the matching contents from the stored blockchains.
 	QueryFeedContents(filter content.Filter) []feed.Content
 	// DiscoverContentIDs returns the matched content ids in all the network.
@@ -26,9 +38,26 @@ type SocialPeer interface {
 	GetUserID() string
 	GetKnownUsers() map[string]struct{}
 	GetFeedContents(userID string) []feed.Content
+	// GetContentHistory returns the versions of the content associated with the given content id, from the original
+	// content to the latest edit.
+	GetContentHistory(contentID string) []feed.Content
 	GetReactions(contentID string) []feed.ReactionInfo
 	GetUserState(userID string) feed.UserState
 	BlockUser(publicKeyHash [32]byte)
 	UnblockUser(publicKeyHash [32]byte)
 	IsBlocked(userID string) bool
+	// UpdateRevocationList fetches the latest revocation list from the CA and, if it is more recent than ours, spreads
+	// it in the network.
+	UpdateRevocationList() error
+	// RevokeCertificate asks the CA to revoke the certificate of the user, e.g., because its private key has leaked,
+	// and spreads the new revocation list in the network.
+	RevokeCertificate() error
+	// RenewCertificate gets a new certificate for the public key of the user from the CA, so that the user keeps its
+	// identity.
+	RenewCertificate() error
+	// IsRevoked returns whether the public key of the given user has been revoked by the CA.
+	IsRevoked(userID string) bool
+	// CollectGarbage removes the data blobs that are not pinned, nor referenced by any visible content of the feeds,
+	// e.g., the posts that were undone. Returns the number of removed blobs.
+	CollectGarbage() uint
 }
@@ -1,24 +1,43 @@
 package unit
 
 import (
+	"bytes"
 	"crypto/rsa"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"go.dedis.ch/cs438/peer/impl"
+	"go.dedis.ch/cs438/peer/impl/consensus"
+	"go.dedis.ch/cs438/peer/impl/consensus/protocol"
+	"go.dedis.ch/cs438/peer/impl/consensus/protocol/raft"
 	"go.dedis.ch/cs438/peer/impl/content"
+	"go.dedis.ch/cs438/peer/impl/dht"
 	"go.dedis.ch/cs438/peer/impl/social/feed"
 	"go.dedis.ch/cs438/peer/impl/utils"
 	"io"
 	"math/rand"
+	"os"
+	"path/filepath"
 	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	"github.com/stretchr/testify/require"
 	"go.dedis.ch/cs438/internal/graph"
 	z "go.dedis.ch/cs438/internal/testing"
 	"go.dedis.ch/cs438/peer"
+	"go.dedis.ch/cs438/registry/standard"
+	"go.dedis.ch/cs438/storage"
+	"go.dedis.ch/cs438/storage/file"
+	"go.dedis.ch/cs438/storage/inmemory"
 	"go.dedis.ch/cs438/transport"
+	"go.dedis.ch/cs438/transport/channel"
+	"go.dedis.ch/cs438/transport/codec"
+	"go.dedis.ch/cs438/transport/tcptls"
 	"go.dedis.ch/cs438/types"
 )
 
@@ -57,6 +76,483 @@ func Test_Partage_Registration(t *testing.T) {
 	}
 }
 
+func Test_Partage_Raft(t *testing.T) {
+	// Use Raft for every protocol but the default one.
+	raftProtocol := func(protocolID string) peer.ConsensusType {
+		if protocolID == "default" {
+			return peer.Paxos
+		}
+		return peer.Raft
+	}
+	node1 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0", z.WithTotalPeers(3), z.WithPaxosID(1),
+		z.WithAntiEntropy(time.Second), z.WithConsensusProtocol(raftProtocol))
+	defer node1.Stop()
+	node2 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0", z.WithTotalPeers(3), z.WithPaxosID(2),
+		z.WithAntiEntropy(time.Second), z.WithConsensusProtocol(raftProtocol))
+	defer node2.Stop()
+	node3 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0", z.WithTotalPeers(3), z.WithPaxosID(3),
+		z.WithAntiEntropy(time.Second), z.WithConsensusProtocol(raftProtocol))
+	defer node3.Stop()
+
+	node1.AddPeer(node2.GetAddr(), node3.GetAddr())
+	node2.AddPeer(node1.GetAddr(), node3.GetAddr())
+	node3.AddPeer(node2.GetAddr(), node1.GetAddr())
+
+	// Register the nodes concurrently, which triggers competing elections.
+	nodes := []z.TestNode{node1, node2, node3}
+	wg := sync.WaitGroup{}
+	for _, n := range nodes {
+		wg.Add(1)
+		go func(node z.TestNode) {
+			defer wg.Done()
+			node.RegisterUser()
+		}(n)
+	}
+	wg.Wait()
+
+	// Wait for a while.
+	time.Sleep(2 * time.Second)
+
+	for _, owner := range nodes {
+		require.Len(t, owner.GetKnownUsers(), 3)
+	}
+
+	// Every node posts twice into its own feed.
+	for i, n := range nodes {
+		wg.Add(1)
+		go func(nodeIndex int, node z.TestNode) {
+			defer wg.Done()
+			for j := 1; j <= 2; j++ {
+				_, err := node.UpdateFeed(content.Metadata{
+					FeedUserID: node.GetUserID(),
+					Type:       content.TEXT,
+					ContentID:  fmt.Sprintf("%d-%d", nodeIndex, j),
+					Timestamp:  utils.Time(),
+				})
+				require.NoError(t, err)
+			}
+		}(i, n)
+	}
+	wg.Wait()
+
+	// Wait for a while.
+	time.Sleep(2 * time.Second)
+
+	// The feeds should be identical at every node.
+	for nodeIndex, n := range nodes {
+		for _, owner := range nodes {
+			posts := owner.GetFeedContents(n.GetUserID())
+			require.Len(t, posts, 2)
+			for i := 1; i <= 2; i++ {
+				require.Equal(t, fmt.Sprintf("%d-%d", nodeIndex, i), posts[i-1].ContentID)
+			}
+		}
+	}
+}
+
+func Test_Partage_Raft_Feeds(t *testing.T) {
+	// Run the feed tests again with Raft for every protocol but the default one.
+	require.NoError(t, os.Setenv("PEER_CONSENSUS", string(peer.Raft)))
+	defer os.Unsetenv("PEER_CONSENSUS")
+	feedTests := []struct {
+		name string
+		test func(*testing.T)
+	}{
+		{"Two_Posts_All_Nodes", Test_Partage_Two_Posts_All_Nodes},
+		{"Change_Username", Test_Partage_Change_Username},
+		{"Follow_Unfollow", Test_Partage_Follow_Unfollow},
+		{"Endorsement", Test_Partage_Endorsement},
+		{"Reaction", Test_Partage_Reaction},
+		{"Undo", Test_Partage_Undo},
+		{"Edit", Test_Partage_Edit},
+		{"Invalid_Block", Test_Partage_Invalid_Block},
+		{"Metadata_Signature", Test_Partage_Metadata_Signature},
+		{"Private_Post", Test_Partage_Private_Post},
+	}
+	for _, feedTest := range feedTests {
+		t.Run(feedTest.name, feedTest.test)
+	}
+}
+
+func Test_Partage_Raft_Consensus(t *testing.T) {
+	// Run the membership and blockchain tests again with Raft for every protocol but the default one.
+	require.NoError(t, os.Setenv("PEER_CONSENSUS", string(peer.Raft)))
+	defer os.Unsetenv("PEER_CONSENSUS")
+	consensusTests := []struct {
+		name string
+		test func(*testing.T)
+	}{
+		{"Registration", Test_Partage_Registration},
+		{"Leave", Test_Partage_Leave},
+		{"Remove_Member", Test_Partage_Remove_Member},
+		{"Late_Registration", Test_Partage_Late_Registration},
+		{"Feed_Batching", Test_Partage_Feed_Batching},
+		{"Blockchain_Sync", Test_Partage_Blockchain_Sync},
+		{"Blockchain_Sync_Forged", Test_Partage_Blockchain_Sync_Forged},
+		{"Verify_Blockchain", Test_Partage_Verify_Blockchain},
+	}
+	for _, consensusTest := range consensusTests {
+		t.Run(consensusTest.name, consensusTest.test)
+	}
+}
+
+func Test_Partage_Raft_Tag(t *testing.T) {
+	// Run the tag tests that don't inspect the Paxos messages again with Raft for the default protocol too.
+	require.NoError(t, os.Setenv("PEER_CONSENSUS", string(peer.Raft)))
+	defer os.Unsetenv("PEER_CONSENSUS")
+	require.NoError(t, os.Setenv("PEER_CONSENSUS_DEFAULT", string(peer.Raft)))
+	defer os.Unsetenv("PEER_CONSENSUS_DEFAULT")
+	tagTests := []struct {
+		name string
+		test func(*testing.T)
+	}{
+		{"Alone", Test_HW3_Tag_Alone},
+		{"Name_Taken", Test_HW3_Tag_Paxos_Name_Taken},
+		{"Catchup", Test_HW3_Tag_Paxos_Catchup},
+		{"Consensus_Stress_Test", Test_HW3_Tag_Paxos_Consensus_Stress_Test},
+	}
+	for _, tagTest := range tagTests {
+		t.Run(tagTest.name, tagTest.test)
+	}
+}
+
+func Test_Partage_Raft_Leader_Failure(t *testing.T) {
+	raftProtocol := func(protocolID string) peer.ConsensusType {
+		if protocolID == "default" {
+			return peer.Paxos
+		}
+		return peer.Raft
+	}
+	bst2 := inmemory.NewPersistentMultipurposeStorage()
+	bst3 := inmemory.NewPersistentMultipurposeStorage()
+	node1 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0", z.WithTotalPeers(3), z.WithPaxosID(1),
+		z.WithAntiEntropy(time.Second), z.WithAckTimeout(time.Second), z.WithConsensusProtocol(raftProtocol),
+		z.WithRaftHeartbeat(200*time.Millisecond))
+	node2 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0", z.WithTotalPeers(3), z.WithPaxosID(2),
+		z.WithAntiEntropy(time.Second), z.WithAckTimeout(time.Second), z.WithConsensusProtocol(raftProtocol),
+		z.WithRaftHeartbeat(200*time.Millisecond), z.WithBlockchainStorage(bst2))
+	defer node2.Stop()
+	node3 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0", z.WithTotalPeers(3), z.WithPaxosID(3),
+		z.WithAntiEntropy(time.Second), z.WithAckTimeout(time.Second), z.WithConsensusProtocol(raftProtocol),
+		z.WithRaftHeartbeat(200*time.Millisecond), z.WithBlockchainStorage(bst3))
+	defer node3.Stop()
+
+	node1.AddPeer(node2.GetAddr(), node3.GetAddr())
+	node2.AddPeer(node1.GetAddr(), node3.GetAddr())
+	node3.AddPeer(node2.GetAddr(), node1.GetAddr())
+
+	// The first node is elected as the leader of the registration protocol, and appends the other registrations.
+	nodes := []z.TestNode{node1, node2, node3}
+	for _, n := range nodes {
+		n.RegisterUser()
+	}
+	time.Sleep(time.Second)
+	for _, owner := range nodes {
+		require.Len(t, owner.GetKnownUsers(), 3)
+	}
+
+	// Stop the leader. The others should elect a new leader once they stop hearing from it.
+	require.NoError(t, node1.Stop())
+	time.Sleep(3 * time.Second)
+
+	// The remaining majority should still append into the registration blockchain, without waiting for the stopped
+	// leader to time out.
+	start := time.Now()
+	require.NoError(t, node3.LeaveNetwork())
+	require.Less(t, int64(time.Since(start)), int64(5*time.Second))
+	time.Sleep(time.Second)
+
+	lastBlockHash := bst2.GetStore("registration").Get(storage.LastBlockKey)
+	require.Equal(t, lastBlockHash, bst3.GetStore("registration").Get(storage.LastBlockKey))
+	lastBlockBuf := bst2.GetStore("registration").Get(hex.EncodeToString(lastBlockHash))
+	var lastBlock types.BlockchainBlock
+	require.NoError(t, lastBlock.Unmarshal(lastBlockBuf))
+	require.Equal(t, uint(3), lastBlock.Index)
+	require.Equal(t, content.LEAVE, content.ParseMetadata(lastBlock.Value.CustomValue).Type)
+}
+
+func Test_Partage_Raft_Forged_Votes(t *testing.T) {
+	raftProtocol := func(protocolID string) peer.ConsensusType {
+		if protocolID == "default" {
+			return peer.Paxos
+		}
+		return peer.Raft
+	}
+	bst := inmemory.NewPersistentMultipurposeStorage()
+	node1 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0", z.WithTotalPeers(3), z.WithPaxosID(1),
+		z.WithAntiEntropy(time.Second), z.WithConsensusProtocol(raftProtocol), z.WithBlockchainStorage(bst))
+	defer node1.Stop()
+	node2 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0", z.WithTotalPeers(3), z.WithPaxosID(2),
+		z.WithAntiEntropy(time.Second), z.WithConsensusProtocol(raftProtocol))
+	defer node2.Stop()
+	node3 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0", z.WithTotalPeers(3), z.WithPaxosID(3),
+		z.WithAntiEntropy(time.Second), z.WithConsensusProtocol(raftProtocol))
+	defer node3.Stop()
+
+	node1.AddPeer(node2.GetAddr(), node3.GetAddr())
+	node2.AddPeer(node1.GetAddr(), node3.GetAddr())
+	node3.AddPeer(node2.GetAddr(), node1.GetAddr())
+
+	nodes := []z.TestNode{node1, node2, node3}
+	for _, n := range nodes {
+		require.NoError(t, n.RegisterUser())
+	}
+	time.Sleep(time.Second)
+	for _, n := range nodes {
+		require.Len(t, n.GetKnownUsers(), 3)
+	}
+
+	// The first node makes itself the leader of its feed protocol with votes that don't prove a majority, so the
+	// others should ignore it.
+	post := content.CreateTextMetadata(node1.GetUserID(), utils.Time(), "abc")
+	require.NoError(t, post.Sign(node1.GetPrivateKey()))
+	zeroHash := make([]byte, 32)
+	valid := signedVotes(t, bst, node1.GetUserID(), node1, nodes...)
+
+	// The votes are not signed.
+	var unsigned []types.RaftVoteMessage
+	for _, vote := range valid {
+		vote.PublicKey = nil
+		vote.Signature = nil
+		unsigned = append(unsigned, vote)
+	}
+	// The vote of the first node is replayed on behalf of the others.
+	replayed := make([]types.RaftVoteMessage, len(valid))
+	for i, n := range nodes {
+		replayed[i] = valid[0]
+		replayed[i].Source = n.GetAddr()
+	}
+	// The votes were granted in another protocol.
+	otherProtocol := signedVotes(t, bst, node2.GetUserID(), node1, nodes...)
+	for _, votes := range [][]types.RaftVoteMessage{unsigned, replayed, otherProtocol} {
+		injectFeedProposal(t, node1, node1.GetUserID(), 0, zeroHash, post, votes)
+		for _, n := range []z.TestNode{node2, node3} {
+			require.Len(t, n.GetFeedContents(node1.GetUserID()), 0)
+		}
+	}
+
+	// The votes signed by a majority elect the first node.
+	injectFeedProposal(t, node1, node1.GetUserID(), 0, zeroHash, post, valid)
+	for _, n := range nodes {
+		require.Len(t, n.GetFeedContents(node1.GetUserID()), 1)
+	}
+}
+
+func Test_Partage_Leave(t *testing.T) {
+	// The system size follows the registered users once the three of them have joined.
+	node1 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0", z.WithTotalPeers(3), z.WithPaxosID(1),
+		z.WithAntiEntropy(time.Second))
+	defer node1.Stop()
+	node2 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0", z.WithTotalPeers(3), z.WithPaxosID(2),
+		z.WithAntiEntropy(time.Second))
+	defer node2.Stop()
+	node3 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0", z.WithTotalPeers(3), z.WithPaxosID(3),
+		z.WithAntiEntropy(time.Second))
+	defer node3.Stop()
+
+	node1.AddPeer(node2.GetAddr(), node3.GetAddr())
+	node2.AddPeer(node1.GetAddr(), node3.GetAddr())
+	node3.AddPeer(node2.GetAddr(), node1.GetAddr())
+
+	nodes := []z.TestNode{node1, node2, node3}
+	for _, n := range nodes {
+		n.RegisterUser()
+		time.Sleep(time.Second)
+	}
+
+	for _, owner := range nodes {
+		require.Len(t, owner.GetKnownUsers(), 3)
+	}
+
+	// Node 3 leaves the network.
+	require.NoError(t, node3.LeaveNetwork())
+	time.Sleep(time.Second)
+
+	// Node 3 should not be able to post anymore.
+	_, err := node3.UpdateFeed(content.CreateTextMetadata(node3.GetUserID(), utils.Time(), "hello"))
+	require.Error(t, err)
+
+	// Node 2 leaves the network as well.
+	require.NoError(t, node2.LeaveNetwork())
+	time.Sleep(time.Second)
+
+	// Node 1 should be able to reach consensus on its own.
+	_, err = node1.UpdateFeed(content.CreateTextMetadata(node1.GetUserID(), utils.Time(), "hello"))
+	require.NoError(t, err)
+	require.Len(t, node1.GetFeedContents(node1.GetUserID()), 1)
+}
+
+func Test_Partage_Remove_Member(t *testing.T) {
+	node1 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0", z.WithTotalPeers(3), z.WithPaxosID(1),
+		z.WithAntiEntropy(time.Second))
+	defer node1.Stop()
+	node2 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0", z.WithTotalPeers(3), z.WithPaxosID(2),
+		z.WithAntiEntropy(time.Second))
+	defer node2.Stop()
+	node3 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0", z.WithTotalPeers(3), z.WithPaxosID(3),
+		z.WithAntiEntropy(time.Second))
+
+	node1.AddPeer(node2.GetAddr(), node3.GetAddr())
+	node2.AddPeer(node1.GetAddr(), node3.GetAddr())
+	node3.AddPeer(node2.GetAddr(), node1.GetAddr())
+
+	// The users register in the reverse order of their paxos ids.
+	nodes := []z.TestNode{node3, node2, node1}
+	for _, n := range nodes {
+		require.NoError(t, n.RegisterUser())
+		time.Sleep(time.Second)
+	}
+	for _, owner := range nodes {
+		require.Len(t, owner.GetKnownUsers(), 3)
+	}
+
+	// Only the other members can be removed.
+	require.Error(t, node1.RemoveMember(node1.GetUserID()))
+	require.Error(t, node1.RemoveMember(hex.EncodeToString(make([]byte, 32))))
+
+	// Node 3 becomes unresponsive, and is removed once both others have voted for it.
+	require.NoError(t, node3.Stop())
+	require.NoError(t, node1.RemoveMember(node3.GetUserID()))
+	time.Sleep(time.Second)
+	require.Error(t, node1.RemoveMember(node3.GetUserID()))
+	require.NoError(t, node2.RemoveMember(node3.GetUserID()))
+	time.Sleep(time.Second)
+	require.Error(t, node2.RemoveMember(node3.GetUserID()))
+
+	// Once node 2 leaves as well, node 1 should be able to reach consensus on its own, since the removed node is not
+	// counted anymore.
+	require.NoError(t, node2.LeaveNetwork())
+	time.Sleep(time.Second)
+	_, err := node1.UpdateFeed(content.CreateTextMetadata(node1.GetUserID(), utils.Time(), "hello"))
+	require.NoError(t, err)
+	require.Len(t, node1.GetFeedContents(node1.GetUserID()), 1)
+}
+
+func Test_Partage_Remove_Member_Alone(t *testing.T) {
+	node1 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0", z.WithTotalPeers(3), z.WithPaxosID(1),
+		z.WithAntiEntropy(time.Second))
+	defer node1.Stop()
+	node2 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0", z.WithTotalPeers(3), z.WithPaxosID(2),
+		z.WithAntiEntropy(time.Second))
+	defer node2.Stop()
+	node3 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0", z.WithTotalPeers(3), z.WithPaxosID(3),
+		z.WithAntiEntropy(time.Second))
+	defer node3.Stop()
+
+	node1.AddPeer(node2.GetAddr(), node3.GetAddr())
+	node2.AddPeer(node1.GetAddr(), node3.GetAddr())
+	node3.AddPeer(node2.GetAddr(), node1.GetAddr())
+
+	nodes := []z.TestNode{node1, node2, node3}
+	for _, n := range nodes {
+		require.NoError(t, n.RegisterUser())
+		time.Sleep(time.Second)
+	}
+
+	// Node 1 alone votes to remove node 3, and cannot vote again.
+	require.NoError(t, node1.RemoveMember(node3.GetUserID()))
+	time.Sleep(time.Second)
+	require.Error(t, node1.RemoveMember(node3.GetUserID()))
+
+	// Node 3 is still a member: its posts are accepted by everyone.
+	_, err := node3.UpdateFeed(content.CreateTextMetadata(node3.GetUserID(), utils.Time(), "still here"))
+	require.NoError(t, err)
+	time.Sleep(time.Second)
+	for _, n := range nodes {
+		require.Len(t, n.GetFeedContents(node3.GetUserID()), 1)
+	}
+}
+
+func Test_Partage_Feed_Batching(t *testing.T) {
+	bst := inmemory.NewPersistentMultipurposeStorage()
+	node1 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0", z.WithTotalPeers(2), z.WithPaxosID(1),
+		z.WithBlockchainStorage(bst), z.WithFeedBatchWindow(500*time.Millisecond))
+	defer node1.Stop()
+	node2 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0", z.WithTotalPeers(2), z.WithPaxosID(2))
+	defer node2.Stop()
+
+	node1.AddPeer(node2.GetAddr())
+	node2.AddPeer(node1.GetAddr())
+
+	node1.RegisterUser()
+	node2.RegisterUser()
+	time.Sleep(time.Second)
+
+	// Node 1 sends a burst of metadata, one of which conflicts with another.
+	base := utils.Time()
+	burst := []content.Metadata{
+		content.CreateChangeUsernameMetadata(node1.GetUserID(), utils.Time(), "batcher"),
+		content.CreateReactionMetadata(node1.GetUserID(), content.HAPPY, base+1, "c1"),
+		content.CreateReactionMetadata(node1.GetUserID(), content.HAPPY, base+2, "c2"),
+		content.CreateReactionMetadata(node1.GetUserID(), content.HAPPY, base+3, "c3"),
+		content.CreateReactionMetadata(node1.GetUserID(), content.CONFUSED, base+3, "c3"),
+	}
+	burst[0].Timestamp = base
+	hashes := make([]string, len(burst))
+	errs := make([]error, len(burst))
+	wg := sync.WaitGroup{}
+	for i, metadata := range burst {
+		wg.Add(1)
+		go func(i int, metadata content.Metadata) {
+			defer wg.Done()
+			hashes[i], errs[i] = node1.UpdateFeed(metadata)
+		}(i, metadata)
+	}
+	wg.Wait()
+
+	// Only one of the conflicting reactions should be accepted.
+	require.NoError(t, errs[0])
+	require.NoError(t, errs[1])
+	require.NoError(t, errs[2])
+	require.True(t, (errs[3] == nil) != (errs[4] == nil))
+	// Every accepted metadata gets its own hash.
+	accepted := make(map[string]struct{})
+	for i, hash := range hashes {
+		if errs[i] == nil {
+			accepted[hash] = struct{}{}
+		}
+	}
+	require.Len(t, accepted, 4)
+
+	// Wait for a while.
+	time.Sleep(time.Second)
+
+	// The burst should have been decided in a single block.
+	require.Len(t, utils.LoadBlockchain(bst.GetStore(feed.IDFromUserID(node1.GetUserID()))), 1)
+	for _, n := range []z.TestNode{node1, node2} {
+		require.Len(t, n.GetFeedContents(node1.GetUserID()), 4)
+	}
+
+	// The hash of a metadata in the middle of the block should be usable to undo it.
+	_, err := node1.UpdateFeed(content.CreateUndoMetadata(node1.GetUserID(), base+4, hashes[1]))
+	require.NoError(t, err)
+	_, err = node1.UpdateFeed(content.CreateReactionMetadata(node1.GetUserID(), content.HAPPY, base+5, "c1"))
+	require.NoError(t, err)
+
+	// Each metadata of a batch is checked against the state that includes the ones before it: a follow right after a
+	// username change, and a text along with its edit are all accepted within a single block.
+	text := content.CreateTextMetadata(node1.GetUserID(), base+7, "text")
+	burst = []content.Metadata{
+		content.CreateChangeUsernameMetadata(node1.GetUserID(), base+6, "batcher2"),
+		content.CreateFollowUserMetadata(node1.GetUserID(), base+7, node2.GetUserID()),
+		text,
+		content.CreateEditMetadata(node1.GetUserID(), base+8, text.ContentID, "edited"),
+	}
+	errs = make([]error, len(burst))
+	for i, metadata := range burst {
+		wg.Add(1)
+		go func(i int, metadata content.Metadata) {
+			defer wg.Done()
+			_, errs[i] = node1.UpdateFeed(metadata)
+		}(i, metadata)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+	require.Len(t, utils.LoadBlockchain(bst.GetStore(feed.IDFromUserID(node1.GetUserID()))), 4)
+}
+
 func Test_Partage_Late_Registration(t *testing.T) {
 	node1 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0", z.WithTotalPeers(3), z.WithPaxosID(1))
 	defer node1.Stop()
@@ -97,6 +593,139 @@ func Test_Partage_Late_Registration(t *testing.T) {
 	}
 }
 
+func Test_Partage_Blockchain_Sync(t *testing.T) {
+	// No anti-entropy, so that the late joiner can only learn the past blocks through the sync.
+	node1 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0", z.WithTotalPeers(1), z.WithPaxosID(1))
+	defer node1.Stop()
+
+	// The first node registers and posts alone.
+	require.NoError(t, node1.RegisterUser())
+	_, err := node1.UpdateFeed(content.CreateTextMetadata(node1.GetUserID(), utils.Time(), "a"))
+	require.NoError(t, err)
+
+	// The second node joins later.
+	node2 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0", z.WithTotalPeers(1), z.WithPaxosID(2))
+	defer node2.Stop()
+	node2.AddPeer(node1.GetAddr())
+	node1.AddPeer(node2.GetAddr())
+
+	// Sync the blockchains from the first node.
+	require.NoError(t, node2.SyncBlockchains(node1.GetAddr()))
+	require.Len(t, node2.GetKnownUsers(), 1)
+	require.Len(t, node2.GetFeedContents(node1.GetUserID()), 1)
+
+	// Syncing again should not append anything.
+	require.NoError(t, node2.SyncBlockchains(node1.GetAddr()))
+	require.Len(t, node2.GetFeedContents(node1.GetUserID()), 1)
+
+	// The second node should now be able to register and follow the new blocks.
+	require.NoError(t, node2.RegisterUser())
+	time.Sleep(time.Second)
+	require.Len(t, node1.GetKnownUsers(), 2)
+	require.Len(t, node2.GetKnownUsers(), 2)
+
+	_, err = node1.UpdateFeed(content.CreateTextMetadata(node1.GetUserID(), utils.Time(), "b"))
+	require.NoError(t, err)
+	time.Sleep(time.Second)
+	require.Len(t, node2.GetFeedContents(node1.GetUserID()), 2)
+}
+
+func Test_Partage_Blockchain_Sync_Forged(t *testing.T) {
+	bst1 := inmemory.NewPersistentMultipurposeStorage()
+	node1 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0", z.WithTotalPeers(1), z.WithPaxosID(1),
+		z.WithBlockchainStorage(bst1))
+	defer node1.Stop()
+
+	require.NoError(t, node1.RegisterUser())
+	_, err := node1.UpdateFeed(content.CreateTextMetadata(node1.GetUserID(), utils.Time(), "a"))
+	require.NoError(t, err)
+
+	// Append a post that is not signed by the owner of the feed, but whose block hash is correct, to the feed
+	// blockchain served by the first node.
+	feedStore := bst1.GetStore(feed.IDFromUserID(node1.GetUserID()))
+	prevHash := feedStore.Get(storage.LastBlockKey)
+	batch := []content.Metadata{content.CreateTextMetadata(node1.GetUserID(), utils.Time(), "forged")}
+	forged := types.BlockchainBlock{
+		Index: 1,
+		Value: types.PaxosValue{
+			UniqID:      "forged",
+			CustomValue: content.UnparseMetadataBatch(batch),
+		},
+		PrevHash: prevHash,
+	}
+	forged.Hash = content.HashMetadataBatch(forged.Index, forged.Value.UniqID, batch, prevHash)
+	forgedBytes, err := forged.Marshal()
+	require.NoError(t, err)
+	feedStore.Set(hex.EncodeToString(forged.Hash), forgedBytes)
+	feedStore.Set(storage.LastBlockKey, forged.Hash)
+
+	node2 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0", z.WithTotalPeers(1), z.WithPaxosID(2))
+	defer node2.Stop()
+	node2.AddPeer(node1.GetAddr())
+	node1.AddPeer(node2.GetAddr())
+
+	// The sync should stop at the forged block.
+	require.Error(t, node2.SyncBlockchains(node1.GetAddr()))
+	require.Len(t, node2.GetKnownUsers(), 1)
+	require.Len(t, node2.GetFeedContents(node1.GetUserID()), 1)
+}
+
+func Test_Partage_Verify_Blockchain(t *testing.T) {
+	st := inmemory.NewPersistency()
+	bst := inmemory.NewPersistentMultipurposeStorage()
+	node1 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0", z.WithTotalPeers(1), z.WithPaxosID(1),
+		z.WithStorage(st), z.WithBlockchainStorage(bst))
+	defer node1.Stop()
+
+	require.NoError(t, node1.RegisterUser())
+	for _, text := range []string{"a", "b", "c"} {
+		_, err := node1.UpdateFeed(content.CreateTextMetadata(node1.GetUserID(), utils.Time(), text))
+		require.NoError(t, err)
+	}
+
+	// All the blockchains should be valid.
+	config := peer.Configuration{Storage: st, BlockchainStorage: bst}
+	reports := impl.VerifyBlockchains(config)
+	require.Len(t, reports, 3)
+	lengths := map[string]int{}
+	for _, report := range reports {
+		require.NoError(t, report.Err)
+		lengths[report.ProtocolID] = report.Length
+	}
+	require.Equal(t, 0, lengths["default"])
+	require.Equal(t, 1, lengths["registration"])
+	require.Equal(t, 3, lengths[feed.IDFromUserID(node1.GetUserID())])
+
+	// Tamper with the second block of the feed.
+	feedStore := bst.GetStore(feed.IDFromUserID(node1.GetUserID()))
+	blocks := utils.LoadBlockchain(feedStore)
+	require.Len(t, blocks, 3)
+	tampered := blocks[1]
+	tampered.Value.UniqID = "tampered"
+	tamperedBytes, err := tampered.Marshal()
+	require.NoError(t, err)
+	feedStore.Set(hex.EncodeToString(tampered.Hash), tamperedBytes)
+
+	// The verifier should report the tampered block as the first broken link.
+	_, err = consensus.VerifyBlockchain(feedStore, consensus.MetadataBlockHasher)
+	require.Error(t, err)
+	brokenLink, ok := err.(consensus.BrokenLinkError)
+	require.True(t, ok)
+	require.Equal(t, uint(1), brokenLink.Index)
+
+	// Break the link between the last two blocks.
+	tampered = blocks[2]
+	tampered.PrevHash = blocks[0].Hash
+	tamperedBytes, err = tampered.Marshal()
+	require.NoError(t, err)
+	feedStore.Set(hex.EncodeToString(tampered.Hash), tamperedBytes)
+	_, err = consensus.VerifyBlockchain(feedStore, consensus.MetadataBlockHasher)
+	require.Error(t, err)
+	brokenLink, ok = err.(consensus.BrokenLinkError)
+	require.True(t, ok)
+	require.Equal(t, uint(2), brokenLink.Index)
+}
+
 func Test_Partage_Single_Post_Single_Node(t *testing.T) {
 	node1 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0",
 		z.WithTotalPeers(3),
@@ -131,6 +760,7 @@ func Test_Partage_Single_Post_Single_Node(t *testing.T) {
 		FeedUserID: node1.GetUserID(),
 		Type:       content.TEXT,
 		ContentID:  "123",
+		Timestamp:  utils.Time(),
 		Signature:  nil,
 	})
 	time.Sleep(1 * time.Second)
@@ -183,12 +813,14 @@ func Test_Partage_Two_Posts_Single_Node(t *testing.T) {
 		FeedUserID: node1.GetUserID(),
 		Type:       content.TEXT,
 		ContentID:  "1",
+		Timestamp:  utils.Time(),
 		Signature:  nil,
 	})
 	node1.UpdateFeed(content.Metadata{
 		FeedUserID: node1.GetUserID(),
 		Type:       content.TEXT,
 		ContentID:  "2",
+		Timestamp:  utils.Time(),
 		Signature:  nil,
 	})
 	time.Sleep(3 * time.Second)
@@ -247,12 +879,14 @@ func Test_Partage_Two_Posts_All_Nodes(t *testing.T) {
 				FeedUserID: node.GetUserID(),
 				Type:       content.TEXT,
 				ContentID:  fmt.Sprintf("%d-1", nodeIndex),
+				Timestamp:  utils.Time(),
 				Signature:  nil,
 			})
 			node.UpdateFeed(content.Metadata{
 				FeedUserID: node.GetUserID(),
 				Type:       content.TEXT,
 				ContentID:  fmt.Sprintf("%d-2", nodeIndex),
+				Timestamp:  utils.Time(),
 				Signature:  nil,
 			})
 		}(i, n)
@@ -315,13 +949,13 @@ func Test_Partage_Change_Username(t *testing.T) {
 	// For each user, check a username change scenario.
 	for _, ni := range nodes {
 		// First, change the username.
-		ni.UpdateFeed(content.CreateChangeUsernameMetadata(ni.GetUserID(), "Descartes"))
+		ni.UpdateFeed(content.CreateChangeUsernameMetadata(ni.GetUserID(), utils.Time(), "Descartes"))
 		time.Sleep(1 * time.Second)
 		for _, nj := range nodes {
 			require.Equal(t, "Descartes", nj.GetUserState(ni.GetUserID()).Username)
 		}
 		// Change it again.
-		ni.UpdateFeed(content.CreateChangeUsernameMetadata(ni.GetUserID(), "Fiat"))
+		ni.UpdateFeed(content.CreateChangeUsernameMetadata(ni.GetUserID(), utils.Time(), "Fiat"))
 		time.Sleep(1 * time.Second)
 		for _, nj := range nodes {
 			require.Equal(t, "Fiat", nj.GetUserState(ni.GetUserID()).Username)
@@ -366,7 +1000,7 @@ func Test_Partage_Follow_Unfollow(t *testing.T) {
 				continue
 			}
 			// Follow a user.
-			followHash, _ := node1.UpdateFeed(content.CreateFollowUserMetadata(node1.GetUserID(), node2.GetUserID()))
+			followHash, _ := node1.UpdateFeed(content.CreateFollowUserMetadata(node1.GetUserID(), utils.Time(), node2.GetUserID()))
 			time.Sleep(1 * time.Second)
 			for _, n := range nodes {
 				require.Len(t, n.GetUserState(node1.GetUserID()).Followees, 1)
@@ -375,7 +1009,7 @@ func Test_Partage_Follow_Unfollow(t *testing.T) {
 				require.True(t, n.GetUserState(node2.GetUserID()).IsFollowedBy(node1.GetUserID()))
 			}
 			// Double follow is not allowed.
-			_, err := node1.UpdateFeed(content.CreateFollowUserMetadata(node1.GetUserID(), node2.GetUserID()))
+			_, err := node1.UpdateFeed(content.CreateFollowUserMetadata(node1.GetUserID(), utils.Time(), node2.GetUserID()))
 			time.Sleep(1 * time.Second)
 			require.NotNil(t, err)
 			for _, n := range nodes {
@@ -430,16 +1064,21 @@ func Test_Partage_Endorsement(t *testing.T) {
 	node2.RegisterUser()
 	node3.RegisterUser()
 
-	// First, request an endorsement.
+	// First, request an endorsement. The users start with more credits than
+	// they may have to request one, so raise the limit for the test.
+	defaultRequestCreditLimit := feed.ENDORSEMENT_REQUEST_CREDIT_LIMIT
+	feed.ENDORSEMENT_REQUEST_CREDIT_LIMIT = feed.INITIAL_CREDITS
+	defer func() { feed.ENDORSEMENT_REQUEST_CREDIT_LIMIT = defaultRequestCreditLimit }()
 	nodes := []z.TestNode{node1, node2, node3}
-	node1.UpdateFeed(content.CreateEndorsementRequestMetadata(node1.GetUserID(), utils.Time()))
+	_, err := node1.UpdateFeed(content.CreateEndorsementRequestMetadata(node1.GetUserID(), utils.Time()))
+	require.NoError(t, err)
 	time.Sleep(1 * time.Second)
 	for _, n := range nodes {
 		require.Equal(t, feed.INITIAL_CREDITS, n.GetUserState(node1.GetUserID()).CurrentCredits)
 		require.Equal(t, 0, n.GetUserState(node1.GetUserID()).ReceivedEndorsements)
 	}
 	// Try self-endorsement. Should not be appended into the blockchain.
-	_, err := node1.UpdateFeed(content.CreateEndorseUserMetadata(node1.GetUserID(), utils.Time(), node1.GetUserID()))
+	_, err = node1.UpdateFeed(content.CreateEndorseUserMetadata(node1.GetUserID(), utils.Time(), node1.GetUserID()))
 	require.NotNil(t, err)
 	time.Sleep(1 * time.Second)
 	for _, n := range nodes {
@@ -737,7 +1376,7 @@ func Test_Partage_Undo(t *testing.T) {
 		require.Equal(t, content.UNDO, contents[1].Type)
 	}
 	// Let node 3 follow node 1.
-	followHash, _ := node3.UpdateFeed(content.CreateFollowUserMetadata(node3.GetUserID(), node1.GetUserID()))
+	followHash, _ := node3.UpdateFeed(content.CreateFollowUserMetadata(node3.GetUserID(), utils.Time(), node1.GetUserID()))
 	time.Sleep(1 * time.Second)
 	for _, n := range nodes {
 		require.True(t, n.GetUserState(node3.GetUserID()).IsFollowing(node1.GetUserID()))
@@ -757,7 +1396,9 @@ func Test_Partage_Undo(t *testing.T) {
 	require.NotNil(t, err)
 }
 
-func Test_Partage_Invalid_Block(t *testing.T) {
+// The posts of the followees are kept in the blob store despite its quota, and
+// the blobs of the undone posts are garbage collected.
+func Test_Partage_Blob_Store_GC(t *testing.T) {
 	node1 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0",
 		z.WithTotalPeers(3),
 		z.WithPaxosID(1),
@@ -774,6 +1415,7 @@ func Test_Partage_Invalid_Block(t *testing.T) {
 		z.WithTotalPeers(3),
 		z.WithPaxosID(3),
 		z.WithAntiEntropy(time.Second),
+		z.WithBlobStore(1, 0),
 	)
 	defer node3.Stop()
 
@@ -786,121 +1428,1394 @@ func Test_Partage_Invalid_Block(t *testing.T) {
 	node2.RegisterUser()
 	node3.RegisterUser()
 
-	// Share a dummy post. Should be rejected by the network.
-	_, err := node1.UpdateFeed(content.Metadata{Type: content.DUMMY})
-	require.NotNil(t, err)
+	// Let node 1 and node 2 share a text post, and node 3 follow node 1.
+	md1, _, err := node1.ShareDownloadableContent(content.NewPublicContent(node1.GetUserID(), "followed", utils.Time(), "").Unencrypted(), content.TEXT)
+	require.NoError(t, err)
+	md2, n2TextBlockHash, err := node2.ShareDownloadableContent(content.NewPublicContent(node2.GetUserID(), "not followed", utils.Time(), "").Unencrypted(), content.TEXT)
+	require.NoError(t, err)
+	_, err = node3.UpdateFeed(content.CreateFollowUserMetadata(node3.GetUserID(), utils.Time(), node1.GetUserID()))
+	require.NoError(t, err)
+	time.Sleep(1 * time.Second)
+	_, err = node3.DiscoverContentIDs(content.Filter{})
+	require.NoError(t, err)
+
+	// Node 3 keeps the post of node 1 while downloading the post of node 2,
+	// although nothing fits in its quota.
+	blobStore := node3.GetStorage().GetDataBlobStore()
+	for _, contentID := range []string{md1.ContentID, md2.ContentID, md2.ContentID} {
+		_, err = node3.DownloadContent(contentID)
+		require.NoError(t, err)
+	}
+	require.NotNil(t, blobStore.Get(string(md1.Data)))
+	require.NotNil(t, blobStore.Get(string(md2.Data)))
+	require.Zero(t, node3.CollectGarbage())
+
+	// Undo the post of node 2: its blobs are garbage collected, except on
+	// node 2 that uploaded it.
+	_, err = node2.UpdateFeed(content.CreateUndoMetadata(node2.GetUserID(), utils.Time(), n2TextBlockHash))
+	require.NoError(t, err)
+	time.Sleep(1 * time.Second)
+	require.NotZero(t, node3.CollectGarbage())
+	require.Nil(t, blobStore.Get(string(md2.Data)))
+	require.NotNil(t, blobStore.Get(string(md1.Data)))
+	node2.CollectGarbage()
+	require.NotNil(t, node2.GetStorage().GetDataBlobStore().Get(string(md2.Data)))
 }
 
-func Test_Partage_Private_Post(t *testing.T) {
+func Test_Partage_Edit(t *testing.T) {
+	bst := inmemory.NewPersistentMultipurposeStorage()
 	node1 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0",
-		z.WithTotalPeers(3),
+		z.WithTotalPeers(2),
 		z.WithPaxosID(1),
 		z.WithAntiEntropy(time.Second),
+		z.WithBlockchainStorage(bst),
 	)
 	defer node1.Stop()
 	node2 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0",
-		z.WithTotalPeers(3),
+		z.WithTotalPeers(2),
 		z.WithPaxosID(2),
 		z.WithAntiEntropy(time.Second),
 	)
 	defer node2.Stop()
-	node3 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0",
-		z.WithTotalPeers(3),
-		z.WithPaxosID(3),
-		z.WithAntiEntropy(time.Second),
-	)
-	defer node3.Stop()
-	node4 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0",
-		z.WithTotalPeers(3),
-		z.WithPaxosID(3),
-		z.WithAntiEntropy(time.Second),
-	)
-	defer node4.Stop()
 
-	node1.AddPeer(node2.GetAddr(), node3.GetAddr(), node4.GetAddr())
-	node2.AddPeer(node1.GetAddr(), node3.GetAddr(), node4.GetAddr())
-	node3.AddPeer(node2.GetAddr(), node1.GetAddr(), node4.GetAddr())
-	node4.AddPeer(node1.GetAddr(), node2.GetAddr(), node3.GetAddr())
+	node1.AddPeer(node2.GetAddr())
+	node2.AddPeer(node1.GetAddr())
 
 	// Register the nodes.
 	node1.RegisterUser()
 	node2.RegisterUser()
-	node3.RegisterUser()
-	node4.RegisterUser()
 
-	privateText := "private stuff"
-	recipientMap := map[[32]byte]*rsa.PublicKey{
-		node2.GetHashedPublicKey(): node2.GetPublicKey(node2.GetHashedPublicKey()),
+	// Share a text post.
+	texts := []string{"Lorem ipsum", "Lorem ipsum dolor", "Lorem ipsum dolor sit amet!!!"}
+	nodes := []z.TestNode{node1, node2}
+	md, _, err := node1.ShareDownloadableContent(content.NewPublicContent(node1.GetUserID(), texts[0], utils.Time(), "").Unencrypted(), content.TEXT)
+	require.NoError(t, err)
+	textContentID := md.ContentID
+	time.Sleep(1 * time.Second)
+
+	// Node 2 should not be able to edit the post of node 1.
+	_, _, err = node2.ShareDownloadableContent(content.NewPublicContent(node2.GetUserID(), "Hacked", utils.Time(), textContentID).Unencrypted(), content.EDIT)
+	require.Error(t, err)
+
+	// Node 1 edits its post twice.
+	metahashes := []string{string(md.Data)}
+	for _, text := range texts[1:] {
+		editMd, _, err := node1.ShareDownloadableContent(content.NewPublicContent(node1.GetUserID(), text, utils.Time(), textContentID).Unencrypted(), content.EDIT)
+		require.NoError(t, err)
+		metahashes = append(metahashes, string(editMd.Data))
+	}
+	time.Sleep(1 * time.Second)
+
+	for _, n := range nodes {
+		// The feed should hold the latest version of the post, marked as edited.
+		contents := n.QueryFeedContents(content.Filter{Types: []content.Type{content.TEXT}})
+		require.Len(t, contents, 1)
+		require.Equal(t, textContentID, contents[0].ContentID)
+		require.True(t, contents[0].Edited)
+		require.Equal(t, metahashes[2], string(contents[0].Data))
+		// The earlier versions should be kept.
+		history := n.GetContentHistory(textContentID)
+		require.Len(t, history, 3)
+		for i, version := range history {
+			require.Equal(t, metahashes[i], string(version.Data))
+		}
+	}
+
+	// Downloading the post should return the latest version.
+	downloaded, err := node1.DownloadContent(textContentID)
+	require.NoError(t, err)
+	require.Equal(t, texts[2], content.ParseContent(downloaded).Text)
+
+	// The stored metadata of the post should still be the original one, signed by its owner.
+	stored := content.ParseMetadata(bst.GetStore("metadata").Get(textContentID))
+	require.Equal(t, metahashes[0], string(stored.Data))
+	require.NoError(t, stored.VerifySignature(node1.GetPublicKey(node1.GetHashedPublicKey())))
+
+	// Once undone, the post cannot be edited anymore.
+	contents := node1.GetFeedContents(node1.GetUserID())
+	_, err = node1.UpdateFeed(content.CreateUndoMetadata(node1.GetUserID(), utils.Time(), contents[0].BlockHash))
+	require.NoError(t, err)
+	_, _, err = node1.ShareDownloadableContent(content.NewPublicContent(node1.GetUserID(), "Too late", utils.Time(), textContentID).Unencrypted(), content.EDIT)
+	require.Error(t, err)
+}
+
+func Test_Partage_Invalid_Block(t *testing.T) {
+	node1 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0",
+		z.WithTotalPeers(3),
+		z.WithPaxosID(1),
+		z.WithAntiEntropy(time.Second),
+	)
+	defer node1.Stop()
+	node2 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0",
+		z.WithTotalPeers(3),
+		z.WithPaxosID(2),
+		z.WithAntiEntropy(time.Second),
+	)
+	defer node2.Stop()
+	node3 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0",
+		z.WithTotalPeers(3),
+		z.WithPaxosID(3),
+		z.WithAntiEntropy(time.Second),
+	)
+	defer node3.Stop()
+
+	node1.AddPeer(node2.GetAddr(), node3.GetAddr())
+	node2.AddPeer(node1.GetAddr(), node3.GetAddr())
+	node3.AddPeer(node2.GetAddr(), node1.GetAddr())
+
+	// Register the nodes.
+	node1.RegisterUser()
+	node2.RegisterUser()
+	node3.RegisterUser()
+
+	// Share a dummy post. Should be rejected by the network.
+	_, err := node1.UpdateFeed(content.Metadata{Type: content.DUMMY})
+	require.NotNil(t, err)
+}
+
+// Without the TLS transport, the metadata cannot be signed and verified, so
+// the social layer refuses to register or post.
+func Test_Partage_Social_Requires_TLS(t *testing.T) {
+	transp := channel.NewTransport()
+
+	node1 := z.NewTestNode(t, peerFac, transp, "127.0.0.1:0")
+	defer node1.Stop()
+
+	require.Error(t, node1.RegisterUser())
+	_, err := node1.UpdateFeed(content.CreateTextMetadata(hex.EncodeToString(make([]byte, 32)), utils.Time(), "hello"))
+	require.Error(t, err)
+}
+
+func Test_Partage_Metadata_Signature(t *testing.T) {
+	bst := inmemory.NewPersistentMultipurposeStorage()
+	node1 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0",
+		z.WithTotalPeers(3),
+		z.WithPaxosID(1),
+		z.WithAntiEntropy(time.Second),
+		z.WithBlockchainStorage(bst),
+	)
+	defer node1.Stop()
+	node2 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0",
+		z.WithTotalPeers(3),
+		z.WithPaxosID(2),
+		z.WithAntiEntropy(time.Second),
+	)
+	defer node2.Stop()
+	node3 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0",
+		z.WithTotalPeers(3),
+		z.WithPaxosID(3),
+		z.WithAntiEntropy(time.Second),
+	)
+	defer node3.Stop()
+
+	node1.AddPeer(node2.GetAddr(), node3.GetAddr())
+	node2.AddPeer(node1.GetAddr(), node3.GetAddr())
+	node3.AddPeer(node2.GetAddr(), node1.GetAddr())
+
+	// Register the nodes.
+	node1.RegisterUser()
+	node2.RegisterUser()
+	node3.RegisterUser()
+
+	// The first node is sharing a text post.
+	_, err := node1.UpdateFeed(content.CreateTextMetadata(node1.GetUserID(), utils.Time(), "123"))
+	require.NoError(t, err)
+	time.Sleep(1 * time.Second)
+
+	// Every node should see a post that is signed by the first node.
+	n1PublicKey := node1.GetPublicKey(node1.GetHashedPublicKey())
+	for _, n := range []z.TestNode{node1, node2, node3} {
+		posts := n.GetFeedContents(node1.GetUserID())
+		require.Len(t, posts, 1)
+		require.NoError(t, posts[0].Metadata.VerifySignature(n1PublicKey))
+	}
+
+	// A metadata signed by the first node on behalf of the second node should not be accepted.
+	forged := content.CreateTextMetadata(node2.GetUserID(), utils.Time(), "456")
+	require.NoError(t, forged.Sign(node1.GetPrivateKey()))
+	require.Error(t, forged.VerifySignature(node2.GetPublicKey(node2.GetHashedPublicKey())))
+
+	// Tampering with a signed metadata should invalidate the signature.
+	tampered := content.CreateTextMetadata(node1.GetUserID(), utils.Time(), "789")
+	require.NoError(t, tampered.Sign(node1.GetPrivateKey()))
+	tampered.ContentID = "000"
+	require.Error(t, tampered.VerifySignature(n1PublicKey))
+
+	// The first node bypasses its social layer and drives the consensus by itself. Its own signed post should be
+	// accepted, which shows that the proposals reach the proposal checker of the others.
+	nodes := []z.TestNode{node1, node2, node3}
+	own := content.CreateTextMetadata(node1.GetUserID(), utils.Time(), "abc")
+	require.NoError(t, own.Sign(node1.GetPrivateKey()))
+	lastBlockHash := bst.GetStore(feed.IDFromUserID(node1.GetUserID())).Get(storage.LastBlockKey)
+	votes := signedVotes(t, bst, node1.GetUserID(), node1, nodes...)
+	injectFeedProposal(t, node1, node1.GetUserID(), 1, lastBlockHash, own, votes)
+	for _, n := range nodes {
+		require.Len(t, n.GetFeedContents(node1.GetUserID()), 2)
+	}
+
+	// A post forged on behalf of the second node, or not signed at all, should be rejected by the proposal checker.
+	votes = signedVotes(t, bst, node2.GetUserID(), node1, nodes...)
+	injectFeedProposal(t, node1, node2.GetUserID(), 0, make([]byte, 32), forged, votes)
+	unsigned := content.CreateTextMetadata(node2.GetUserID(), utils.Time(), "def")
+	injectFeedProposal(t, node1, node2.GetUserID(), 0, make([]byte, 32), unsigned, votes)
+	for _, n := range []z.TestNode{node1, node2, node3} {
+		require.Len(t, n.GetFeedContents(node2.GetUserID()), 0)
+	}
+}
+
+// injectedTerm is the paxos id and the Raft term of the proposals made by injectFeedProposal.
+const injectedTerm = 100
+
+// signedVotes returns the votes granted by the given voters to the given candidate in the term of the injected
+// proposals, for the feed protocol of the given user. The votes are signed with the keys of the voters, whose public
+// keys are taken from the registration blockchain in the given storage.
+func signedVotes(t *testing.T, bst storage.MultipurposeStorage, userID string, candidate z.TestNode,
+	voters ...z.TestNode) []types.RaftVoteMessage {
+	signedPKs := make(map[string]*transport.SignedPublicKey)
+	for _, block := range utils.LoadBlockchain(bst.GetStore("registration")) {
+		metadata := content.ParseMetadata(block.Value.CustomValue)
+		if metadata.Type == content.JOIN {
+			signedPK, err := content.ParseJoinPublicKey(metadata)
+			require.NoError(t, err)
+			signedPKs[metadata.FeedUserID] = signedPK
+		}
+	}
+	var votes []types.RaftVoteMessage
+	for _, voter := range voters {
+		vote := types.RaftVoteMessage{Term: injectedTerm, Source: voter.GetAddr(), Candidate: candidate.GetAddr(),
+			Granted: true}
+		require.NotNil(t, signedPKs[voter.GetUserID()])
+		require.NoError(t, raft.SignVote(&vote, feed.IDFromUserID(userID), voter.GetPrivateKey(),
+			signedPKs[voter.GetUserID()]))
+		votes = append(votes, vote)
+	}
+	return votes
+}
+
+// injectFeedProposal makes the given node propose the given metadata at the given step of the feed blockchain of the
+// given user, as a malicious peer would, without going through its social layer. Both a paxos prepare and propose,
+// and a Raft append entry and commit from a leader of a new term that carry the given votes, are broadcast, so that
+// the proposal reaches the proposal checker whichever protocol is used. The proposal is made in the membership epoch
+// reached after three registrations, on top of the block with the given hash.
+func injectFeedProposal(t *testing.T, node z.TestNode, userID string, step uint, prevHash []byte,
+	metadata content.Metadata, votes []types.RaftVoteMessage) {
+	protocolID := feed.IDFromUserID(userID)
+	id := uint(injectedTerm)
+	epoch := uint(3)
+	batch := []content.Metadata{metadata}
+	value := types.PaxosValue{
+		UniqID:      fmt.Sprint("injected-", metadata.ContentID),
+		CustomValue: content.UnparseMetadataBatch(batch),
+	}
+	block := types.BlockchainBlock{
+		Index:    step,
+		Hash:     content.HashMetadataBatch(step, value.UniqID, batch, prevHash),
+		Value:    value,
+		PrevHash: prevHash,
+	}
+	msgs := []types.Message{
+		&types.PaxosPrepareMessage{Step: step, ID: id, Source: node.GetAddr(), Epoch: epoch},
+		&types.PaxosProposeMessage{Step: step, ID: id, Source: node.GetAddr(), Value: value, Epoch: epoch},
+		&types.RaftAppendEntryMessage{Term: id, Leader: node.GetAddr(), Step: step, PrevHash: prevHash,
+			Value: value, Epoch: epoch, Votes: votes},
+		&types.RaftCommitMessage{Term: id, Leader: node.GetAddr(), Step: step, Block: block, Votes: votes},
+	}
+	for _, msg := range msgs {
+		transpMsg, err := node.GetRegistry().MarshalMessage(msg)
+		require.NoError(t, err)
+		consensusMsg := protocol.WrapInConsensusMessage(protocolID, transpMsg)
+		consensusTranspMsg, err := node.GetRegistry().MarshalMessage(&consensusMsg)
+		require.NoError(t, err)
+		require.NoError(t, node.Broadcast(consensusTranspMsg))
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// fakeClock is a clock that only moves forward when asked to, so that the peers can be tested against contents from
+// the past or the future.
+type fakeClock struct {
+	now int64
+}
+
+// Now returns the current unix time of the fake clock.
+func (c *fakeClock) Now() int64 {
+	return atomic.LoadInt64(&c.now)
+}
+
+// Advance moves the fake clock forward by the given number of seconds.
+func (c *fakeClock) Advance(seconds int64) {
+	atomic.AddInt64(&c.now, seconds)
+}
+
+func Test_Partage_Timestamp_Window(t *testing.T) {
+	start := time.Now().Unix()
+	clock := &fakeClock{now: start}
+
+	node1 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0",
+		z.WithTotalPeers(3),
+		z.WithPaxosID(1),
+		z.WithAntiEntropy(time.Second),
+		z.WithClock(clock.Now),
+	)
+	defer node1.Stop()
+	node2 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0",
+		z.WithTotalPeers(3),
+		z.WithPaxosID(2),
+		z.WithAntiEntropy(time.Second),
+		z.WithClock(clock.Now),
+	)
+	defer node2.Stop()
+	node3 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0",
+		z.WithTotalPeers(3),
+		z.WithPaxosID(3),
+		z.WithAntiEntropy(time.Second),
+		z.WithClock(clock.Now),
+	)
+	defer node3.Stop()
+
+	node1.AddPeer(node2.GetAddr(), node3.GetAddr())
+	node2.AddPeer(node1.GetAddr(), node3.GetAddr())
+	node3.AddPeer(node2.GetAddr(), node1.GetAddr())
+
+	// Register the nodes.
+	node1.RegisterUser()
+	node2.RegisterUser()
+	node3.RegisterUser()
+
+	// Move the clock forward. Contents that were created too long ago should be rejected.
+	clock.Advance(feed.TIMESTAMP_SKEW_WINDOW + 10)
+	_, err := node1.UpdateFeed(content.CreateTextMetadata(node1.GetUserID(), start, "1"))
+	require.Error(t, err)
+	// Contents from the future should be rejected as well.
+	_, err = node1.UpdateFeed(content.CreateTextMetadata(node1.GetUserID(), clock.Now()+feed.TIMESTAMP_SKEW_WINDOW+10, "2"))
+	require.Error(t, err)
+	// Small clock skews are tolerated.
+	_, err = node1.UpdateFeed(content.CreateTextMetadata(node1.GetUserID(), clock.Now()-feed.TIMESTAMP_SKEW_WINDOW/2, "3"))
+	require.NoError(t, err)
+	_, err = node1.UpdateFeed(content.CreateTextMetadata(node1.GetUserID(), clock.Now()+feed.TIMESTAMP_SKEW_WINDOW/2, "4"))
+	require.NoError(t, err)
+	time.Sleep(1 * time.Second)
+
+	for _, n := range []z.TestNode{node1, node2, node3} {
+		posts := n.GetFeedContents(node1.GetUserID())
+		require.Len(t, posts, 2)
+		require.Equal(t, "3", string(posts[0].Data))
+		require.Equal(t, "4", string(posts[1].Data))
+	}
+}
+
+func Test_Partage_Timestamp_Monotonicity(t *testing.T) {
+	start := time.Now().Unix()
+	clock := &fakeClock{now: start}
+
+	node1 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0",
+		z.WithTotalPeers(3),
+		z.WithPaxosID(1),
+		z.WithAntiEntropy(time.Second),
+		z.WithClock(clock.Now),
+	)
+	defer node1.Stop()
+	node2 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0",
+		z.WithTotalPeers(3),
+		z.WithPaxosID(2),
+		z.WithAntiEntropy(time.Second),
+		z.WithClock(clock.Now),
+	)
+	defer node2.Stop()
+	node3 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0",
+		z.WithTotalPeers(3),
+		z.WithPaxosID(3),
+		z.WithAntiEntropy(time.Second),
+		z.WithClock(clock.Now),
+	)
+	defer node3.Stop()
+
+	node1.AddPeer(node2.GetAddr(), node3.GetAddr())
+	node2.AddPeer(node1.GetAddr(), node3.GetAddr())
+	node3.AddPeer(node2.GetAddr(), node1.GetAddr())
+
+	// Register the nodes.
+	node1.RegisterUser()
+	node2.RegisterUser()
+	node3.RegisterUser()
+
+	// Share a post, then move the clock forward and share another one.
+	_, err := node1.UpdateFeed(content.CreateTextMetadata(node1.GetUserID(), clock.Now(), "1"))
+	require.NoError(t, err)
+	clock.Advance(60)
+	_, err = node1.UpdateFeed(content.CreateTextMetadata(node1.GetUserID(), clock.Now(), "2"))
+	require.NoError(t, err)
+	// A post that is older than the last post should be rejected, even though it is within the skew window.
+	_, err = node1.UpdateFeed(content.CreateTextMetadata(node1.GetUserID(), clock.Now()-30, "3"))
+	require.Error(t, err)
+	// Posts with the same timestamp as the last post are fine.
+	_, err = node1.UpdateFeed(content.CreateTextMetadata(node1.GetUserID(), clock.Now(), "4"))
+	require.NoError(t, err)
+	time.Sleep(1 * time.Second)
+
+	for _, n := range []z.TestNode{node1, node2, node3} {
+		posts := n.GetFeedContents(node1.GetUserID())
+		require.Len(t, posts, 3)
+		require.Equal(t, "1", string(posts[0].Data))
+		require.Equal(t, "2", string(posts[1].Data))
+		require.Equal(t, "4", string(posts[2].Data))
+	}
+}
+
+func Test_Partage_Private_Post(t *testing.T) {
+	node1 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0",
+		z.WithTotalPeers(3),
+		z.WithPaxosID(1),
+		z.WithAntiEntropy(time.Second),
+	)
+	defer node1.Stop()
+	node2 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0",
+		z.WithTotalPeers(3),
+		z.WithPaxosID(2),
+		z.WithAntiEntropy(time.Second),
+	)
+	defer node2.Stop()
+	node3 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0",
+		z.WithTotalPeers(3),
+		z.WithPaxosID(3),
+		z.WithAntiEntropy(time.Second),
+	)
+	defer node3.Stop()
+	node4 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0",
+		z.WithTotalPeers(3),
+		z.WithPaxosID(3),
+		z.WithAntiEntropy(time.Second),
+	)
+	defer node4.Stop()
+
+	node1.AddPeer(node2.GetAddr(), node3.GetAddr(), node4.GetAddr())
+	node2.AddPeer(node1.GetAddr(), node3.GetAddr(), node4.GetAddr())
+	node3.AddPeer(node2.GetAddr(), node1.GetAddr(), node4.GetAddr())
+	node4.AddPeer(node1.GetAddr(), node2.GetAddr(), node3.GetAddr())
+
+	// Register the nodes.
+	node1.RegisterUser()
+	node2.RegisterUser()
+	node3.RegisterUser()
+	node4.RegisterUser()
+
+	privateText := "private stuff"
+	recipientMap := map[[32]byte]*rsa.PublicKey{
+		node2.GetHashedPublicKey(): node2.GetPublicKey(node2.GetHashedPublicKey()),
 		node4.GetHashedPublicKey(): node4.GetPublicKey(node4.GetHashedPublicKey()),
 	}
 
-	fmt.Println("encrypted msg to be sent to node2 and node4:", privateText)
-	// Let node 2 share a private message with node 4 & node 2 (itself).
-	encryptedContent, err := content.NewPublicContent(node2.GetUserID(), privateText, utils.Time(), "").Encrypted(recipientMap)
+	fmt.Println("encrypted msg to be sent to node2 and node4:", privateText)
+	// Let node 2 share a private message with node 4 & node 2 (itself).
+	encryptedContent, err := content.NewPublicContent(node2.GetUserID(), privateText, utils.Time(), "").Encrypted(recipientMap)
+	require.NoError(t, err)
+	md, _, err := node2.ShareDownloadableContent(encryptedContent, content.TEXT)
+	time.Sleep(time.Second * 1)
+	require.NoError(t, err)
+	// Node 2 and node 4 should be able to decrypt the message.
+	for _, n := range []z.TestNode{node2, node4} {
+		// Discover everything :p
+		_, err := n.DiscoverContentIDs(content.Filter{})
+		require.NoError(t, err)
+		// Download the shared content.
+		bytes, err := n.DownloadContent(md.ContentID)
+		require.NoError(t, err)
+		decrypted, err := content.ParseContent(bytes).Decrypted(n.GetHashedPublicKey(), n.GetPrivateKey())
+		require.NoError(t, err)
+		require.Equal(t, privateText, decrypted.Text)
+	}
+	// Node 1 and node 3 should not be able to decrypt the message.
+	for _, n := range []z.TestNode{node1, node3} {
+		// Discover everything :p
+		_, err := n.DiscoverContentIDs(content.Filter{})
+		require.NoError(t, err)
+		// Download the shared content.
+		bytes, err := n.DownloadContent(md.ContentID)
+		require.NoError(t, err)
+		_, err = content.ParseContent(bytes).Decrypted(n.GetHashedPublicKey(), n.GetPrivateKey())
+		require.Error(t, err)
+	}
+}
+
+func Test_Partage_Broadcast_Rumor_To_Blocked_User(t *testing.T) {
+	fake := z.NewFakeMessage(t)
+	handler1, _ := fake.GetHandler(t)
+	handler2, _ := fake.GetHandler(t)
+	handler3, _ := fake.GetHandler(t)
+	handler4, _ := fake.GetHandler(t)
+
+	node1 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0", z.WithMessage(fake, handler1), z.WithAntiEntropy(time.Millisecond*50))
+	defer node1.Stop()
+
+	net2 := tcpFac()
+	node2 := z.NewTestNode(t, peerFac, net2, "127.0.0.1:0", z.WithMessage(fake, handler2), z.WithAntiEntropy(time.Millisecond*50))
+	defer node2.Stop()
+
+	net3 := tcpFac()
+	node3 := z.NewTestNode(t, peerFac, net3, "127.0.0.1:0", z.WithMessage(fake, handler3), z.WithAntiEntropy(time.Millisecond*50))
+	defer node3.Stop()
+
+	net4 := tcpFac()
+	node4 := z.NewTestNode(t, peerFac, net4, "127.0.0.1:0", z.WithMessage(fake, handler4), z.WithAntiEntropy(time.Millisecond*50))
+	defer node4.Stop()
+
+	node1.AddPeer(node2.GetAddr())
+	node2.AddPeer(node3.GetAddr())
+	node3.AddPeer(node4.GetAddr())
+	//topology:
+	//A--->B---->C---->D
+
+	//nodeC blocks nodeA
+	node3.BlockUser(node1.GetHashedPublicKey())
+
+	//nodeA broadcasts rumor
+	node1.Broadcast(fake.GetNetMsg(t))
+
+	time.Sleep(time.Second * 1)
+}
+
+func Test_Partage_Unblock_User(t *testing.T) {
+	fake := z.NewFakeMessage(t)
+	handler1, _ := fake.GetHandler(t)
+	handler2, _ := fake.GetHandler(t)
+	handler3, _ := fake.GetHandler(t)
+
+	// Do not use the anti-entropy, so that only the unblocking can bring back the dropped rumors.
+	node1 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0", z.WithMessage(fake, handler1))
+	defer node1.Stop()
+	node2 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0", z.WithMessage(fake, handler2))
+	defer node2.Stop()
+	node3 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0", z.WithMessage(fake, handler3))
+	defer node3.Stop()
+
+	node1.AddPeer(node2.GetAddr())
+	node2.AddPeer(node1.GetAddr(), node3.GetAddr())
+	node3.AddPeer(node2.GetAddr())
+	//topology:
+	//A<--->B<---->C
+
+	//nodeC blocks nodeA
+	node3.BlockUser(node1.GetHashedPublicKey())
+	require.True(t, node3.IsBlocked(node1.GetUserID()))
+
+	//nodeA broadcasts two rumors
+	require.NoError(t, node1.Broadcast(fake.GetNetMsg(t)))
+	time.Sleep(time.Millisecond * 500)
+	require.NoError(t, node1.Broadcast(fake.GetNetMsg(t)))
+	time.Sleep(time.Second)
+
+	require.Len(t, node2.GetFakes(), 2)
+	require.Len(t, node3.GetFakes(), 0)
+
+	//nodeC unblocks nodeA, and should fetch the dropped rumors again
+	node3.UnblockUser(node1.GetHashedPublicKey())
+	require.False(t, node3.IsBlocked(node1.GetUserID()))
+	time.Sleep(time.Second)
+
+	require.Len(t, node3.GetFakes(), 2)
+}
+
+func Test_Partage_Revoke_Certificate(t *testing.T) {
+	fake := z.NewFakeMessage(t)
+	handler1, _ := fake.GetHandler(t)
+	handler2, _ := fake.GetHandler(t)
+	handler3, _ := fake.GetHandler(t)
+	handler4, _ := fake.GetHandler(t)
+
+	node1 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0", z.WithMessage(fake, handler1))
+	defer node1.Stop()
+	node2 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0", z.WithMessage(fake, handler2))
+	defer node2.Stop()
+	node3 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0", z.WithMessage(fake, handler3))
+	defer node3.Stop()
+	node4 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0", z.WithMessage(fake, handler4))
+	defer node4.Stop()
+
+	node1.AddPeer(node2.GetAddr())
+	node2.AddPeer(node1.GetAddr(), node3.GetAddr())
+	node3.AddPeer(node2.GetAddr())
+	//topology:
+	//A<--->B<---->C    D
+
+	require.NoError(t, node1.Broadcast(fake.GetNetMsg(t)))
+	time.Sleep(time.Second)
+	require.Len(t, node3.GetFakes(), 1)
+
+	//nodeA revokes its certificate, and the revocation list reaches nodeC through nodeB
+	require.NoError(t, node1.RevokeCertificate())
+	time.Sleep(time.Second)
+	require.True(t, node1.IsRevoked(node1.GetUserID()))
+	require.True(t, node2.IsRevoked(node1.GetUserID()))
+	require.True(t, node3.IsRevoked(node1.GetUserID()))
+	require.False(t, node3.IsRevoked(node2.GetUserID()))
+
+	//the rumors of nodeA are not accepted anymore
+	require.NoError(t, node1.Broadcast(fake.GetNetMsg(t)))
+	time.Sleep(time.Second)
+	require.Len(t, node2.GetFakes(), 1)
+	require.Len(t, node3.GetFakes(), 1)
+
+	//nodeD fetches the revocation list from the CA, and refuses the connections of nodeA
+	require.False(t, node4.IsRevoked(node1.GetUserID()))
+	require.NoError(t, node4.UpdateRevocationList())
+	require.True(t, node4.IsRevoked(node1.GetUserID()))
+	node1.AddPeer(node4.GetAddr())
+	require.Error(t, node1.Unicast(node4.GetAddr(), fake.GetNetMsg(t)))
+	time.Sleep(time.Millisecond * 500)
+	require.Len(t, node4.GetFakes(), 0)
+}
+
+func Test_Partage_Renew_Certificate(t *testing.T) {
+	fake := z.NewFakeMessage(t)
+	handler1, _ := fake.GetHandler(t)
+	handler2, _ := fake.GetHandler(t)
+	handler3, _ := fake.GetHandler(t)
+
+	node1 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0", z.WithMessage(fake, handler1))
+	defer node1.Stop()
+	node2 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0", z.WithMessage(fake, handler2))
+	defer node2.Stop()
+	node3 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0", z.WithMessage(fake, handler3))
+	defer node3.Stop()
+
+	node1.AddPeer(node2.GetAddr())
+	node2.AddPeer(node1.GetAddr())
+
+	require.NoError(t, node1.Broadcast(fake.GetNetMsg(t)))
+	time.Sleep(time.Second)
+	require.Len(t, node2.GetFakes(), 1)
+
+	// > the CA signs the certificates for less than the year asked by the users
+	sock1 := node1.GetSocket().(*tcptls.Socket)
+	before := sock1.GetCertificate()
+	require.True(t, before.NotAfter.Before(time.Now().Add(364*24*time.Hour)))
+	require.False(t, sock1.ExpiresWithin(24*time.Hour))
+	require.True(t, sock1.ExpiresWithin(365*24*time.Hour))
+
+	// > the renewed certificate is valid for longer, and n1 keeps its identity
+	userID := node1.GetUserID()
+	time.Sleep(time.Second)
+	require.NoError(t, node1.RenewCertificate())
+	after := sock1.GetCertificate()
+	require.NotEqual(t, before.SerialNumber, after.SerialNumber)
+	require.True(t, after.NotAfter.After(before.NotAfter))
+	require.Equal(t, before.PublicKey, after.PublicKey)
+	require.Equal(t, userID, node1.GetUserID())
+
+	// > n1 keeps its connection with n2, and opens new ones with the renewed
+	// certificate
+	require.NoError(t, node1.Broadcast(fake.GetNetMsg(t)))
+	time.Sleep(time.Second)
+	require.Len(t, node2.GetFakes(), 2)
+
+	node1.AddPeer(node3.GetAddr())
+	require.NoError(t, node1.Unicast(node3.GetAddr(), fake.GetNetMsg(t)))
+	time.Sleep(time.Millisecond * 500)
+	require.Len(t, node3.GetFakes(), 1)
+}
+
+// Two identities run side by side from their own profile, and keep it across
+// restarts.
+func Test_Partage_Profiles(t *testing.T) {
+	dir := t.TempDir()
+	alice := utils.NewProfile(filepath.Join(dir, "alice"))
+	bob := utils.NewProfile(filepath.Join(dir, "bob"))
+	newTransport := func(profile utils.Profile, caAddress string) transport.Transport {
+		return tcptls.NewTCPWithOptions(tcptls.Options{
+			Profile:    profile,
+			Persistent: true,
+			CAAddress:  caAddress,
+			Codecs:     tcptls.DefaultCodecs,
+		})
+	}
+
+	fake := z.NewFakeMessage(t)
+	handler1, _ := fake.GetHandler(t)
+	handler2, _ := fake.GetHandler(t)
+
+	node1 := z.NewTestNode(t, peerFac, newTransport(alice, tcptls.DefaultCAAddress), "127.0.0.1:0",
+		z.WithMessage(fake, handler1))
+	// the e-mail addresses of the tests are drawn from the current second
+	time.Sleep(time.Second)
+	node2 := z.NewTestNode(t, peerFac, newTransport(bob, tcptls.DefaultCAAddress), "127.0.0.1:0",
+		z.WithMessage(fake, handler2))
+	defer node2.Stop()
+
+	require.NoError(t, node1.RegisterUser())
+	require.NoError(t, node2.RegisterUser())
+
+	// > each identity is stored in its own profile
+	for _, profile := range []utils.Profile{alice, bob} {
+		require.FileExists(t, profile.CertificatePath())
+		require.FileExists(t, profile.KeyPath())
+		require.FileExists(t, profile.SignaturePath())
+		require.FileExists(t, profile.CACertificatePath())
+	}
+	require.NotEqual(t, node1.GetUserID(), node2.GetUserID())
+
+	node1.AddPeer(node2.GetAddr())
+	require.NoError(t, node1.Unicast(node2.GetAddr(), fake.GetNetMsg(t)))
+	time.Sleep(time.Millisecond * 500)
+	require.Len(t, node2.GetFakes(), 1)
+
+	// > n1 restarts from its profile with the same registered identity
+	userID := node1.GetUserID()
+	require.NoError(t, node1.Stop())
+	node1 = z.NewTestNode(t, peerFac, newTransport(alice, tcptls.DefaultCAAddress), "127.0.0.1:0",
+		z.WithMessage(fake, handler1))
+	defer node1.Stop()
+	require.Equal(t, userID, node1.GetUserID())
+	selfSigned, err := utils.TLSIsSelfSigned(node1.GetSocket().(*tcptls.Socket).GetTLSCertificate())
+	require.NoError(t, err)
+	require.False(t, selfSigned)
+
+	node1.AddPeer(node2.GetAddr())
+	require.NoError(t, node1.Unicast(node2.GetAddr(), fake.GetNetMsg(t)))
+	time.Sleep(time.Millisecond * 500)
+	require.Len(t, node2.GetFakes(), 2)
+
+	// > the CA is reached at the configured address
+	node3 := z.NewTestNode(t, peerFac, newTransport(utils.NewProfile(filepath.Join(dir, "eve")), "127.0.0.1:1"),
+		"127.0.0.1:0")
+	defer node3.Stop()
+	sock3 := node3.GetSocket().(*tcptls.Socket)
+	require.Error(t, sock3.RegisterUser())
+	selfSigned, err = utils.TLSIsSelfSigned(sock3.GetTLSCertificate())
+	require.NoError(t, err)
+	require.True(t, selfSigned)
+}
+
+// A peer that restarts on the same profile and storage reloads its registration,
+// feeds and data, and takes part in the consensus again.
+func Test_Partage_Restart(t *testing.T) {
+	dir := t.TempDir()
+	profile := utils.NewProfile(filepath.Join(dir, "profile"))
+	newPersistentNode := func() z.TestNode {
+		persistency, err := file.NewPersistency(filepath.Join(dir, "data"))
+		require.NoError(t, err)
+		blockchainStorage, err := file.NewPersistentMultipurposeStorage(filepath.Join(dir, "data"))
+		require.NoError(t, err)
+		trans := tcptls.NewTCPWithOptions(tcptls.Options{
+			Profile:    profile,
+			Persistent: true,
+			CAAddress:  tcptls.DefaultCAAddress,
+			Codecs:     tcptls.DefaultCodecs,
+		})
+		return z.NewTestNode(t, peerFac, trans, "127.0.0.1:0",
+			z.WithTotalPeers(3),
+			z.WithAntiEntropy(time.Second),
+			z.WithStorage(persistency),
+			z.WithBlockchainStorage(blockchainStorage),
+		)
+	}
+
+	node1 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0",
+		z.WithTotalPeers(3),
+		z.WithPaxosID(1),
+		z.WithAntiEntropy(time.Second),
+	)
+	defer node1.Stop()
+	node2 := newPersistentNode()
+	node3 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0",
+		z.WithTotalPeers(3),
+		z.WithPaxosID(3),
+		z.WithAntiEntropy(time.Second),
+	)
+	defer node3.Stop()
+
+	node1.AddPeer(node2.GetAddr(), node3.GetAddr())
+	node2.AddPeer(node1.GetAddr(), node3.GetAddr())
+	node3.AddPeer(node2.GetAddr(), node1.GetAddr())
+
+	require.NoError(t, node1.RegisterUser())
+	require.NoError(t, node2.RegisterUser())
+	require.NoError(t, node3.RegisterUser())
+	time.Sleep(time.Second)
+
+	// The second node shares a post, sets its username, follows the first node and uploads a file.
+	_, err := node2.UpdateFeed(content.CreateTextMetadata(node2.GetUserID(), utils.Time(), "persisted"))
+	require.NoError(t, err)
+	_, err = node2.UpdateFeed(content.CreateChangeUsernameMetadata(node2.GetUserID(), utils.Time(), "Restarter"))
+	require.NoError(t, err)
+	_, err = node2.UpdateFeed(content.CreateFollowUserMetadata(node2.GetUserID(), utils.Time(), node1.GetUserID()))
+	require.NoError(t, err)
+	metahash, err := node2.Upload(bytes.NewBufferString("kept on disk"))
+	require.NoError(t, err)
+	time.Sleep(time.Second)
+
+	// > the second node stops, and is rebuilt on the same profile and storage.
+	userID := node2.GetUserID()
+	require.NoError(t, node2.Stop())
+	node2 = newPersistentNode()
+	defer node2.Stop()
+	require.Equal(t, userID, node2.GetUserID())
+	require.Len(t, node2.GetKnownUsers(), 3)
+	state := node2.GetUserState(userID)
+	require.Equal(t, "Restarter", state.Username)
+	require.Contains(t, state.Followees, node1.GetUserID())
+	posts := node2.GetFeedContents(userID)
+	require.Len(t, posts, 3)
+	require.Equal(t, "persisted", string(posts[0].Data))
+	require.NotNil(t, node2.GetStorage().GetDataBlobStore().Get(metahash))
+
+	// > it resumes its feed where it stopped, and keeps up with the feeds of the others.
+	node2.AddPeer(node1.GetAddr(), node3.GetAddr())
+	node1.AddPeer(node2.GetAddr())
+	node3.AddPeer(node2.GetAddr())
+	_, err = node2.UpdateFeed(content.CreateTextMetadata(userID, utils.Time(), "after restart"))
+	require.NoError(t, err)
+	_, err = node1.UpdateFeed(content.CreateTextMetadata(node1.GetUserID(), utils.Time(), "hello again"))
+	require.NoError(t, err)
+	time.Sleep(time.Second)
+	for _, n := range []z.TestNode{node1, node2, node3} {
+		require.Len(t, n.GetFeedContents(userID), 4)
+		require.Len(t, n.GetFeedContents(node1.GetUserID()), 1)
+	}
+}
+
+// A peer that sends corrupted chunks is penalized for a while, and the chunks
+// are fetched from another owner.
+func Test_Partage_Download_Corrupted_Chunk(t *testing.T) {
+	transp := channel.NewTransport()
+
+	var now int64 = 1000
+	clock := func() int64 { return atomic.LoadInt64(&now) }
+	node1 := z.NewTestNode(t, peerFac, transp, "127.0.0.1:0", z.WithDataRequestBackoff(time.Millisecond*100, 2, 2),
+		z.WithClock(clock))
+	defer node1.Stop()
+	node2 := z.NewTestNode(t, peerFac, transp, "127.0.0.1:0")
+	defer node2.Stop()
+	node3 := z.NewTestNode(t, peerFac, transp, "127.0.0.1:0")
+	defer node3.Stop()
+
+	node1.AddPeer(node2.GetAddr(), node3.GetAddr())
+	node2.AddPeer(node1.GetAddr())
+	node3.AddPeer(node1.GetAddr())
+
+	chunks := [][]byte{{'a', 'a', 'a'}, {'b', 'b', 'b'}}
+	data := append(chunks[0], chunks[1]...)
+	c1 := "9834876dcfb05cb167a5c24953eba58c4ac89b1adf57f28f2f9d09af107ee8f0"
+	c2 := "3e744b9dc39389baf0c5a0660589b8402f3dbb49b89b3e75f2c9355852a3c677"
+	mh := "6a0b1d67884e58786e97bc51544cbba4cc3e1279d8ff46da2fa32bcdb44a053e"
+
+	// node2 has a poisoned chunk, node3 has the genuine file
+	storage := node2.GetStorage().GetDataBlobStore()
+	storage.Set(c1, []byte("evil"))
+	storage.Set(c2, chunks[1])
+	storage.Set(mh, []byte(fmt.Sprintf("%s\n%s", c1, c2)))
+	storage = node3.GetStorage().GetDataBlobStore()
+	storage.Set(c1, chunks[0])
+	storage.Set(c2, chunks[1])
+	storage.Set(mh, []byte(fmt.Sprintf("%s\n%s", c1, c2)))
+
+	// > node2 is the only owner: the download fails and nothing is stored
+	node1.UpdateCatalog(c1, node2.GetAddr())
+	node1.UpdateCatalog(c2, node2.GetAddr())
+	node1.UpdateCatalog(mh, node2.GetAddr())
+	_, err := node1.Download(mh)
+	require.Error(t, err)
+	require.Nil(t, node1.GetStorage().GetDataBlobStore().Get(c1))
+
+	// > node2 is out of the catalog, and its updates are ignored
+	node1.UpdateCatalog(c1, node2.GetAddr())
+	for _, owners := range node1.GetCatalog() {
+		require.NotContains(t, owners, node2.GetAddr())
+	}
+
+	// > the file is fetched from node3
+	node1.UpdateCatalog(c1, node3.GetAddr())
+	node1.UpdateCatalog(c2, node3.GetAddr())
+	node1.UpdateCatalog(mh, node3.GetAddr())
+	buf, err := node1.Download(mh)
+	require.NoError(t, err)
+	require.Equal(t, data, buf)
+	require.Equal(t, chunks[0], node1.GetStorage().GetDataBlobStore().Get(c1))
+
+	// > once the penalty is over, node2 is back in the catalog
+	atomic.AddInt64(&now, 3600)
+	node1.UpdateCatalog(c1, node2.GetAddr())
+	require.Contains(t, node1.GetCatalog()[c1], node2.GetAddr())
+}
+
+// A metafile whose chunk hashes are not in lower-case hex is rejected, even
+// though it decodes to the genuine chunk hashes, so that it is neither stored
+// nor served to other peers.
+func Test_Partage_Download_Case_Mangled_Metafile(t *testing.T) {
+	transp := channel.NewTransport()
+
+	node1 := z.NewTestNode(t, peerFac, transp, "127.0.0.1:0", z.WithDataRequestBackoff(time.Millisecond*100, 2, 2))
+	defer node1.Stop()
+	node2 := z.NewTestNode(t, peerFac, transp, "127.0.0.1:0")
+	defer node2.Stop()
+
+	node1.AddPeer(node2.GetAddr())
+	node2.AddPeer(node1.GetAddr())
+
+	chunks := [][]byte{{'a', 'a', 'a'}, {'b', 'b', 'b'}}
+	c1 := "9834876dcfb05cb167a5c24953eba58c4ac89b1adf57f28f2f9d09af107ee8f0"
+	c2 := "3e744b9dc39389baf0c5a0660589b8402f3dbb49b89b3e75f2c9355852a3c677"
+	mh := "6a0b1d67884e58786e97bc51544cbba4cc3e1279d8ff46da2fa32bcdb44a053e"
+
+	// > the metafile of node2 has its chunk hashes in upper case
+	mangled := fmt.Sprintf("%s\n%s", strings.ToUpper(c1), strings.ToUpper(c2))
+	require.False(t, utils.VerifyData(mh, []byte(mangled), peer.MetafileSep))
+	require.True(t, utils.VerifyData(mh, []byte(fmt.Sprintf("%s\n%s", c1, c2)), peer.MetafileSep))
+
+	storage := node2.GetStorage().GetDataBlobStore()
+	storage.Set(c1, chunks[0])
+	storage.Set(c2, chunks[1])
+	storage.Set(mh, []byte(mangled))
+
+	node1.UpdateCatalog(c1, node2.GetAddr())
+	node1.UpdateCatalog(c2, node2.GetAddr())
+	node1.UpdateCatalog(mh, node2.GetAddr())
+	_, err := node1.Download(mh)
+	require.Error(t, err)
+	require.Nil(t, node1.GetStorage().GetDataBlobStore().Get(mh))
+}
+
+// The chunks are downloaded concurrently from all their owners, and the
+// download resumes from the chunks fetched before a failure.
+func Test_Partage_Download_Parallel_Resume(t *testing.T) {
+	transp := channel.NewTransport()
+	opts := []z.Option{
+		z.WithChunkSize(64),
+		z.WithDownloadWorkers(8, 2),
+		z.WithDataRequestBackoff(time.Millisecond*100, 2, 2),
+	}
+
+	node1 := z.NewTestNode(t, peerFac, transp, "127.0.0.1:0", opts...)
+	defer node1.Stop()
+	owners := make([]z.TestNode, 3)
+	for i := range owners {
+		owners[i] = z.NewTestNode(t, peerFac, transp, "127.0.0.1:0", opts...)
+		defer owners[i].Stop()
+		node1.AddPeer(owners[i].GetAddr())
+		owners[i].AddPeer(node1.GetAddr())
+	}
+
+	data := make([]byte, 64*32)
+	rand.Read(data)
+	var mh string
+	var err error
+	for _, owner := range owners {
+		mh, err = owner.Upload(bytes.NewReader(data))
+		require.NoError(t, err)
+	}
+	chunkHashes := strings.Split(string(owners[0].GetStorage().GetDataBlobStore().Get(mh)), peer.MetafileSep)
+	require.Len(t, chunkHashes, 32)
+
+	// > the only known owner lost half of the chunks: the download fails, but
+	// the chunks fetched so far are kept
+	for i := 1; i < len(chunkHashes); i += 2 {
+		owners[0].GetStorage().GetDataBlobStore().Delete(chunkHashes[i])
+	}
+	node1.UpdateCatalog(mh, owners[0].GetAddr())
+	for _, chunkHash := range chunkHashes {
+		node1.UpdateCatalog(chunkHash, owners[0].GetAddr())
+	}
+	_, err = node1.Download(mh)
+	require.Error(t, err)
+
+	kept := make(map[string]struct{})
+	for _, chunkHash := range chunkHashes {
+		if node1.GetStorage().GetDataBlobStore().Get(chunkHash) != nil {
+			kept[chunkHash] = struct{}{}
+		}
+	}
+	require.NotEmpty(t, kept)
+
+	// > once the other owners are known, the missing chunks are fetched from
+	// all of them
+	seenIns := make([]int, len(owners))
+	for i, owner := range owners {
+		seenIns[i] = len(owner.GetIns())
+		for _, chunkHash := range chunkHashes {
+			node1.UpdateCatalog(chunkHash, owner.GetAddr())
+		}
+	}
+	buf, err := node1.Download(mh)
 	require.NoError(t, err)
-	md, _, err := node2.ShareDownloadableContent(encryptedContent, content.TEXT)
-	time.Sleep(time.Second * 1)
+	require.Equal(t, data, buf)
+
+	for i, owner := range owners {
+		requests := 0
+		for _, pkt := range owner.GetIns()[seenIns[i]:] {
+			if pkt.Msg.Type != "datarequest" {
+				continue
+			}
+			msg := z.GetDataRequest(t, pkt.Msg)
+			require.NotContains(t, kept, msg.Key)
+			requests++
+		}
+		if i > 0 {
+			require.NotZero(t, requests)
+		}
+	}
+}
+
+// A stream of unknown length is uploaded chunk after chunk, and downloaded to
+// a writer, with progress reports.
+func Test_Partage_Streaming_Transfer(t *testing.T) {
+	transp := channel.NewTransport()
+
+	node1 := z.NewTestNode(t, peerFac, transp, "127.0.0.1:0", z.WithChunkSize(64), z.WithDownloadWorkers(4, 2))
+	defer node1.Stop()
+	node2 := z.NewTestNode(t, peerFac, transp, "127.0.0.1:0", z.WithChunkSize(64), z.WithDownloadWorkers(4, 2))
+	defer node2.Stop()
+
+	node1.AddPeer(node2.GetAddr())
+	node2.AddPeer(node1.GetAddr())
+
+	data := make([]byte, 64*20+10)
+	rand.Read(data)
+
+	// > the stream is split in full chunks, however it is read
+	var uploads [][2]uint
+	mh, err := node1.UploadFrom(iotest.OneByteReader(bytes.NewReader(data)), func(done, total uint) {
+		uploads = append(uploads, [2]uint{done, total})
+	})
 	require.NoError(t, err)
-	// Node 2 and node 4 should be able to decrypt the message.
-	for _, n := range []z.TestNode{node2, node4} {
-		// Discover everything :p
-		_, err := n.DiscoverContentIDs(content.Filter{})
-		require.NoError(t, err)
-		// Download the shared content.
-		bytes, err := n.DownloadContent(md.ContentID)
-		require.NoError(t, err)
-		decrypted, err := content.ParseContent(bytes).Decrypted(n.GetHashedPublicKey(), n.GetPrivateKey())
-		require.NoError(t, err)
-		require.Equal(t, privateText, decrypted.Text)
+	_, expectedMh, err := utils.Chunkify(64, peer.MetafileSep, bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, expectedMh, mh)
+	require.Len(t, uploads, 22)
+	require.Equal(t, [2]uint{1, 0}, uploads[0])
+	require.Equal(t, [2]uint{21, 0}, uploads[20])
+	require.Equal(t, [2]uint{21, 21}, uploads[21])
+
+	// > node2 writes the file as it downloads it
+	node2.UpdateCatalog(mh, node1.GetAddr())
+	for _, chunkHash := range strings.Split(string(node1.GetStorage().GetDataBlobStore().Get(mh)), peer.MetafileSep) {
+		node2.UpdateCatalog(chunkHash, node1.GetAddr())
 	}
-	// Node 1 and node 3 should not be able to decrypt the message.
-	for _, n := range []z.TestNode{node1, node3} {
-		// Discover everything :p
-		_, err := n.DiscoverContentIDs(content.Filter{})
-		require.NoError(t, err)
-		// Download the shared content.
-		bytes, err := n.DownloadContent(md.ContentID)
+	var buf bytes.Buffer
+	var downloads [][2]uint
+	err = node2.DownloadTo(mh, &buf, func(done, total uint) {
+		written := int(done) * 64
+		if written > len(data) {
+			written = len(data)
+		}
+		require.Equal(t, written, buf.Len())
+		downloads = append(downloads, [2]uint{done, total})
+	})
+	require.NoError(t, err)
+	require.Equal(t, data, buf.Bytes())
+	require.Len(t, downloads, 22)
+	require.Equal(t, [2]uint{0, 21}, downloads[0])
+	require.Equal(t, [2]uint{21, 21}, downloads[21])
+
+	// > a failing writer stops the download
+	reader, writer := io.Pipe()
+	reader.Close()
+	require.Error(t, node2.DownloadTo(mh, writer, nil))
+}
+
+// Content-defined chunks survive an edit at the start of the data, and are
+// downloaded like fixed-size chunks.
+func Test_Partage_Content_Defined_Chunking(t *testing.T) {
+	transp := channel.NewTransport()
+
+	node1 := z.NewTestNode(t, peerFac, transp, "127.0.0.1:0", z.WithContentDefinedChunking(64, 256, 1024))
+	defer node1.Stop()
+	node2 := z.NewTestNode(t, peerFac, transp, "127.0.0.1:0")
+	defer node2.Stop()
+
+	node1.AddPeer(node2.GetAddr())
+	node2.AddPeer(node1.GetAddr())
+
+	data := make([]byte, 1<<15)
+	rand.Read(data)
+	edited := append([]byte("an edit at the start of the file"), data...)
+
+	mh1, err := node1.Upload(bytes.NewReader(data))
+	require.NoError(t, err)
+	mh2, err := node1.Upload(bytes.NewReader(edited))
+	require.NoError(t, err)
+
+	store := node1.GetStorage().GetDataBlobStore()
+	chunks1 := strings.Split(string(store.Get(mh1)), peer.MetafileSep)
+	chunks2 := strings.Split(string(store.Get(mh2)), peer.MetafileSep)
+
+	// > the chunks are within bounds
+	for i, chunkHash := range chunks2 {
+		size := len(store.Get(chunkHash))
+		require.LessOrEqual(t, size, 1024)
+		if i < len(chunks2)-1 {
+			require.GreaterOrEqual(t, size, 64)
+		}
+	}
+
+	// > most of the chunks are shared by both versions
+	known := make(map[string]struct{})
+	for _, chunkHash := range chunks1 {
+		known[chunkHash] = struct{}{}
+	}
+	shared := 0
+	for _, chunkHash := range chunks2 {
+		if _, ok := known[chunkHash]; ok {
+			shared++
+		}
+	}
+	require.Greater(t, shared, len(chunks1)*3/4)
+
+	// > node2 downloads the file without knowing how it was chunked
+	node2.UpdateCatalog(mh2, node1.GetAddr())
+	for _, chunkHash := range chunks2 {
+		node2.UpdateCatalog(chunkHash, node1.GetAddr())
+	}
+	buf, err := node2.Download(mh2)
+	require.NoError(t, err)
+	require.Equal(t, edited, buf)
+}
+
+// The content-defined chunks of random data are Average bytes long on
+// average.
+func Test_Partage_Content_Defined_Chunking_Average(t *testing.T) {
+	sizes := []struct{ min, average, max uint }{
+		{64, 256, 1024},
+		{256, 1024, 4096},
+		{2048, 8192, 65536},
+	}
+	for _, size := range sizes {
+		data := make([]byte, 1<<22)
+		rand.Read(data)
+		chunker, err := utils.NewContentDefinedChunker(bytes.NewReader(data), size.min, size.average, size.max)
 		require.NoError(t, err)
-		_, err = content.ParseContent(bytes).Decrypted(n.GetHashedPublicKey(), n.GetPrivateKey())
-		require.Error(t, err)
+		n := 0
+		for {
+			_, err := chunker.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			n++
+		}
+		mean := float64(len(data)) / float64(n)
+		require.InEpsilon(t, float64(size.average), mean, 0.1)
 	}
+
+	// > the average must be above the minimum
+	_, err := utils.NewContentDefinedChunker(bytes.NewReader(nil), 256, 256, 1024)
+	require.Error(t, err)
 }
 
-func Test_Partage_Broadcast_Rumor_To_Blocked_User(t *testing.T) {
-	fake := z.NewFakeMessage(t)
-	handler1, _ := fake.GetHandler(t)
-	handler2, _ := fake.GetHandler(t)
-	handler3, _ := fake.GetHandler(t)
-	handler4, _ := fake.GetHandler(t)
+// A file is found through the DHT by a peer that doesn't know any of its
+// owners, without flooding the network with search requests.
+func Test_Partage_DHT_Lookup(t *testing.T) {
+	transp := channel.NewTransport()
+	opts := []z.Option{
+		z.WithChunkSize(64),
+		z.WithDHT(20, 0),
+	}
 
-	node1 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0", z.WithMessage(fake, handler1), z.WithAntiEntropy(time.Millisecond*50))
+	nodes := make([]z.TestNode, 6)
+	for i := range nodes {
+		nodes[i] = z.NewTestNode(t, peerFac, transp, "127.0.0.1:0", opts...)
+		defer nodes[i].Stop()
+	}
+	// > the nodes form a chain, and can reach each other through their
+	// neighbors
+	for i := range nodes {
+		for j := range nodes {
+			switch {
+			case j == i-1 || j == i+1:
+				nodes[i].AddPeer(nodes[j].GetAddr())
+			case j < i:
+				nodes[i].SetRoutingEntry(nodes[j].GetAddr(), nodes[i-1].GetAddr())
+			case j > i:
+				nodes[i].SetRoutingEntry(nodes[j].GetAddr(), nodes[i+1].GetAddr())
+			}
+		}
+	}
+
+	data := make([]byte, 64*8)
+	rand.Read(data)
+	owner := nodes[len(nodes)-1]
+	mh, err := owner.Upload(bytes.NewReader(data))
+	require.NoError(t, err)
+
+	// > wait for the provider record to be announced
+	time.Sleep(time.Second)
+
+	// > an unknown file can't be found
+	_, err = nodes[0].Download(hex.EncodeToString(make([]byte, 32)))
+	require.Error(t, err)
+
+	buf, err := nodes[0].Download(mh)
+	require.NoError(t, err)
+	require.Equal(t, data, buf)
+
+	for _, pkt := range nodes[0].GetOuts() {
+		require.NotEqual(t, "searchrequest", pkt.Msg.Type)
+		if pkt.Msg.Type == "datarequest" {
+			require.Equal(t, owner.GetAddr(), pkt.Header.Destination)
+		}
+	}
+
+	// > the downloader becomes a provider of the file, and serves it once the
+	// first owner lost it
+	time.Sleep(time.Second)
+	var keys []string
+	owner.GetStorage().GetDataBlobStore().ForEach(func(key string, val []byte) bool {
+		keys = append(keys, key)
+		return true
+	})
+	for _, key := range keys {
+		owner.GetStorage().GetDataBlobStore().Delete(key)
+	}
+	buf, err = nodes[1].Download(mh)
+	require.NoError(t, err)
+	require.Equal(t, data, buf)
+}
+
+// A peer that announces itself as the provider of someone else's public key
+// and answers with its own CA-signed key is ignored, and the right key is
+// found by flooding the network.
+func Test_Partage_DHT_Wrong_Public_Key(t *testing.T) {
+	// > node1 is not part of the DHT, so the attacker is the only provider of
+	// its public key
+	node1 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0")
 	defer node1.Stop()
+	node2 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0", z.WithDHT(20, 0))
+	defer node2.Stop()
+	attacker := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0", z.WithDHT(20, 0))
+	defer attacker.Stop()
+
+	node1.AddPeer(node2.GetAddr(), attacker.GetAddr())
+	node2.AddPeer(node1.GetAddr(), attacker.GetAddr())
+	attacker.AddPeer(node1.GetAddr(), node2.GetAddr())
+
+	// > the attacker answers every search request with its own CA-signed key
+	attackerPK := attacker.GetSocket().(*tcptls.Socket).GetSignedPublicKey()
+	attacker.GetRegistry().RegisterMessageCallback(types.SearchPKRequestMessage{},
+		func(msg types.Message, pkt transport.Packet) error {
+			request := msg.(*types.SearchPKRequestMessage)
+			reply := types.SearchPKReplyMessage{RequestID: request.RequestID, Response: *attackerPK}
+			transpMsg, err := attacker.GetRegistry().MarshalMessage(&reply)
+			require.NoError(t, err)
+			return attacker.Unicast(request.Origin, transpMsg)
+		})
+	provideMsg, err := attacker.GetRegistry().MarshalMessage(&dht.ProvideMessage{Key: dht.PublicKeyKey(node1.GetHashedPublicKey())})
+	require.NoError(t, err)
+	require.NoError(t, attacker.Unicast(node2.GetAddr(), provideMsg))
+	time.Sleep(100 * time.Millisecond)
+
+	publicKey := node2.GetPublicKey(node1.GetHashedPublicKey())
+	require.NotNil(t, publicKey)
+	require.Equal(t, node1.GetHashedPublicKey(), utils.HashPublicKey(publicKey))
+
+	// > the attacker did answer, and its key was not saved for node1
+	replied := false
+	for _, pkt := range node2.GetIns() {
+		if pkt.Msg.Type == "searchpkreply" && pkt.Header.Source == attacker.GetAddr() {
+			replied = true
+		}
+	}
+	require.True(t, replied)
+	require.Equal(t, node1.GetHashedPublicKey(), utils.HashPublicKey(node2.GetPublicKey(node1.GetHashedPublicKey())))
+}
 
-	net2 := tcpFac()
-	node2 := z.NewTestNode(t, peerFac, net2, "127.0.0.1:0", z.WithMessage(fake, handler2), z.WithAntiEntropy(time.Millisecond*50))
+// The blob store is kept within its quota by evicting the least recently used
+// blobs, except the pinned ones.
+func Test_Partage_Blob_Store_Quota(t *testing.T) {
+	transp := channel.NewTransport()
+
+	// > a file of 4 chunks of 64 bytes has a metafile of 4 hashes of 64 hex
+	// characters and 3 separators, and the quota holds 2 such files
+	fileSize := uint(4*64 + 4*64 + 3)
+	node1 := z.NewTestNode(t, peerFac, transp, "127.0.0.1:0", z.WithChunkSize(64), z.WithBlobStore(2*fileSize, 0))
+	defer node1.Stop()
+	node2 := z.NewTestNode(t, peerFac, transp, "127.0.0.1:0", z.WithChunkSize(64))
 	defer node2.Stop()
+	node1.AddPeer(node2.GetAddr())
+	node2.AddPeer(node1.GetAddr())
 
-	net3 := tcpFac()
-	node3 := z.NewTestNode(t, peerFac, net3, "127.0.0.1:0", z.WithMessage(fake, handler3), z.WithAntiEntropy(time.Millisecond*50))
-	defer node3.Stop()
+	// upload makes a new file of 4 chunks available on node2
+	upload := func() string {
+		data := make([]byte, 4*64)
+		rand.Read(data)
+		mh, err := node2.Upload(bytes.NewReader(data))
+		require.NoError(t, err)
+		node1.UpdateCatalog(mh, node2.GetAddr())
+		for _, chunkHash := range strings.Split(string(node2.GetStorage().GetDataBlobStore().Get(mh)), peer.MetafileSep) {
+			node1.UpdateCatalog(chunkHash, node2.GetAddr())
+		}
+		return mh
+	}
+	// stored returns the number of blobs of the file stored on node1
+	stored := func(mh string) int {
+		blobStore := node1.GetStorage().GetDataBlobStore()
+		metafile := node2.GetStorage().GetDataBlobStore().Get(mh)
+		n := 0
+		for _, key := range append(strings.Split(string(metafile), peer.MetafileSep), mh) {
+			if blobStore.Get(key) != nil {
+				n++
+			}
+		}
+		return n
+	}
+	mhA, mhB, mhC, mhD := upload(), upload(), upload(), upload()
 
-	net4 := tcpFac()
-	node4 := z.NewTestNode(t, peerFac, net4, "127.0.0.1:0", z.WithMessage(fake, handler4), z.WithAntiEntropy(time.Millisecond*50))
-	defer node4.Stop()
+	// > B is the least recently used file when C is downloaded
+	for _, mh := range []string{mhA, mhB, mhA, mhC} {
+		_, err := node1.Download(mh)
+		require.NoError(t, err)
+	}
+	require.Equal(t, 5, stored(mhA))
+	require.Equal(t, 0, stored(mhB))
+	require.Equal(t, 5, stored(mhC))
 
-	node1.AddPeer(node2.GetAddr())
-	node2.AddPeer(node3.GetAddr())
-	node3.AddPeer(node4.GetAddr())
-	//topology:
-	//A--->B---->C---->D
+	// > C is pinned, so A is evicted instead
+	node1.Pin(mhC)
+	_, err := node1.Download(mhD)
+	require.NoError(t, err)
+	require.Equal(t, 0, stored(mhA))
+	require.Equal(t, 5, stored(mhC))
+	require.Equal(t, 5, stored(mhD))
+
+	// > the uploads are pinned
+	data := make([]byte, 4*64)
+	rand.Read(data)
+	mhE, err := node1.Upload(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, 5, stored(mhC))
+	require.Equal(t, 0, stored(mhD))
+	require.NotNil(t, node1.GetStorage().GetDataBlobStore().Get(mhE))
+	buf, err := node1.Download(mhE)
+	require.NoError(t, err)
+	require.Equal(t, data, buf)
+}
 
-	//nodeC blocks nodeA
-	node3.BlockUser(node1.GetHashedPublicKey())
+// The garbage collection does not remove the chunks of an ongoing upload,
+// although they are not referenced yet.
+func Test_Partage_Blob_Store_GC_During_Upload(t *testing.T) {
+	transp := channel.NewTransport()
 
-	//nodeA broadcasts rumor
-	node1.Broadcast(fake.GetNetMsg(t))
+	node1 := z.NewTestNode(t, peerFac, transp, "127.0.0.1:0", z.WithChunkSize(64))
+	defer node1.Stop()
 
-	time.Sleep(time.Second * 1)
+	// > collect the garbage once half of the chunks are stored
+	data := make([]byte, 4*64)
+	rand.Read(data)
+	collected := false
+	mh, err := node1.UploadFrom(bytes.NewReader(data), func(done, total uint) {
+		if done == 2 && total == 0 {
+			require.Zero(t, node1.CollectGarbage())
+			collected = true
+		}
+	})
+	require.NoError(t, err)
+	require.True(t, collected)
+
+	// > the file is complete
+	blobStore := node1.GetStorage().GetDataBlobStore()
+	for _, chunkHash := range strings.Split(string(blobStore.Get(mh)), peer.MetafileSep) {
+		require.NotNil(t, blobStore.Get(chunkHash))
+	}
+	buf, err := node1.Download(mh)
+	require.NoError(t, err)
+	require.Equal(t, data, buf)
 }
 
 // A simple send/recv using tls
@@ -1069,6 +2984,143 @@ func Test_Partage_Messaging_Broadcast_Rumor_Simple(t *testing.T) {
 	t.Run("TCP+TLS transport", getTest(tcpFac()))
 }
 
+// A node that supports both codecs and marshals its messages in binary should
+// be able to talk with a node that only supports JSON: the connections fall
+// back to JSON, and the binary payloads are still decoded. A node that only
+// supports the binary codec can't connect to the JSON-only node.
+func Test_Partage_Codec_Negotiation(t *testing.T) {
+	node1 := z.NewTestNode(t, peerFac, tcptls.NewTCPWithCodecs(false, codec.Binary, codec.JSON), "127.0.0.1:0",
+		z.WithMessageRegistry(standard.NewRegistryWithCodec(codec.Binary)))
+	defer node1.Stop()
+
+	node2 := z.NewTestNode(t, peerFac, tcptls.NewTCPWithCodecs(false, codec.JSON), "127.0.0.1:0")
+	defer node2.Stop()
+
+	node3 := z.NewTestNode(t, peerFac, tcptls.NewTCPWithCodecs(false, codec.Binary), "127.0.0.1:0",
+		z.WithMessageRegistry(standard.NewRegistryWithCodec(codec.Binary)))
+	defer node3.Stop()
+
+	node1.AddPeer(node2.GetAddr())
+	node2.AddPeer(node1.GetAddr())
+	node3.AddPeer(node2.GetAddr())
+
+	chat1 := types.ChatMessage{Message: "binary"}
+	msg1, err := node1.GetRegistry().MarshalMessage(&chat1)
+	require.NoError(t, err)
+	require.True(t, codec.IsBinary(msg1.Payload))
+	err = node1.Broadcast(msg1)
+	require.NoError(t, err)
+
+	chat2 := types.ChatMessage{Message: "json"}
+	msg2, err := node2.GetRegistry().MarshalMessage(&chat2)
+	require.NoError(t, err)
+	require.False(t, codec.IsBinary(msg2.Payload))
+	err = node2.Broadcast(msg2)
+	require.NoError(t, err)
+
+	time.Sleep(time.Second * 2)
+
+	// > both nodes should have processed both messages
+
+	require.ElementsMatch(t, []*types.ChatMessage{&chat1, &chat2}, node1.GetChatMsgs())
+	require.ElementsMatch(t, []*types.ChatMessage{&chat1, &chat2}, node2.GetChatMsgs())
+
+	// > node3 has no codec in common with node2
+
+	err = node3.Unicast(node2.GetAddr(), msg1)
+	require.Error(t, err)
+	require.Empty(t, node3.GetOuts())
+}
+
+// The nodes should authenticate the packets they exchange with a MAC instead of
+// a signature, since their certificates are signed by the CA. The rumors should
+// still be signed by their origin.
+func Test_Partage_Session_MAC(t *testing.T) {
+	fake := z.NewFakeMessage(t)
+	handler1, status1 := fake.GetHandler(t)
+	handler2, status2 := fake.GetHandler(t)
+
+	node1 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0", z.WithMessage(fake, handler1))
+	defer node1.Stop()
+
+	node2 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0", z.WithMessage(fake, handler2))
+	defer node2.Stop()
+
+	node1.AddPeer(node2.GetAddr())
+
+	err := node1.Broadcast(fake.GetNetMsg(t))
+	require.NoError(t, err)
+
+	time.Sleep(time.Second * 2)
+
+	status1.CheckCalled(t)
+	status2.CheckCalled(t)
+
+	// > n2 should have received a rumor with a MAC and a signed rumor
+
+	n2Ins := node2.GetIns()
+	require.Len(t, n2Ins, 1)
+	pkt := n2Ins[0]
+	require.NotNil(t, pkt.Header.MAC)
+	require.Nil(t, pkt.Header.Check)
+
+	rumor := z.GetRumor(t, pkt.Msg)
+	require.Len(t, rumor.Rumors, 1)
+	require.NotNil(t, rumor.Rumors[0].Check)
+	pkBytes, err := utils.PublicKeyToBytes(rumor.Rumors[0].Check.SrcPublicKey.PublicKey)
+	require.NoError(t, err)
+	require.Equal(t, node1.GetHashedPublicKey(), utils.Hash(pkBytes))
+
+	// > n1 should have received an ack with a MAC, on the connection n2 has
+	// accepted
+
+	n1Ins := node1.GetIns()
+	require.Len(t, n1Ins, 1)
+	pkt = n1Ins[0]
+	require.Equal(t, "ack", pkt.Msg.Type)
+	require.NotNil(t, pkt.Header.MAC)
+	require.Nil(t, pkt.Header.Check)
+}
+
+// The MAC only covers the final hop: a packet relayed to its destination should
+// still carry the signature of its source.
+func Test_Partage_Session_MAC_Relayed(t *testing.T) {
+	fake := z.NewFakeMessage(t)
+	handler3, status3 := fake.GetHandler(t)
+
+	node1 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0")
+	defer node1.Stop()
+
+	node2 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0")
+	defer node2.Stop()
+
+	node3 := z.NewTestNode(t, peerFac, tcpFac(), "127.0.0.1:0", z.WithMessage(fake, handler3))
+	defer node3.Stop()
+
+	node1.AddPeer(node2.GetAddr())
+	node2.AddPeer(node3.GetAddr())
+	node1.SetRoutingEntry(node3.GetAddr(), node2.GetAddr())
+
+	err := node1.Unicast(node3.GetAddr(), fake.GetNetMsg(t))
+	require.NoError(t, err)
+
+	time.Sleep(time.Second)
+
+	status3.CheckCalled(t)
+
+	// > n3 should have received the packet signed by n1, without a MAC
+
+	n3Ins := node3.GetIns()
+	require.Len(t, n3Ins, 1)
+	pkt := n3Ins[0]
+	require.Equal(t, node1.GetAddr(), pkt.Header.Source)
+	require.Nil(t, pkt.Header.MAC)
+	require.NotNil(t, pkt.Header.Check)
+	pkBytes, err := utils.PublicKeyToBytes(pkt.Header.Check.SrcPublicKey.PublicKey)
+	require.NoError(t, err)
+	require.Equal(t, node1.GetHashedPublicKey(), utils.Hash(pkBytes))
+}
+
 // Given the following topology:
 //   A -> B -> C
 // If A broadcast a message, then B should receive it AND then send it to C. C
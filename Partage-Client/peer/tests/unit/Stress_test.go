@@ -6,6 +6,12 @@ import (
 	z "go.dedis.ch/cs438/internal/testing"
 	"go.dedis.ch/cs438/peer/impl/content"
 	"go.dedis.ch/cs438/peer/impl/utils"
+	"go.dedis.ch/cs438/registry/standard"
+	"go.dedis.ch/cs438/transport"
+	"go.dedis.ch/cs438/transport/codec"
+	"go.dedis.ch/cs438/transport/tcptls"
+	"go.dedis.ch/cs438/types"
+	"partage-common/framing"
 	"sync"
 	"testing"
 	"time"
@@ -62,7 +68,7 @@ func Test_Partage_Global_Feed_Compare(t *testing.T) {
 			for _, n := range nodes {
 				wg.Add(1)
 				go func(n z.TestNode) {
-					_, err := n.UpdateFeed(content.CreateChangeUsernameMetadata(n.GetUserID(), "tester"))
+					_, err := n.UpdateFeed(content.CreateChangeUsernameMetadata(n.GetUserID(), utils.Time(), "tester"))
 					require.Nil(t, err)
 					wg.Done()
 				}(n)
@@ -97,7 +103,7 @@ func Test_Partage_Stress(t *testing.T) {
 		// Start the timer.
 		tStart := time.Now()
 		// Propose a block.
-		_, err := nodes[0].UpdateFeed(content.CreateChangeUsernameMetadata(nodes[0].GetUserID(), "tester"))
+		_, err := nodes[0].UpdateFeed(content.CreateChangeUsernameMetadata(nodes[0].GetUserID(), utils.Time(), "tester"))
 		require.Nil(t, err)
 		// End the timer.
 		tEnd := time.Now()
@@ -117,3 +123,72 @@ func Test_Partage_Stress(t *testing.T) {
 		systemSize = systemSize + 5
 	}
 }
+
+// Benchmark_Partage_Codec compares the bytes on the wire and the CPU cost of
+// the codecs on the packets of the rumor tests. Each codec is used on its own,
+// to marshal both the packets and the messages they carry.
+func Benchmark_Partage_Codec(b *testing.B) {
+	for _, c := range []codec.Codec{codec.JSON, codec.Binary} {
+		c := c
+		pkts := captureRumorPackets(b, c)
+		b.Run(c.Name(), func(b *testing.B) {
+			wireBytes := 0
+			for _, pkt := range pkts {
+				buf, err := pkt.MarshalWith(c)
+				require.NoError(b, err)
+				wireBytes += framing.HeaderSize + len(buf)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for _, pkt := range pkts {
+					buf, err := pkt.MarshalWith(c)
+					if err != nil {
+						b.Fatal(err)
+					}
+					var decoded transport.Packet
+					err = decoded.Unmarshal(buf)
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+			b.ReportMetric(float64(len(pkts)), "packets/op")
+			b.ReportMetric(float64(wireBytes), "wire-bytes/op")
+			// The number of packets may vary from one run to the other.
+			b.ReportMetric(float64(wireBytes)/float64(len(pkts)), "wire-bytes/packet")
+		})
+	}
+}
+
+// captureRumorPackets lets three nodes in a line, which only support the given
+// codec, broadcast chat messages. Returns the packets they have sent.
+func captureRumorPackets(b *testing.B, c codec.Codec) []transport.Packet {
+	var nodes []z.TestNode
+	for i := 0; i < 3; i++ {
+		n := z.NewTestNode(b, peerFac, tcptls.NewTCPWithCodecs(false, c), "127.0.0.1:0",
+			z.WithMessageRegistry(standard.NewRegistryWithCodec(c)),
+			z.WithContinueMongering(0),
+		)
+		defer n.Stop()
+		nodes = append(nodes, n)
+	}
+	nodes[0].AddPeer(nodes[1].GetAddr())
+	nodes[1].AddPeer(nodes[0].GetAddr(), nodes[2].GetAddr())
+	nodes[2].AddPeer(nodes[1].GetAddr())
+	for i, n := range nodes {
+		for j := 0; j < 5; j++ {
+			chat := types.ChatMessage{Message: fmt.Sprintf("message %d from node %d", j, i)}
+			msg, err := n.GetRegistry().MarshalMessage(&chat)
+			require.NoError(b, err)
+			err = n.Broadcast(msg)
+			require.NoError(b, err)
+		}
+	}
+	time.Sleep(time.Second * 3)
+	var pkts []transport.Packet
+	for _, n := range nodes {
+		pkts = append(pkts, n.GetOuts()...)
+	}
+	return pkts
+}
@@ -1,10 +1,10 @@
 package registry
 
 import (
-	"encoding/json"
 	"sync"
 
 	"go.dedis.ch/cs438/transport"
+	"go.dedis.ch/cs438/transport/codec"
 	"go.dedis.ch/cs438/types"
 	"golang.org/x/xerrors"
 )
@@ -28,6 +28,14 @@ func init() {
 	GlobalRegistry.Add(types.PaxosPromiseMessage{})
 	GlobalRegistry.Add(types.PaxosPrepareMessage{})
 	GlobalRegistry.Add(types.TLCMessage{})
+	GlobalRegistry.Add(types.RaftRequestVoteMessage{})
+	GlobalRegistry.Add(types.RaftVoteMessage{})
+	GlobalRegistry.Add(types.RaftHeartbeatMessage{})
+	GlobalRegistry.Add(types.RaftForwardMessage{})
+	GlobalRegistry.Add(types.RaftAppendEntryMessage{})
+	GlobalRegistry.Add(types.RaftAppendReplyMessage{})
+	GlobalRegistry.Add(types.RaftCommitMessage{})
+	GlobalRegistry.Add(types.RaftRejectMessage{})
 }
 
 type globalRegistry struct {
@@ -59,7 +67,7 @@ func (r globalRegistry) GetMessage(transpMsg *transport.Message) (types.Message,
 	// which is only used to get an empty message that we can fill.
 	msg := m.NewEmpty()
 
-	err := json.Unmarshal(transpMsg.Payload, &msg)
+	err := codec.Unmarshal(transpMsg.Payload, &msg)
 	if err != nil {
 		return nil, xerrors.Errorf("failed to unmarshal payload: %v", err)
 	}
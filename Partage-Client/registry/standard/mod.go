@@ -1,23 +1,31 @@
 package standard
 
 import (
-	"encoding/json"
 	"reflect"
 	"sync"
 	"time"
 
 	"go.dedis.ch/cs438/registry"
 	"go.dedis.ch/cs438/transport"
+	"go.dedis.ch/cs438/transport/codec"
 	"go.dedis.ch/cs438/types"
 	"golang.org/x/xerrors"
 )
 
-// NewRegistry returns a new initialized registry.
+// NewRegistry returns a new initialized registry, which marshals the messages
+// in JSON.
 func NewRegistry() registry.Registry {
+	return NewRegistryWithCodec(codec.JSON)
+}
+
+// NewRegistryWithCodec returns a new initialized registry, which marshals the
+// messages with the given codec.
+func NewRegistryWithCodec(c codec.Codec) registry.Registry {
 	return &Registry{
 		handlers: make(map[string]registry.Exec),
 		notif:    notifications{},
 		msgs:     messages{},
+		codec:    c,
 	}
 }
 
@@ -30,6 +38,7 @@ type Registry struct {
 	handlers map[string]registry.Exec
 	notif    notifications
 	msgs     messages
+	codec    codec.Codec
 }
 
 // RegisterMessageCallback implements registry.Registry.
@@ -97,7 +106,7 @@ func (r *Registry) ProcessPacket(pkt transport.Packet) error {
 
 // MarshalMessage implements registry.Registry.
 func (r *Registry) MarshalMessage(msg types.Message) (transport.Message, error) {
-	buf, err := json.Marshal(msg)
+	buf, err := r.codec.Marshal(msg)
 	if err != nil {
 		return transport.Message{}, xerrors.Errorf("failed to marshal: %v", err)
 	}
@@ -108,13 +117,14 @@ func (r *Registry) MarshalMessage(msg types.Message) (transport.Message, error)
 	}, nil
 }
 
-// UnmarshalMessage implements registry.Registry.
+// UnmarshalMessage implements registry.Registry. The payload is decoded with
+// the codec it has been marshalled with, which may differ from ours.
 func (r *Registry) UnmarshalMessage(transpMsg *transport.Message, msg types.Message) error {
 	if reflect.ValueOf(msg).Kind() != reflect.Ptr {
 		return xerrors.Errorf("types.Message must be a pointer")
 	}
 
-	return json.Unmarshal(transpMsg.Payload, msg)
+	return codec.Unmarshal(transpMsg.Payload, msg)
 }
 
 // RegisterNotify implements registry.Registry.
@@ -82,6 +82,7 @@ func (s Storage) GetBlockchainStore() storage.Store {
 // MultipurposeStorage implements a file-based multi-purpose storage.
 // - implements storage.MultipurposeStorage
 type MultipurposeStorage struct {
+	*sync.Mutex
 	folderPath string
 	storeMap   map[string]*store
 }
@@ -93,12 +94,16 @@ func NewPersistentMultipurposeStorage(folderPath string) (storage.MultipurposeSt
 		return nil, xerrors.Errorf("failed to create root folder: %v", err)
 	}
 	return MultipurposeStorage{
+		Mutex:      new(sync.Mutex),
 		folderPath: multiPurposeFolderPath,
 		storeMap:   make(map[string]*store),
 	}, nil
 }
 
 func (s MultipurposeStorage) GetStore(id string) storage.Store {
+	s.Lock()
+	defer s.Unlock()
+
 	_, ok := s.storeMap[id]
 	if !ok {
 		f, err := newStore(filepath.Join(s.folderPath, id))
@@ -0,0 +1,567 @@
+package codec
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// binaryMagic starts every binary encoding. It is a UTF-8 continuation byte, so it can't start a JSON document.
+const binaryMagic byte = 0xb1
+
+// errTruncated is returned when the binary data ends in the middle of a value.
+var errTruncated = errors.New("codec: truncated binary data")
+
+var (
+	binaryMarshalerType   = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+	gobEncoderType        = reflect.TypeOf((*gob.GobEncoder)(nil)).Elem()
+	gobDecoderType        = reflect.TypeOf((*gob.GobDecoder)(nil)).Elem()
+)
+
+// IsBinary returns true if the given data has been encoded with the binary codec.
+func IsBinary(data []byte) bool {
+	return len(data) > 0 && data[0] == binaryMagic
+}
+
+// binaryCodec encodes the values without any schema: both ends must decode into the same types as the ones that were
+// encoded. The fields of a struct are encoded in the order of their declaration, without their names. Integers are
+// encoded as varints, and slices, maps and pointers are prefixed by a length or a presence flag, so that nil values
+// survive the round trip.
+type binaryCodec struct{}
+
+// Name implements codec.Codec.
+func (binaryCodec) Name() string {
+	return "binary"
+}
+
+// Marshal implements codec.Codec.
+func (binaryCodec) Marshal(v interface{}) ([]byte, error) {
+	rv := reflect.ValueOf(v)
+	// Like JSON, the top-level pointers are transparent.
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil, errors.New("codec: cannot encode a nil value")
+		}
+		rv = rv.Elem()
+	}
+	e := encoder{buf: []byte{binaryMagic}}
+	err := e.encode(rv)
+	if err != nil {
+		return nil, err
+	}
+	return e.buf, nil
+}
+
+// Unmarshal implements codec.Codec.
+func (binaryCodec) Unmarshal(data []byte, v interface{}) error {
+	if !IsBinary(data) {
+		return errors.New("codec: data is not binary encoded")
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("codec: cannot decode into a non-pointer value")
+	}
+	// Follow the pointers and the non-nil interfaces, e.g. a *types.Message holding a *types.ChatMessage.
+	for {
+		if rv.Kind() == reflect.Ptr {
+			if rv.IsNil() {
+				rv.Set(reflect.New(rv.Type().Elem()))
+			}
+			rv = rv.Elem()
+		} else if rv.Kind() == reflect.Interface && !rv.IsNil() && rv.Elem().Kind() == reflect.Ptr {
+			rv = rv.Elem()
+		} else {
+			break
+		}
+	}
+	d := decoder{buf: data[1:]}
+	err := d.decode(rv)
+	if err != nil {
+		return err
+	}
+	if len(d.buf) > 0 {
+		return fmt.Errorf("codec: %d trailing bytes after the binary data", len(d.buf))
+	}
+	return nil
+}
+
+// typeInfo holds what the codec needs to know about a type.
+type typeInfo struct {
+	// fields are the indices of the encoded fields of a struct, i.e., the exported ones that are not ignored by JSON.
+	fields []int
+	// marshaler is true if the type implements encoding.BinaryMarshaler or gob.GobEncoder, e.g., big.Int or
+	// time.Time.
+	marshaler bool
+	// unmarshaler is true if the type implements encoding.BinaryUnmarshaler or gob.GobDecoder.
+	unmarshaler bool
+	// minSize is the number of bytes the smallest encoding of a value of the type takes. It bounds the number of
+	// elements a slice or a map can announce, so that the decoder does not allocate more than the data can fill.
+	minSize uint64
+}
+
+// typeInfos caches the typeInfo of each type.
+var typeInfos sync.Map
+
+func typeInfoOf(t reflect.Type) *typeInfo {
+	cached, ok := typeInfos.Load(t)
+	if ok {
+		return cached.(*typeInfo)
+	}
+	info := &typeInfo{}
+	if t.Kind() != reflect.Ptr {
+		ptrType := reflect.PtrTo(t)
+		info.marshaler = ptrType.Implements(binaryMarshalerType) || ptrType.Implements(gobEncoderType)
+		info.unmarshaler = ptrType.Implements(binaryUnmarshalerType) || ptrType.Implements(gobDecoderType)
+	}
+	if t.Kind() == reflect.Struct {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" || field.Tag.Get("json") == "-" {
+				continue
+			}
+			info.fields = append(info.fields, i)
+		}
+	}
+	info.minSize = minSizeOf(t, info)
+	typeInfos.Store(t, info)
+	return info
+}
+
+// minSizeOf returns the number of bytes the smallest encoding of a value of the given type takes. The types that
+// contain themselves do so through a pointer, a slice or a map, whose smallest encoding is a single byte, so that the
+// recursion ends.
+func minSizeOf(t reflect.Type, info *typeInfo) uint64 {
+	if info.marshaler || info.unmarshaler {
+		// The length of the marshaled bytes.
+		return 1
+	}
+	switch t.Kind() {
+	case reflect.Float32:
+		return 4
+	case reflect.Float64:
+		return 8
+	case reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return uint64(t.Len())
+		}
+		return uint64(t.Len()) * typeInfoOf(t.Elem()).minSize
+	case reflect.Struct:
+		var size uint64
+		for _, i := range info.fields {
+			size += typeInfoOf(t.Field(i).Type).minSize
+		}
+		return size
+	}
+	// The booleans, the varints, and the length or the presence flag of the strings, slices, maps and pointers.
+	return 1
+}
+
+type encoder struct {
+	buf     []byte
+	scratch [binary.MaxVarintLen64]byte
+}
+
+func (e *encoder) uvarint(x uint64) {
+	n := binary.PutUvarint(e.scratch[:], x)
+	e.buf = append(e.buf, e.scratch[:n]...)
+}
+
+func (e *encoder) varint(x int64) {
+	n := binary.PutVarint(e.scratch[:], x)
+	e.buf = append(e.buf, e.scratch[:n]...)
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uvarint(uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+// length encodes the length of a slice or a map, where 0 stands for nil.
+func (e *encoder) length(v reflect.Value) {
+	if v.IsNil() {
+		e.uvarint(0)
+		return
+	}
+	e.uvarint(uint64(v.Len()) + 1)
+}
+
+func (e *encoder) encode(v reflect.Value) error {
+	t := v.Type()
+	info := typeInfoOf(t)
+	if info.marshaler {
+		b, err := marshal(v)
+		if err != nil {
+			return fmt.Errorf("codec: could not encode %s: %w", t, err)
+		}
+		e.bytes(b)
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			e.buf = append(e.buf, 1)
+		} else {
+			e.buf = append(e.buf, 0)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.varint(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.uvarint(v.Uint())
+	case reflect.Float32:
+		var b [4]byte
+		binary.LittleEndian.PutUint32(b[:], math.Float32bits(float32(v.Float())))
+		e.buf = append(e.buf, b[:]...)
+	case reflect.Float64:
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(v.Float()))
+		e.buf = append(e.buf, b[:]...)
+	case reflect.String:
+		e.uvarint(uint64(v.Len()))
+		e.buf = append(e.buf, v.String()...)
+	case reflect.Ptr:
+		if v.IsNil() {
+			e.buf = append(e.buf, 0)
+			return nil
+		}
+		e.buf = append(e.buf, 1)
+		return e.encode(v.Elem())
+	case reflect.Slice:
+		e.length(v)
+		if t.Elem().Kind() == reflect.Uint8 {
+			e.buf = append(e.buf, v.Bytes()...)
+			return nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			err := e.encode(v.Index(i))
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.Array:
+		// The length of an array is part of its type.
+		if t.Elem().Kind() == reflect.Uint8 {
+			for i := 0; i < v.Len(); i++ {
+				e.buf = append(e.buf, byte(v.Index(i).Uint()))
+			}
+			return nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			err := e.encode(v.Index(i))
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		return e.encodeMap(v)
+	case reflect.Struct:
+		for _, i := range info.fields {
+			err := e.encode(v.Field(i))
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("codec: cannot encode values of type %s", t)
+	}
+	return nil
+}
+
+// encodeMap encodes the entries of the map sorted by their encoded key, so that the encoding is deterministic.
+func (e *encoder) encodeMap(v reflect.Value) error {
+	e.length(v)
+	type entry struct {
+		key   []byte
+		value reflect.Value
+	}
+	entries := make([]entry, 0, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		keyEncoder := encoder{}
+		err := keyEncoder.encode(iter.Key())
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry{key: keyEncoder.buf, value: iter.Value()})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].key, entries[j].key) < 0
+	})
+	for _, entry := range entries {
+		e.buf = append(e.buf, entry.key...)
+		err := e.encode(entry.value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// marshal encodes the given value, whose type implements encoding.BinaryMarshaler or gob.GobEncoder.
+func marshal(v reflect.Value) ([]byte, error) {
+	// Use an addressable copy, in case the methods have a pointer receiver.
+	ptr := reflect.New(v.Type())
+	ptr.Elem().Set(v)
+	switch m := ptr.Interface().(type) {
+	case encoding.BinaryMarshaler:
+		return m.MarshalBinary()
+	case gob.GobEncoder:
+		return m.GobEncode()
+	}
+	return nil, errors.New("not a marshaler")
+}
+
+type decoder struct {
+	buf []byte
+}
+
+func (d *decoder) byte() (byte, error) {
+	if len(d.buf) == 0 {
+		return 0, errTruncated
+	}
+	b := d.buf[0]
+	d.buf = d.buf[1:]
+	return b, nil
+}
+
+func (d *decoder) uvarint() (uint64, error) {
+	x, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		return 0, errTruncated
+	}
+	d.buf = d.buf[n:]
+	return x, nil
+}
+
+func (d *decoder) varint() (int64, error) {
+	x, n := binary.Varint(d.buf)
+	if n <= 0 {
+		return 0, errTruncated
+	}
+	d.buf = d.buf[n:]
+	return x, nil
+}
+
+func (d *decoder) next(n uint64) ([]byte, error) {
+	if n > uint64(len(d.buf)) {
+		return nil, errTruncated
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b, nil
+}
+
+func (d *decoder) bytes() ([]byte, error) {
+	n, err := d.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	return d.next(n)
+}
+
+// length decodes the length of a slice or a map whose elements take at least the given number of bytes each. Returns
+// false if the slice or the map is nil. The length is checked against the remaining data before anything is allocated.
+func (d *decoder) length(elemSize uint64) (int, bool, error) {
+	n, err := d.uvarint()
+	if err != nil {
+		return 0, false, err
+	}
+	if n == 0 {
+		return 0, false, nil
+	}
+	// The elements that take no bytes, e.g. the empty structs, are still bounded by the remaining data, so that a
+	// forged length can't make the decoder loop for nothing.
+	if elemSize == 0 {
+		elemSize = 1
+	}
+	if n-1 > uint64(len(d.buf))/elemSize {
+		return 0, false, errTruncated
+	}
+	return int(n - 1), true, nil
+}
+
+// decode decodes into the given value, which must be settable.
+func (d *decoder) decode(v reflect.Value) error {
+	t := v.Type()
+	info := typeInfoOf(t)
+	if info.unmarshaler {
+		return d.unmarshal(v)
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		b, err := d.byte()
+		if err != nil {
+			return err
+		}
+		v.SetBool(b != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		x, err := d.varint()
+		if err != nil {
+			return err
+		}
+		if v.OverflowInt(x) {
+			return fmt.Errorf("codec: %d overflows %s", x, t)
+		}
+		v.SetInt(x)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		x, err := d.uvarint()
+		if err != nil {
+			return err
+		}
+		if v.OverflowUint(x) {
+			return fmt.Errorf("codec: %d overflows %s", x, t)
+		}
+		v.SetUint(x)
+	case reflect.Float32:
+		b, err := d.next(4)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(b))))
+	case reflect.Float64:
+		b, err := d.next(8)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(math.Float64frombits(binary.LittleEndian.Uint64(b)))
+	case reflect.String:
+		b, err := d.bytes()
+		if err != nil {
+			return err
+		}
+		v.SetString(string(b))
+	case reflect.Ptr:
+		present, err := d.byte()
+		if err != nil {
+			return err
+		}
+		if present == 0 {
+			v.Set(reflect.Zero(t))
+			return nil
+		}
+		elem := reflect.New(t.Elem())
+		err = d.decode(elem.Elem())
+		if err != nil {
+			return err
+		}
+		v.Set(elem)
+	case reflect.Slice:
+		return d.decodeSlice(v)
+	case reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			b, err := d.next(uint64(v.Len()))
+			if err != nil {
+				return err
+			}
+			for i, x := range b {
+				v.Index(i).SetUint(uint64(x))
+			}
+			return nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			err := d.decode(v.Index(i))
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		return d.decodeMap(v)
+	case reflect.Struct:
+		for _, i := range info.fields {
+			err := d.decode(v.Field(i))
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("codec: cannot decode values of type %s", t)
+	}
+	return nil
+}
+
+func (d *decoder) decodeSlice(v reflect.Value) error {
+	t := v.Type()
+	n, present, err := d.length(typeInfoOf(t.Elem()).minSize)
+	if err != nil {
+		return err
+	}
+	if !present {
+		v.Set(reflect.Zero(t))
+		return nil
+	}
+	if t.Elem().Kind() == reflect.Uint8 {
+		b, err := d.next(uint64(n))
+		if err != nil {
+			return err
+		}
+		// Do not alias the buffer of the decoder.
+		slice := reflect.MakeSlice(t, n, n)
+		reflect.Copy(slice, reflect.ValueOf(b))
+		v.Set(slice)
+		return nil
+	}
+	slice := reflect.MakeSlice(t, n, n)
+	for i := 0; i < n; i++ {
+		err := d.decode(slice.Index(i))
+		if err != nil {
+			return err
+		}
+	}
+	v.Set(slice)
+	return nil
+}
+
+func (d *decoder) decodeMap(v reflect.Value) error {
+	t := v.Type()
+	n, present, err := d.length(typeInfoOf(t.Key()).minSize + typeInfoOf(t.Elem()).minSize)
+	if err != nil {
+		return err
+	}
+	if !present {
+		v.Set(reflect.Zero(t))
+		return nil
+	}
+	m := reflect.MakeMapWithSize(t, n)
+	for i := 0; i < n; i++ {
+		key := reflect.New(t.Key()).Elem()
+		err := d.decode(key)
+		if err != nil {
+			return err
+		}
+		value := reflect.New(t.Elem()).Elem()
+		err = d.decode(value)
+		if err != nil {
+			return err
+		}
+		m.SetMapIndex(key, value)
+	}
+	v.Set(m)
+	return nil
+}
+
+// unmarshal decodes the given value, whose type implements encoding.BinaryUnmarshaler or gob.GobDecoder.
+func (d *decoder) unmarshal(v reflect.Value) error {
+	b, err := d.bytes()
+	if err != nil {
+		return err
+	}
+	ptr := reflect.New(v.Type())
+	switch u := ptr.Interface().(type) {
+	case encoding.BinaryUnmarshaler:
+		err = u.UnmarshalBinary(b)
+	case gob.GobDecoder:
+		err = u.GobDecode(b)
+	}
+	if err != nil {
+		return fmt.Errorf("codec: could not decode %s: %w", v.Type(), err)
+	}
+	v.Set(ptr.Elem())
+	return nil
+}
@@ -0,0 +1,241 @@
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type sample struct {
+	Bool    bool
+	Int     int
+	Int8    int8
+	Uint64  uint64
+	Float32 float32
+	Float64 float64
+	String  string
+	Bytes   []byte
+	Strings []string
+	Array   [3]uint16
+	Hash    [4]byte
+	Next    *sample
+	Map     map[string]int64
+	Nested  []nested
+	Time    time.Time
+	Ignored string `json:"-"`
+
+	unexported int
+}
+
+type nested struct {
+	Name   string
+	Values map[uint8][]byte
+	Empty  struct{}
+}
+
+func newSample() sample {
+	return sample{
+		Bool:    true,
+		Int:     -1 << 40,
+		Int8:    -128,
+		Uint64:  1<<64 - 1,
+		Float32: 1.5,
+		Float64: -0.25,
+		String:  "partage",
+		Bytes:   []byte{0, 1, 2},
+		Strings: []string{"a", "", "c"},
+		Array:   [3]uint16{1, 300, 65535},
+		Hash:    [4]byte{0xde, 0xad, 0xbe, 0xef},
+		Next:    &sample{String: "next", Bytes: []byte{}, Map: map[string]int64{}},
+		Map:     map[string]int64{"a": 1, "b": -2},
+		Nested: []nested{
+			{Name: "first", Values: map[uint8][]byte{1: {1}, 2: nil}},
+			{Name: "second"},
+		},
+		Time: time.Unix(1640995200, 42).UTC(),
+	}
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	value := newSample()
+	value.Ignored = "ignored"
+	value.unexported = 1
+
+	buf, err := Binary.Marshal(&value)
+	require.NoError(t, err)
+	require.True(t, IsBinary(buf))
+
+	var decoded sample
+	err = Unmarshal(buf, &decoded)
+	require.NoError(t, err)
+
+	// the ignored and the unexported fields are not encoded
+	expected := newSample()
+	require.Equal(t, expected, decoded)
+
+	// nil and empty slices and maps are told apart
+	require.Nil(t, decoded.Next.Strings)
+	require.NotNil(t, decoded.Next.Bytes)
+	require.NotNil(t, decoded.Next.Map)
+	require.Nil(t, decoded.Nested[1].Values)
+
+	// the encoding is deterministic, despite the maps
+	again, err := Binary.Marshal(decoded)
+	require.NoError(t, err)
+	require.Equal(t, buf, again)
+
+	// trailing bytes are rejected
+	err = Binary.Unmarshal(append(buf, 0), &decoded)
+	require.Error(t, err)
+}
+
+func TestBinaryMinSize(t *testing.T) {
+	table := []struct {
+		value    interface{}
+		expected uint64
+	}{
+		{true, 1},
+		{int64(0), 1},
+		{float32(0), 4},
+		{float64(0), 8},
+		{"", 1},
+		{[]string{}, 1},
+		{map[string]string{}, 1},
+		{struct{ Next *sample }{}, 1},
+		{[5]byte{}, 5},
+		{[2]float64{}, 16},
+		{struct{}{}, 0},
+		{time.Time{}, 1},
+		{nested{}, 2},
+	}
+
+	for _, entry := range table {
+		typ := reflect.TypeOf(entry.value)
+		require.Equal(t, entry.expected, typeInfoOf(typ).minSize, typ.String())
+
+		// the smallest value of the type does not take less than the bound
+		buf, err := Binary.Marshal(reflect.New(typ).Interface())
+		require.NoError(t, err)
+		require.LessOrEqual(t, entry.expected, uint64(len(buf)-1), typ.String())
+	}
+}
+
+func TestBinaryTruncated(t *testing.T) {
+	value := newSample()
+	buf, err := Binary.Marshal(value)
+	require.NoError(t, err)
+
+	// every prefix of the encoding ends in the middle of the value
+	for i := 1; i < len(buf); i++ {
+		var decoded sample
+		err := Binary.Unmarshal(buf[:i], &decoded)
+		require.Error(t, err, "prefix of %d bytes", i)
+	}
+}
+
+func TestBinaryForgedLength(t *testing.T) {
+	// forge a length prefix announcing n elements, followed by the given data
+	forge := func(n uint64, data ...byte) []byte {
+		buf := make([]byte, 1+binary.MaxVarintLen64)
+		buf[0] = binaryMagic
+		buf = buf[:1+binary.PutUvarint(buf[1:], n+1)]
+		return append(buf, data...)
+	}
+
+	table := []struct {
+		name  string
+		value interface{}
+		data  []byte
+	}{
+		{"huge byte slice", &[]byte{}, forge(1<<62, 1, 2, 3)},
+		{"huge string slice", &[]string{}, forge(1<<40, 0, 0, 0)},
+		{"huge map", &map[string]string{}, forge(1<<40, 0, 0)},
+		{"huge slice of empty structs", &[]struct{}{}, forge(1 << 40)},
+		// there are as many bytes as elements, but each element takes at least 8 bytes
+		{"slice of floats", &[]float64{}, forge(8, 0, 0, 0, 0, 0, 0, 0, 0)},
+		// each entry takes at least 2 bytes
+		{"map", &map[uint8]uint8{}, forge(2, 1, 1)},
+		{"slice of large arrays", &[][1024]byte{}, forge(1000, make([]byte, 1000)...)},
+	}
+
+	for _, entry := range table {
+		err := Binary.Unmarshal(entry.data, entry.value)
+		require.True(t, errors.Is(err, errTruncated), "%s: %v", entry.name, err)
+	}
+
+	// a forged length is rejected before the slice is allocated: 4096 arrays of 4KiB would take 16MiB
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	err := Binary.Unmarshal(forge(4096, make([]byte, 4096)...), &[][4096]byte{})
+	runtime.ReadMemStats(&after)
+	require.True(t, errors.Is(err, errTruncated), err)
+	require.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(1<<20))
+
+	// the same lengths are accepted when the data holds the elements
+	var floats []float64
+	err = Binary.Unmarshal(forge(1, 0, 0, 0, 0, 0, 0, 0, 0), &floats)
+	require.NoError(t, err)
+	require.Equal(t, []float64{0}, floats)
+}
+
+// TestBinaryFuzz decodes random and mutated encodings: the decoder must never panic, and whatever it accepts must
+// survive a new round trip.
+func TestBinaryFuzz(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+
+	seed, err := Binary.Marshal(newSample())
+	require.NoError(t, err)
+
+	inputs := make([][]byte, 0, 20000)
+	for i := 0; i < 10000; i++ {
+		random := make([]byte, 1+rnd.Intn(64))
+		rnd.Read(random)
+		random[0] = binaryMagic
+		inputs = append(inputs, random)
+	}
+	for i := 0; i < 10000; i++ {
+		mutated := append([]byte{}, seed...)
+		mutations := 1 + rnd.Intn(4)
+		for j := 0; j < mutations && len(mutated) > 1; j++ {
+			pos := 1 + rnd.Intn(len(mutated)-1)
+			switch rnd.Intn(3) {
+			case 0:
+				mutated[pos] = byte(rnd.Intn(256))
+			case 1:
+				mutated = mutated[:pos]
+			case 2:
+				// a continuation byte turns a length into a large varint
+				mutated = append(mutated[:pos], append([]byte{0xff}, mutated[pos:]...)...)
+			}
+		}
+		inputs = append(inputs, mutated)
+	}
+
+	accepted := 0
+	for _, input := range inputs {
+		var decoded sample
+		err := Binary.Unmarshal(input, &decoded)
+		if err != nil {
+			continue
+		}
+		accepted++
+
+		buf, err := Binary.Marshal(decoded)
+		require.NoError(t, err)
+		var again sample
+		err = Binary.Unmarshal(buf, &again)
+		require.NoError(t, err)
+		reencoded, err := Binary.Marshal(again)
+		require.NoError(t, err)
+		require.Equal(t, buf, reencoded)
+	}
+
+	// some of the mutated encodings are still valid, so that the round trip is exercised
+	require.Greater(t, accepted, 0)
+}
@@ -0,0 +1,74 @@
+// Package codec defines how the packets and the messages are encoded on the wire. Two codecs are available: JSON,
+// which is human-readable and useful for debugging, and a compact binary codec. Binary encodings start with a magic
+// byte that can never start a JSON document, so that any encoding can be decoded without knowing its codec.
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Codec encodes and decodes the values sent over the network.
+type Codec interface {
+	// Name returns the name of the codec. It is used to negotiate the codec
+	// with the other peers.
+	Name() string
+
+	// Marshal encodes the given value.
+	Marshal(v interface{}) ([]byte, error)
+
+	// Unmarshal decodes the data into the given value, which MUST be a
+	// pointer.
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// JSON is the codec based on encoding/json.
+var JSON Codec = jsonCodec{}
+
+// Binary is the compact binary codec.
+var Binary Codec = binaryCodec{}
+
+var codecs = map[string]Codec{
+	JSON.Name():   JSON,
+	Binary.Name(): Binary,
+}
+
+// Get returns the codec with the given name.
+func Get(name string) (Codec, error) {
+	c, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown codec: %s", name)
+	}
+	return c, nil
+}
+
+// Detect returns the codec the given data has been encoded with.
+func Detect(data []byte) Codec {
+	if IsBinary(data) {
+		return Binary
+	}
+	return JSON
+}
+
+// Unmarshal decodes the data into the given value with the codec the data has
+// been encoded with.
+func Unmarshal(data []byte, v interface{}) error {
+	return Detect(data).Unmarshal(data, v)
+}
+
+type jsonCodec struct{}
+
+// Name implements codec.Codec.
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+// Marshal implements codec.Codec.
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal implements codec.Codec.
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
@@ -2,17 +2,21 @@ package transport
 
 import (
 	"crypto"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/x509"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"math"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/rs/xid"
+	"go.dedis.ch/cs438/transport/codec"
 )
 
 // Factory defines the general function to create a network.
@@ -98,16 +102,23 @@ type Packet struct {
 }
 
 // Marshal transforms a packet to something that can be sent over the network.
+// The packet is encoded in JSON.
 func (p Packet) Marshal() ([]byte, error) {
-	return json.Marshal(&p)
+	return p.MarshalWith(codec.JSON)
 }
 
-// Unmarshal transforms a marshaled packet to an actual packet. Example
-// creating a new packet out of a buffer:
+// MarshalWith transforms a packet to something that can be sent over the
+// network, using the given codec.
+func (p Packet) MarshalWith(c codec.Codec) ([]byte, error) {
+	return c.Marshal(&p)
+}
+
+// Unmarshal transforms a marshaled packet to an actual packet. The codec is
+// detected from the buffer. Example creating a new packet out of a buffer:
 //   var packet Packet
 //   packet.Unmarshal(buf)
 func (p *Packet) Unmarshal(buf []byte) error {
-	return json.Unmarshal(buf, p)
+	return codec.Unmarshal(buf, p)
 }
 
 // Copy returns a copy of the packet
@@ -151,6 +162,13 @@ type Header struct {
 
 	//---PARTAGE allows to check integrity and authenticity check
 	Check *Validation
+
+	// MAC replaces Check for the packets sent within a mutually-authenticated
+	// session to the peer of the session itself. It is the HMAC of the packet
+	// under the key of the session. It only covers the final hop: the packets
+	// that are relayed are still signed, on every hop, since the destination
+	// must authenticate their source and not the relay.
+	MAC []byte
 }
 
 func (h Header) String() string {
@@ -179,13 +197,45 @@ func (h Header) HTML() string {
 }
 
 // Message defines the type of message sent over the network. Payload should be
-// a json marshalled representation of a types.Message, and Type the
-// corresponding message name, available with types.Message.Name().
+// a representation of a types.Message marshalled with one of the codecs, and
+// Type the corresponding message name, available with types.Message.Name().
 type Message struct {
 	Type    string
 	Payload json.RawMessage
 }
 
+// jsonMessage is the JSON representation of a message. Payloads encoded with
+// the binary codec aren't valid JSON, so they are carried in BinaryPayload
+// instead.
+type jsonMessage struct {
+	Type          string
+	Payload       json.RawMessage `json:",omitempty"`
+	BinaryPayload []byte          `json:",omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (m Message) MarshalJSON() ([]byte, error) {
+	if codec.IsBinary(m.Payload) {
+		return json.Marshal(jsonMessage{Type: m.Type, BinaryPayload: m.Payload})
+	}
+	return json.Marshal(jsonMessage{Type: m.Type, Payload: m.Payload})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (m *Message) UnmarshalJSON(data []byte) error {
+	var msg jsonMessage
+	err := json.Unmarshal(data, &msg)
+	if err != nil {
+		return err
+	}
+	m.Type = msg.Type
+	m.Payload = msg.Payload
+	if msg.BinaryPayload != nil {
+		m.Payload = msg.BinaryPayload
+	}
+	return nil
+}
+
 // Copy returns a copy of the message
 func (m Message) Copy() Message {
 	return Message{
@@ -242,7 +292,10 @@ func (p *Packet) AddValidation(myPrivateKey *rsa.PrivateKey, mySignedPublicKey *
 	return nil
 }
 
-func (p *Packet) Validate(publicKeyCA *rsa.PublicKey) error {
+// RevocationChecker tells whether the public key with the given hash has been revoked by the CA.
+type RevocationChecker func(publicKeyHash [32]byte) bool
+
+func (p *Packet) Validate(publicKeyCA *rsa.PublicKey, isRevoked RevocationChecker) error {
 	if p.Header.Check == nil {
 		if p.Msg.Type == "searchpkrequest" || p.Msg.Type == "searchpkreply" || p.Msg.Type == "datarequest" || p.Msg.Type == "datareply" || p.Msg.Type == "searchrequest" || p.Msg.Type == "searchreply" {
 			return nil
@@ -263,6 +316,9 @@ func (p *Packet) Validate(publicKeyCA *rsa.PublicKey) error {
 		//invalid SignedPublicKey (not signed by trusted CA)
 		return fmt.Errorf("src public key is not signed by trusted CA")
 	}
+	if isRevoked != nil && isRevoked(hashedSrcPK) {
+		return fmt.Errorf("src public key has been revoked by trusted CA")
+	}
 
 	//2- Check if Hash(packet.Msg||packet.Header.Source) was signed by src's private key
 	byteMsg, _ := json.Marshal(p.Msg)
@@ -276,6 +332,45 @@ func (p *Packet) Validate(publicKeyCA *rsa.PublicKey) error {
 	return nil
 }
 
+// AddMAC authenticates the packet with the given session key, instead of
+// signing it. The MAC covers the header, but the MAC itself, and the message.
+func (p *Packet) AddMAC(sessionKey []byte) {
+	p.Header.Check = nil
+	p.Header.MAC = p.computeMAC(sessionKey)
+}
+
+// VerifyMAC checks that the packet has been authenticated with the given
+// session key.
+func (p *Packet) VerifyMAC(sessionKey []byte) error {
+	if p.Header.MAC == nil {
+		return fmt.Errorf("pkt.Header has no MAC")
+	}
+	if !hmac.Equal(p.Header.MAC, p.computeMAC(sessionKey)) {
+		return fmt.Errorf("invalid MAC")
+	}
+	return nil
+}
+
+func (p *Packet) computeMAC(sessionKey []byte) []byte {
+	mac := hmac.New(sha256.New, sessionKey)
+	// Prefix each field with its length, so that the fields can't be shifted.
+	writeField := func(field []byte) {
+		var length [8]byte
+		binary.BigEndian.PutUint64(length[:], uint64(len(field)))
+		mac.Write(length[:])
+		mac.Write(field)
+	}
+	writeField([]byte(p.Header.PacketID))
+	writeField([]byte(strconv.FormatUint(uint64(p.Header.TTL), 10)))
+	writeField([]byte(strconv.FormatInt(p.Header.Timestamp, 10)))
+	writeField([]byte(p.Header.Source))
+	writeField([]byte(p.Header.RelayedBy))
+	writeField([]byte(p.Header.Destination))
+	writeField([]byte(p.Msg.Type))
+	writeField(p.Msg.Payload)
+	return mac.Sum(nil)
+}
+
 func (k *SignedPublicKey) Encode() ([]byte, error) {
 	return json.Marshal(k)
 }
@@ -6,27 +6,31 @@ import (
 )
 
 type ConnPool struct {
-	pool  map[string]*tls.Conn
-	mutex sync.RWMutex
+	pool map[string]*tls.Conn
+	// sessions maps each address to the session negotiated with the peer.
+	sessions map[string]*session
+	mutex    sync.RWMutex
 }
 
 func newConnPool() ConnPool {
-	return ConnPool{pool: make(map[string]*tls.Conn)}
+	return ConnPool{pool: make(map[string]*tls.Conn), sessions: make(map[string]*session)}
 }
 
-func (p *ConnPool) GetConn(addr string) *tls.Conn {
+// GetConn returns the connection to the given address along with its session, or nil if there is none.
+func (p *ConnPool) GetConn(addr string) (*tls.Conn, *session) {
 	p.mutex.RLock()
 	defer p.mutex.RUnlock()
 	if conn, ok := p.pool[addr]; ok {
-		return conn
+		return conn, p.sessions[addr]
 	}
-	return nil
+	return nil, nil
 }
 
-func (p *ConnPool) AddConn(addr string, conn *tls.Conn) {
+func (p *ConnPool) AddConn(addr string, conn *tls.Conn, sess *session) {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 	p.pool[addr] = conn
+	p.sessions[addr] = sess
 	return
 }
 
@@ -43,6 +47,7 @@ func (p *ConnPool) CloseConn(addr string) {
 	if conn, ok := p.pool[addr]; ok {
 		conn.Close()
 		delete(p.pool, addr)
+		delete(p.sessions, addr)
 	}
 	return
 }
@@ -54,5 +59,6 @@ func (p *ConnPool) Close() {
 		conn.Close()
 	}
 	p.pool = nil //will be garbage collected
+	p.sessions = nil
 	return
 }
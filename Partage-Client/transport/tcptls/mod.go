@@ -13,52 +13,91 @@ import (
 
 	"go.dedis.ch/cs438/peer/impl/utils"
 	"go.dedis.ch/cs438/transport"
+	"go.dedis.ch/cs438/transport/codec"
+	"go.dedis.ch/cs438/types"
+	"partage-common/framing"
 )
 
-const bufSize = 65000
-
 // NewTCP returns a new tcp transport implementation.
 func NewTCP() transport.Transport {
-	return &TCP{}
+	return NewTCPWithCodecs(false, DefaultCodecs...)
+}
+
+// NewPersistentTCP returns a new tcp transport implementation whose sockets store and load their certificate from
+// persistent memory, so that the identity of the user survives restarts.
+func NewPersistentTCP() transport.Transport {
+	return NewTCPWithCodecs(true, DefaultCodecs...)
+}
+
+// NewTCPWithCodecs returns a new tcp transport implementation whose sockets only support the given codecs, by order
+// of preference. The codec of each connection is negotiated when it is opened.
+func NewTCPWithCodecs(persistent bool, codecs ...codec.Codec) transport.Transport {
+	return NewTCPWithOptions(Options{
+		Persistent: persistent,
+		CAAddress:  DefaultCAAddress,
+		Codecs:     codecs,
+	})
+}
+
+// NewTCPWithOptions returns a new tcp transport implementation whose sockets are configured with the given options.
+func NewTCPWithOptions(options Options) transport.Transport {
+	return &TCP{options: options}
+}
+
+// Options configures the sockets of the tcp transport.
+type Options struct {
+	// Profile is the directory that holds the identity of the user. The default profile is used if it is empty.
+	Profile utils.Profile
+	// Persistent sockets store and load their certificate from the profile, so that the identity of the user
+	// survives restarts. Otherwise, each socket has a fresh identity (for testing).
+	Persistent bool
+	// CAAddress is the address of the CA server.
+	CAAddress string
+	// Codecs are the supported codecs, by order of preference.
+	Codecs []codec.Codec
 }
 
 // TCP implements a transport layer using TCP
 //
 // - implements transport.Transport
 type TCP struct {
+	options Options
 }
 
 // CreateSocket implements transport.Transport
 func (n *TCP) CreateSocket(address string) (transport.ClosableSocket, error) {
-	// Load my TLS certificate from memory and my public key signature or generate one (if no certificate is found)
-	certificate, err := utils.LoadCertificate(false) //false for testing purposed, true if you want to store and load a certificate from persistent memory!
+	profile := n.options.Profile
+	if profile.Dir == "" {
+		var err error
+		profile, err = utils.DefaultProfile()
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := profile.Init()
 	if err != nil {
 		return nil, err
 	}
-	// Create tls config with loaded certificate
-	cfg := &tls.Config{Certificates: []tls.Certificate{*certificate}, InsecureSkipVerify: true, ClientAuth: tls.RequestClientCert}
-	// Create the listening TCP/TLS socket.
-	listener, err := tls.Listen("tcp", address, cfg)
+	// Load my TLS certificate from memory and my public key signature or generate one (if no certificate is found)
+	certificate, err := profile.LoadCertificate(n.options.Persistent) //false for testing purposed, true if you want to store and load a certificate from persistent memory!
 	if err != nil {
 		return nil, err
 	}
 
-	ca := utils.LoadCACertificate()
-	pkSignature := utils.LoadPublicKeySignature()
+	ca := profile.LoadCACertificate()
+	pkSignature := profile.LoadPublicKeySignature()
 
 	var blockedUsers map[[32]byte]struct{}
 	if utils.TESTING {
 		blockedUsers = make(map[[32]byte]struct{})
 	} else {
-		blockedUsers, _ = utils.LoadBlockedUsers()
+		blockedUsers, _ = profile.LoadBlockedUsers()
 	}
-	fp, _ := utils.OpenFileToAppend(utils.BlockedUsersPath) //to save blocked users in persistent memory
+	fp, _ := utils.OpenFileToAppend(profile.BlockedUsersPath()) //to save blocked users in persistent memory
 
-	return &Socket{
-		listener:         &listener,
+	sock := &Socket{
 		ins:              []transport.Packet{},
 		outs:             []transport.Packet{},
-		tlsConfig:        cfg,
 		myTLSCertificate: certificate,
 		CA:               ca,
 		Catalog:          make(map[[32]byte]*transport.SignedPublicKey), //hashed public key maps to *rsa.PublicKey
@@ -68,7 +107,22 @@ func (n *TCP) CreateSocket(address string) (transport.ClosableSocket, error) {
 		blockedUsers:     blockedUsers,
 		blockedIPs:       make(map[string][32]byte), //to reject rumors by origin!
 		fpBlockedUsers:   fp,
-	}, nil
+		codecs:           n.options.Codecs,
+		profile:          profile,
+		caAddress:        n.options.CAAddress,
+	}
+	// Create tls config with loaded certificate
+	sock.tlsConfig = sock.newTLSConfig(nil)
+	// Create the listening TCP/TLS socket.
+	listener, err := tls.Listen("tcp", address, sock.tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	sock.listener = &listener
+	if !utils.TESTING {
+		sock.loadRevocationList()
+	}
+	return sock, nil
 }
 
 // Socket implements a network socket using TCP.
@@ -84,6 +138,7 @@ type Socket struct {
 	tlsConfig        *tls.Config
 	myTLSCertificate *tls.Certificate
 	myPKSignature    []byte
+	certificateMutex sync.RWMutex
 	Catalog          map[[32]byte]*transport.SignedPublicKey
 	CatalogLock      sync.RWMutex
 	connPool         ConnPool
@@ -95,6 +150,15 @@ type Socket struct {
 	blockedIPs        map[string][32]byte
 	blockedIPsMutex   sync.RWMutex
 	fpBlockedUsers    *os.File
+	// revocation mechanism
+	revocationList  *types.RevocationList
+	revoked         map[[32]byte]struct{}
+	revocationMutex sync.RWMutex
+	// codecs are the codecs we support, by order of preference.
+	codecs []codec.Codec
+	// profile holds our identity, and caAddress is where we reach the CA.
+	profile   utils.Profile
+	caAddress string
 }
 
 // Close implements transport.Socket. It returns an error if already closed.
@@ -104,54 +168,56 @@ func (s *Socket) Close() error {
 
 // Send implements transport.Socket
 func (s *Socket) Send(dest string, pkt transport.Packet, timeout time.Duration) error {
-	pktBytes, err := pkt.Marshal()
-	if err != nil {
-		return err
-	}
+	return s.send(dest, pkt, timeout, false)
+}
 
-	//newConn := false
-	conn := s.connPool.GetConn(dest)
+// SendAuthenticated sends the packet like Send, but authenticates it first. Within a mutually-authenticated session
+// with the destination of the packet, the packet carries a MAC. Otherwise, e.g., when the packet is sent to a relay, it
+// is signed with our private key.
+func (s *Socket) SendAuthenticated(dest string, pkt transport.Packet, timeout time.Duration) error {
+	return s.send(dest, pkt, timeout, true)
+}
+
+func (s *Socket) send(dest string, pkt transport.Packet, timeout time.Duration, authenticate bool) error {
+	var err error
+	conn, sess := s.connPool.GetConn(dest)
 	if conn == nil {
-		//newConn = true
-		// Use Dialer to allow timeout on dial call
-		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", dest, s.tlsConfig)
+		conn, sess, err = s.dial(dest, timeout)
 		if err != nil {
-			// Convert to a network error to specifically check for timeout errors.
-			netErr, ok := err.(net.Error)
-			if ok && netErr.Timeout() {
-				return transport.TimeoutErr(0)
-			}
 			return err
 		}
-		// Add conn to pool
-		s.connPool.AddConn(dest, conn)
-		utils.PrintDebug("tls", s.GetAddress(), " created a goroutine to handle ", dest, " conn")
+	}
 
-		// Create a pkt listening goroutine for this new conn
-		go s.HandleTLSConn(conn, true)
+	if authenticate {
+		// The header is authenticated for this connection only, so do not modify the caller's one.
+		header := *pkt.Header
+		pkt.Header = &header
+		// The MAC only authenticates the packet to the peer of the session, so the packets that this peer will relay
+		// are still signed: the signature holds end-to-end.
+		if sess.key != nil && pkt.Header.Destination == dest {
+			pkt.AddMAC(sess.key)
+		} else {
+			err = pkt.AddValidation(s.GetTLSCertificate().PrivateKey.(*rsa.PrivateKey), s.GetSignedPublicKey())
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// The packet is encoded with the codec negotiated with the destination.
+	pktBytes, err := pkt.MarshalWith(sess.codec)
+	if err != nil {
+		return err
 	}
 
-	_, err = conn.Write(pktBytes)
+	err = framing.WriteFrame(conn, pktBytes)
 	if err != nil {
 		//conn may have been closed due to read time out...
 		//return s.Send(dest,pkt,timeout)
-		// Use Dialer to allow timeout on dial call
-		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", dest, s.tlsConfig)
+		_, _, err = s.dial(dest, timeout)
 		if err != nil {
-			// Convert to a network error to specifically check for timeout errors.
-			netErr, ok := err.(net.Error)
-			if ok && netErr.Timeout() {
-				return transport.TimeoutErr(0)
-			}
 			return err
 		}
-		// Add conn to pool
-		s.connPool.AddConn(dest, conn)
-		utils.PrintDebug("tls", s.GetAddress(), " created a goroutine to handle ", dest, " conn")
-
-		// Create a pkt listening goroutine for this new conn
-		go s.HandleTLSConn(conn, true)
-		
 		//return err
 	}
 
@@ -179,6 +245,33 @@ func (s *Socket) Send(dest string, pkt transport.Packet, timeout time.Duration)
 	return nil
 }
 
+// dial opens a new connection to the given destination, negotiates its session and adds it to the connection pool.
+func (s *Socket) dial(dest string, timeout time.Duration) (*tls.Conn, *session, error) {
+	// Use Dialer to allow timeout on dial call
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", dest, s.tlsConfig)
+	if err != nil {
+		// Convert to a network error to specifically check for timeout errors.
+		netErr, ok := err.(net.Error)
+		if ok && netErr.Timeout() {
+			return nil, nil, transport.TimeoutErr(0)
+		}
+		return nil, nil, err
+	}
+	sess, err := s.offerSession(conn)
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("could not negotiate the session with %s: %w", dest, err)
+	}
+	// Add conn to pool
+	s.connPool.AddConn(dest, conn, sess)
+	utils.PrintDebug("tls", s.GetAddress(), " created a goroutine to handle ", dest, " conn with codec ",
+		sess.codec.Name(), " authenticated: ", sess.key != nil)
+
+	// Create a pkt listening goroutine for this new conn
+	go s.receive(conn, sess, true)
+	return conn, sess, nil
+}
+
 func (s *Socket) Accept() (*tls.Conn, bool, error) {
 	conn, err := (*s.listener).Accept()
 	if err != nil {
@@ -191,17 +284,37 @@ func (s *Socket) Accept() (*tls.Conn, bool, error) {
 
 	tlsConn.Handshake()
 	if tlsConn.ConnectionState().HandshakeComplete && tlsConn.ConnectionState().PeerCertificates[0].CheckSignatureFrom(s.CA) == nil {
+		if time.Now().After(tlsConn.ConnectionState().PeerCertificates[0].NotAfter) {
+			fmt.Println("Refused Connection: Certificate has expired!")
+			tlsConn.Close()
+			return nil, true, errors.New("refused: certificate has expired")
+		}
+		publicKey, ok := tlsConn.ConnectionState().PeerCertificates[0].PublicKey.(*rsa.PublicKey)
+		if ok && s.IsRevoked(utils.HashPublicKey(publicKey)) {
+			fmt.Println("Refused Connection: Certificate has been revoked by the trusted CA!")
+			tlsConn.Close()
+			return nil, true, errors.New("refused: certificate has been revoked by trusted CA")
+		}
 		return tlsConn, true, nil
 	}
 	fmt.Println("Refused Connection: Certificate is not signed by the trusted CA!")
 	return nil, true, errors.New("refused: certificate isnt signed by trusted CA")
 }
 
+// HandleTLSConn negotiates the session of a connection the peer has dialed, and receives its packets.
 func (s *Socket) HandleTLSConn(tlsConn *tls.Conn, connSaved bool) {
+	sess, err := s.acceptSession(tlsConn)
+	if err != nil {
+		fmt.Println("closing connection with", tlsConn.RemoteAddr(), "->", err)
+		tlsConn.Close()
+		return
+	}
+	s.receive(tlsConn, sess, connSaved)
+}
+
+// receive receives the packets of the given connection until it is closed.
+func (s *Socket) receive(tlsConn *tls.Conn, sess *session, connSaved bool) {
 	recvTimeout := time.Second * 60 * 3 //3 minutes
-	//conn
-	var pkt transport.Packet
-	buffer := make([]byte, bufSize)
 	deadline := time.Now().Add(recvTimeout)
 	err := tlsConn.SetReadDeadline(deadline)
 	if err != nil {
@@ -210,53 +323,63 @@ func (s *Socket) HandleTLSConn(tlsConn *tls.Conn, connSaved bool) {
 	}
 
 	for {
-		size, err := tlsConn.Read(buffer)
+		frame, err := framing.ReadFrame(tlsConn)
 		if err != nil {
+			// Oversized or malformed frames leave the stream out of sync, so the connection cannot be used anymore.
+			if errors.Is(err, framing.ErrFrameTooLarge) || errors.Is(err, framing.ErrMalformedFrame) {
+				fmt.Println("closing connection with", tlsConn.RemoteAddr(), "->", err)
+				tlsConn.Close()
+			}
 			s.RemoveConn(tlsConn)
 			return
 		}
-
-		err = pkt.Unmarshal(buffer[:size])
-		if err != nil { //unmarshaling error
-			//try to read the rest of the packet
-			//Note..the pkts holding a certificate are too large so we're not able to read it in one Read() call, this is how we can go arround that problem
-			cum := append([]byte{}, buffer[:size]...)
-			for {
-				size, err := tlsConn.Read(buffer)
-				if err != nil {
-					s.RemoveConn(tlsConn)
-					return
-				}
-				cum = append(cum, buffer[:size]...)
-				err = pkt.Unmarshal(cum)
-				if err != nil {
-					continue
-				} else {
-					break
-				}
-			}
+		var pkt transport.Packet
+		err = pkt.Unmarshal(frame)
+		if err != nil {
+			fmt.Println("closing connection with", tlsConn.RemoteAddr(), "-> malformed packet:", err)
+			tlsConn.Close()
+			s.RemoveConn(tlsConn)
+			return
 		}
 
 		//check packet RelayedBy parameter and not the actual tlsConn source addr parameter. Because you can't use the same addr socket to listen from and to dial from, so nodes will dial from a different addr than the one they are listening to. We just care about the listening-socket addr
 		//add to connPool
 		if !connSaved {
 			if !s.connPool.ConnExists(pkt.Header.RelayedBy) {
-				s.connPool.AddConn(pkt.Header.RelayedBy, tlsConn)
+				s.connPool.AddConn(pkt.Header.RelayedBy, tlsConn, sess)
 				utils.PrintDebug("tls", s.GetAddress(), " created a goroutine to handle ", pkt.Header.RelayedBy, " conn")
 			}
 			connSaved = true
 		}
 
 		//VALIDATE PACKET!
-		// Validate packet signatures
-		if pkt.Validate(s.GetCAPublicKey()) != nil {
-			//signatures aren't valid..drop packet
-			fmt.Println("pkt signature no valid")
+		// Within a mutually-authenticated session, the packets carry a MAC instead of a signature.
+		var senderKey *rsa.PublicKey
+		if pkt.Header.MAC != nil && sess.key != nil {
+			if pkt.VerifyMAC(sess.key) != nil {
+				fmt.Println("pkt MAC no valid")
+				continue
+			}
+			senderKey = sess.peerKey
+		} else {
+			// Validate packet signatures
+			if pkt.Validate(s.GetCAPublicKey(), s.IsRevoked) != nil {
+				//signatures aren't valid..drop packet
+				fmt.Println("pkt signature no valid")
+				continue
+			}
+			if pkt.Header.Check != nil {
+				senderKey = pkt.Header.Check.SrcPublicKey.PublicKey
+			}
+		}
+		// The session may have been opened before the peer's certificate was revoked.
+		if senderKey != nil && s.IsRevoked(utils.HashPublicKey(senderKey)) {
+			fmt.Println("avoided packet from revoked user")
 			continue
 		}
 		// Check for banned users packets and drop the ones that are for me! (still relay packets from blocked users)
-		if pkt.Header.Destination == s.GetAddress() && pkt.Header.Check != nil {
-			pkBytes, _ := utils.PublicKeyToBytes(pkt.Header.Check.SrcPublicKey.PublicKey)
+		if pkt.Header.Destination == s.GetAddress() && senderKey != nil {
+			pkBytes, _ := utils.PublicKeyToBytes(senderKey)
 			if s.IsBlocked(utils.Hash(pkBytes)) {
 				fmt.Println("avoided packet from blocked user")
 				continue
@@ -322,9 +445,49 @@ func (s *Socket) GetOuts() []transport.Packet {
 }
 
 func (s *Socket) GetTLSCertificate() *tls.Certificate {
+	s.certificateMutex.RLock()
+	defer s.certificateMutex.RUnlock()
 	return s.myTLSCertificate
 }
 
+// GetCertificate returns our certificate, as signed by the CA once we are registered.
+func (s *Socket) GetCertificate() *x509.Certificate {
+	tlsCert := s.GetTLSCertificate()
+	if tlsCert.Leaf != nil {
+		return tlsCert.Leaf
+	}
+	cert, err := x509.ParseCertificate(tlsCert.Certificate[0])
+	if err != nil {
+		return nil
+	}
+	return cert
+}
+
+// newTLSConfig returns the TLS configuration of the socket, where the connections are checked against the given CA
+// certificate if there is one. The certificate of the socket is looked up at every handshake, so that a renewed
+// certificate is used right away without restarting the listener.
+func (s *Socket) newTLSConfig(ca *x509.Certificate) *tls.Config {
+	cfg := &tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return s.GetTLSCertificate(), nil
+		},
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return s.GetTLSCertificate(), nil
+		},
+		InsecureSkipVerify: true,
+		ClientAuth:         tls.RequestClientCert,
+	}
+	if ca != nil {
+		//TLS will check every connection against his CA's Certificate
+		caCertPool := x509.NewCertPool()
+		caCertPool.AddCert(ca)
+		cfg.RootCAs = caCertPool
+		cfg.ClientCAs = caCertPool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg
+}
+
 func (s *Socket) RemoveConn(tlsConn *tls.Conn) {
 	s.connPool.CloseConn(tlsConn.RemoteAddr().String())
 }
@@ -337,6 +500,10 @@ func (s *Socket) CloseSocketConnections() {
 	s.connPool.Close()
 }
 
+// UpdateCertificate replaces our certificate and our public key signature. A renewed certificate, i.e., for the same
+// public key and CA, is swapped in place: our identity (the hash of our public key) stays the same, and the listener
+// and the open connections are kept. Otherwise, e.g., once registered, the listener is restarted with the new
+// certificate and the connections are dropped.
 func (s *Socket) UpdateCertificate(cert *x509.Certificate, privKey *rsa.PrivateKey, publicKeySignature []byte) error {
 	keyPem, err := utils.PrivateKeyToPem(privKey)
 	if err != nil {
@@ -350,18 +517,19 @@ func (s *Socket) UpdateCertificate(cert *x509.Certificate, privKey *rsa.PrivateK
 	if err != nil {
 		return err
 	}
-	var newTLSConf *tls.Config
-	ca := utils.LoadCACertificate()
-	if ca != nil {
-		//TLS will check every connection against his CA's Certificate
-		caCertPool := x509.NewCertPool()
-		caPem, _ := utils.CertificateToPem(ca)
-		caCertPool.AppendCertsFromPEM(caPem)
-		//TODO:
-		newTLSConf = &tls.Config{Certificates: []tls.Certificate{tlsCert}, InsecureSkipVerify: true, RootCAs: caCertPool, ClientCAs: caCertPool, ClientAuth: tls.RequireAndVerifyClientCert}
-	} else {
-		newTLSConf = &tls.Config{Certificates: []tls.Certificate{tlsCert}, InsecureSkipVerify: true, ClientAuth: tls.RequestClientCert}
+	tlsCert.Leaf = cert
+	ca := s.profile.LoadCACertificate()
+	selfSigned, _ := utils.TLSIsSelfSigned(s.GetTLSCertificate())
+	renewed := !selfSigned && ca != nil && s.CA != nil && ca.Equal(s.CA) &&
+		utils.HashPublicKey(&privKey.PublicKey) == s.GetHashedPublicKey()
+	if renewed {
+		s.certificateMutex.Lock()
+		s.myTLSCertificate = &tlsCert
+		s.myPKSignature = publicKeySignature
+		s.certificateMutex.Unlock()
+		return nil
 	}
+	newTLSConf := s.newTLSConfig(ca)
 	addr := s.GetAddress()
 	s.Close()
 	newListener, err := tls.Listen("tcp", addr, newTLSConf)
@@ -371,10 +539,12 @@ func (s *Socket) UpdateCertificate(cert *x509.Certificate, privKey *rsa.PrivateK
 
 	s.tlsConfig = newTLSConf
 	s.listener = &newListener
+	s.certificateMutex.Lock()
 	s.myTLSCertificate = &tlsCert
+	s.myPKSignature = publicKeySignature
+	s.certificateMutex.Unlock()
 	s.connPool = newConnPool()
 	s.CA = ca
-	s.myPKSignature = publicKeySignature
 
 	return nil
 }
@@ -384,11 +554,14 @@ func (s *Socket) GetTLSConfig() *tls.Config {
 }
 
 func (s *Socket) GetPublicKey() *rsa.PublicKey {
-	return &s.myTLSCertificate.PrivateKey.(*rsa.PrivateKey).PublicKey
+	return &s.GetTLSCertificate().PrivateKey.(*rsa.PrivateKey).PublicKey
 }
 
 func (s *Socket) GetSignedPublicKey() *transport.SignedPublicKey {
-	return &transport.SignedPublicKey{PublicKey: s.GetPublicKey(), Signature: s.myPKSignature}
+	s.certificateMutex.RLock()
+	signature := s.myPKSignature
+	s.certificateMutex.RUnlock()
+	return &transport.SignedPublicKey{PublicKey: s.GetPublicKey(), Signature: signature}
 }
 
 func (s *Socket) GetHashedPublicKey() [32]byte {
@@ -424,19 +597,24 @@ func (s *Socket) Block(publicKeyHash [32]byte) {
 	utils.AppendToFile(publicKeyHash[:], s.fpBlockedUsers)
 }
 
-func (s *Socket) Unblock(publicKeyHash [32]byte) {
+// Unblock removes the user from the blocked users and returns the IPs that were blocked because of the user, i.e.,
+// the origins whose rumors were dropped.
+func (s *Socket) Unblock(publicKeyHash [32]byte) []string {
 	s.blockedUsersMutex.Lock()
 	delete(s.blockedUsers, publicKeyHash)
 	s.blockedUsersMutex.Unlock()
 	//remove blocked ip associated with user's pk
+	var unblockedIPs []string
 	s.blockedIPsMutex.Lock()
 	for k, v := range s.blockedIPs {
 		if v == publicKeyHash {
 			delete(s.blockedIPs, k)
+			unblockedIPs = append(unblockedIPs, k)
 		}
 	}
 	s.blockedIPsMutex.Unlock()
 	s.storeBlockedUsers() //re-write blocked-users.db file with updated blocked users
+	return unblockedIPs
 }
 
 func (s *Socket) IsBlockedIP(addr string) bool {
@@ -462,7 +640,7 @@ func (s *Socket) storeBlockedUsers() error {
 	s.blockedUsersMutex.Lock()
 	defer s.blockedUsersMutex.Unlock()
 	s.fpBlockedUsers.Close()
-	fp, err := utils.OpenFileToWrite(utils.BlockedUsersPath)
+	fp, err := utils.OpenFileToWrite(s.profile.BlockedUsersPath())
 	if err != nil {
 		return err
 	}
@@ -471,7 +649,7 @@ func (s *Socket) storeBlockedUsers() error {
 		utils.AppendToFile(k[:], s.fpBlockedUsers)
 	}
 	s.fpBlockedUsers.Close()
-	s.fpBlockedUsers, err = utils.OpenFileToAppend(utils.BlockedUsersPath)
+	s.fpBlockedUsers, err = utils.OpenFileToAppend(s.profile.BlockedUsersPath())
 	if err != nil {
 		return err
 	}
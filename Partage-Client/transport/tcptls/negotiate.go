@@ -0,0 +1,191 @@
+package tcptls
+
+import (
+	"crypto/rsa"
+	"crypto/tls"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.dedis.ch/cs438/peer/impl/utils"
+	"go.dedis.ch/cs438/transport/codec"
+	"partage-common/framing"
+)
+
+// DefaultCodecs are the codecs supported by the sockets, by order of preference.
+var DefaultCodecs = []codec.Codec{codec.Binary, codec.JSON}
+
+// The first frame sent on a new connection by the peer that has dialed it is an offer made of "key:value" lines, e.g.:
+//
+//	codecs:binary,json
+//	mac:hmac-sha256
+//
+// The codecs are listed by order of preference. The mac line is only there if the dialer has checked that our
+// certificate is signed by the CA. The reply has the same format: it holds the chosen codec, and the mac line if the
+// connection is a mutually-authenticated session whose packets carry a MAC instead of a signature. Only the packets
+// whose destination is the peer of the session carry a MAC: the packets that the peer relays stay signed, since the MAC
+// does not authenticate their source to the next hops.
+const (
+	offeredCodecsKey = "codecs"
+	chosenCodecKey   = "codec"
+	macKey           = "mac"
+	macAlgorithm     = "hmac-sha256"
+)
+
+// sessionKeyLabel is the label used to export the key of a session from the TLS connection, so that both endpoints
+// agree on it without any additional round-trip.
+const sessionKeyLabel = "partage session mac"
+
+// sessionKeySize is the size of the key of a session, in bytes.
+const sessionKeySize = 32
+
+// negotiationTimeout is the maximum amount of time to negotiate the session of a new connection.
+const negotiationTimeout = time.Second * 5
+
+// session holds what has been negotiated on a connection.
+type session struct {
+	codec codec.Codec
+	// key authenticates the packets of the session. nil if the session isn't mutually authenticated, in which case
+	// the packets are signed.
+	key []byte
+	// peerKey is the public key of the peer's certificate.
+	peerKey *rsa.PublicKey
+}
+
+// offerSession offers our codecs to the peer we have dialed, and returns the session it has chosen.
+func (s *Socket) offerSession(conn *tls.Conn) (*session, error) {
+	peerKey, authenticated := s.authenticatePeer(conn)
+	names := make([]string, len(s.codecs))
+	for i, c := range s.codecs {
+		names[i] = c.Name()
+	}
+	offer := map[string]string{offeredCodecsKey: strings.Join(names, ",")}
+	if authenticated {
+		offer[macKey] = macAlgorithm
+	}
+	err := framing.WriteFrame(conn, formatHello(offer))
+	if err != nil {
+		return nil, err
+	}
+	err = conn.SetReadDeadline(time.Now().Add(negotiationTimeout))
+	if err != nil {
+		return nil, err
+	}
+	frame, err := framing.ReadFrame(conn)
+	if err != nil {
+		return nil, fmt.Errorf("no session chosen: %w", err)
+	}
+	reply := parseHello(frame)
+	c := s.supportedCodec(reply[chosenCodecKey])
+	if c == nil {
+		return nil, fmt.Errorf("the peer has chosen a codec we haven't offered: %q", reply[chosenCodecKey])
+	}
+	sess := &session{codec: c, peerKey: peerKey}
+	if reply[macKey] != "" {
+		if reply[macKey] != macAlgorithm || !authenticated {
+			return nil, fmt.Errorf("the peer has chosen a MAC we haven't offered: %q", reply[macKey])
+		}
+		sess.key, err = exportSessionKey(conn)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sess, nil
+}
+
+// acceptSession reads the offer of the peer that has dialed us, and replies with the session we choose: the first
+// codec we support and, if both certificates are signed by the CA, a MAC.
+func (s *Socket) acceptSession(conn *tls.Conn) (*session, error) {
+	err := conn.SetReadDeadline(time.Now().Add(negotiationTimeout))
+	if err != nil {
+		return nil, err
+	}
+	frame, err := framing.ReadFrame(conn)
+	if err != nil {
+		return nil, fmt.Errorf("no session offered: %w", err)
+	}
+	offer := parseHello(frame)
+	offered, ok := offer[offeredCodecsKey]
+	if !ok {
+		return nil, fmt.Errorf("expected a session offer, got %d bytes", len(frame))
+	}
+	sess := &session{}
+	for _, name := range strings.Split(offered, ",") {
+		sess.codec = s.supportedCodec(name)
+		if sess.codec != nil {
+			break
+		}
+	}
+	if sess.codec == nil {
+		return nil, fmt.Errorf("no supported codec among %q", offered)
+	}
+	reply := map[string]string{chosenCodecKey: sess.codec.Name()}
+	peerKey, authenticated := s.authenticatePeer(conn)
+	sess.peerKey = peerKey
+	if authenticated && offer[macKey] == macAlgorithm {
+		sess.key, err = exportSessionKey(conn)
+		if err != nil {
+			return nil, err
+		}
+		reply[macKey] = macAlgorithm
+	}
+	return sess, framing.WriteFrame(conn, formatHello(reply))
+}
+
+// authenticatePeer returns the public key of the peer's certificate, and whether the certificate is signed by the CA,
+// not revoked and not expired.
+func (s *Socket) authenticatePeer(conn *tls.Conn) (*rsa.PublicKey, bool) {
+	certificates := conn.ConnectionState().PeerCertificates
+	if len(certificates) == 0 {
+		return nil, false
+	}
+	publicKey, ok := certificates[0].PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, false
+	}
+	if s.IsRevoked(utils.HashPublicKey(publicKey)) || time.Now().After(certificates[0].NotAfter) {
+		return publicKey, false
+	}
+	return publicKey, s.CA != nil && certificates[0].CheckSignatureFrom(s.CA) == nil
+}
+
+// exportSessionKey derives the key of the session from the master secret of the TLS connection.
+func exportSessionKey(conn *tls.Conn) ([]byte, error) {
+	state := conn.ConnectionState()
+	key, err := state.ExportKeyingMaterial(sessionKeyLabel, nil, sessionKeySize)
+	if err != nil {
+		return nil, fmt.Errorf("could not export the session key: %w", err)
+	}
+	return key, nil
+}
+
+// supportedCodec returns the codec of the given name if we support it, nil otherwise.
+func (s *Socket) supportedCodec(name string) codec.Codec {
+	for _, c := range s.codecs {
+		if c.Name() == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func formatHello(fields map[string]string) []byte {
+	lines := make([]string, 0, len(fields))
+	for key, value := range fields {
+		lines = append(lines, key+":"+value)
+	}
+	sort.Strings(lines)
+	return []byte(strings.Join(lines, "\n"))
+}
+
+func parseHello(frame []byte) map[string]string {
+	fields := make(map[string]string)
+	for _, line := range strings.Split(string(frame), "\n") {
+		field := strings.SplitN(line, ":", 2)
+		if len(field) == 2 {
+			fields[field[0]] = field[1]
+		}
+	}
+	return fields
+}
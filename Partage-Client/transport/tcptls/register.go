@@ -12,9 +12,11 @@ import (
 	"go.dedis.ch/cs438/peer/impl/utils"
 	"go.dedis.ch/cs438/transport"
 	"go.dedis.ch/cs438/types"
+	"partage-common/framing"
 )
 
-const serverAddr = "127.0.0.1:1234" //Certificate Authority Server address
+// DefaultCAAddress is the address of the Certificate Authority Server, unless configured otherwise.
+const DefaultCAAddress = "127.0.0.1:1234"
 
 // Since we are using Certificates in order to authenticate users, the users authentication is directly related to the TLS Socket
 func (tlsSock *Socket) RegisterUser() error {
@@ -24,24 +26,9 @@ func (tlsSock *Socket) RegisterUser() error {
 		utils.PrintDebug("tls", "user already registered")
 		return nil
 	}
-	bufSize := 65000
-	buf := make([]byte, bufSize)
-	var msg types.CertificateAuthorityMessage
 	// Dial CA Server using my current TLS certificate (CA server will interpret it as a sign-request)
-	dialTimeout := 5 * time.Second
-	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: dialTimeout}, "tcp", serverAddr, tlsSock.GetTLSConfig())
+	conn, err := tlsSock.dialCA()
 	if err != nil {
-		// Convert to a network error to specifically check for timeout errors.
-		netErr, ok := err.(net.Error)
-		if ok && netErr.Timeout() {
-			utils.PrintDebug("tls", "[ERROR] timeout while trying to establish connection with CA server...")
-			return transport.TimeoutErr(0)
-		}
-		if err == io.EOF {
-			utils.PrintDebug("tls", "[WARNING] the CA server closed the connection!")
-		} else {
-			utils.PrintDebug("tls", "[ERROR] dialing CA server...", err)
-		}
 		return err
 	}
 
@@ -51,41 +38,23 @@ func (tlsSock *Socket) RegisterUser() error {
 		utils.PrintDebug("tls", "[ERROR] setting read timeout with CA server...", err)
 		return err
 	}
-	size, err := conn.Read(buf)
+	msg, err := readCAMessage(conn)
 	if err != nil {
 		utils.PrintDebug("tls", "CA server didn't respond...try again later!", err)
 		return err
 	}
-	err = msg.Decode(buf[:size])
-	if err != nil { //unmarshaling error
-		//try to read the rest of the packet
-		//Note..the pkts holding a certificate are too large so we're not able to read it in one Read() call, this is how we can go arround that problem
-		cum := append([]byte{}, buf[:size]...)
-		for {
-			size, err := conn.Read(buf)
-			if err != nil {
-				utils.PrintDebug("tls", "[ERROR] reading from CA server...", err)
-				return err
-			}
-			cum = append(cum, buf[:size]...)
-			err = msg.Decode(cum)
-			if err != nil {
-				continue
-			} else {
-				break
-			}
-		}
-	}
-	//PROCESS CA response
-	if msg.Type == "ERROR" {
-		utils.PrintDebug("tls", "[ERROR] "+string(msg.Payload))
-		return errors.New(string(msg.Payload))
-	} else if msg.Type == "WARNING" {
+	// The CA asks for a verification code, unless it approves our e-mail address right away
+	if msg.Type == "WARNING" {
 		utils.PrintDebug("tls", string(msg.Payload))
 		var input string
 		if utils.TESTING {
 			time.Sleep(time.Second * 2)
-			input = "12348765"
+			// Read the code from the mail dropped by the CA
+			input, err = utils.ReadTestVerificationCode(tlsSock.GetCertificate().Subject.Organization[0])
+			if err != nil {
+				utils.PrintDebug("tls", "[ERROR] reading the verification code...", err)
+				return err
+			}
 		} else {
 			fmt.Print("[VERIFICATION CODE]: ")
 			//Read from stdin
@@ -97,82 +66,35 @@ func (tlsSock *Socket) RegisterUser() error {
 			Payload: []byte(input),
 		}
 		bytes, _ := codeMsg.Encode()
-		conn.Write(bytes)
-	} else {
-		utils.PrintDebug("tls", "[ERROR] unknown type of CA message:", msg.Type)
-		return errors.New("unknown type of CA msg")
-	}
-
-	deadline = time.Now().Add(15 * time.Second) // Waits for CA-server response for..15 seconds
-	err = conn.SetReadDeadline(deadline)
-	if err != nil {
-		utils.PrintDebug("tls", "[ERROR] setting read timeout with CA server...", err)
-		return err
-	}
-	//WAIT FOR CA RESPONSE
-	size, err = conn.Read(buf)
-	if err != nil {
-		utils.PrintDebug("tls", "CA server didn't respond...try again later!", err)
-		return err
-	}
-	err = msg.Decode(buf[:size])
-	if err != nil { //unmarshaling error
-		//try to read the rest of the packet
-		//Note..the pkts holding a certificate are too large so we're not able to read it in one Read() call, this is how we can go arround that problem
-		cum := append([]byte{}, buf[:size]...)
-		for {
-			size, err := conn.Read(buf)
-			if err != nil {
-				utils.PrintDebug("tls", "[ERROR] reading from CA server...", err)
-				return err
-			}
-			cum = append(cum, buf[:size]...)
-			err = msg.Decode(cum)
-			if err != nil {
-				continue
-			} else {
-				break
-			}
-		}
-	}
-	//PROCESS CA response
-	if msg.Type == "ERROR" {
-		utils.PrintDebug("tls", "[ERROR] "+string(msg.Payload))
-		return errors.New(string(msg.Payload))
-	} else if msg.Type == "OK" {
-		var details types.Registration
-		//SUCESS!
-		err = details.Decode(msg.Payload)
+		err = framing.WriteFrame(conn, bytes)
 		if err != nil {
-			utils.PrintDebug("tls", "[ERROR] marshaling Registration details from CA server OK-response...", err)
+			utils.PrintDebug("tls", "[ERROR] sending the verification code to CA server...", err)
 			return err
 		}
-		newCert, err := utils.PemToCertificate(details.SignedCertificate)
-		if err != nil {
-			utils.PrintDebug("tls", "[ERROR] converting signed PEM certificate to x509.Certificate...", err)
-			return err
-		}
-		// Store signed-certificate
-		_, err = utils.StoreCertificate(newCert)
+
+		deadline = time.Now().Add(15 * time.Second) // Waits for CA-server response for..15 seconds
+		err = conn.SetReadDeadline(deadline)
 		if err != nil {
-			utils.PrintDebug("tls", "[ERROR] storing signed certificate...", err)
+			utils.PrintDebug("tls", "[ERROR] setting read timeout with CA server...", err)
 			return err
 		}
-		// Store CA's certificate
-		_, err = utils.StoreCACertificate(conn.ConnectionState().PeerCertificates[0])
+		//WAIT FOR CA RESPONSE
+		msg, err = readCAMessage(conn)
 		if err != nil {
-			utils.PrintDebug("tls", "[ERROR] storing CA's certificate...", err)
+			utils.PrintDebug("tls", "CA server didn't respond...try again later!", err)
 			return err
 		}
-		// Store CA's signature of my Public Key
-		err = utils.StorePublicKeySignature(details.PublicKeySignature)
+	}
+	//PROCESS CA response
+	if msg.Type == "ERROR" {
+		utils.PrintDebug("tls", "[ERROR] "+string(msg.Payload))
+		return errors.New(string(msg.Payload))
+	} else if msg.Type == "OK" {
+		//SUCESS!
+		err = tlsSock.applyRegistration(conn, msg.Payload)
 		if err != nil {
-			utils.PrintDebug("tls", "[ERROR] storing public key signature...", err)
 			return err
 		}
-
-		// Update all certificate-dependent attributes with this newly-signed certificate
-		tlsSock.UpdateCertificate(newCert, tlsSock.GetTLSCertificate().PrivateKey.(*rsa.PrivateKey), details.PublicKeySignature)
 	} else {
 		utils.PrintDebug("tls", "[ERROR] unknown type of CA message:", msg.Type)
 		return errors.New("unknown type of CA msg")
@@ -181,3 +103,121 @@ func (tlsSock *Socket) RegisterUser() error {
 	utils.PrintDebug("tls", "DEBUG: successfully registered user!")
 	return nil
 }
+
+// RenewCertificate gets a new certificate for our public key from the CA, so that our identity is kept. The CA only
+// renews the certificates it has signed, and skips the e-mail verification since the TLS handshake proves that we own
+// the private key of our current certificate, which may already have expired.
+func (tlsSock *Socket) RenewCertificate() error {
+	if res, _ := utils.TLSIsSelfSigned(tlsSock.GetTLSCertificate()); res {
+		return errors.New("user is not registered")
+	}
+	conn, err := tlsSock.dialCA()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	request := &types.CertificateAuthorityMessage{Type: "RENEW"}
+	bytes, _ := request.Encode()
+	err = framing.WriteFrame(conn, bytes)
+	if err != nil {
+		return fmt.Errorf("could not send the renewal request to CA server: %w", err)
+	}
+	err = conn.SetReadDeadline(time.Now().Add(15 * time.Second))
+	if err != nil {
+		return err
+	}
+	msg, err := readCAMessage(conn)
+	if err != nil {
+		return err
+	}
+	if msg.Type == "ERROR" {
+		return errors.New(string(msg.Payload))
+	}
+	if msg.Type != "OK" {
+		return fmt.Errorf("unknown type of CA msg: %s", msg.Type)
+	}
+	err = tlsSock.applyRegistration(conn, msg.Payload)
+	if err != nil {
+		return err
+	}
+	utils.PrintDebug("tls", "DEBUG: successfully renewed certificate until", tlsSock.GetCertificate().NotAfter)
+	return nil
+}
+
+// ExpiresWithin returns whether our certificate expires within the given duration.
+func (tlsSock *Socket) ExpiresWithin(d time.Duration) bool {
+	cert := tlsSock.GetCertificate()
+	return cert == nil || time.Now().Add(d).After(cert.NotAfter)
+}
+
+// applyRegistration stores the certificate and the public key signature sent by the CA, and starts using them.
+func (tlsSock *Socket) applyRegistration(conn *tls.Conn, payload []byte) error {
+	var details types.Registration
+	err := details.Decode(payload)
+	if err != nil {
+		utils.PrintDebug("tls", "[ERROR] marshaling Registration details from CA server OK-response...", err)
+		return err
+	}
+	newCert, err := utils.PemToCertificate(details.SignedCertificate)
+	if err != nil {
+		utils.PrintDebug("tls", "[ERROR] converting signed PEM certificate to x509.Certificate...", err)
+		return err
+	}
+	// Store signed-certificate
+	_, err = tlsSock.profile.StoreCertificate(newCert)
+	if err != nil {
+		utils.PrintDebug("tls", "[ERROR] storing signed certificate...", err)
+		return err
+	}
+	// Store CA's certificate
+	_, err = tlsSock.profile.StoreCACertificate(conn.ConnectionState().PeerCertificates[0])
+	if err != nil {
+		utils.PrintDebug("tls", "[ERROR] storing CA's certificate...", err)
+		return err
+	}
+	// Store CA's signature of my Public Key
+	err = tlsSock.profile.StorePublicKeySignature(details.PublicKeySignature)
+	if err != nil {
+		utils.PrintDebug("tls", "[ERROR] storing public key signature...", err)
+		return err
+	}
+
+	// Update all certificate-dependent attributes with this newly-signed certificate
+	return tlsSock.UpdateCertificate(newCert, tlsSock.GetTLSCertificate().PrivateKey.(*rsa.PrivateKey), details.PublicKeySignature)
+}
+
+// dialCA opens a connection with the CA server using my current TLS certificate. The CA server interprets a
+// self-signed certificate as a sign-request, and a CA-signed one as a registered user that sends a request.
+func (tlsSock *Socket) dialCA() (*tls.Conn, error) {
+	dialTimeout := 5 * time.Second
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: dialTimeout}, "tcp", tlsSock.caAddress, tlsSock.GetTLSConfig())
+	if err != nil {
+		// Convert to a network error to specifically check for timeout errors.
+		netErr, ok := err.(net.Error)
+		if ok && netErr.Timeout() {
+			utils.PrintDebug("tls", "[ERROR] timeout while trying to establish connection with CA server...")
+			return nil, transport.TimeoutErr(0)
+		}
+		if err == io.EOF {
+			utils.PrintDebug("tls", "[WARNING] the CA server closed the connection!")
+		} else {
+			utils.PrintDebug("tls", "[ERROR] dialing CA server...", err)
+		}
+		return nil, err
+	}
+	return conn, nil
+}
+
+// readCAMessage reads the next message sent by the CA server.
+func readCAMessage(conn net.Conn) (types.CertificateAuthorityMessage, error) {
+	var msg types.CertificateAuthorityMessage
+	frame, err := framing.ReadFrame(conn)
+	if err != nil {
+		return msg, err
+	}
+	err = msg.Decode(frame)
+	if err != nil {
+		return msg, fmt.Errorf("malformed message from CA server: %w", err)
+	}
+	return msg, nil
+}
@@ -0,0 +1,121 @@
+package tcptls
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.dedis.ch/cs438/peer/impl/utils"
+	"go.dedis.ch/cs438/types"
+	"partage-common/framing"
+)
+
+// FetchRevocationList asks the CA for its latest revocation list. The list is not applied: see UpdateRevocationList.
+func (s *Socket) FetchRevocationList() (*types.RevocationList, error) {
+	return s.requestRevocationList("CRL")
+}
+
+// RevokeCertificate asks the CA to revoke our certificate, e.g., because our private key has leaked. Returns the
+// revocation list that includes our public key.
+func (s *Socket) RevokeCertificate() (*types.RevocationList, error) {
+	return s.requestRevocationList("REVOKE")
+}
+
+// requestRevocationList sends a request of the given type to the CA, which replies with its latest revocation list.
+// Only registered users can send requests, since the CA recognizes them by their CA-signed certificate.
+func (s *Socket) requestRevocationList(requestType string) (*types.RevocationList, error) {
+	if s.CA == nil {
+		return nil, errors.New("user is not registered")
+	}
+	conn, err := s.dialCA()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	request := &types.CertificateAuthorityMessage{Type: requestType}
+	bytes, _ := request.Encode()
+	err = framing.WriteFrame(conn, bytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not send the %s request to CA server: %w", requestType, err)
+	}
+	err = conn.SetReadDeadline(time.Now().Add(15 * time.Second))
+	if err != nil {
+		return nil, err
+	}
+	msg, err := readCAMessage(conn)
+	if err != nil {
+		return nil, err
+	}
+	if msg.Type == "ERROR" {
+		return nil, errors.New(string(msg.Payload))
+	}
+	if msg.Type != "CRL" {
+		return nil, fmt.Errorf("unknown type of CA msg: %s", msg.Type)
+	}
+	var list types.RevocationList
+	err = list.Decode(msg.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("malformed revocation list from CA server: %w", err)
+	}
+	return &list, nil
+}
+
+// UpdateRevocationList replaces our revocation list with the given one if it is signed by the CA and more recent.
+// The list is stored in persistent memory. Returns whether the list has replaced ours.
+func (s *Socket) UpdateRevocationList(list *types.RevocationList) (bool, error) {
+	err := list.Verify(s.GetCAPublicKey())
+	if err != nil {
+		return false, err
+	}
+	s.revocationMutex.Lock()
+	defer s.revocationMutex.Unlock()
+	if s.revocationList != nil && s.revocationList.Serial >= list.Serial {
+		return false, nil
+	}
+	revoked := make(map[[32]byte]struct{}, len(list.Revoked))
+	for _, hash := range list.Revoked {
+		revoked[hash] = struct{}{}
+	}
+	s.revocationList = list
+	s.revoked = revoked
+	data, err := json.Marshal(list)
+	if err == nil {
+		err = s.profile.StoreRevocationList(data)
+	}
+	if err != nil {
+		utils.PrintDebug("tls", "[ERROR] storing revocation list...", err)
+	}
+	return true, nil
+}
+
+// GetRevocationList returns our latest revocation list, nil if we have none.
+func (s *Socket) GetRevocationList() *types.RevocationList {
+	s.revocationMutex.RLock()
+	defer s.revocationMutex.RUnlock()
+	return s.revocationList
+}
+
+// IsRevoked returns whether the public key with the given hash is in our revocation list.
+func (s *Socket) IsRevoked(publicKeyHash [32]byte) bool {
+	s.revocationMutex.RLock()
+	defer s.revocationMutex.RUnlock()
+	_, exists := s.revoked[publicKeyHash]
+	return exists
+}
+
+// loadRevocationList loads the revocation list stored in persistent memory, if it is still signed by our CA.
+func (s *Socket) loadRevocationList() {
+	data := s.profile.LoadRevocationList()
+	if data == nil {
+		return
+	}
+	var list types.RevocationList
+	if json.Unmarshal(data, &list) != nil {
+		return
+	}
+	_, err := s.UpdateRevocationList(&list)
+	if err != nil {
+		utils.PrintDebug("tls", "[WARNING] ignoring the stored revocation list:", err)
+	}
+}
@@ -16,6 +16,8 @@ type PaxosPrepareMessage struct {
 	ID   uint
 	// Source is the address of the peer that sends the prepare
 	Source string
+	// Epoch is the membership epoch of the proposer
+	Epoch uint
 }
 
 // PaxosPromiseMessage defines a promise message in Paxos
@@ -41,6 +43,8 @@ type PaxosProposeMessage struct {
 	ID     uint
 	Source string
 	Value  PaxosValue
+	// Epoch is the membership epoch of the proposer
+	Epoch uint
 }
 
 // PaxosAcceptMessage defines an accept message in Paxos
@@ -52,6 +56,8 @@ type PaxosAcceptMessage struct {
 	ID     uint
 	Source string
 	Value  PaxosValue
+	// Epoch is the membership epoch of the proposer
+	Epoch uint
 }
 
 // TLCMessage defines a TLC message
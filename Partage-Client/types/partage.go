@@ -5,6 +5,7 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -36,7 +37,7 @@ func (r *Rumor) AddValidation(myPrivateKey *rsa.PrivateKey, mySignedPublicKey *t
 	return nil
 }
 
-func (r *Rumor) Validate(publicKeyCA *rsa.PublicKey) error {
+func (r *Rumor) Validate(publicKeyCA *rsa.PublicKey, isRevoked transport.RevocationChecker) error {
 	if r.Check == nil {
 		return fmt.Errorf("rumor has empty validation check")
 	}
@@ -51,6 +52,9 @@ func (r *Rumor) Validate(publicKeyCA *rsa.PublicKey) error {
 		//invalid SignedPublicKey (not signed by trusted CA)
 		return fmt.Errorf("rumor's src public key is not signed by trusted CA")
 	}
+	if isRevoked != nil && isRevoked(hashedSrcPK) {
+		return fmt.Errorf("rumor's src public key has been revoked by trusted CA")
+	}
 
 	//2- Check if Hash(rumor.Msg||rumor.Origin||rumor.Sequence) was signed by src's private key
 	byteMsg, _ := json.Marshal(r.Msg)
@@ -171,6 +175,57 @@ func (r *Registration) Decode(bytes []byte) error {
 	return json.Unmarshal(bytes, &r)
 }
 
+// RevocationList that comes with the CertificateAuthorityMessage
+func (l *RevocationList) Decode(bytes []byte) error {
+	return json.Unmarshal(bytes, &l)
+}
+
+// Digest returns the hash signed by the CA: Hash(Serial||Timestamp||Revoked...)
+func (l *RevocationList) Digest() [32]byte {
+	data := make([]byte, 16, 16+32*len(l.Revoked))
+	binary.BigEndian.PutUint64(data[:8], l.Serial)
+	binary.BigEndian.PutUint64(data[8:], uint64(l.Timestamp))
+	for _, hash := range l.Revoked {
+		data = append(data, hash[:]...)
+	}
+	return transport.Hash(data)
+}
+
+// Verify checks that the list was signed by the CA.
+func (l *RevocationList) Verify(publicKeyCA *rsa.PublicKey) error {
+	if publicKeyCA == nil {
+		return fmt.Errorf("no trusted CA to check the revocation list against")
+	}
+	digest := l.Digest()
+	if rsa.VerifyPKCS1v15(publicKeyCA, crypto.SHA256, digest[:], l.Signature) != nil {
+		return fmt.Errorf("revocation list is not signed by trusted CA")
+	}
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+// RevocationListMessage
+
+// NewEmpty implements types.Message.
+func (r RevocationListMessage) NewEmpty() Message {
+	return &RevocationListMessage{}
+}
+
+// Name implements types.Message.
+func (r RevocationListMessage) Name() string {
+	return "revocationlist"
+}
+
+// String implements types.Message.
+func (r RevocationListMessage) String() string {
+	return fmt.Sprintf("revocationlist{serial %d, %d revoked}", r.List.Serial, len(r.List.Revoked))
+}
+
+// HTML implements types.Message.
+func (r RevocationListMessage) HTML() string {
+	return r.String()
+}
+
 // RecipientsMap map[hash(rsaPublicKeyA)]EncPKA(AESKey) == map[[32]byte][128]byte
 func (r RecipientsMap) Encode() ([]byte, error) {
 	window := 32 + 128
@@ -14,6 +14,16 @@ type Registration struct {
 	PublicKeySignature []byte
 }
 
+// RevocationList is the list of the public keys revoked by the CA, signed by the CA.
+type RevocationList struct {
+	// Serial increases with every revocation, so that the peers only keep the latest list.
+	Serial    uint64
+	Timestamp int64
+	// Revoked holds the hashes of the revoked public keys.
+	Revoked   [][32]byte
+	Signature []byte
+}
+
 //======================PARTAGE
 //---------------Post
 type Post struct {
@@ -55,6 +65,11 @@ type SearchPKReplyMessage struct {
 	RequestID string
 }
 
+// RevocationListMessage spreads the latest revocation list of the CA in the network.
+type RevocationListMessage struct {
+	List RevocationList
+}
+
 /*
 //---------------SignedMessage
 type SignedMessage struct{
@@ -0,0 +1,187 @@
+package types
+
+import "fmt"
+
+// -----------------------------------------------------------------------------
+// RaftRequestVoteMessage
+
+// NewEmpty implements types.Message.
+func (p RaftRequestVoteMessage) NewEmpty() Message {
+	return &RaftRequestVoteMessage{}
+}
+
+// Name implements types.Message.
+func (p RaftRequestVoteMessage) Name() string {
+	return "raftrequestvote"
+}
+
+// String implements types.Message.
+func (p RaftRequestVoteMessage) String() string {
+	return fmt.Sprintf("{raftrequestvote %d - %s}", p.Term, p.Candidate)
+}
+
+// HTML implements types.Message.
+func (p RaftRequestVoteMessage) HTML() string {
+	return p.String()
+}
+
+// -----------------------------------------------------------------------------
+// RaftVoteMessage
+
+// NewEmpty implements types.Message.
+func (p RaftVoteMessage) NewEmpty() Message {
+	return &RaftVoteMessage{}
+}
+
+// Name implements types.Message.
+func (p RaftVoteMessage) Name() string {
+	return "raftvote"
+}
+
+// String implements types.Message.
+func (p RaftVoteMessage) String() string {
+	return fmt.Sprintf("{raftvote %d - %s for %s (%v)}", p.Term, p.Source, p.Candidate, p.Granted)
+}
+
+// HTML implements types.Message.
+func (p RaftVoteMessage) HTML() string {
+	return p.String()
+}
+
+// -----------------------------------------------------------------------------
+// RaftHeartbeatMessage
+
+// NewEmpty implements types.Message.
+func (p RaftHeartbeatMessage) NewEmpty() Message {
+	return &RaftHeartbeatMessage{}
+}
+
+// Name implements types.Message.
+func (p RaftHeartbeatMessage) Name() string {
+	return "raftheartbeat"
+}
+
+// String implements types.Message.
+func (p RaftHeartbeatMessage) String() string {
+	return fmt.Sprintf("{raftheartbeat %d - %s - %d}", p.Term, p.Leader, p.Step)
+}
+
+// HTML implements types.Message.
+func (p RaftHeartbeatMessage) HTML() string {
+	return p.String()
+}
+
+// -----------------------------------------------------------------------------
+// RaftForwardMessage
+
+// NewEmpty implements types.Message.
+func (p RaftForwardMessage) NewEmpty() Message {
+	return &RaftForwardMessage{}
+}
+
+// Name implements types.Message.
+func (p RaftForwardMessage) Name() string {
+	return "raftforward"
+}
+
+// String implements types.Message.
+func (p RaftForwardMessage) String() string {
+	return fmt.Sprintf("{raftforward %s (%v)}", p.Source, p.Value)
+}
+
+// HTML implements types.Message.
+func (p RaftForwardMessage) HTML() string {
+	return p.String()
+}
+
+// -----------------------------------------------------------------------------
+// RaftAppendEntryMessage
+
+// NewEmpty implements types.Message.
+func (p RaftAppendEntryMessage) NewEmpty() Message {
+	return &RaftAppendEntryMessage{}
+}
+
+// Name implements types.Message.
+func (p RaftAppendEntryMessage) Name() string {
+	return "raftappendentry"
+}
+
+// String implements types.Message.
+func (p RaftAppendEntryMessage) String() string {
+	return fmt.Sprintf("{raftappendentry %d - %d (%v)}", p.Term, p.Step, p.Value)
+}
+
+// HTML implements types.Message.
+func (p RaftAppendEntryMessage) HTML() string {
+	return p.String()
+}
+
+// -----------------------------------------------------------------------------
+// RaftAppendReplyMessage
+
+// NewEmpty implements types.Message.
+func (p RaftAppendReplyMessage) NewEmpty() Message {
+	return &RaftAppendReplyMessage{}
+}
+
+// Name implements types.Message.
+func (p RaftAppendReplyMessage) Name() string {
+	return "raftappendreply"
+}
+
+// String implements types.Message.
+func (p RaftAppendReplyMessage) String() string {
+	return fmt.Sprintf("{raftappendreply %d - %d %s (%v)}", p.Term, p.Step, p.Source, p.Success)
+}
+
+// HTML implements types.Message.
+func (p RaftAppendReplyMessage) HTML() string {
+	return p.String()
+}
+
+// -----------------------------------------------------------------------------
+// RaftCommitMessage
+
+// NewEmpty implements types.Message.
+func (p RaftCommitMessage) NewEmpty() Message {
+	return &RaftCommitMessage{}
+}
+
+// Name implements types.Message.
+func (p RaftCommitMessage) Name() string {
+	return "raftcommit"
+}
+
+// String implements types.Message.
+func (p RaftCommitMessage) String() string {
+	return fmt.Sprintf("{raftcommit %d - %d (%v)}", p.Term, p.Step, p.Block)
+}
+
+// HTML implements types.Message.
+func (p RaftCommitMessage) HTML() string {
+	return p.String()
+}
+
+// -----------------------------------------------------------------------------
+// RaftRejectMessage
+
+// NewEmpty implements types.Message.
+func (p RaftRejectMessage) NewEmpty() Message {
+	return &RaftRejectMessage{}
+}
+
+// Name implements types.Message.
+func (p RaftRejectMessage) Name() string {
+	return "raftreject"
+}
+
+// String implements types.Message.
+func (p RaftRejectMessage) String() string {
+	return fmt.Sprintf("{raftreject %s}", p.UniqID)
+}
+
+// HTML implements types.Message.
+func (p RaftRejectMessage) HTML() string {
+	return p.String()
+}
@@ -0,0 +1,127 @@
+package types
+
+import "go.dedis.ch/cs438/transport"
+
+// RaftRequestVoteMessage is broadcast by a candidate to request votes for a
+// new term.
+//
+// - implements types.Message
+type RaftRequestVoteMessage struct {
+	Term uint
+	// Candidate is the address of the candidate.
+	Candidate string
+	// Step is the index of the next block of the candidate.
+	Step uint
+	// AcceptedTerm is the term of the entry accepted by the candidate at its
+	// step. 0 if the candidate hasn't accepted any entry.
+	AcceptedTerm uint
+	// Epoch is the membership epoch of the candidate.
+	Epoch uint
+}
+
+// RaftVoteMessage is the response to a vote request.
+//
+// - implements types.Message
+type RaftVoteMessage struct {
+	Term uint
+	// Source is the address of the voter.
+	Source string
+	// Candidate is the address of the candidate the vote is for.
+	Candidate string
+	Granted   bool
+
+	// Step is the index of the next block of the voter.
+	Step uint
+	// Irrelevant if the voter hasn't accepted any entry at its step.
+	AcceptedTerm uint
+	// Must be nil if the voter hasn't accepted any entry at its step.
+	AcceptedValue *PaxosValue
+
+	// PublicKey is the public key of the voter, signed by the CA, and
+	// Signature is the signature of the granted vote by the voter. Both are
+	// nil without the TLS transport.
+	PublicKey *transport.SignedPublicKey
+	Signature []byte
+}
+
+// RaftHeartbeatMessage is broadcast periodically by a leader to announce
+// itself, so that its followers do not start an election.
+//
+// - implements types.Message
+type RaftHeartbeatMessage struct {
+	Term   uint
+	Leader string
+	// Step is the index of the next block of the leader. The followers that
+	// are behind ask the leader for the blocks they have missed.
+	Step uint
+	// Votes are the granted votes that have elected the leader.
+	Votes []RaftVoteMessage
+}
+
+// RaftForwardMessage is sent by a follower to forward a proposal to the
+// leader.
+//
+// - implements types.Message
+type RaftForwardMessage struct {
+	// Source is the address of the follower.
+	Source string
+	Value  PaxosValue
+}
+
+// RaftAppendEntryMessage is broadcast by a leader to append a value at the
+// given step.
+//
+// - implements types.Message
+type RaftAppendEntryMessage struct {
+	Term   uint
+	Leader string
+	Step   uint
+	// PrevHash is the hash of the block preceding the entry at the leader.
+	// The entry is only appended by the followers whose last block matches.
+	PrevHash []byte
+	Value    PaxosValue
+	// Epoch is the membership epoch of the leader.
+	Epoch uint
+	// Votes are the granted votes that have elected the leader.
+	Votes []RaftVoteMessage
+}
+
+// RaftAppendReplyMessage is the response to an append entry message.
+//
+// - implements types.Message
+type RaftAppendReplyMessage struct {
+	Term uint
+	// Source is the address of the follower.
+	Source  string
+	Step    uint
+	UniqID  string
+	Success bool
+	// Rejected is true if the follower's proposal checker has rejected the
+	// value.
+	Rejected bool
+	// NextStep is the index of the next block of the follower. The leader
+	// resends the committed blocks from this index if the follower is behind.
+	NextStep uint
+}
+
+// RaftCommitMessage is broadcast by a leader once a value has been appended
+// by enough peers. It is also sent privately to resend a committed block to a
+// follower that has missed it.
+//
+// - implements types.Message
+type RaftCommitMessage struct {
+	Term   uint
+	Leader string
+	Step   uint
+	Block  BlockchainBlock
+	// Votes are the granted votes that have elected the leader.
+	Votes []RaftVoteMessage
+}
+
+// RaftRejectMessage is sent by a leader to the follower that has forwarded a
+// proposal that was rejected.
+//
+// - implements types.Message
+type RaftRejectMessage struct {
+	UniqID string
+}
@@ -0,0 +1,64 @@
+// Package framing defines the wire format of the TLS connections, which is shared by the peer sockets and the
+// Partage-CA protocol. Each frame is made of a 4-byte big-endian length header followed by that many bytes of payload.
+package framing
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// HeaderSize is the size of the length header of a frame, in bytes.
+const HeaderSize = 4
+
+// MaxFrameSize is the maximum size of the payload of a frame, in bytes.
+const MaxFrameSize = 16 << 20
+
+// ErrFrameTooLarge is returned when a frame exceeds MaxFrameSize.
+var ErrFrameTooLarge = errors.New("frame exceeds the maximum frame size")
+
+// ErrMalformedFrame is returned when a frame cannot be read entirely or is empty.
+var ErrMalformedFrame = errors.New("malformed frame")
+
+// WriteFrame writes the given payload as a single frame. The header and the payload are written with a single call, so
+// that concurrent writers do not interleave their frames.
+func WriteFrame(w io.Writer, payload []byte) error {
+	if len(payload) > MaxFrameSize {
+		return fmt.Errorf("%w: %d > %d bytes", ErrFrameTooLarge, len(payload), MaxFrameSize)
+	}
+	frame := make([]byte, HeaderSize+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	copy(frame[HeaderSize:], payload)
+	_, err := w.Write(frame)
+	return err
+}
+
+// ReadFrame blocks until a whole frame is read and returns its payload. Returns io.EOF if the reader is closed in
+// between two frames.
+func ReadFrame(r io.Reader) ([]byte, error) {
+	header := make([]byte, HeaderSize)
+	_, err := io.ReadFull(r, header)
+	if err == io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("%w: truncated header", ErrMalformedFrame)
+	}
+	if err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header)
+	if size == 0 {
+		return nil, fmt.Errorf("%w: empty payload", ErrMalformedFrame)
+	}
+	if size > MaxFrameSize {
+		return nil, fmt.Errorf("%w: %d > %d bytes", ErrFrameTooLarge, size, MaxFrameSize)
+	}
+	payload := make([]byte, size)
+	_, err = io.ReadFull(r, payload)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		return nil, fmt.Errorf("%w: truncated payload", ErrMalformedFrame)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
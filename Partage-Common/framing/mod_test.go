@@ -0,0 +1,95 @@
+package framing
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	payloads := [][]byte{
+		[]byte("a"),
+		[]byte(`{"type":"rumor"}`),
+		bytes.Repeat([]byte{0xff}, 1<<16),
+	}
+
+	var stream bytes.Buffer
+	for _, payload := range payloads {
+		err := WriteFrame(&stream, payload)
+		if err != nil {
+			t.Fatalf("failed to write a frame: %v", err)
+		}
+	}
+
+	// the frames are read back in order from a single stream
+	for _, payload := range payloads {
+		frame, err := ReadFrame(&stream)
+		if err != nil {
+			t.Fatalf("failed to read a frame: %v", err)
+		}
+		if !bytes.Equal(payload, frame) {
+			t.Fatalf("read %d bytes instead of the %d written", len(frame), len(payload))
+		}
+	}
+
+	_, err := ReadFrame(&stream)
+	if err != io.EOF {
+		t.Fatalf("expected io.EOF between two frames, got %v", err)
+	}
+}
+
+func TestTruncatedFrame(t *testing.T) {
+	var stream bytes.Buffer
+	err := WriteFrame(&stream, []byte("hello world"))
+	if err != nil {
+		t.Fatalf("failed to write a frame: %v", err)
+	}
+	frame := stream.Bytes()
+
+	cuts := map[string]int{
+		"header":  HeaderSize - 1,
+		"payload": len(frame) - 1,
+	}
+	for name, cut := range cuts {
+		_, err := ReadFrame(bytes.NewReader(frame[:cut]))
+		if !errors.Is(err, ErrMalformedFrame) {
+			t.Fatalf("truncated %s: expected ErrMalformedFrame, got %v", name, err)
+		}
+	}
+}
+
+func TestEmptyFrame(t *testing.T) {
+	header := make([]byte, HeaderSize)
+	_, err := ReadFrame(bytes.NewReader(header))
+	if !errors.Is(err, ErrMalformedFrame) {
+		t.Fatalf("expected ErrMalformedFrame, got %v", err)
+	}
+}
+
+func TestOversizedFrame(t *testing.T) {
+	// the length prefix is rejected before the payload is read
+	header := make([]byte, HeaderSize)
+	binary.BigEndian.PutUint32(header, MaxFrameSize+1)
+	_, err := ReadFrame(bytes.NewReader(header))
+	if !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("expected ErrFrameTooLarge when reading, got %v", err)
+	}
+
+	binary.BigEndian.PutUint32(header, 0xffffffff)
+	_, err = ReadFrame(bytes.NewReader(header))
+	if !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("expected ErrFrameTooLarge when reading, got %v", err)
+	}
+
+	// nothing is written for an oversized payload
+	var stream bytes.Buffer
+	err = WriteFrame(&stream, make([]byte, MaxFrameSize+1))
+	if !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("expected ErrFrameTooLarge when writing, got %v", err)
+	}
+	if stream.Len() != 0 {
+		t.Fatalf("expected nothing to be written, got %d bytes", stream.Len())
+	}
+}
@@ -0,0 +1,3 @@
+module partage-common
+
+go 1.16